@@ -1,8 +1,9 @@
 package adt7410
 
 import (
-	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
 )
 
 type Error uint8
@@ -20,8 +21,10 @@ func (e Error) Error() string {
 	}
 }
 
+var _ drivers.Thermometer = (*Device)(nil)
+
 type Device struct {
-	bus  *machine.I2C
+	bus  drivers.I2C
 	buf  []byte
 	addr uint8
 }
@@ -31,7 +34,7 @@ type Device struct {
 // can be set using by connecting to the A1 and A0 pins to VDD or GND (for a
 // total of up to 4 devices on a I2C bus).  Also note that 10k pullups are
 // recommended for the SDA and SCL lines.
-func New(i2c *machine.I2C, addressBits uint8) *Device {
+func New(i2c drivers.I2C, addressBits uint8) *Device {
 	return &Device{
 		bus:  i2c,
 		buf:  make([]byte, 2),
@@ -59,7 +62,12 @@ func (dev *Device) Configure() (err error) {
 
 // ReadTemperature returns the temperature in celsius milli degrees (°C/1000)
 func (d *Device) ReadTemperature() (temperature int32, err error) {
-	return (int32(d.readUint16(RegTempValueMSB)) * 1000) / 128, nil
+	err = d.bus.ReadRegister(d.addr, RegTempValueMSB, d.buf)
+	if err != nil {
+		return
+	}
+	t := int16(uint16(d.buf[0])<<8 | uint16(d.buf[1]))
+	return (int32(t) * 1000) / 128, nil
 }
 
 // ReadTempC returns the value in the temperature value register, in Celcius
@@ -0,0 +1,57 @@
+package adt7410_test
+
+import (
+	"testing"
+
+	"tinygo.org/x/drivers/adt7410"
+	"tinygo.org/x/drivers/tester"
+)
+
+func TestConfigure(t *testing.T) {
+	bus := tester.NewI2CBus(t)
+	fake := tester.NewI2CDevice8(t, adt7410.Address|0x2)
+	bus.AddDevice(fake)
+
+	dev := adt7410.New(bus, 0x2)
+	if err := dev.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	bus.AssertWritten(adt7410.Address|0x2, adt7410.RegReset, 0xFF)
+}
+
+func TestReadTemperature(t *testing.T) {
+	bus := tester.NewI2CBus(t)
+	fake := tester.NewI2CDevice8(t, adt7410.Address)
+	bus.AddDevice(fake)
+	dev := adt7410.New(bus, 0)
+
+	tests := []struct {
+		raw  uint16
+		want int32
+	}{
+		{0x0C80, 25000},
+		{0x0C88, 25062}, // 25.0625 °C, truncated
+		{0x0000, 0},
+		{0xFB00, -10000},
+	}
+	for _, tt := range tests {
+		fake.SetRegisters(adt7410.RegTempValueMSB, byte(tt.raw>>8), byte(tt.raw))
+		temp, err := dev.ReadTemperature()
+		if err != nil || temp != tt.want {
+			t.Errorf("raw 0x%04X: got %d, %v; want %d", tt.raw, temp, err, tt.want)
+		}
+	}
+
+	fake.SetRegisters(adt7410.RegTempValueMSB, 0x0C, 0x80)
+	if c := dev.ReadTempC(); c != 25 {
+		t.Errorf("got %v °C, want 25", c)
+	}
+	if f := dev.ReadTempF(); f != 77 {
+		t.Errorf("got %v °F, want 77", f)
+	}
+
+	fake.NACK = true
+	if _, err := dev.ReadTemperature(); err != tester.ErrNACK {
+		t.Errorf("got %v, want %v", err, tester.ErrNACK)
+	}
+}
@@ -41,8 +41,10 @@ func (s *bbSPI) delay() {
 	}
 }
 
-// Transfer is used to send a single byte.
-func (s *bbSPI) Transfer(b byte) {
+// Transfer matches signature of machine.SPI.Transfer() and is used to send a
+// single byte. The received value is always zero and no error will ever be
+// returned.
+func (s *bbSPI) Transfer(b byte) (byte, error) {
 	for i := uint8(0); i < 8; i++ {
 
 		// half clock cycle high to start
@@ -65,4 +67,5 @@ func (s *bbSPI) Transfer(b byte) {
 		s.delay()
 
 	}
+	return 0, nil
 }
@@ -6,13 +6,14 @@ package at24cx // import "tinygo.org/x/drivers/at24cx"
 
 import (
 	"errors"
-	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
 )
 
 // Device wraps an I2C connection to a DS3231 device.
 type Device struct {
-	bus               machine.I2C
+	bus               drivers.I2C
 	Address           uint16
 	pageSize          uint16
 	currentRAMAddress uint16
@@ -30,7 +31,7 @@ type Config struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{
 		bus:     bus,
 		Address: Address,
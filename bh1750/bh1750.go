@@ -8,15 +8,17 @@ package bh1750 // import "tinygo.org/x/drivers/bh1750"
 import (
 	"time"
 
-	"machine"
+	"tinygo.org/x/drivers"
 )
 
 // SamplingMode is the sampling's resolution of the measurement
 type SamplingMode byte
 
+var _ drivers.Luxmeter = (*Device)(nil)
+
 // Device wraps an I2C connection to a bh1750 device.
 type Device struct {
-	bus     machine.I2C
+	bus     drivers.I2C
 	Address uint16
 	mode    SamplingMode
 }
@@ -25,7 +27,7 @@ type Device struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{
 		bus:     bus,
 		Address: Address,
@@ -41,16 +43,30 @@ func (d *Device) Configure() {
 
 // RawSensorData returns the raw value from the bh1750
 func (d *Device) RawSensorData() uint16 {
+	raw, _ := d.readRawSensorData()
+	return raw
+}
 
+func (d *Device) readRawSensorData() (uint16, error) {
 	buf := []byte{1, 0}
-	d.bus.Tx(d.Address, nil, buf)
-	return (uint16(buf[0]) << 8) | uint16(buf[1])
+	err := d.bus.Tx(d.Address, nil, buf)
+	return (uint16(buf[0]) << 8) | uint16(buf[1]), err
 }
 
 // Illuminance returns the adjusted value in mlx (milliLux)
 func (d *Device) Illuminance() int32 {
+	lux, _ := d.ReadIlluminance()
+	return lux
+}
+
+// ReadIlluminance returns the adjusted value in mlx (milliLux)
+func (d *Device) ReadIlluminance() (int32, error) {
+	raw, err := d.readRawSensorData()
+	if err != nil {
+		return 0, err
+	}
 
-	lux := uint32(d.RawSensorData())
+	lux := uint32(raw)
 	var coef uint32
 	if d.mode == CONTINUOUS_HIGH_RES_MODE || d.mode == ONE_TIME_HIGH_RES_MODE {
 		coef = HIGH_RES
@@ -61,7 +77,7 @@ func (d *Device) Illuminance() int32 {
 	}
 	// 100 * coef * lux * (5/6)
 	// 5/6 = measurement accuracy as per the datasheet
-	return int32(250 * coef * lux / 3)
+	return int32(250 * coef * lux / 3), nil
 }
 
 // SetMode changes the reading mode for the sensor
@@ -7,8 +7,9 @@
 package bme280
 
 import (
-	"machine"
 	"math"
+
+	"tinygo.org/x/drivers"
 )
 
 // calibrationCoefficients reads at startup and stores the calibration coefficients
@@ -33,9 +34,15 @@ type calibrationCoefficients struct {
 	h6 int8
 }
 
+var (
+	_ drivers.Thermometer = (*Device)(nil)
+	_ drivers.Barometer   = (*Device)(nil)
+	_ drivers.Hygrometer  = (*Device)(nil)
+)
+
 // Device wraps an I2C connection to a BME280 device.
 type Device struct {
-	bus                     machine.I2C
+	bus                     drivers.I2C
 	Address                 uint16
 	calibrationCoefficients calibrationCoefficients
 }
@@ -44,7 +51,7 @@ type Device struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{
 		bus:     bus,
 		Address: Address,
@@ -0,0 +1,76 @@
+package bme280_test
+
+import (
+	"testing"
+
+	"tinygo.org/x/drivers/bme280"
+	"tinygo.org/x/drivers/tester"
+)
+
+// newDevice returns a driver and a fake sensor loaded with the calibration
+// example of the datasheet, section 8.
+func newDevice(t *testing.T) (*bme280.Device, *tester.I2CDevice8, *tester.I2CBus) {
+	bus := tester.NewI2CBus(t)
+	fake := tester.NewI2CDevice8(t, bme280.Address)
+	bus.AddDevice(fake)
+
+	// T1..T3 and P1..P9, little endian
+	calib := []int{27504, 26435, -1000, 36477, -10685, 3024, 2855, 140, -7, 15500, -14600, 6000}
+	for i, v := range calib {
+		fake.SetRegisters(bme280.REG_CALIBRATION+uint8(2*i), byte(v), byte(v>>8))
+	}
+	// H1 = 75, H2 = 362, H3 = 0, H4 = 313, H5 = 50, H6 = 30
+	fake.SetRegisters(bme280.REG_CALIBRATION_H1, 75)
+	fake.SetRegisters(bme280.REG_CALIBRATION_H2LSB, 362&0xFF, 362>>8, 0, 313>>4, 313&0x0F|(50&0x0F)<<4, 50>>4, 30)
+	fake.SetRegisters(bme280.WHO_AM_I, bme280.CHIP_ID)
+
+	// raw pressure 415148, temperature 519888 and humidity 30000
+	fake.SetRegisters(bme280.REG_PRESSURE,
+		415148>>12, 415148>>4&0xFF, 415148<<4&0xF0,
+		519888>>12, 519888>>4&0xFF, 519888<<4&0xF0,
+		30000>>8, 30000&0xFF)
+
+	dev := bme280.New(bus)
+	dev.Configure()
+	return &dev, fake, bus
+}
+
+func TestConfigure(t *testing.T) {
+	dev, fake, bus := newDevice(t)
+	bus.AssertWritten(bme280.Address, bme280.CTRL_HUMIDITY_ADDR, 0x3F)
+	bus.AssertWritten(bme280.Address, bme280.CTRL_MEAS_ADDR, 0xB7)
+	bus.AssertWritten(bme280.Address, bme280.CTRL_CONFIG, 0x00)
+	fake.AssertRegister(bme280.CTRL_MEAS_ADDR, 0xB7)
+	if !dev.Connected() {
+		t.Error("not connected")
+	}
+	fake.Registers[bme280.WHO_AM_I] = 0x58
+	if dev.Connected() {
+		t.Error("BMP280 reported as connected")
+	}
+}
+
+func TestRead(t *testing.T) {
+	dev, _, _ := newDevice(t)
+
+	temp, err := dev.ReadTemperature()
+	if err != nil || temp != 25080 {
+		t.Errorf("temperature: got %d, %v; want 25080", temp, err)
+	}
+	pressure, err := dev.ReadPressure()
+	if err != nil || pressure != 100653000 {
+		t.Errorf("pressure: got %d, %v; want 100653000", pressure, err)
+	}
+	humidity, err := dev.ReadHumidity()
+	if err != nil || humidity != 5500 {
+		t.Errorf("humidity: got %d, %v; want 5500", humidity, err)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	dev, fake, _ := newDevice(t)
+	fake.NACK = true
+	if _, err := dev.ReadTemperature(); err != tester.ErrNACK {
+		t.Errorf("got %v, want %v", err, tester.ErrNACK)
+	}
+}
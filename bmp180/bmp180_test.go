@@ -0,0 +1,89 @@
+package bmp180_test
+
+import (
+	"testing"
+
+	"tinygo.org/x/drivers/bmp180"
+	"tinygo.org/x/drivers/tester"
+)
+
+// sensor is a fake BMP180 that loads the result of the conversion started
+// by a write to the control register, as the temperature and pressure share
+// the output registers.
+type sensor struct {
+	*tester.I2CDevice8
+	temp     []byte
+	pressure []byte
+}
+
+func (s *sensor) WriteRegister(r uint8, buf []byte) error {
+	if err := s.I2CDevice8.WriteRegister(r, buf); err != nil {
+		return err
+	}
+	if r == bmp180.REG_CTRL && len(buf) == 1 {
+		if buf[0] == bmp180.CMD_TEMP {
+			s.SetRegisters(bmp180.REG_TEMP_MSB, s.temp...)
+		} else {
+			s.SetRegisters(bmp180.REG_PRESSURE_MSB, s.pressure...)
+		}
+	}
+	return nil
+}
+
+// newDevice returns a driver and a fake sensor loaded with the calibration
+// example of the datasheet, section 3.5.
+func newDevice(t *testing.T) (*bmp180.Device, *sensor, *tester.I2CBus) {
+	bus := tester.NewI2CBus(t)
+	fake := &sensor{I2CDevice8: tester.NewI2CDevice8(t, bmp180.Address)}
+	bus.AddDevice(fake)
+
+	calib := []int{408, -72, -14383, 32741, 32757, 23153, 6190, 4, -32768, -8711, 2868}
+	for i, v := range calib {
+		fake.SetRegisters(bmp180.AC1_MSB+uint8(2*i), byte(v>>8), byte(v))
+	}
+	fake.SetRegisters(bmp180.WHO_AM_I, bmp180.CHIP_ID)
+
+	// UT = 27898, and UP = 23843 at the lowest oversampling, which is read
+	// with 3 more bits at the highest one used by the driver
+	fake.temp = []byte{27898 >> 8, 27898 & 0xFF}
+	fake.pressure = []byte{0x5D, 0x23, 0x00}
+
+	dev := bmp180.New(bus)
+	dev.Configure()
+	return &dev, fake, bus
+}
+
+func TestConfigure(t *testing.T) {
+	dev, _, bus := newDevice(t)
+	txs := bus.Transactions()
+	if len(txs) != 1 || txs[0].Op != tester.OpReadRegister || txs[0].Reg != bmp180.AC1_MSB || len(txs[0].R) != 22 {
+		t.Errorf("calibration not read: %v", txs)
+	}
+	if !dev.Connected() {
+		t.Error("not connected")
+	}
+}
+
+func TestRead(t *testing.T) {
+	dev, _, bus := newDevice(t)
+
+	temp, err := dev.ReadTemperature()
+	if err != nil || temp != 15000 {
+		t.Errorf("temperature: got %d, %v; want 15000", temp, err)
+	}
+	bus.AssertWritten(bmp180.Address, bmp180.REG_CTRL, bmp180.CMD_TEMP)
+
+	pressure, err := dev.ReadPressure()
+	if err != nil || pressure != 69963000 {
+		t.Errorf("pressure: got %d, %v; want 69963000", pressure, err)
+	}
+	bus.AssertWritten(bmp180.Address, bmp180.REG_CTRL, bmp180.CMD_PRESSURE|byte(bmp180.ULTRAHIGHRESOLUTION)<<6)
+}
+
+func TestReadError(t *testing.T) {
+	dev, fake, _ := newDevice(t)
+	fake.NACK = true
+	if _, err := dev.ReadPressure(); err != tester.ErrNACK {
+		t.Errorf("got %v, want %v", err, tester.ErrNACK)
+	}
+}
@@ -37,6 +37,8 @@
 //
 // Each individual driver is contained within its own sub-package within this package and
 // there are no interdependencies in order to minimize the final size of compiled code that
-// uses any of these drivers.
+// uses any of these drivers. The only shared code is the set of small interfaces in this
+// package, such as I2C and SPI, that drivers accept instead of concrete machine types so
+// they can be used with any bus implementation.
 //
 package drivers // import "tinygo.org/x/drivers"
@@ -5,15 +5,18 @@
 package ds3231 // import "tinygo.org/x/drivers/ds3231"
 
 import (
-	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
 )
 
 type Mode uint8
 
+var _ drivers.Thermometer = (*Device)(nil)
+
 // Device wraps an I2C connection to a DS3231 device.
 type Device struct {
-	bus     machine.I2C
+	bus     drivers.I2C
 	Address uint16
 }
 
@@ -21,7 +24,7 @@ type Device struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{
 		bus:     bus,
 		Address: Address,
@@ -99,7 +102,7 @@ func (d *Device) SetTime(dt time.Time) error {
 
 	data[3] = uint8ToBCD(uint8(dt.Weekday()))
 	data[4] = uint8ToBCD(uint8(dt.Day()))
-	data[5] = uint8ToBCD(uint8(dt.Month()) | centuryFlag)
+	data[5] = uint8ToBCD(uint8(dt.Month())) | centuryFlag
 	data[6] = uint8ToBCD(year)
 
 	err = d.bus.WriteRegister(uint8(d.Address), REG_TIMEDATE, data)
@@ -0,0 +1,101 @@
+package ds3231_test
+
+import (
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/ds3231"
+	"tinygo.org/x/drivers/tester"
+)
+
+func newDevice(t *testing.T) (*ds3231.Device, *tester.I2CDevice8, *tester.I2CBus) {
+	bus := tester.NewI2CBus(t)
+	fake := tester.NewI2CDevice8(t, ds3231.Address)
+	bus.AddDevice(fake)
+	dev := ds3231.New(bus)
+	if !dev.Configure() {
+		t.Fatal("configure failed")
+	}
+	return &dev, fake, bus
+}
+
+func TestSetTime(t *testing.T) {
+	dev, fake, bus := newDevice(t)
+	fake.SetRegisters(ds3231.REG_STATUS, 1<<ds3231.OSF|1<<ds3231.EN32KHZ)
+	if dev.IsTimeValid() {
+		t.Error("time valid after an oscillator stop")
+	}
+
+	err := dev.SetTime(time.Date(2021, time.March, 14, 15, 9, 26, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.AssertWritten(ds3231.Address, ds3231.REG_STATUS, 1<<ds3231.EN32KHZ)
+	bus.AssertWritten(ds3231.Address, ds3231.REG_TIMEDATE, 0x26, 0x09, 0x15, 0x00, 0x14, 0x03, 0x21)
+	if !dev.IsTimeValid() {
+		t.Error("time not valid after SetTime")
+	}
+
+	// the century bit is set in the month register
+	err = dev.SetTime(time.Date(2101, time.December, 31, 23, 59, 58, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.AssertRegister(ds3231.REG_TIMEDATE, 0x58, 0x59, 0x23, 0x06, 0x31, 0x92, 0x01)
+}
+
+func TestReadTime(t *testing.T) {
+	dev, fake, _ := newDevice(t)
+	tests := []struct {
+		regs []byte
+		want time.Time
+	}{
+		{[]byte{0x26, 0x09, 0x15, 0x00, 0x14, 0x03, 0x21}, time.Date(2021, time.March, 14, 15, 9, 26, 0, time.UTC)},
+		{[]byte{0x58, 0x59, 0x23, 0x06, 0x31, 0x92, 0x01}, time.Date(2101, time.December, 31, 23, 59, 58, 0, time.UTC)},
+		// 12-hour mode, 5 PM
+		{[]byte{0x00, 0x30, 0x65, 0x02, 0x01, 0x06, 0x20}, time.Date(2020, time.June, 1, 17, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		fake.SetRegisters(ds3231.REG_TIMEDATE, tt.regs...)
+		got, err := dev.ReadTime()
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("% X: got %v, %v; want %v", tt.regs, got, err, tt.want)
+		}
+	}
+}
+
+func TestRunning(t *testing.T) {
+	dev, fake, _ := newDevice(t)
+	fake.SetRegisters(ds3231.REG_CONTROL, 1<<ds3231.INTCN)
+	if !dev.IsRunning() {
+		t.Error("not running")
+	}
+	if err := dev.SetRunning(false); err != nil {
+		t.Fatal(err)
+	}
+	fake.AssertRegister(ds3231.REG_CONTROL, 1<<ds3231.EOSC|1<<ds3231.INTCN)
+	if dev.IsRunning() {
+		t.Error("running after SetRunning(false)")
+	}
+	if err := dev.SetRunning(true); err != nil {
+		t.Fatal(err)
+	}
+	fake.AssertRegister(ds3231.REG_CONTROL, 1<<ds3231.INTCN)
+}
+
+func TestReadTemperature(t *testing.T) {
+	dev, fake, _ := newDevice(t)
+	fake.SetRegisters(ds3231.REG_TEMP, 0x19, 0x40)
+	temp, err := dev.ReadTemperature()
+	if err != nil || temp != 25250 {
+		t.Errorf("got %d, %v; want 25250", temp, err)
+	}
+	fake.SetRegisters(ds3231.REG_TEMP, 0x19, 0xC0)
+	if temp, _ := dev.ReadTemperature(); temp != 25750 {
+		t.Errorf("got %d, want 25750", temp)
+	}
+	fake.NACK = true
+	if _, err := dev.ReadTemperature(); err != tester.ErrNACK {
+		t.Errorf("got %v, want %v", err, tester.ErrNACK)
+	}
+}
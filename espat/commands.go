@@ -25,6 +25,9 @@ const (
 
 	// Configure UART
 	UARTConfig = "+UART"
+
+	// Read or write the user partitions of the flash
+	SysFlash = "+SYSFLASH"
 )
 
 // WiFi commands.
@@ -100,6 +103,9 @@ const (
 	// Set multiple connections mode
 	TCPMultiple = "+CIPMUX"
 
+	// Show the remote IP and port in "+IPD" messages
+	TCPDataInfo = "+CIPDINFO"
+
 	// Configure as server
 	ServerConfig = "+CIPSERVER"
 
@@ -108,4 +114,10 @@ const (
 
 	// Set timeout when ESP8266/ESP32 runs as TCP server
 	SetServerTimeout = "+CIPSTO"
+
+	// Set SSL client configuration
+	SSLConfig = "+CIPSSLCCONF"
+
+	// Set SSL client Server Name Indication
+	SSLServerName = "+CIPSSLCSNI"
 )
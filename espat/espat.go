@@ -19,38 +19,56 @@
 package espat // import "tinygo.org/x/drivers/espat"
 
 import (
-	"errors"
-	"machine"
-	"strconv"
-	"strings"
-	"time"
+	"io"
 
+	"tinygo.org/x/drivers"
 	"tinygo.org/x/drivers/net"
 )
 
 // Device wraps UART connection to the ESP8266/ESP32.
 type Device struct {
-	bus machine.UART
+	bus drivers.UART
 
 	// command responses that come back from the ESP8266/ESP32
 	response []byte
 
-	// data received from a TCP/UDP connection forwarded by the ESP8266/ESP32
-	socketdata []byte
+	// line being received from the ESP8266/ESP32
+	line []byte
+
+	// link ID and length of the "+IPD" socket data still to be received, the
+	// link ID is -1 when the data is for a link that is not open and is
+	// dropped
+	ipdID, ipdLeft int
+
+	// called for every unsolicited result code received
+	urcHandler func(URC)
+
+	// sockets in use, indexed by link ID
+	sockets [MaxSockets]socket
+
+	// mux is set once the ESP8266/ESP32 is in multiple connection mode
+	mux bool
+
+	// port of the TCP server, 0 when it is not running
+	serverPort int
+
+	// hashes of the certificates written to the PKI partitions
+	pki map[string]uint32
 }
 
 // ActiveDevice is the currently configured Device in use. There can only be one.
 var ActiveDevice *Device
 
-// New returns a new espat driver. Pass in a fully configured UART bus.
-func New(b machine.UART) *Device {
-	return &Device{bus: b, response: make([]byte, 512), socketdata: make([]byte, 0, 1024)}
+// New returns a new espat driver. Pass in a fully configured UART bus, such
+// as a machine.UART.
+func New(b drivers.UART) *Device {
+	return &Device{bus: b, response: make([]byte, 0, 512), line: make([]byte, 0, 256)}
 }
 
 // Configure sets up the device for communication.
-func (d Device) Configure() {
-	ActiveDevice = &d
-	net.ActiveDevice = ActiveDevice
+func (d *Device) Configure() {
+	ActiveDevice = d
+	net.UseDriver(d)
 }
 
 // Connected checks if there is communication with the ESP8266/ESP32.
@@ -99,7 +117,7 @@ func (d Device) Set(cmd, params string) error {
 }
 
 // Version returns the ESP8266/ESP32 firmware version info.
-func (d Device) Version() []byte {
+func (d *Device) Version() []byte {
 	d.Execute(Version)
 	r, err := d.Response(100)
 	if err != nil {
@@ -109,7 +127,7 @@ func (d Device) Version() []byte {
 }
 
 // Echo sets the ESP8266/ESP32 echo setting.
-func (d Device) Echo(set bool) {
+func (d *Device) Echo(set bool) {
 	if set {
 		d.Execute(EchoConfigOn)
 	} else {
@@ -122,101 +140,72 @@ func (d Device) Echo(set bool) {
 // Reset restarts the ESP8266/ESP32 firmware. Due to how the baud rate changes,
 // this messes up communication with the ESP8266/ESP32 module. So make sure you know
 // what you are doing when you call this.
-func (d Device) Reset() {
+func (d *Device) Reset() {
 	d.Execute(Restart)
 	d.Response(100)
 }
 
-// ReadSocket returns the data that has already been read in from the responses.
-func (d *Device) ReadSocket(b []byte) (n int, err error) {
-	// make sure no data in buffer
-	d.Response(300)
+// RecvSocket returns the data for the socket that has already been read in
+// from the responses.
+func (d *Device) RecvSocket(sock net.Socket, b []byte) (n int, err error) {
+	s, err := d.socket(sock)
+	if err != nil {
+		return 0, err
+	}
+
+	// read any data waiting in the UART buffer, without blocking when
+	// there is none so that the caller can honor its deadline
+	if len(s.data) == 0 {
+		d.poll()
+	}
+	if len(s.data) == 0 && len(b) > 0 && s.closed {
+		return 0, io.EOF
+	}
+
+	// UDP datagrams are returned one at a time, once they have been read
+	// from the UART
+	var dg *datagram
+	if s.protocol == net.ProtocolUDP && len(s.datagrams) > 0 {
+		dg = &s.datagrams[0]
+		if len(b) > dg.size {
+			b = b[:dg.size]
+		}
+		if len(s.data) < len(b) {
+			d.poll()
+			if len(s.data) < len(b) {
+				return 0, nil
+			}
+		}
+		s.remoteIP, s.remotePort = dg.ip, dg.port
+	}
 
 	count := len(b)
-	if len(b) >= len(d.socketdata) {
+	if len(b) >= len(s.data) {
 		// copy it all, then clear socket data
-		count = len(d.socketdata)
-		copy(b, d.socketdata[:count])
-		d.socketdata = d.socketdata[:0]
+		count = len(s.data)
+		copy(b, s.data[:count])
+		s.data = s.data[:0]
 	} else {
 		// copy all we can, then keep the remaining socket data around
-		copy(b, d.socketdata[:count])
-		copy(d.socketdata, d.socketdata[count:])
-		d.socketdata = d.socketdata[:len(d.socketdata)-count]
+		copy(b, s.data[:count])
+		copy(s.data, s.data[count:])
+		s.data = s.data[:len(s.data)-count]
 	}
 
-	return count, nil
-}
-
-// Response gets the next response bytes from the ESP8266/ESP32.
-// The call will retry for up to timeout milliseconds before returning nothing.
-func (d *Device) Response(timeout int) ([]byte, error) {
-	// read data
-	var size int
-	var start, end int
-	pause := 100 // pause to wait for 100 ms
-	retries := timeout / pause
-
-	for {
-		size = d.bus.Buffered()
-
-		if size > 0 {
-			end += size
-			d.bus.Read(d.response[start:end])
-
-			// if "+IPD" then read socket data
-			if strings.Contains(string(d.response[:end]), "+IPD") {
-				// handle socket data
-				return nil, d.parseIPD(end)
-			}
-
-			// if "OK" then the command worked
-			if strings.Contains(string(d.response[:end]), "OK") {
-				return d.response[start:end], nil
-			}
-
-			// if "Error" then the command failed
-			if strings.Contains(string(d.response[:end]), "ERROR") {
-				return d.response[start:end], errors.New("response error:" + string(d.response[start:end]))
-			}
-
-			// if anything else, then keep reading data in?
-			start = end
-		}
-
-		// wait longer?
-		retries--
-		if retries == 0 {
-			return nil, errors.New("response timeout error:" + string(d.response[start:end]))
+	if dg != nil {
+		dg.size -= count
+		if dg.size == 0 {
+			s.datagrams = s.datagrams[:copy(s.datagrams, s.datagrams[1:])]
 		}
-
-		time.Sleep(time.Duration(pause) * time.Millisecond)
 	}
+	return count, nil
 }
 
-func (d *Device) parseIPD(end int) error {
-	// find the "+IPD," to get length
-	s := strings.Index(string(d.response[:end]), "+IPD,")
-
-	// find the ":"
-	e := strings.Index(string(d.response[:end]), ":")
-
-	// find the data length
-	val := string(d.response[s+5 : e])
-
-	// TODO: verify count
-	_, err := strconv.Atoi(val)
+// IsSocketDataAvailable returns of there is socket data available
+func (d *Device) IsSocketDataAvailable(sock net.Socket) bool {
+	s, err := d.socket(sock)
 	if err != nil {
-		// not expected data here. what to do?
-		return err
+		return false
 	}
-
-	// load up the socket data
-	d.socketdata = append(d.socketdata, d.response[e+1:end]...)
-	return nil
-}
-
-// IsSocketDataAvailable returns of there is socket data available
-func (d *Device) IsSocketDataAvailable() bool {
-	return len(d.socketdata) > 0 || d.bus.Buffered() > 0
+	return len(s.data) > 0 || d.bus.Buffered() > 0
 }
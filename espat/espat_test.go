@@ -0,0 +1,302 @@
+package espat_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	stdnet "net"
+	"strings"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/espat"
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/tester"
+)
+
+func setup(t *testing.T) (*espat.Device, *tester.ESPAT) {
+	uart := tester.NewUART()
+	sim := tester.NewESPAT(t, uart)
+	t.Cleanup(sim.Close)
+	sim.Networks[0].Password = "pw0"
+	sim.Networks = append(sim.Networks, tester.ESPATNetwork{SSID: `we,ird"net`, Password: "pw", BSSID: "00:11:22:33:44:55", RSSI: -70, Channel: 11, Encryption: 3})
+	d := espat.New(uart)
+	d.Configure()
+	return d, sim
+}
+
+func TestWifi(t *testing.T) {
+	d, sim := setup(t)
+	if !d.Connected() {
+		t.Fatal("not connected")
+	}
+	var urcs []espat.URC
+	d.SetURCHandler(func(u espat.URC) { urcs = append(urcs, u) })
+	if _, err := d.ConnectedAP(); err != espat.ErrNoAP {
+		t.Fatal(err)
+	}
+	if err := d.ConnectToAP("tinygo", "bad", 1); !errors.Is(err, espat.ErrFail) {
+		t.Fatal(err)
+	}
+	if err := d.ConnectToAP("tinygo", "pw0", 1); err != nil {
+		t.Fatal(err)
+	}
+	sim.AssertCommand(`AT+CWJAP="tinygo","pw0"`)
+	if len(urcs) != 2 || urcs[0].Kind != espat.URCWifiConnected || urcs[1].Kind != espat.URCWifiGotIP {
+		t.Fatalf("%+v", urcs)
+	}
+	aps, err := d.ScanNetworks()
+	if err != nil || len(aps) != 2 || aps[1].SSID != `we,ird"net` || aps[1].RSSI != -70 || aps[1].Channel != 11 || aps[1].Encryption != espat.EncryptionWPA2_PSK || aps[1].BSSID != "00:11:22:33:44:55" {
+		t.Fatalf("%+v %v", aps, err)
+	}
+	ap, err := d.ConnectedAP()
+	if err != nil || ap.SSID != "tinygo" || ap.Channel != 6 || ap.RSSI != -50 {
+		t.Fatalf("%+v %v", ap, err)
+	}
+	c, err := d.IPConfig()
+	if err != nil || c.IP != "192.168.1.100" || c.Gateway != "192.168.1.1" || c.Netmask != "255.255.255.0" {
+		t.Fatalf("%+v %v", c, err)
+	}
+	sim.Hosts["example.test"] = "10.1.2.3"
+	ip, err := d.GetDNS("example.test")
+	if err != nil || ip != "10.1.2.3" {
+		t.Fatal(ip, err)
+	}
+	sim.Busy = true
+	if _, err := d.GetDNS("example.test"); err != espat.ErrBusy {
+		t.Fatal(err)
+	}
+	sim.Busy = false
+	if err := d.WriteFlash("client_ca", []byte("hello")); err != nil || string(sim.Flash["client_ca"]) != "hello" {
+		t.Fatal(err)
+	}
+	if err := d.DisconnectFromAP(); err != nil || urcs[len(urcs)-1].Kind != espat.URCWifiDisconnected {
+		t.Fatal(err)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	d, sim := setup(t)
+	if err := d.ConnectToAP("tinygo", "pw0", 1); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		c.Write([]byte("echo " + line))
+		c.Close()
+	}()
+	addr := ln.Addr().(*stdnet.TCPAddr)
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: addr.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hi\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	b, err := io.ReadAll(conn)
+	if err != nil || string(b) != "echo hi\n" {
+		t.Fatalf("%q %v", b, err)
+	}
+	// the connection stays closed once the data has been read
+	if n, err := conn.Read(b); n != 0 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+	sim.AssertCommand("AT+CIPMUX=1")
+	conn.Close()
+}
+
+func TestServer(t *testing.T) {
+	d, sim := setup(t)
+	sim.Listen = func(network, address string) (stdnet.Listener, error) {
+		return stdnet.Listen(network, "127.0.0.1:0")
+	}
+	if err := d.ConnectToAP("tinygo", "pw0", 1); err != nil {
+		t.Fatal(err)
+	}
+	sock, err := d.ListenSocket(net.ProtocolTCP, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := stdnet.Dial("tcp", sim.ServerAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var id net.Socket = net.NoSocket
+	for i := 0; i < 100 && id == net.NoSocket; i++ {
+		id, _ = d.AcceptSocket(sock)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if id == net.NoSocket {
+		t.Fatal("no connection")
+	}
+	c.Write([]byte("ping"))
+	buf := make([]byte, 10)
+	var n int
+	for i := 0; i < 100 && n == 0; i++ {
+		n, _ = d.RecvSocket(id, buf)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("%q", buf[:n])
+	}
+	if host, _, err := d.SocketRemoteAddr(id); err != nil || host != "127.0.0.1" {
+		t.Fatal(host, err)
+	}
+	if _, err := d.SendSocket(id, []byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	n, _ = c.Read(buf)
+	if string(buf[:n]) != "pong" {
+		t.Fatalf("%q", buf[:n])
+	}
+	if err := d.CloseSocket(id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	d, _ := setup(t)
+	if err := d.ConnectToAP("tinygo", "pw0", 1); err != nil {
+		t.Fatal(err)
+	}
+	pc, err := stdnet.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 100)
+		n, addr, err := pc.ReadFrom(buf)
+		if err == nil {
+			pc.WriteTo(append([]byte("re:"), buf[:n]...), addr)
+			time.Sleep(50 * time.Millisecond)
+			pc.WriteTo([]byte("one"), addr)
+			pc.WriteTo([]byte("two"), addr)
+		}
+	}()
+	port := pc.LocalAddr().(*stdnet.UDPAddr).Port
+	sock, _ := d.OpenSocket(net.ProtocolUDP)
+	if err := d.ConnectSocket(sock, "127.0.0.1", port, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SendSocket(sock, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	var n int
+	for i := 0; i < 100 && n == 0; i++ {
+		n, _ = d.RecvSocket(sock, buf)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(buf[:n]) != "re:x" {
+		t.Fatalf("%q", buf[:n])
+	}
+
+	// datagrams are not merged
+	var got []string
+	for i := 0; i < 100 && len(got) < 2; i++ {
+		if n, _ = d.RecvSocket(sock, buf); n > 0 {
+			got = append(got, string(buf[:n]))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Fatalf("%q", got)
+	}
+	d.CloseSocket(sock)
+}
+
+func TestServerLinks(t *testing.T) {
+	uart := tester.NewUART()
+	sim := tester.NewESPAT(t, uart)
+	defer sim.Close()
+	sim.Networks[0].Password = "pw0"
+	sim.Listen = func(network, address string) (stdnet.Listener, error) {
+		return stdnet.Listen(network, "127.0.0.1:0")
+	}
+	d := espat.New(uart)
+	d.Configure()
+	if err := d.ConnectToAP("tinygo", "pw0", 1); err != nil {
+		t.Fatal(err)
+	}
+	sock, err := d.ListenSocket(net.ProtocolTCP, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accept := func() (stdnet.Conn, net.Socket) {
+		c, err := stdnet.Dial("tcp", sim.ServerAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var id net.Socket = net.NoSocket
+		for i := 0; i < 100 && id == net.NoSocket; i++ {
+			id, _ = d.AcceptSocket(sock)
+			time.Sleep(10 * time.Millisecond)
+		}
+		if id == net.NoSocket {
+			t.Fatal("no connection")
+		}
+		return c, id
+	}
+	recv := func(id net.Socket) (string, error) {
+		buf := make([]byte, 10)
+		for i := 0; i < 100; i++ {
+			n, err := d.RecvSocket(id, buf)
+			if n > 0 || err != nil {
+				return string(buf[:n]), err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return "", nil
+	}
+
+	// the peer closes the connection before it is closed by the driver
+	c, id := accept()
+	c.Close()
+	if s, err := recv(id); s != "" || err != io.EOF {
+		t.Fatalf("%q %v", s, err)
+	}
+
+	// data for a closed link is dropped
+	uart.Send([]byte("\r\n+IPD," + string(rune('0'+id)) + ",3:bad"))
+	if s, err := recv(id); s != "" || err != io.EOF {
+		t.Fatalf("%q %v", s, err)
+	}
+
+	// the ESP8266/ESP32 reuses the link ID for the next connection
+	c, reused := accept()
+	defer c.Close()
+	if reused != id {
+		t.Fatalf("link %d reused as %d", id, reused)
+	}
+	c.Write([]byte("ping"))
+	if s, err := recv(id); s != "ping" || err != nil {
+		t.Fatalf("%q %v", s, err)
+	}
+
+	// the link closed by the peer is not closed again
+	c.Close()
+	if s, err := recv(id); s != "" || err != io.EOF {
+		t.Fatalf("%q %v", s, err)
+	}
+	if err := d.CloseSocket(id); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range sim.Commands() {
+		if strings.HasPrefix(cmd, "AT+CIPCLOSE") {
+			t.Fatal(cmd)
+		}
+	}
+}
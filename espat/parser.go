@@ -0,0 +1,344 @@
+package espat
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+)
+
+// Errors returned for the final result codes of the commands that failed.
+var (
+	// ErrCommand is returned when the ESP8266/ESP32 responds "ERROR", such as
+	// for a command it does not know or with invalid parameters.
+	ErrCommand = errors.New("espat: command error")
+
+	// ErrFail is returned when the ESP8266/ESP32 responds "FAIL", such as
+	// when it cannot connect to an access point.
+	ErrFail = errors.New("espat: command failed")
+
+	// ErrBusy is returned when the ESP8266/ESP32 responds "busy p..." or
+	// "busy s...", as it is still processing the previous command. The
+	// command has been discarded and can be sent again later.
+	ErrBusy = errors.New("espat: busy")
+
+	// ErrSendFail is returned when the ESP8266/ESP32 responds "SEND FAIL" to
+	// the data sent after a "+CIPSEND" command.
+	ErrSendFail = errors.New("espat: send failed")
+
+	// ErrTimeout is returned when no final result code is received in time.
+	ErrTimeout = errors.New("espat: response timeout")
+)
+
+// URCKind is the kind of an unsolicited result code.
+type URCKind uint8
+
+const (
+	URCReady               URCKind = iota // "ready", the firmware has started
+	URCWifiConnected                      // "WIFI CONNECTED"
+	URCWifiGotIP                          // "WIFI GOT IP"
+	URCWifiDisconnected                   // "WIFI DISCONNECT"
+	URCConnect                            // "<id>,CONNECT"
+	URCConnectFail                        // "<id>,CONNECT FAIL"
+	URCClosed                             // "<id>,CLOSED"
+	URCStationConnected                   // "+STA_CONNECTED:<mac>"
+	URCStationDisconnected                // "+STA_DISCONNECTED:<mac>"
+	URCStationIP                          // "+DIST_STA_IP:<mac>,<ip>"
+)
+
+// URC is an unsolicited result code, a line sent by the ESP8266/ESP32 on its
+// own to report an event, rather than in response to a command.
+type URC struct {
+	Kind URCKind
+
+	// ID is the link ID of the connection for URCConnect, URCConnectFail and
+	// URCClosed in multiple connection mode, -1 otherwise.
+	ID int
+
+	// Line is the whole line received.
+	Line string
+}
+
+// SetURCHandler sets the function called for every unsolicited result code
+// received. It is called while the responses of the ESP8266/ESP32 are read,
+// so it must not send commands itself, but it can forward the URC to a
+// channel with a non-blocking send.
+func (d *Device) SetURCHandler(h func(URC)) {
+	d.urcHandler = h
+}
+
+// result is what parse found in the bytes received.
+type result uint8
+
+const (
+	resultNone   result = iota // all the bytes buffered have been read
+	resultFinal                // final result code of a command
+	resultPrompt               // ">" prompt to send data
+)
+
+const ipdPrefix = "+IPD,"
+
+// Response gets the next response bytes from the ESP8266/ESP32, up to and
+// including its final result code. The call will wait for up to timeout
+// milliseconds, and returns ErrTimeout if the response is not complete by
+// then. The URCs and socket data received meanwhile are handled, and are not
+// part of the response. The response is only valid until the next command.
+func (d *Device) Response(timeout int) ([]byte, error) {
+	err := d.wait(timeout, false)
+	return d.response, err
+}
+
+// waitPrompt waits up to timeout milliseconds for the ">" prompt sent by the
+// ESP8266/ESP32 when it is ready to receive data.
+func (d *Device) waitPrompt(timeout int) error {
+	return d.wait(timeout, true)
+}
+
+// wait reads the responses of the ESP8266/ESP32 for up to timeout
+// milliseconds, until the final result code of the command, or until the ">"
+// prompt if prompt is set. The result code "OK" that comes before the prompt
+// is skipped.
+func (d *Device) wait(timeout int, prompt bool) error {
+	d.response = d.response[:0]
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	for {
+		r, err := d.parse()
+		switch {
+		case err != nil:
+			return err
+		case r == resultPrompt && prompt, r == resultFinal && !prompt:
+			return nil
+		case r == resultNone:
+			if !time.Now().Before(deadline) {
+				return ErrTimeout
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// poll handles the URCs and the socket data buffered by the UART, without
+// waiting for more.
+func (d *Device) poll() {
+	d.response = d.response[:0]
+	for {
+		if r, err := d.parse(); r == resultNone && err == nil {
+			return
+		}
+	}
+}
+
+// parse reads the bytes buffered by the UART one line at a time, and returns
+// as soon as a final result code or a prompt is found. The "+IPD" socket data
+// is read into the buffer of its socket, and may span several calls.
+func (d *Device) parse() (result, error) {
+	var b [1]byte
+	for {
+		if d.ipdLeft > 0 {
+			if err := d.readIPD(); err != nil || d.ipdLeft > 0 {
+				return resultNone, err
+			}
+		}
+
+		if d.bus.Buffered() == 0 {
+			return resultNone, nil
+		}
+		if _, err := d.bus.Read(b[:]); err != nil {
+			return resultNone, err
+		}
+
+		switch c := b[0]; {
+		case c == '\r':
+		case c == '\n':
+			line := bytes.TrimSpace(d.line)
+			d.line = d.line[:0]
+			if len(line) == 0 {
+				continue
+			}
+			if r, err := d.parseLine(line); r != resultNone {
+				return r, err
+			}
+		case c == '>' && len(d.line) == 0:
+			return resultPrompt, nil
+		case c == ':' && bytes.HasPrefix(d.line, []byte(ipdPrefix)):
+			// the data follows the header without a line ending
+			err := d.parseIPD(d.line)
+			d.line = d.line[:0]
+			if err != nil {
+				return resultNone, err
+			}
+		default:
+			// the end of lines too long to be useful is dropped
+			if len(d.line) < cap(d.line) {
+				d.line = append(d.line, c)
+			}
+		}
+	}
+}
+
+// parseLine handles a URC, or else adds the line to the response and returns
+// resultFinal if it is a final result code.
+func (d *Device) parseLine(line []byte) (result, error) {
+	if d.parseURC(line) {
+		return resultNone, nil
+	}
+
+	d.response = append(d.response, line...)
+	d.response = append(d.response, '\r', '\n')
+	switch {
+	case string(line) == "OK", string(line) == "SEND OK":
+		return resultFinal, nil
+	case string(line) == "ERROR":
+		return resultFinal, ErrCommand
+	case string(line) == "FAIL":
+		return resultFinal, ErrFail
+	case string(line) == "SEND FAIL":
+		return resultFinal, ErrSendFail
+	case bytes.HasPrefix(line, []byte("busy ")):
+		return resultFinal, ErrBusy
+	}
+	return resultNone, nil
+}
+
+// parseURC reports if line is a URC, and handles it.
+func (d *Device) parseURC(line []byte) bool {
+	u := URC{ID: -1}
+	s := line
+	// connection events are prefixed by the link ID in multiple connection
+	// mode
+	if len(s) > 2 && s[1] == ',' && s[0] >= '0' && s[0] < '0'+MaxSockets {
+		u.ID = int(s[0] - '0')
+		s = s[2:]
+	}
+
+	switch {
+	case string(s) == "CONNECT":
+		u.Kind = URCConnect
+	case string(s) == "CONNECT FAIL":
+		u.Kind = URCConnectFail
+	case string(s) == "CLOSED":
+		u.Kind = URCClosed
+	case u.ID >= 0:
+		return false
+	case string(s) == "ready":
+		u.Kind = URCReady
+	case string(s) == "WIFI CONNECTED":
+		u.Kind = URCWifiConnected
+	case string(s) == "WIFI GOT IP":
+		u.Kind = URCWifiGotIP
+	case string(s) == "WIFI DISCONNECT":
+		u.Kind = URCWifiDisconnected
+	case bytes.HasPrefix(s, []byte("+STA_CONNECTED:")):
+		u.Kind = URCStationConnected
+	case bytes.HasPrefix(s, []byte("+STA_DISCONNECTED:")):
+		u.Kind = URCStationDisconnected
+	case bytes.HasPrefix(s, []byte("+DIST_STA_IP:")):
+		u.Kind = URCStationIP
+	default:
+		return false
+	}
+
+	if u.ID >= 0 {
+		d.updateSocket(u)
+	}
+	if d.urcHandler != nil {
+		u.Line = string(line)
+		d.urcHandler(u)
+	}
+	return true
+}
+
+// updateSocket updates the state of the socket of a connection event. A
+// "<id>,CONNECT" is a new connection to the TCP server, to be returned by
+// AcceptSocket, unless it answers the ConnectSocket in progress on the link.
+// The ESP8266/ESP32 only reuses the link ID of a closed connection, so the
+// link is reset even if it is still in use, the CLOSED message of the old
+// connection may have been missed.
+func (d *Device) updateSocket(u URC) {
+	s := &d.sockets[u.ID]
+	switch u.Kind {
+	case URCConnect:
+		if d.serverPort != 0 && !s.connecting {
+			s.inUse = true
+			s.protocol = net.ProtocolTCP
+			s.incoming = true
+			s.closed = false
+			s.data = s.data[:0]
+			s.datagrams = s.datagrams[:0]
+			s.remoteIP, s.remotePort = "", 0
+			s.deadline = time.Time{}
+		}
+	case URCClosed:
+		if s.inUse {
+			s.closed = true
+		}
+	}
+}
+
+// parseIPD parses the header of a "+IPD,<id>,<len>[,<ip>,<port>]:<data>"
+// message, the data is then read into the buffer of its socket. The data of
+// links that are not open is dropped.
+func (d *Device) parseIPD(header []byte) error {
+	vals := strings.Split(string(header[len(ipdPrefix):]), ",")
+	if len(vals) < 2 {
+		return errors.New("invalid +IPD header:" + string(header))
+	}
+	id, err := strconv.Atoi(vals[0])
+	if err != nil || id < 0 || id >= MaxSockets {
+		return errors.New("invalid +IPD link ID:" + vals[0])
+	}
+	size, err := strconv.Atoi(vals[1])
+	if err != nil {
+		return err
+	}
+	s := &d.sockets[id]
+	if !s.inUse || s.closed {
+		d.ipdID, d.ipdLeft = -1, size
+		return nil
+	}
+	var ip string
+	var port int
+	if len(vals) >= 4 {
+		ip = strings.Trim(vals[2], "\"")
+		port, _ = strconv.Atoi(vals[3])
+	}
+	if s.protocol == net.ProtocolUDP {
+		// the sender is set when the datagram is read
+		s.datagrams = append(s.datagrams, datagram{size: size, ip: ip, port: port})
+	} else if ip != "" {
+		s.remoteIP, s.remotePort = ip, port
+	}
+	d.ipdID, d.ipdLeft = id, size
+	return nil
+}
+
+// readIPD reads the socket data of the last "+IPD" message buffered by the
+// UART.
+func (d *Device) readIPD() error {
+	var buf [64]byte
+	for d.ipdLeft > 0 {
+		size := d.bus.Buffered()
+		if size == 0 {
+			return nil
+		}
+		if size > d.ipdLeft {
+			size = d.ipdLeft
+		}
+		if size > len(buf) {
+			size = len(buf)
+		}
+		count, err := d.bus.Read(buf[:size])
+		if err != nil {
+			return err
+		}
+		if d.ipdID >= 0 {
+			s := &d.sockets[d.ipdID]
+			s.data = append(s.data, buf[:count]...)
+		}
+		d.ipdLeft -= count
+	}
+	return nil
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
 )
 
 const (
@@ -12,8 +15,16 @@ const (
 
 	TCPTransferModeNormal      = 0
 	TCPTransferModeUnvarnished = 1
+
+	// MaxSockets is the number of connections the ESP8266/ESP32 supports in
+	// multiple connection mode.
+	MaxSockets = 5
 )
 
+// serverSocket is the handle of the listening socket. The ESP8266/ESP32 only
+// supports a single TCP server, which does not use a link ID.
+const serverSocket net.Socket = MaxSockets
+
 // GetDNS returns the IP address for a domain name.
 func (d *Device) GetDNS(domain string) (string, error) {
 	d.Set(TCPDNSLookup, "\""+domain+"\"")
@@ -32,62 +43,302 @@ func (d *Device) GetDNS(domain string) (string, error) {
 	return res[0], nil
 }
 
-// ConnectTCPSocket creates a new TCP socket connection for the ESP8266/ESP32.
-// Currently only supports single connection mode.
-func (d *Device) ConnectTCPSocket(addr, port string) error {
-	protocol := "TCP"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
-	err := d.Set(TCPConnect, val)
-	if err != nil {
+// socket is the state of one of the ESP8266/ESP32 link IDs.
+type socket struct {
+	inUse    bool
+	protocol net.Protocol
+
+	// set for connections to the server that have not been accepted yet
+	incoming bool
+
+	// set once the connection has been closed by the remote end
+	closed bool
+
+	// set while ConnectSocket waits for the connection to be established
+	connecting bool
+
+	// deadline of the operation on the connection, see SetSocketDeadline
+	deadline time.Time
+
+	// data received from the connection forwarded by the ESP8266/ESP32
+	data []byte
+
+	// sender of the last data received
+	remoteIP   string
+	remotePort int
+
+	// size and sender of the UDP datagrams in data, so that they are read
+	// one at a time
+	datagrams []datagram
+}
+
+type datagram struct {
+	size int
+	ip   string
+	port int
+}
+
+// socket returns the state of an open socket.
+func (d *Device) socket(sock net.Socket) (*socket, error) {
+	if sock < 0 || sock >= MaxSockets || !d.sockets[sock].inUse {
+		return nil, net.ErrInvalidSocket
+	}
+	return &d.sockets[sock], nil
+}
+
+// OpenSocket allocates a free link ID of the ESP8266/ESP32 for a new
+// connection. The first call puts the ESP8266/ESP32 in multiple connection
+// mode.
+func (d *Device) OpenSocket(protocol net.Protocol) (net.Socket, error) {
+	if err := d.enableMux(); err != nil {
+		return net.NoSocket, err
+	}
+
+	for i := range d.sockets {
+		s := &d.sockets[i]
+		if !s.inUse {
+			s.inUse = true
+			s.protocol = protocol
+			s.incoming = false
+			s.closed = false
+			s.data = s.data[:0]
+			s.datagrams = s.datagrams[:0]
+			s.remoteIP, s.remotePort = "", 0
+			s.deadline = time.Time{}
+			return net.Socket(i), nil
+		}
+	}
+	return net.NoSocket, net.ErrNoSocketAvail
+}
+
+// enableMux puts the ESP8266/ESP32 in multiple connection mode, if it is not
+// already. The remote address is also added to the "+IPD" messages, so it can
+// be returned by SocketRemoteAddr.
+func (d *Device) enableMux() error {
+	if d.mux {
+		return nil
+	}
+	if err := d.SetMux(TCPMuxMultiple); err != nil {
 		return err
 	}
-	_, e := d.Response(3000)
-	if e != nil {
-		return e
+	if err := d.Set(TCPDataInfo, "1"); err != nil {
+		return err
 	}
+	if _, err := d.Response(pause); err != nil {
+		return err
+	}
+	d.mux = true
 	return nil
 }
 
-// ConnectUDPSocket creates a new UDP connection for the ESP8266/ESP32.
-func (d *Device) ConnectUDPSocket(addr, sendport, listenport string) error {
-	protocol := "UDP"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + sendport + "," + listenport + ",2"
-	err := d.Set(TCPConnect, val)
+// ListenSocket starts the TCP server of the ESP8266/ESP32 on port. Incoming
+// connections get a link ID of their own, and are returned by AcceptSocket.
+func (d *Device) ListenSocket(protocol net.Protocol, port int) (net.Socket, error) {
+	if protocol != net.ProtocolTCP {
+		return net.NoSocket, errors.New("ListenSocket error: only TCP is supported")
+	}
+	if d.serverPort != 0 {
+		return net.NoSocket, net.ErrNoSocketAvail
+	}
+	if err := d.enableMux(); err != nil {
+		return net.NoSocket, err
+	}
+
+	err := d.Set(ServerConfig, "1,"+strconv.Itoa(port))
+	if err != nil {
+		return net.NoSocket, err
+	}
+	_, err = d.Response(pause)
+	if err != nil {
+		return net.NoSocket, err
+	}
+	d.serverPort = port
+	return serverSocket, nil
+}
+
+// AcceptSocket returns the link ID of the next connection to the TCP server
+// of the ESP8266/ESP32, or net.NoSocket if there is none.
+func (d *Device) AcceptSocket(sock net.Socket) (net.Socket, error) {
+	if sock != serverSocket || d.serverPort == 0 {
+		return net.NoSocket, net.ErrInvalidSocket
+	}
+
+	if id := d.nextIncoming(); id != net.NoSocket {
+		return id, nil
+	}
+
+	// read any pending "<id>,CONNECT" messages
+	d.poll()
+	return d.nextIncoming(), nil
+}
+
+// nextIncoming returns the first connection that has not been accepted yet.
+func (d *Device) nextIncoming() net.Socket {
+	for i := range d.sockets {
+		s := &d.sockets[i]
+		if s.inUse && s.incoming {
+			s.incoming = false
+			return net.Socket(i)
+		}
+	}
+	return net.NoSocket
+}
+
+// ConnectSocket creates a new TCP, UDP or SSL connection on the socket.
+// For UDP, the ESP8266/ESP32 listens to localPort and sends to the remote
+// address of the last message received.
+func (d *Device) ConnectSocket(sock net.Socket, addr string, port, localPort int) error {
+	s, err := d.socket(sock)
 	if err != nil {
 		return err
 	}
-	_, e := d.Response(3000)
-	if e != nil {
-		return e
+
+	val := strconv.Itoa(int(sock)) + ","
+	timeout := 3000
+	switch s.protocol {
+	case net.ProtocolTCP:
+		val += "\"TCP\",\"" + addr + "\"," + strconv.Itoa(port) + ",120"
+	case net.ProtocolUDP:
+		val += "\"UDP\",\"" + addr + "\"," + strconv.Itoa(port) + "," + strconv.Itoa(localPort) + ",2"
+	case net.ProtocolTLS:
+		val += "\"SSL\",\"" + addr + "\"," + strconv.Itoa(port) + ",120"
+		// this operation takes longer, so wait up to 6 seconds to complete.
+		timeout = 6000
+	default:
+		return errors.New("ConnectSocket error: unknown protocol")
 	}
-	return nil
+
+	err = d.Set(TCPConnect, val)
+	if err != nil {
+		return err
+	}
+	s.connecting = true
+	_, err = d.Response(timeout)
+	s.connecting = false
+	return err
+}
+
+// SendSocket sends data over the socket connection.
+func (d *Device) SendSocket(sock net.Socket, b []byte) (n int, err error) {
+	s, err := d.socket(sock)
+	if err != nil {
+		return 0, err
+	}
+
+	val := strconv.Itoa(int(sock)) + "," + strconv.Itoa(len(b))
+	if err := d.Set(TCPSend, val); err != nil {
+		return 0, err
+	}
+	if err := d.waitSocket(s, 2000, true); err != nil {
+		return 0, err
+	}
+	n, err = d.Write(b)
+	if err != nil {
+		return n, err
+	}
+	if err := d.waitSocket(s, 1000, false); err != nil {
+		return n, err
+	}
+	return n, nil
 }
 
-// ConnectSSLSocket creates a new SSL socket connection for the ESP8266/ESP32.
-// Currently only supports single connection mode.
-func (d *Device) ConnectSSLSocket(addr, port string) error {
-	protocol := "SSL"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
-	d.Set(TCPConnect, val)
-	// this operation takes longer, so wait up to 6 seconds to complete.
-	_, err := d.Response(6000)
+// SetSocketDeadline implements net.DeadlineDriver. Sending data gives up
+// waiting for the ESP8266/ESP32 at the deadline, reading does not wait.
+func (d *Device) SetSocketDeadline(sock net.Socket, t time.Time) error {
+	s, err := d.socket(sock)
 	if err != nil {
 		return err
 	}
+	s.deadline = t
 	return nil
 }
 
-// DisconnectSocket disconnects the ESP8266/ESP32 from the current TCP/UDP connection.
-func (d *Device) DisconnectSocket() error {
-	err := d.Execute(TCPClose)
+// waitSocket waits like wait, but no longer than the deadline of the socket.
+func (d *Device) waitSocket(s *socket, timeout int, prompt bool) error {
+	early := false
+	if !s.deadline.IsZero() {
+		left := int(time.Until(s.deadline) / time.Millisecond)
+		if left < timeout {
+			timeout, early = left, true
+		}
+	}
+	err := d.wait(timeout, prompt)
+	if err == ErrTimeout && early {
+		return net.ErrDeadlineExceeded
+	}
+	return err
+}
+
+// SendSocketTo implements net.UDPDriver, the datagram is sent to addr and
+// port rather than to the remote address of the socket.
+func (d *Device) SendSocketTo(sock net.Socket, b []byte, addr string, port int) (n int, err error) {
+	s, err := d.socket(sock)
 	if err != nil {
+		return 0, err
+	}
+	if s.protocol != net.ProtocolUDP {
+		return 0, net.ErrInvalidSocket
+	}
+
+	val := strconv.Itoa(int(sock)) + "," + strconv.Itoa(len(b)) + ",\"" + addr + "\"," + strconv.Itoa(port)
+	if err := d.Set(TCPSend, val); err != nil {
+		return 0, err
+	}
+	if err := d.waitSocket(s, 2000, true); err != nil {
+		return 0, err
+	}
+	n, err = d.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, d.waitSocket(s, 1000, false)
+}
+
+// SocketRemoteAddr returns the sender of the last data received by the
+// socket.
+func (d *Device) SocketRemoteAddr(sock net.Socket) (string, int, error) {
+	s, err := d.socket(sock)
+	if err != nil {
+		return "", 0, err
+	}
+	if s.remoteIP == "" {
+		return "", 0, errors.New("SocketRemoteAddr error: no data received")
+	}
+	return s.remoteIP, s.remotePort, nil
+}
+
+// CloseSocket closes the socket connection and frees its link ID. Closing the
+// listening socket stops the TCP server. Connections already closed by the
+// remote end only free their link ID, the ESP8266/ESP32 has no link to close.
+func (d *Device) CloseSocket(sock net.Socket) error {
+	if sock == serverSocket && d.serverPort != 0 {
+		d.serverPort = 0
+		err := d.Set(ServerConfig, "0")
+		if err != nil {
+			return err
+		}
+		_, err = d.Response(pause)
 		return err
 	}
-	_, e := d.Response(pause)
-	if e != nil {
-		return e
+
+	s, err := d.socket(sock)
+	if err != nil {
+		return err
 	}
-	return nil
+	s.inUse = false
+	s.data = s.data[:0]
+	s.datagrams = s.datagrams[:0]
+	s.deadline = time.Time{}
+	if s.closed {
+		return nil
+	}
+
+	err = d.Set(TCPClose, strconv.Itoa(int(sock)))
+	if err != nil {
+		return err
+	}
+	_, err = d.Response(pause)
+	return err
 }
 
 // SetMux sets the ESP8266/ESP32 current client TCP/UDP configuration for concurrent connections
@@ -120,21 +371,18 @@ func (d *Device) GetTCPTransferMode() ([]byte, error) {
 	return d.Response(pause)
 }
 
-// StartSocketSend gets the ESP8266/ESP32 ready to receive TCP/UDP socket data.
-func (d *Device) StartSocketSend(size int) error {
-	val := strconv.Itoa(size)
-	d.Set(TCPSend, val)
-
-	// when ">" is received, it indicates
-	// ready to receive data
-	r, err := d.Response(2000)
+// StartSocketSend gets the ESP8266/ESP32 ready to receive TCP/UDP socket data
+// for link ID id.
+func (d *Device) StartSocketSend(id, size int) error {
+	val := strconv.Itoa(id) + "," + strconv.Itoa(size)
+	err := d.Set(TCPSend, val)
 	if err != nil {
 		return err
 	}
-	if strings.Contains(string(r), ">") {
-		return nil
-	}
-	return errors.New("StartSocketSend error:" + string(r))
+
+	// when ">" is received, it indicates
+	// ready to receive data
+	return d.waitPrompt(2000)
 }
 
 // EndSocketSend tell the ESP8266/ESP32 the TCP/UDP socket data sending is complete,
@@ -0,0 +1,134 @@
+package espat
+
+import (
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"strconv"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/tls"
+)
+
+// SSL client authentication modes of AT+CIPSSLCCONF.
+const (
+	SSLAuthNone   = 0
+	SSLAuthClient = 1 // load the client certificate and key
+	SSLAuthServer = 2 // load the CA to verify the server
+	SSLAuthBoth   = SSLAuthClient | SSLAuthServer
+)
+
+// PKI partitions of the ESP-AT firmware where the certificates are stored.
+const (
+	PartitionClientCA   = "client_ca"
+	PartitionClientCert = "client_cert"
+	PartitionClientKey  = "client_key"
+)
+
+// ErrPEM is returned by ConfigureTLS for certificates or keys in PEM format,
+// which the firmware cannot read from its PKI partitions.
+var ErrPEM = errors.New("espat: PEM data must be converted with the AtPKI.py tool")
+
+// ConfigureTLS implements tls.DeviceDriver. The ESP-AT firmware reads the
+// certificates from its PKI partitions, so for this driver RootCAs,
+// Certificate and PrivateKey must be in the format generated by the AtPKI.py
+// tool of ESP-AT; plain PEM is rejected with ErrPEM. They are only written to
+// the flash when they change.
+func (d *Device) ConfigureTLS(sock net.Socket, config *tls.Config) error {
+	if _, err := d.socket(sock); err != nil {
+		return err
+	}
+	for _, data := range [][]byte{config.RootCAs, config.Certificate, config.PrivateKey} {
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+			return ErrPEM
+		}
+	}
+	id := strconv.Itoa(int(sock))
+
+	if config.ServerName != "" {
+		err := d.Set(SSLServerName, id+",\""+config.ServerName+"\"")
+		if err != nil {
+			return err
+		}
+		if _, err := d.Response(pause); err != nil {
+			return err
+		}
+	}
+
+	auth := SSLAuthNone
+	if config.RootCAs != nil {
+		if err := d.provisionPKI(PartitionClientCA, config.RootCAs); err != nil {
+			return err
+		}
+		auth |= SSLAuthServer
+	}
+	if config.Certificate != nil || config.PrivateKey != nil {
+		if config.Certificate == nil || config.PrivateKey == nil {
+			return errors.New("ConfigureTLS error: both certificate and key are needed")
+		}
+		if err := d.provisionPKI(PartitionClientCert, config.Certificate); err != nil {
+			return err
+		}
+		if err := d.provisionPKI(PartitionClientKey, config.PrivateKey); err != nil {
+			return err
+		}
+		auth |= SSLAuthClient
+	}
+
+	err := d.Set(SSLConfig, id+","+strconv.Itoa(auth)+",0,0")
+	if err != nil {
+		return err
+	}
+	_, err = d.Response(pause)
+	return err
+}
+
+// provisionPKI writes data to a PKI partition, unless it was already written
+// since the device was configured.
+func (d *Device) provisionPKI(partition string, data []byte) error {
+	h := fnv.New32a()
+	h.Write(data)
+	sum := h.Sum32()
+	if d.pki == nil {
+		d.pki = make(map[string]uint32)
+	}
+	if written, ok := d.pki[partition]; ok && written == sum {
+		return nil
+	}
+
+	if err := d.WriteFlash(partition, data); err != nil {
+		delete(d.pki, partition)
+		return err
+	}
+	d.pki[partition] = sum
+	return nil
+}
+
+// WriteFlash erases a user partition of the ESP32 flash, such as one of the
+// PKI partitions, and writes data to it. The ESP8266 AT firmware does not
+// support it.
+func (d *Device) WriteFlash(partition string, data []byte) error {
+	err := d.Set(SysFlash, "0,\""+partition+"\"")
+	if err != nil {
+		return err
+	}
+	if _, err := d.Response(2000); err != nil {
+		return err
+	}
+
+	err = d.Set(SysFlash, "1,\""+partition+"\",0,"+strconv.Itoa(len(data)))
+	if err != nil {
+		return err
+	}
+
+	// when ">" is received, it indicates
+	// ready to receive data
+	if err := d.waitPrompt(2000); err != nil {
+		return err
+	}
+	if _, err := d.Write(data); err != nil {
+		return err
+	}
+	_, err = d.Response(2000)
+	return err
+}
@@ -0,0 +1,41 @@
+package espat_test
+
+import (
+	"bytes"
+	"testing"
+
+	"tinygo.org/x/drivers/espat"
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/tls"
+)
+
+func TestConfigureTLS(t *testing.T) {
+	d, sim := setup(t)
+	sock, err := d.OpenSocket(net.ProtocolTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PEM data is rejected before anything is sent
+	pem := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+	n := len(sim.Commands())
+	if err := d.ConfigureTLS(sock, &tls.Config{RootCAs: pem}); err != espat.ErrPEM {
+		t.Fatalf("got %v, want %v", err, espat.ErrPEM)
+	}
+	if err := d.ConfigureTLS(sock, &tls.Config{Certificate: []byte("cert"), PrivateKey: append([]byte("\n"), pem...)}); err != espat.ErrPEM {
+		t.Fatalf("got %v, want %v", err, espat.ErrPEM)
+	}
+	if len(sim.Commands()) != n {
+		t.Fatalf("commands sent: %q", sim.Commands()[n:])
+	}
+
+	ca := []byte("\x01\x02\x03 generated by AtPKI.py")
+	if err := d.ConfigureTLS(sock, &tls.Config{ServerName: "example.com", RootCAs: ca}); err != nil {
+		t.Fatal(err)
+	}
+	sim.AssertCommand(`AT+CIPSSLCSNI=0,"example.com"`)
+	sim.AssertCommand(`AT+CIPSSLCCONF=0,2,0,0`)
+	if !bytes.Equal(sim.Flash[espat.PartitionClientCA], ca) {
+		t.Fatalf("%q", sim.Flash[espat.PartitionClientCA])
+	}
+}
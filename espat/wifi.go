@@ -1,7 +1,9 @@
 package espat
 
 import (
+	"errors"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -15,6 +17,72 @@ const (
 	WifiAPSecurityWPA_WPA2_PSK = 4
 )
 
+// ErrNoAP is returned by ConnectedAP when the ESP8266/ESP32 is not connected
+// to an access point.
+var ErrNoAP = errors.New("espat: not connected to an access point")
+
+// EncryptionType is the encryption of an access point, as reported by the
+// ESP8266/ESP32.
+type EncryptionType uint8
+
+const (
+	EncryptionOpen EncryptionType = iota
+	EncryptionWEP
+	EncryptionWPA_PSK
+	EncryptionWPA2_PSK
+	EncryptionWPA_WPA2_PSK
+	EncryptionWPA2_Enterprise
+	EncryptionWPA3_PSK
+	EncryptionWPA2_WPA3_PSK
+)
+
+func (e EncryptionType) String() string {
+	switch e {
+	case EncryptionOpen:
+		return "Open"
+	case EncryptionWEP:
+		return "WEP"
+	case EncryptionWPA_PSK:
+		return "WPA"
+	case EncryptionWPA2_PSK:
+		return "WPA2"
+	case EncryptionWPA_WPA2_PSK:
+		return "WPA/WPA2"
+	case EncryptionWPA2_Enterprise:
+		return "WPA2 Enterprise"
+	case EncryptionWPA3_PSK:
+		return "WPA3"
+	case EncryptionWPA2_WPA3_PSK:
+		return "WPA2/WPA3"
+	default:
+		return "Unknown"
+	}
+}
+
+// AccessPoint is a wifi network seen by the ESP8266/ESP32.
+type AccessPoint struct {
+	SSID       string
+	BSSID      string // MAC address, such as "aa:bb:cc:dd:ee:ff"
+	RSSI       int32  // dBm
+	Channel    uint8
+	Encryption EncryptionType
+}
+
+// IPConfig is the IP configuration of the station or of the access point of
+// the ESP8266/ESP32.
+type IPConfig struct {
+	IP      string
+	Gateway string
+	Netmask string
+}
+
+// Station is a client connected to the ESP8266/ESP32 acting as an access
+// point.
+type Station struct {
+	IP  string
+	MAC string
+}
+
 // GetWifiMode returns the ESP8266/ESP32 wifi mode.
 func (d *Device) GetWifiMode() ([]byte, error) {
 	d.Query(WifiMode)
@@ -50,6 +118,74 @@ func (d *Device) ConnectToAP(ssid, pwd string, ws int) error {
 	return nil
 }
 
+// ScanNetworks returns the access points in range of the ESP8266/ESP32.
+// Scanning all the channels takes a few seconds.
+func (d *Device) ScanNetworks() ([]AccessPoint, error) {
+	if err := d.Execute(ListAP); err != nil {
+		return nil, err
+	}
+	r, err := d.Response(10000)
+	if err != nil {
+		return nil, err
+	}
+
+	var aps []AccessPoint
+	for _, line := range lines(r) {
+		// +CWLAP:(<ecn>,<ssid>,<rssi>,<mac>,<channel>,...)
+		v, ok := param(line, ListAP)
+		if !ok {
+			continue
+		}
+		f := fields(strings.TrimSuffix(strings.TrimPrefix(v, "("), ")"))
+		if len(f) < 5 {
+			continue
+		}
+		ecn, _ := strconv.Atoi(f[0])
+		rssi, _ := strconv.Atoi(f[2])
+		ch, _ := strconv.Atoi(f[4])
+		aps = append(aps, AccessPoint{
+			SSID:       f[1],
+			BSSID:      f[3],
+			RSSI:       int32(rssi),
+			Channel:    uint8(ch),
+			Encryption: EncryptionType(ecn),
+		})
+	}
+	return aps, nil
+}
+
+// ConnectedAP returns the access point the ESP8266/ESP32 is connected to as
+// a client, or ErrNoAP. The encryption of the access point is not reported
+// by the ESP8266/ESP32, so it is left to EncryptionOpen.
+func (d *Device) ConnectedAP() (AccessPoint, error) {
+	d.Query(ConnectAP)
+	r, err := d.Response(1000)
+	if err != nil {
+		return AccessPoint{}, err
+	}
+
+	for _, line := range lines(r) {
+		// +CWJAP:<ssid>,<bssid>,<channel>,<rssi>,...
+		v, ok := param(line, ConnectAP)
+		if !ok {
+			continue
+		}
+		f := fields(v)
+		if len(f) < 4 {
+			continue
+		}
+		ch, _ := strconv.Atoi(f[2])
+		rssi, _ := strconv.Atoi(f[3])
+		return AccessPoint{
+			SSID:    f[0],
+			BSSID:   f[1],
+			RSSI:    int32(rssi),
+			Channel: uint8(ch),
+		}, nil
+	}
+	return AccessPoint{}, ErrNoAP
+}
+
 // DisconnectFromAP disconnects the ESP8266/ESP32 from the current access point.
 func (d *Device) DisconnectFromAP() error {
 	d.Execute(Disconnect)
@@ -64,6 +200,12 @@ func (d *Device) GetClientIP() (string, error) {
 	return string(r), err
 }
 
+// IPConfig returns the IP configuration of the ESP8266/ESP32 as a client,
+// once connected to an access point.
+func (d *Device) IPConfig() (IPConfig, error) {
+	return d.ipConfig(SetStationIP)
+}
+
 // SetClientIP sets the ESP8266/ESP32 current client IP addess when connected to an Access Point.
 func (d *Device) SetClientIP(ipaddr string) error {
 	val := "\"" + ipaddr + "\""
@@ -100,6 +242,33 @@ func (d *Device) GetAPClients() (string, error) {
 	return string(r), err
 }
 
+// Stations returns the clients connected to the ESP8266/ESP32 when acting as
+// an Access Point.
+func (d *Device) Stations() ([]Station, error) {
+	if err := d.Execute(ListConnectedIP); err != nil {
+		return nil, err
+	}
+	r, err := d.Response(1000)
+	if err != nil {
+		return nil, err
+	}
+
+	var stations []Station
+	for _, line := range lines(r) {
+		// +CWLIF:<ip>,<mac>, without the prefix on the ESP8266
+		v, ok := param(line, ListConnectedIP)
+		if !ok {
+			v = line
+		}
+		f := fields(v)
+		if len(f) < 2 {
+			continue
+		}
+		stations = append(stations, Station{IP: f[0], MAC: f[1]})
+	}
+	return stations, nil
+}
+
 // GetAPIP returns the ESP8266/ESP32 current IP addess when configured as an Access Point.
 func (d *Device) GetAPIP() (string, error) {
 	d.Query(SetSoftAPIPCurrent)
@@ -107,6 +276,44 @@ func (d *Device) GetAPIP() (string, error) {
 	return string(r), err
 }
 
+// APIPConfig returns the IP configuration of the ESP8266/ESP32 when
+// configured as an Access Point.
+func (d *Device) APIPConfig() (IPConfig, error) {
+	return d.ipConfig(SetSoftAPIPCurrent)
+}
+
+// ipConfig queries the IP configuration with the +CIPSTA or +CIPAP command.
+func (d *Device) ipConfig(cmd string) (IPConfig, error) {
+	d.Query(cmd)
+	r, err := d.Response(1000)
+	if err != nil {
+		return IPConfig{}, err
+	}
+
+	var c IPConfig
+	for _, line := range lines(r) {
+		// +CIPSTA:<name>:<"value">
+		v, ok := param(line, cmd)
+		if !ok {
+			continue
+		}
+		i := strings.IndexByte(v, ':')
+		if i < 0 {
+			continue
+		}
+		value := strings.Trim(v[i+1:], "\"")
+		switch v[:i] {
+		case "ip":
+			c.IP = value
+		case "gateway":
+			c.Gateway = value
+		case "netmask":
+			c.Netmask = value
+		}
+	}
+	return c, nil
+}
+
 // SetAPIP sets the ESP8266/ESP32 current IP addess when configured as an Access Point.
 func (d *Device) SetAPIP(ipaddr string) error {
 	val := "\"" + ipaddr + "\""
@@ -152,3 +359,47 @@ func (d *Device) SetAPIPFlash(ipaddr string) error {
 	_, err := d.Response(500)
 	return err
 }
+
+// lines splits a response into its lines.
+func lines(r []byte) []string {
+	return strings.Split(strings.TrimSpace(string(r)), "\r\n")
+}
+
+// param returns the parameters of a response line of the command cmd, such
+// as the "1,2" of "+CMD:1,2". The "_CUR" suffix of the ESP8266 commands is
+// accepted too.
+func param(line, cmd string) (string, bool) {
+	if !strings.HasPrefix(line, cmd) {
+		return "", false
+	}
+	line = strings.TrimPrefix(line[len(cmd):], "_CUR")
+	if !strings.HasPrefix(line, ":") {
+		return "", false
+	}
+	return line[1:], true
+}
+
+// fields splits the parameters of a response line, such as `3,"a,b",-60`,
+// and removes the quotes around strings. The ESP-AT firmware escapes the
+// quotes and commas in strings with a backslash.
+func fields(s string) []string {
+	var f []string
+	var b []byte
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(s):
+			i++
+			b = append(b, s[i])
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			f = append(f, string(b))
+			b = b[:0]
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(f, string(b))
+}
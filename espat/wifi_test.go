@@ -0,0 +1,23 @@
+package espat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFields(t *testing.T) {
+	got := fields(`3,"a\,b\"c",-60,"aa:bb:cc:dd:ee:ff",6,7`)
+	want := []string{"3", `a,b"c`, "-60", "aa:bb:cc:dd:ee:ff", "6", "7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%q", got)
+	}
+	if v, ok := param("+CIPSTA_CUR:ip:\"1.2.3.4\"", "+CIPSTA"); !ok || v != `ip:"1.2.3.4"` {
+		t.Fatal(v)
+	}
+	if _, ok := param("+CIPSTAMAC:x", "+CIPSTA"); ok {
+		t.Fatal("mac")
+	}
+	if l := lines([]byte("a\r\nb\r\nOK\r\n")); len(l) != 3 {
+		t.Fatal(l)
+	}
+}
@@ -63,6 +63,8 @@ func main() {
 	data := make([]byte, 50)
 	blink := true
 	for {
+		// wait for data up to the next blink of the LED
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
 		n, _ := conn.Read(data)
 		if n > 0 {
 			println(string(data[:n]))
@@ -74,7 +76,6 @@ func main() {
 		} else {
 			readyled.Low()
 		}
-		time.Sleep(500 * time.Millisecond)
 	}
 
 	// Right now this code is never reached. Need a way to trigger it...
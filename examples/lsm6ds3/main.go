@@ -19,9 +19,9 @@ func main() {
 	}
 
 	for {
-		x, y, z := accel.ReadAcceleration()
+		x, y, z, _ := accel.ReadAcceleration()
 		println("Acceleration:", float32(x)/1000000, float32(y)/1000000, float32(z)/1000000)
-		x, y, z = accel.ReadRotation()
+		x, y, z, _ = accel.ReadRotation()
 		println("Gyroscope:", float32(x)/1000000, float32(y)/1000000, float32(z)/1000000)
 		x, _ = accel.ReadTemperature()
 		println("Degrees C", float32(x)/1000, "\n\n")
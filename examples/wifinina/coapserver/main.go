@@ -0,0 +1,119 @@
+// This example is a CoAP server using a device with WiFiNINA firmware. It
+// serves the LED of the board at "/led", which can be read with GET and set
+// with a PUT of "0" or "1", and an uptime counter at "/uptime" that can be
+// observed, for example with:
+//
+//	coap-client -m get -s 60 coap://<ip>/uptime
+package main
+
+import (
+	"machine"
+	"strconv"
+	"time"
+
+	"tinygo.org/x/drivers/net/coap"
+	"tinygo.org/x/drivers/wifinina"
+)
+
+// access point info
+const ssid = ""
+const pass = ""
+
+var (
+
+	// these are the default pins for the Arduino Nano33 IoT.
+	spi = machine.NINA_SPI
+
+	// this is the ESP chip that has the WIFININA firmware flashed on it
+	adaptor = &wifinina.Device{
+		SPI:   spi,
+		CS:    machine.NINA_CS,
+		ACK:   machine.NINA_ACK,
+		GPIO0: machine.NINA_GPIO0,
+		RESET: machine.NINA_RESETN,
+	}
+
+	led = machine.LED
+)
+
+func main() {
+
+	// Configure SPI for 8Mhz, Mode 0, MSB First
+	spi.Configure(machine.SPIConfig{
+		Frequency: 8 * 1e6,
+		MOSI:      machine.NINA_MOSI,
+		MISO:      machine.NINA_MISO,
+		SCK:       machine.NINA_SCK,
+	})
+
+	adaptor.Configure()
+	led.Configure(machine.PinConfig{Mode: machine.PinOutput})
+
+	connectToAP()
+
+	server, err := coap.Listen(":5683")
+	if err != nil {
+		failMessage(err.Error())
+	}
+	start := time.Now()
+	server.Handle("/led", func(resp, req *coap.Message) {
+		switch req.Code {
+		case coap.GET:
+		case coap.PUT:
+			led.Set(string(req.Payload) == "1")
+			resp.Code = coap.Changed
+		default:
+			resp.Code = coap.MethodNotAllowed
+			return
+		}
+		if led.Get() {
+			resp.Payload = []byte("1")
+		} else {
+			resp.Payload = []byte("0")
+		}
+	})
+	server.Handle("/uptime", func(resp, req *coap.Message) {
+		resp.SetUint(coap.ContentFormat, uint32(coap.TextPlain))
+		resp.Payload = []byte(strconv.Itoa(int(time.Since(start).Seconds())))
+	})
+
+	message("Serving on port 5683")
+	next := time.Now()
+	for {
+		if err := server.Poll(); err != nil {
+			message(err.Error())
+		}
+		if time.Now().After(next) {
+			server.Notify("/uptime")
+			next = next.Add(10 * time.Second)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// connect to access point
+func connectToAP() {
+	time.Sleep(2 * time.Second)
+	message("Connecting to " + ssid)
+	adaptor.SetPassphrase(ssid, pass)
+	for st, _ := adaptor.GetConnectionStatus(); st != wifinina.StatusConnected; {
+		message("Connection status: " + st.String())
+		time.Sleep(1 * time.Second)
+		st, _ = adaptor.GetConnectionStatus()
+	}
+	message("Connected.")
+	if ip, _, _, err := adaptor.GetIP(); err == nil {
+		message("IP address: " + ip.String())
+	}
+}
+
+func message(msg string) {
+	println(msg, "\r")
+}
+
+func failMessage(msg string) {
+	for {
+		println(msg)
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -0,0 +1,125 @@
+// This example advertises a device with WiFiNINA firmware on the local
+// network with multicast DNS, so that it can be reached as "tinygo.local",
+// along with an "_http._tcp" service. Every 30 seconds it lists the other
+// web servers found on the network. It can be checked with:
+//
+//	ping tinygo.local
+//	avahi-browse -r _http._tcp
+package main
+
+import (
+	"machine"
+	"strconv"
+	"time"
+
+	"tinygo.org/x/drivers/net/mdns"
+	"tinygo.org/x/drivers/wifinina"
+)
+
+// access point info
+const ssid = ""
+const pass = ""
+
+const hostname = "tinygo"
+
+var (
+
+	// these are the default pins for the Arduino Nano33 IoT.
+	spi = machine.NINA_SPI
+
+	// this is the ESP chip that has the WIFININA firmware flashed on it
+	adaptor = &wifinina.Device{
+		SPI:   spi,
+		CS:    machine.NINA_CS,
+		ACK:   machine.NINA_ACK,
+		GPIO0: machine.NINA_GPIO0,
+		RESET: machine.NINA_RESETN,
+	}
+)
+
+func main() {
+
+	// Configure SPI for 8Mhz, Mode 0, MSB First
+	spi.Configure(machine.SPIConfig{
+		Frequency: 8 * 1e6,
+		MOSI:      machine.NINA_MOSI,
+		MISO:      machine.NINA_MISO,
+		SCK:       machine.NINA_SCK,
+	})
+
+	adaptor.Configure()
+
+	// the hostname is sent to the DHCP server when connecting
+	adaptor.SetHostname(hostname)
+	ip := connectToAP()
+
+	responder, err := mdns.Listen(hostname, ip.String())
+	if err != nil {
+		failMessage(err.Error())
+	}
+	err = responder.AddService(mdns.Service{
+		Instance: "TinyGo web server",
+		Service:  "_http._tcp",
+		Port:     80,
+		Text:     []string{"path=/"},
+	})
+	if err != nil {
+		failMessage(err.Error())
+	}
+	message("Advertised as " + responder.Hostname())
+
+	next := time.Now()
+	for {
+		if err := responder.Poll(); err != nil {
+			message(err.Error())
+		}
+		if time.Now().After(next) {
+			browse(responder)
+			next = time.Now().Add(30 * time.Second)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// browse lists the web servers on the network.
+func browse(responder *mdns.Responder) {
+	found, err := responder.Browse("_http._tcp", 2*time.Second)
+	if err != nil {
+		message(err.Error())
+		return
+	}
+	for _, in := range found {
+		message(in.Name + " at " + in.Host + " (" + in.IP + "):" + strconv.Itoa(int(in.Port)))
+	}
+}
+
+// connect to access point
+func connectToAP() wifinina.IPAddress {
+	time.Sleep(2 * time.Second)
+	message("Connecting to " + ssid)
+	adaptor.SetPassphrase(ssid, pass)
+	for st, _ := adaptor.GetConnectionStatus(); st != wifinina.StatusConnected; {
+		message("Connection status: " + st.String())
+		time.Sleep(1 * time.Second)
+		st, _ = adaptor.GetConnectionStatus()
+	}
+	message("Connected.")
+	ip, _, _, err := adaptor.GetIP()
+	for ; err != nil; ip, _, _, err = adaptor.GetIP() {
+		message(err.Error())
+		time.Sleep(1 * time.Second)
+	}
+	message("IP address: " + ip.String())
+	return ip
+}
+
+func message(msg string) {
+	println(msg, "\r")
+}
+
+func failMessage(msg string) {
+	for {
+		println(msg)
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -0,0 +1,96 @@
+// This example gets the time from an NTP server using a device with WiFiNINA
+// firmware, and sets a DS3231 real-time clock connected to I2C0 with it.
+package main
+
+import (
+	"machine"
+	"time"
+
+	"tinygo.org/x/drivers/ds3231"
+	"tinygo.org/x/drivers/net/sntp"
+	"tinygo.org/x/drivers/wifinina"
+)
+
+// access point info
+const ssid = ""
+const pass = ""
+
+var (
+
+	// these are the default pins for the Arduino Nano33 IoT.
+	spi = machine.NINA_SPI
+
+	// this is the ESP chip that has the WIFININA firmware flashed on it
+	adaptor = &wifinina.Device{
+		SPI:   spi,
+		CS:    machine.NINA_CS,
+		ACK:   machine.NINA_ACK,
+		GPIO0: machine.NINA_GPIO0,
+		RESET: machine.NINA_RESETN,
+	}
+)
+
+func main() {
+
+	// Configure SPI for 8Mhz, Mode 0, MSB First
+	spi.Configure(machine.SPIConfig{
+		Frequency: 8 * 1e6,
+		MOSI:      machine.NINA_MOSI,
+		MISO:      machine.NINA_MISO,
+		SCK:       machine.NINA_SCK,
+	})
+
+	adaptor.Configure()
+
+	machine.I2C0.Configure(machine.I2CConfig{})
+	rtc := ds3231.New(machine.I2C0)
+	rtc.Configure()
+
+	connectToAP()
+
+	client := &sntp.Client{Server: sntp.DefaultServer}
+	for {
+		resp, err := client.Query()
+		if err != nil {
+			message(err.Error())
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		message("Time: " + resp.Now().UTC().String() + ", round trip: " + resp.RTT.String())
+
+		if err := rtc.SetTime(resp.Now().UTC()); err != nil {
+			message(err.Error())
+		}
+		if !rtc.IsRunning() {
+			rtc.SetRunning(true)
+		}
+		break
+	}
+
+	for {
+		dt, err := rtc.ReadTime()
+		if err != nil {
+			message(err.Error())
+		} else {
+			message("RTC: " + dt.String())
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// connect to access point
+func connectToAP() {
+	time.Sleep(2 * time.Second)
+	message("Connecting to " + ssid)
+	adaptor.SetPassphrase(ssid, pass)
+	for st, _ := adaptor.GetConnectionStatus(); st != wifinina.StatusConnected; {
+		message("Connection status: " + st.String())
+		time.Sleep(1 * time.Second)
+		st, _ = adaptor.GetConnectionStatus()
+	}
+	message("Connected.")
+}
+
+func message(msg string) {
+	println(msg, "\r")
+}
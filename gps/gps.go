@@ -6,6 +6,8 @@ import (
 	"machine"
 	"strings"
 	"time"
+
+	"tinygo.org/x/drivers"
 )
 
 // Device wraps a connection to a GPS device.
@@ -14,7 +16,7 @@ type GPSDevice struct {
 	bufIdx   int
 	sentence strings.Builder
 	uart     *machine.UART
-	bus      *machine.I2C
+	bus      drivers.I2C
 	address  uint16
 }
 
@@ -29,7 +31,7 @@ func NewUART(uart *machine.UART) GPSDevice {
 }
 
 // NewI2C creates a new I2C GPS connection.
-func NewI2C(bus *machine.I2C) GPSDevice {
+func NewI2C(bus drivers.I2C) GPSDevice {
 	return GPSDevice{
 		bus:      bus,
 		address:  I2C_ADDRESS,
@@ -7,8 +7,10 @@ package hub75 // import "tinygo.org/x/drivers/hub75"
 
 import (
 	"image/color"
-	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 )
 
 type Config struct {
@@ -21,13 +23,13 @@ type Config struct {
 }
 
 type Device struct {
-	bus               machine.SPI
-	a                 machine.Pin
-	b                 machine.Pin
-	c                 machine.Pin
-	d                 machine.Pin
-	oe                machine.Pin
-	lat               machine.Pin
+	bus               drivers.SPI
+	a                 drivers.Pin
+	b                 drivers.Pin
+	c                 drivers.Pin
+	d                 drivers.Pin
+	oe                drivers.Pin
+	lat               drivers.Pin
 	width             int16
 	height            int16
 	brightness        uint8
@@ -52,13 +54,13 @@ type Device struct {
 }
 
 // New returns a new HUB75 driver. Pass in a fully configured SPI bus.
-func New(b machine.SPI, latPin, oePin, aPin, bPin, cPin, dPin machine.Pin) Device {
-	aPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	bPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	cPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	dPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	oePin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	latPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
+func New(b drivers.SPI, latPin, oePin, aPin, bPin, cPin, dPin drivers.Pin) Device {
+	pin.ConfigureOutput(aPin)
+	pin.ConfigureOutput(bPin)
+	pin.ConfigureOutput(cPin)
+	pin.ConfigureOutput(dPin)
+	pin.ConfigureOutput(oePin)
+	pin.ConfigureOutput(latPin)
 
 	return Device{
 		bus: b,
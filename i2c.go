@@ -0,0 +1,19 @@
+package drivers
+
+// I2C represents an I2C bus. It is notably implemented by the machine.I2C
+// type, but can also be implemented by a software (bit-banged) bus, a bus
+// multiplexer or a fake bus for testing.
+type I2C interface {
+	// Tx performs a write and then a read transfer placing the result in r.
+	// Either w or r may be nil, in which case only a read or a write
+	// transfer is done.
+	Tx(addr uint16, w, r []byte) error
+
+	// ReadRegister transmits the register, restarts the connection as a read
+	// operation, and reads the response into buf.
+	ReadRegister(addr uint8, r uint8, buf []byte) error
+
+	// WriteRegister transmits first the register and then the data to the
+	// peripheral device.
+	WriteRegister(addr uint8, r uint8, buf []byte) error
+}
@@ -0,0 +1,27 @@
+// +build baremetal
+
+// Package pin configures the pins given to the drivers as a drivers.Pin.
+// Only machine pins are configured, the other implementations of
+// drivers.Pin, like the pins of an I/O expander or the fake pins of the
+// tester package, must be configured by the caller.
+package pin // import "tinygo.org/x/drivers/internal/pin"
+
+import (
+	"machine"
+
+	"tinygo.org/x/drivers"
+)
+
+// ConfigureOutput configures p as an output, if it is a machine.Pin.
+func ConfigureOutput(p drivers.Pin) {
+	if p, ok := p.(machine.Pin); ok {
+		p.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	}
+}
+
+// ConfigureInput configures p as an input, if it is a machine.Pin.
+func ConfigureInput(p drivers.Pin) {
+	if p, ok := p.(machine.Pin); ok {
+		p.Configure(machine.PinConfig{Mode: machine.PinInput})
+	}
+}
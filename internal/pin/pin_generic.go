@@ -0,0 +1,12 @@
+// +build !baremetal
+
+package pin // import "tinygo.org/x/drivers/internal/pin"
+
+import "tinygo.org/x/drivers"
+
+// ConfigureOutput does nothing on the host, where the pins are fakes that do
+// not need to be configured.
+func ConfigureOutput(p drivers.Pin) {}
+
+// ConfigureInput does nothing on the host.
+func ConfigureInput(p drivers.Pin) {}
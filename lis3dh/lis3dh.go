@@ -4,13 +4,13 @@
 //
 package lis3dh // import "tinygo.org/x/drivers/lis3dh"
 
-import (
-	"machine"
-)
+import "tinygo.org/x/drivers"
+
+var _ drivers.Accelerometer = (*Device)(nil)
 
 // Device wraps an I2C connection to a LIS3DH device.
 type Device struct {
-	bus     machine.I2C
+	bus     drivers.I2C
 	Address uint16
 	r       Range
 }
@@ -18,7 +18,7 @@ type Device struct {
 // New creates a new LIS3DH connection. The I2C bus must already be configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{bus: bus, Address: Address0}
 }
 
@@ -0,0 +1,76 @@
+package lis3dh_test
+
+import (
+	"testing"
+
+	"tinygo.org/x/drivers/lis3dh"
+	"tinygo.org/x/drivers/tester"
+)
+
+// sensor is a fake LIS3DH. The top bit of a register address enables the
+// auto-increment of multi-byte reads, it is not part of the address.
+type sensor struct {
+	*tester.I2CDevice8
+}
+
+func (s sensor) ReadRegister(r uint8, buf []byte) error {
+	return s.I2CDevice8.ReadRegister(r&0x7F, buf)
+}
+
+func (s sensor) WriteRegister(r uint8, buf []byte) error {
+	return s.I2CDevice8.WriteRegister(r&0x7F, buf)
+}
+
+func newDevice(t *testing.T) (*lis3dh.Device, sensor, *tester.I2CBus) {
+	bus := tester.NewI2CBus(t)
+	fake := sensor{tester.NewI2CDevice8(t, lis3dh.Address0)}
+	fake.SetRegisters(lis3dh.WHO_AM_I, 0x33)
+	bus.AddDevice(fake)
+	dev := lis3dh.New(bus)
+	dev.Configure()
+	return &dev, fake, bus
+}
+
+func TestConfigure(t *testing.T) {
+	dev, fake, bus := newDevice(t)
+	bus.AssertWritten(lis3dh.Address0, lis3dh.REG_CTRL1, 0x07)
+	bus.AssertWritten(lis3dh.Address0, lis3dh.REG_CTRL4, 0x88)
+	// all axes enabled at 400 Hz
+	fake.AssertRegister(lis3dh.REG_CTRL1, 0x77)
+	if !dev.Connected() {
+		t.Error("not connected")
+	}
+	if r := dev.ReadRange(); r != lis3dh.RANGE_2_G {
+		t.Errorf("got range %d, want %d", r, lis3dh.RANGE_2_G)
+	}
+
+	dev.SetDataRate(lis3dh.DATARATE_10_HZ)
+	fake.AssertRegister(lis3dh.REG_CTRL1, 0x27)
+	dev.SetRange(lis3dh.RANGE_8_G)
+	fake.AssertRegister(lis3dh.REG_CTRL4, 0xA8)
+	if r := dev.ReadRange(); r != lis3dh.RANGE_8_G {
+		t.Errorf("got range %d, want %d", r, lis3dh.RANGE_8_G)
+	}
+}
+
+func TestReadAcceleration(t *testing.T) {
+	dev, fake, _ := newDevice(t)
+
+	// x = 16380, y = -8190, z = 0 at ±2g
+	fake.SetRegisters(lis3dh.REG_OUT_X_L, 0xFC, 0x3F, 0x02, 0xE0, 0x00, 0x00)
+	x, y, z := dev.ReadRawAcceleration()
+	if x != 16380 || y != -8190 || z != 0 {
+		t.Errorf("raw: got %d, %d, %d", x, y, z)
+	}
+	x32, y32, z32, err := dev.ReadAcceleration()
+	if err != nil || x32 != 1000000 || y32 != -500000 || z32 != 0 {
+		t.Errorf("got %d, %d, %d, %v; want 1000000, -500000, 0", x32, y32, z32, err)
+	}
+
+	dev.SetRange(lis3dh.RANGE_8_G)
+	fake.SetRegisters(lis3dh.REG_OUT_X_L, 0x00, 0x10, 0x00, 0xF0, 0x00, 0x08)
+	x32, y32, z32, _ = dev.ReadAcceleration()
+	if x32 != 1000000 || y32 != -1000000 || z32 != 500000 {
+		t.Errorf("±8g: got %d, %d, %d; want 1000000, -1000000, 500000", x32, y32, z32)
+	}
+}
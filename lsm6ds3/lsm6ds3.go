@@ -5,9 +5,7 @@
 //
 package lsm6ds3 // import "tinygo.org/x/drivers/lsm6ds3"
 
-import (
-	"machine"
-)
+import "tinygo.org/x/drivers"
 
 type AccelRange uint8
 type AccelSampleRate uint8
@@ -16,9 +14,15 @@ type AccelBandwidth uint8
 type GyroRange uint8
 type GyroSampleRate uint8
 
+var (
+	_ drivers.Accelerometer = (*Device)(nil)
+	_ drivers.Gyroscope     = (*Device)(nil)
+	_ drivers.Thermometer   = (*Device)(nil)
+)
+
 // Device wraps an I2C connection to a LSM6DS3 device.
 type Device struct {
-	bus             machine.I2C
+	bus             drivers.I2C
 	Address         uint16
 	accelRange      AccelRange
 	accelSampleRate AccelSampleRate
@@ -44,7 +48,7 @@ type Configuration struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{bus: bus, Address: Address}
 }
 
@@ -126,8 +130,11 @@ func (d *Device) Connected() bool {
 // it in µg (micro-gravity). When one of the axes is pointing straight to Earth
 // and the sensor is not moving the returned value will be around 1000000 or
 // -1000000.
-func (d *Device) ReadAcceleration() (x int32, y int32, z int32) {
-	d.bus.ReadRegister(uint8(d.Address), OUTX_L_XL, d.dataBufferSix)
+func (d *Device) ReadAcceleration() (x int32, y int32, z int32, err error) {
+	err = d.bus.ReadRegister(uint8(d.Address), OUTX_L_XL, d.dataBufferSix)
+	if err != nil {
+		return
+	}
 	// k comes from "Table 3. Mechanical characteristics" 3 of the datasheet * 1000
 	k := int32(61) // 2G
 	if d.accelRange == ACCEL_4G {
@@ -147,8 +154,11 @@ func (d *Device) ReadAcceleration() (x int32, y int32, z int32) {
 // µ°/s (micro-degrees/sec). This means that if you were to do a complete
 // rotation along one axis and while doing so integrate all values over time,
 // you would get a value close to 360000000.
-func (d *Device) ReadRotation() (x int32, y int32, z int32) {
-	d.bus.ReadRegister(uint8(d.Address), OUTX_L_G, d.dataBufferSix)
+func (d *Device) ReadRotation() (x int32, y int32, z int32, err error) {
+	err = d.bus.ReadRegister(uint8(d.Address), OUTX_L_G, d.dataBufferSix)
+	if err != nil {
+		return
+	}
 	// k comes from "Table 3. Mechanical characteristics" 3 of the datasheet * 1000
 	k := int32(4375) // 125DPS
 	if d.gyroRange == GYRO_250DPS {
@@ -168,7 +178,10 @@ func (d *Device) ReadRotation() (x int32, y int32, z int32) {
 
 // ReadTemperature returns the temperature in celsius milli degrees (°C/1000)
 func (d *Device) ReadTemperature() (int32, error) {
-	d.bus.ReadRegister(uint8(d.Address), OUT_TEMP_L, d.dataBufferTwo)
+	err := d.bus.ReadRegister(uint8(d.Address), OUT_TEMP_L, d.dataBufferTwo)
+	if err != nil {
+		return 0, err
+	}
 
 	// From "Table 5. Temperature sensor characteristics"
 	// temp = value/16 + 25
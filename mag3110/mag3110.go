@@ -6,12 +6,17 @@
 package mag3110 // import "tinygo.org/x/drivers/mag3110"
 
 import (
-	"machine"
+	"tinygo.org/x/drivers"
+)
+
+var (
+	_ drivers.Magnetometer = (*Device)(nil)
+	_ drivers.Thermometer  = (*Device)(nil)
 )
 
 // Device wraps an I2C connection to a MAG3110 device.
 type Device struct {
-	bus     machine.I2C
+	bus     drivers.I2C
 	Address uint16
 }
 
@@ -19,7 +24,7 @@ type Device struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{bus, Address}
 }
 
@@ -49,10 +54,33 @@ func (d Device) ReadMagnetic() (x int16, y int16, z int16) {
 	return
 }
 
+// ReadMagneticField reads the vectors of the magnetic field of the device and
+// returns it in nT (nanotesla). The MAG3110 has a sensitivity of 0.1µT per
+// LSB.
+func (d Device) ReadMagneticField() (x int32, y int32, z int32, err error) {
+	err = d.bus.WriteRegister(uint8(d.Address), CTRL_REG1, []uint8{0x1a}) // Request a measurement
+	if err != nil {
+		return
+	}
+
+	data := make([]byte, 6)
+	err = d.bus.ReadRegister(uint8(d.Address), OUT_X_MSB, data)
+	if err != nil {
+		return
+	}
+	x = int32(int16((uint16(data[0])<<8)|uint16(data[1]))) * 100
+	y = int32(int16((uint16(data[2])<<8)|uint16(data[3]))) * 100
+	z = int32(int16((uint16(data[4])<<8)|uint16(data[5]))) * 100
+	return
+}
+
 // ReadTemperature reads and returns the current die temperature in
 // celsius milli degrees (°C/1000).
 func (d Device) ReadTemperature() (int32, error) {
 	data := make([]byte, 1)
-	d.bus.ReadRegister(uint8(d.Address), DIE_TEMP, data)
-	return int32(data[0]) * 1000, nil
+	err := d.bus.ReadRegister(uint8(d.Address), DIE_TEMP, data)
+	if err != nil {
+		return 0, err
+	}
+	return int32(int8(data[0])) * 1000, nil
 }
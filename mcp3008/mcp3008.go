@@ -6,13 +6,15 @@ package mcp3008 // import "tinygo.org/x/drivers/mcp3008"
 
 import (
 	"errors"
-	"machine"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 )
 
 // Device wraps MCP3008 SPI ADC.
 type Device struct {
-	bus machine.SPI
-	cs  machine.Pin
+	bus drivers.SPI
+	cs  drivers.Pin
 	tx  []byte
 	rx  []byte
 	CH0 ADCPin
@@ -27,12 +29,13 @@ type Device struct {
 
 // ADCPin is the implementation of the ADConverter interface.
 type ADCPin struct {
-	machine.Pin
-	d *Device
+	// Pin is the channel of the ADC, from 0 to 7.
+	Pin uint8
+	d   *Device
 }
 
 // New returns a new MCP3008 driver. Pass in a fully configured SPI bus.
-func New(b machine.SPI, csPin machine.Pin) *Device {
+func New(b drivers.SPI, csPin drivers.Pin) *Device {
 	d := &Device{bus: b,
 		cs: csPin,
 		tx: make([]byte, 3),
@@ -54,7 +57,7 @@ func New(b machine.SPI, csPin machine.Pin) *Device {
 
 // Configure sets up the device for communication
 func (d *Device) Configure() {
-	d.cs.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	pin.ConfigureOutput(d.cs)
 }
 
 // Read analog data from channel
@@ -68,7 +71,7 @@ func (d *Device) Read(ch int) (uint16, error) {
 
 // GetADC returns an ADC for a specific channel.
 func (d *Device) GetADC(ch int) ADCPin {
-	return ADCPin{machine.Pin(ch), d}
+	return ADCPin{uint8(ch), d}
 }
 
 // Get the current reading for a specific ADCPin.
@@ -7,12 +7,14 @@
 package mma8653 // import "tinygo.org/x/drivers/mma8653"
 
 import (
-	"machine"
+	"tinygo.org/x/drivers"
 )
 
+var _ drivers.Accelerometer = (*Device)(nil)
+
 // Device wraps an I2C connection to a MMA8653 device.
 type Device struct {
-	bus         machine.I2C
+	bus         drivers.I2C
 	Address     uint16
 	sensitivity Sensitivity
 }
@@ -21,7 +23,7 @@ type Device struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{bus, Address, Sensitivity2G}
 }
 
@@ -8,12 +8,17 @@
 package mpu6050 // import "tinygo.org/x/drivers/mpu6050"
 
 import (
-	"machine"
+	"tinygo.org/x/drivers"
+)
+
+var (
+	_ drivers.Accelerometer = (*Device)(nil)
+	_ drivers.Gyroscope     = (*Device)(nil)
 )
 
 // Device wraps an I2C connection to a MPU6050 device.
 type Device struct {
-	bus     machine.I2C
+	bus     drivers.I2C
 	Address uint16
 }
 
@@ -21,7 +26,7 @@ type Device struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{bus, Address}
 }
 
@@ -42,9 +47,12 @@ func (d Device) Configure() {
 // it in µg (micro-gravity). When one of the axes is pointing straight to Earth
 // and the sensor is not moving the returned value will be around 1000000 or
 // -1000000.
-func (d Device) ReadAcceleration() (x int32, y int32, z int32) {
+func (d Device) ReadAcceleration() (x int32, y int32, z int32, err error) {
 	data := make([]byte, 6)
-	d.bus.ReadRegister(uint8(d.Address), ACCEL_XOUT_H, data)
+	err = d.bus.ReadRegister(uint8(d.Address), ACCEL_XOUT_H, data)
+	if err != nil {
+		return
+	}
 	// Now do two things:
 	// 1. merge the two values to a 16-bit number (and cast to a 32-bit integer)
 	// 2. scale the value to bring it in the -1000000..1000000 range.
@@ -63,9 +71,12 @@ func (d Device) ReadAcceleration() (x int32, y int32, z int32) {
 // µ°/s (micro-degrees/sec). This means that if you were to do a complete
 // rotation along one axis and while doing so integrate all values over time,
 // you would get a value close to 360000000.
-func (d Device) ReadRotation() (x int32, y int32, z int32) {
+func (d Device) ReadRotation() (x int32, y int32, z int32, err error) {
 	data := make([]byte, 6)
-	d.bus.ReadRegister(uint8(d.Address), GYRO_XOUT_H, data)
+	err = d.bus.ReadRegister(uint8(d.Address), GYRO_XOUT_H, data)
+	if err != nil {
+		return
+	}
 	// First the value is converted from a pair of bytes to a signed 16-bit
 	// value and then to a signed 32-bit value to avoid integer overflow.
 	// Then the value is scaled to µ°/s (micro-degrees per second).
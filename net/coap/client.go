@@ -0,0 +1,500 @@
+// Package coap implements the Constrained Application Protocol (RFC 7252), a
+// lightweight alternative to HTTP and MQTT over UDP for battery-powered
+// nodes, built on the net package of the drivers.
+//
+// The Client sends confirmable requests, retransmitted with an exponential
+// backoff until acknowledged, and matches the responses by token, including
+// the separate responses sent after an empty acknowledgement. Large payloads
+// are sent and received in blocks (RFC 7959), and resources can be observed
+// (RFC 7641):
+//
+//	c, err := coap.Dial("coap.example.com:5683")
+//	if err != nil {
+//		return err
+//	}
+//	resp, err := c.Post("/telemetry", coap.AppJSON, []byte(`{"temp":21.5}`))
+//
+// The Server routes the requests to the handler of their path, and sends the
+// notifications of the observed resources with Notify.
+package coap // import "tinygo.org/x/drivers/net/coap"
+
+import (
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+)
+
+// DefaultPort is the UDP port of CoAP servers.
+const DefaultPort = 5683
+
+// MaxMessageSize is the size of the buffer used to receive messages, larger
+// datagrams are truncated. It fits a 1024 bytes block with its header.
+const MaxMessageSize = 1152
+
+var (
+	// ErrTimeout is returned when no response was received in time.
+	ErrTimeout = errors.New("coap: timeout")
+
+	// ErrReset is returned when the server rejected the request with a reset
+	// message.
+	ErrReset = errors.New("coap: reset by peer")
+
+	// errNoResponse is returned by wait when the request was acknowledged
+	// but the separate response did not come: sending the request again
+	// would not help, the server already has it.
+	errNoResponse = errors.New("coap: no separate response")
+
+	// ErrBodyTooLarge is returned when the payload of a block-wise response
+	// is larger than Client.MaxBodySize.
+	ErrBodyTooLarge = errors.New("coap: body too large")
+
+	// ErrNotObservable is returned by Observe when the server does not
+	// accept the registration.
+	ErrNotObservable = errors.New("coap: resource not observable")
+)
+
+// A Client sends requests to a server. Its fields can be changed before the
+// first request. It is not safe for concurrent use.
+type Client struct {
+	// ACKTimeout is the time to wait for the acknowledgement of a
+	// confirmable request before sending it again, doubled on every
+	// retransmission and increased by a random factor of up to 1.5. Zero
+	// means 2 seconds.
+	ACKTimeout time.Duration
+
+	// MaxRetransmit is the number of retransmissions of a confirmable
+	// request. Zero means 4, use -1 for none.
+	MaxRetransmit int
+
+	// ResponseTimeout is the time to wait for a separate response once the
+	// request has been acknowledged. Zero means 30 seconds.
+	ResponseTimeout time.Duration
+
+	// BlockSize is the size of the blocks used for the payloads sent, and
+	// requested for the payloads received. Zero means 512.
+	BlockSize int
+
+	// MaxBodySize is the maximum size of a payload received in blocks. Zero
+	// means 4096.
+	MaxBodySize int
+
+	conn         *net.UDPSerialConn
+	msgID        uint16
+	buf          [MaxMessageSize]byte
+	wbuf         []byte
+	observations []*Observation
+}
+
+// Dial returns a client for the server at address, in "host:port" form. The
+// port is DefaultPort if omitted.
+func Dial(address string) (*Client, error) {
+	if !strings.Contains(address, ":") {
+		address += ":" + strconv.Itoa(DefaultPort)
+	}
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client sending its requests over conn.
+func NewClient(conn *net.UDPSerialConn) *Client {
+	c := &Client{conn: conn}
+	var id [2]byte
+	rand.Read(id[:])
+	c.msgID = uint16(id[0])<<8 | uint16(id[1])
+	return c
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// NewRequest returns a confirmable request for path, which may include a
+// query such as "/sensors?type=temp".
+func NewRequest(method Code, path string, payload []byte) *Message {
+	m := &Message{Type: Confirmable, Code: method, Payload: payload}
+	m.SetPath(path)
+	return m
+}
+
+// Get sends a GET request.
+func (c *Client) Get(path string) (*Message, error) {
+	return c.Do(NewRequest(GET, path, nil))
+}
+
+// Post sends a POST request with a payload of the given media type.
+func (c *Client) Post(path string, format MediaType, payload []byte) (*Message, error) {
+	req := NewRequest(POST, path, payload)
+	req.SetUint(ContentFormat, uint32(format))
+	return c.Do(req)
+}
+
+// Put sends a PUT request with a payload of the given media type.
+func (c *Client) Put(path string, format MediaType, payload []byte) (*Message, error) {
+	req := NewRequest(PUT, path, payload)
+	req.SetUint(ContentFormat, uint32(format))
+	return c.Do(req)
+}
+
+// Delete sends a DELETE request.
+func (c *Client) Delete(path string) (*Message, error) {
+	return c.Do(NewRequest(DELETE, path, nil))
+}
+
+// Do sends a request and returns its response. Payloads larger than
+// BlockSize are sent in blocks, and responses sent in blocks are assembled.
+// A response with an error code is not an error, the code must be checked.
+func (c *Client) Do(req *Message) (*Message, error) {
+	var resp *Message
+	var err error
+	if len(req.Payload) > c.blockSize() {
+		resp, err = c.sendBlocks(req)
+	} else {
+		resp, err = c.exchange(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.receiveBlocks(req, resp)
+}
+
+// sendBlocks sends the payload of req in blocks with the Block1 option, and
+// returns the response to the last block.
+func (c *Client) sendBlocks(req *Message) (*Message, error) {
+	size := c.blockSize()
+	for offset := 0; ; {
+		end := offset + size
+		if end > len(req.Payload) {
+			end = len(req.Payload)
+		}
+		block := req.copy()
+		block.Token = nil
+		block.Payload = req.Payload[offset:end]
+		block.SetBlock(Block1, Block{Num: uint32(offset / size), More: end < len(req.Payload), Size: size})
+		if offset == 0 {
+			block.SetUint(Size1, uint32(len(req.Payload)))
+		}
+		resp, err := c.exchange(block)
+		if err != nil || end == len(req.Payload) || resp.Code != Continue {
+			return resp, err
+		}
+
+		// the server may ask for smaller blocks
+		offset = end
+		if b, ok := resp.Block(Block1); ok && b.Size < size {
+			size = b.Size
+		}
+	}
+}
+
+// receiveBlocks gets the next blocks of a response with the Block2 option,
+// and returns the response with the whole payload.
+func (c *Client) receiveBlocks(req, resp *Message) (*Message, error) {
+	b, ok := resp.Block(Block2)
+	if !ok || !b.More || !resp.Code.IsSuccess() {
+		return resp, nil
+	}
+	max := c.MaxBodySize
+	if max == 0 {
+		max = 4096
+	}
+	body := resp.Payload
+	for b.More {
+		next := req.copy()
+		next.Token = nil
+		next.Payload = nil
+		next.RemoveOption(Block1)
+		next.RemoveOption(Size1)
+		next.RemoveOption(Observe)
+		next.SetBlock(Block2, Block{Num: uint32(len(body) / b.Size), Size: b.Size})
+		if next.Code != GET {
+			// the other methods have been executed with the first block
+			next.Code = GET
+		}
+		r, err := c.exchange(next)
+		if err != nil {
+			return nil, err
+		}
+		if !r.Code.IsSuccess() {
+			return r, nil
+		}
+		if b, ok = r.Block(Block2); !ok {
+			return nil, ErrInvalidMessage
+		}
+		if len(body)+len(r.Payload) > max {
+			return nil, ErrBodyTooLarge
+		}
+		body = append(body, r.Payload...)
+	}
+	resp.Payload = body
+	resp.RemoveOption(Block2)
+	return resp, nil
+}
+
+// exchange sends a request, retransmitted until acknowledged if it is
+// confirmable, and waits for its response.
+func (c *Client) exchange(req *Message) (*Message, error) {
+	c.msgID++
+	req.MessageID = c.msgID
+	if len(req.Token) == 0 {
+		req.Token = newToken()
+	}
+	var err error
+	c.wbuf, err = req.Append(c.wbuf[:0])
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.ACKTimeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+	// random factor from 1 to 1.5, so that clients do not retransmit in sync
+	var r [1]byte
+	rand.Read(r[:])
+	timeout += timeout * time.Duration(r[0]) / 512
+	retransmit := c.MaxRetransmit
+	switch {
+	case retransmit == 0:
+		retransmit = 4
+	case retransmit < 0:
+		retransmit = 0
+	}
+	if req.Type != Confirmable {
+		// non-confirmable requests are sent once, and the response is waited
+		// for as long as for the retransmissions
+		timeout *= time.Duration(1<<(retransmit+1) - 1)
+		retransmit = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if _, err := c.conn.Write(c.wbuf); err != nil {
+			return nil, err
+		}
+		resp, err := c.wait(req, time.Now().Add(timeout))
+		if err == errNoResponse {
+			return nil, ErrTimeout
+		}
+		if err != ErrTimeout || attempt == retransmit {
+			return resp, err
+		}
+		timeout *= 2
+	}
+}
+
+// wait waits for the response to req until deadline. Once an empty
+// acknowledgement is received, it waits for the separate response for
+// ResponseTimeout instead, and returns errNoResponse if it does not come.
+func (c *Client) wait(req *Message, deadline time.Time) (*Message, error) {
+	acked := false
+	for {
+		m, err := c.read(deadline)
+		if err == ErrTimeout && acked {
+			return nil, errNoResponse
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case m.Type == Acknowledgement && m.MessageID == req.MessageID:
+			if m.Code == Empty {
+				timeout := c.ResponseTimeout
+				if timeout == 0 {
+					timeout = 30 * time.Second
+				}
+				deadline = time.Now().Add(timeout)
+				acked = true
+				continue
+			}
+			if string(m.Token) == string(req.Token) {
+				return m, nil
+			}
+		case m.Type == Reset && m.MessageID == req.MessageID:
+			return nil, ErrReset
+		case m.Type != Acknowledgement && !m.Code.IsRequest() && string(m.Token) == string(req.Token):
+			// separate response, or response to a non-confirmable request
+			if m.Type == Confirmable {
+				c.reply(m, Acknowledgement)
+			}
+			return m, nil
+		default:
+			c.dispatch(m)
+		}
+	}
+}
+
+// read returns the next message received before deadline. The message is
+// copied out of the receive buffer.
+func (c *Client) read(deadline time.Time) (*Message, error) {
+	c.conn.SetReadDeadline(deadline)
+	for {
+		n, err := c.conn.Read(c.buf[:])
+		if err == net.ErrDeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		if err != nil {
+			return nil, err
+		}
+		m, err := ParseMessage(append([]byte(nil), c.buf[:n]...))
+		if err == nil {
+			return m, nil
+		}
+	}
+}
+
+// dispatch handles a message that is not the response to the current
+// request: notifications are passed to their observation, and the other
+// messages are rejected with a reset.
+func (c *Client) dispatch(m *Message) {
+	if m.Code.IsRequest() || m.Type == Acknowledgement || m.Type == Reset {
+		if m.Type == Confirmable {
+			c.reply(m, Reset)
+		}
+		return
+	}
+	for _, o := range c.observations {
+		if string(o.token) == string(m.Token) {
+			if m.Type == Confirmable {
+				c.reply(m, Acknowledgement)
+			}
+			o.notify(m)
+			return
+		}
+	}
+	c.reply(m, Reset)
+}
+
+// reply sends an empty acknowledgement or reset for m.
+func (c *Client) reply(m *Message, typ Type) {
+	b, _ := (&Message{Type: typ, MessageID: m.MessageID}).Append(nil)
+	c.conn.Write(b)
+}
+
+func (c *Client) blockSize() int {
+	if c.BlockSize == 0 {
+		return 512
+	}
+	return 16 << blockSZX(c.BlockSize)
+}
+
+// newToken returns a random token, so that the responses cannot be spoofed
+// easily.
+func newToken() []byte {
+	t := make([]byte, 4)
+	rand.Read(t)
+	return t
+}
+
+// copy returns a copy of m, with its own slice of options.
+func (m *Message) copy() *Message {
+	c := *m
+	c.Options = append([]Option(nil), m.Options...)
+	return &c
+}
+
+// Observation is the registration of the client to the notifications of a
+// resource, returned by Observe.
+type Observation struct {
+	c       *Client
+	req     *Message
+	token   []byte
+	pending *Message
+
+	// the sequence number and time of the latest notification, to discard
+	// the ones received out of order
+	seq      uint32
+	received time.Time
+}
+
+// Observe registers to the notifications of the resource at path. The
+// current state of the resource is returned by the first call to Next.
+func (c *Client) Observe(path string) (*Observation, error) {
+	req := NewRequest(GET, path, nil)
+	req.SetUint(Observe, 0)
+	req.Token = newToken()
+	o := &Observation{c: c, req: req.copy(), token: req.Token}
+	c.observations = append(c.observations, o)
+
+	resp, err := c.exchange(req)
+	if err == nil && (!resp.Code.IsSuccess() || !resp.HasOption(Observe)) {
+		err = ErrNotObservable
+	}
+	if err != nil {
+		c.remove(o)
+		return nil, err
+	}
+	o.notify(resp)
+	return o, nil
+}
+
+// Next returns the next notification, waiting for it up to timeout. The
+// notifications that arrived meanwhile are skipped, only the latest one is
+// kept. A notification with an error code ends the observation.
+func (o *Observation) Next(timeout time.Duration) (*Message, error) {
+	deadline := time.Now().Add(timeout)
+	for o.pending == nil {
+		m, err := o.c.read(deadline)
+		if err != nil {
+			return nil, err
+		}
+		o.c.dispatch(m)
+	}
+	m := o.pending
+	o.pending = nil
+	if !m.Code.IsSuccess() {
+		return m, nil
+	}
+
+	// the notification may only contain the first block of the resource
+	return o.c.receiveBlocks(o.req, m)
+}
+
+// Cancel deregisters from the notifications.
+func (o *Observation) Cancel() error {
+	o.c.remove(o)
+	req := o.req.copy()
+	req.SetUint(Observe, 1)
+	req.Token = o.token
+	_, err := o.c.exchange(req)
+	return err
+}
+
+// notify keeps m as the latest notification, unless it is older than the
+// current one.
+func (o *Observation) notify(m *Message) {
+	seq, ok := m.Uint(Observe)
+	now := time.Now()
+	if ok && !o.received.IsZero() {
+		// RFC 7641, section 3.4: sequence numbers are 24 bits and wrap around
+		newer := o.seq < seq && seq-o.seq < 1<<23 || o.seq > seq && o.seq-seq > 1<<23
+		if !newer && now.Before(o.received.Add(128*time.Second)) {
+			return
+		}
+	}
+	o.seq, o.received = seq, now
+	o.pending = m
+	if !m.Code.IsSuccess() {
+		o.c.remove(o)
+	}
+}
+
+// remove removes an observation from the client.
+func (c *Client) remove(o *Observation) {
+	for i, obs := range c.observations {
+		if obs == o {
+			c.observations = append(c.observations[:i], c.observations[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package coap
+
+import (
+	"bytes"
+	stdnet "net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &Message{Type: Confirmable, Code: POST, MessageID: 0x1234, Token: []byte{1, 2, 3}}
+	m.SetPath("/a/b?x=1&y=2")
+	m.SetUint(ContentFormat, uint32(AppJSON))
+	m.AddOption(ProxyURI, bytes.Repeat([]byte("p"), 300))
+	m.SetBlock(Block1, Block{Num: 3, More: true, Size: 256})
+	m.Payload = []byte("hi")
+	b, err := m.Append(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Path() != "a/b" || strings.Join(p.Query(), "&") != "x=1&y=2" || string(p.Payload) != "hi" || p.MessageID != 0x1234 || string(p.Token) != "\x01\x02\x03" {
+		t.Fatalf("%+v", p)
+	}
+	if v, _ := p.Uint(ContentFormat); v != 50 {
+		t.Fatal(v)
+	}
+	if blk, _ := p.Block(Block1); blk != (Block{3, true, 256}) {
+		t.Fatal(blk)
+	}
+	if len(p.Option(ProxyURI)) != 300 {
+		t.Fatal("proxy")
+	}
+	if Content.String() != "2.05" || RequestEntityTooLarge.String() != "4.13" {
+		t.Fatal(Content.String())
+	}
+	// example from RFC: empty ACK
+	if _, err := ParseMessage([]byte{0x60, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMessage([]byte{0x40, 1, 0, 1, 0xFF}); err == nil {
+		t.Fatal("payload marker")
+	}
+}
+
+var port = 57000
+
+func setup(t *testing.T) (*Server, *Client, *sync.Mutex) {
+	net.UseDriver(loopback.New())
+	port++
+	s, err := Listen("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu := &sync.Mutex{}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			mu.Lock()
+			s.Poll()
+			mu.Unlock()
+			time.Sleep(2 * time.Millisecond)
+		}
+	}()
+	c, err := Dial("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { close(done); mu.Lock(); s.Close(); mu.Unlock(); c.Close() })
+	return s, c, mu
+}
+
+func TestClientServer(t *testing.T) {
+	s, c, mu := setup(t)
+	var stored []byte
+	mu.Lock()
+	s.Handle("/hello", func(resp, req *Message) {
+		resp.Payload = []byte("world " + strings.Join(req.Query(), ","))
+	})
+	s.Handle("/store/*", func(resp, req *Message) {
+		switch req.Code {
+		case PUT, POST:
+			stored = append([]byte(nil), req.Payload...)
+			resp.Code = Changed
+		case GET:
+			resp.Payload = stored
+		default:
+			resp.Code = MethodNotAllowed
+		}
+	})
+	s.BlockSize = 128
+	s.MaxBodySize = 4000
+	mu.Unlock()
+
+	resp, err := c.Get("/hello?a=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != Content || string(resp.Payload) != "world a=1" {
+		t.Fatal(resp.Code, string(resp.Payload))
+	}
+	resp, err = c.Get("/nope")
+	if err != nil || resp.Code != NotFound {
+		t.Fatal(resp, err)
+	}
+	resp, _ = c.Delete("/store/x")
+	if resp.Code != MethodNotAllowed {
+		t.Fatal(resp.Code)
+	}
+
+	big := make([]byte, 3000)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	c.BlockSize = 256
+	resp, err = c.Put("/store/x", OctetStream, big)
+	if err != nil || resp.Code != Changed {
+		t.Fatal(resp, err)
+	}
+	mu.Lock()
+	ok := bytes.Equal(stored, big)
+	mu.Unlock()
+	if !ok {
+		t.Fatal("upload mismatch")
+	}
+	resp, err = c.Get("/store/x")
+	if err != nil || resp.Code != Content {
+		t.Fatal(resp, err)
+	}
+	if !bytes.Equal(resp.Payload, big) {
+		t.Fatal("download mismatch", len(resp.Payload))
+	}
+	c.MaxBodySize = 1000
+	if _, err = c.Get("/store/x"); err != ErrBodyTooLarge {
+		t.Fatal(err)
+	}
+	c.MaxBodySize = 0
+
+	// too large for the server
+	resp, err = c.Put("/store/x", OctetStream, make([]byte, 5000))
+	if err != nil || resp.Code != RequestEntityTooLarge {
+		t.Fatal(resp, err)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	s, c, mu := setup(t)
+	value := 0
+	mu.Lock()
+	s.Handle("/temp", func(resp, req *Message) {
+		resp.Payload = []byte(strconv.Itoa(value))
+	})
+	mu.Unlock()
+
+	o, err := c.Observe("/temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := o.Next(time.Second)
+	if err != nil || string(m.Payload) != "0" {
+		t.Fatal(m, err)
+	}
+	for i := 1; i <= 3; i++ {
+		mu.Lock()
+		value = i
+		if n := s.Observers("/temp"); n != 1 {
+			t.Fatal("observers", n)
+		}
+		s.Notify("/temp")
+		mu.Unlock()
+		m, err := o.Next(time.Second)
+		if err != nil || string(m.Payload) != strconv.Itoa(i) {
+			t.Fatal(i, m, err)
+		}
+	}
+	if _, err := o.Next(50 * time.Millisecond); err != ErrTimeout {
+		t.Fatal(err)
+	}
+	if err := o.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	n := s.Observers("/temp")
+	mu.Unlock()
+	if n != 0 {
+		t.Fatal("observers after cancel", n)
+	}
+	if _, err := c.Observe("/nope"); err != ErrNotObservable {
+		t.Fatal(err)
+	}
+
+	// a notification to a forgotten observation is rejected with a reset
+	o, err = c.Observe("/temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.remove(o)
+	mu.Lock()
+	s.Notify("/temp")
+	mu.Unlock()
+	c.Get("/temp") // reads the notification, and resets it
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	n = s.Observers("/temp")
+	mu.Unlock()
+	if n != 0 {
+		t.Fatal("observers after reset", n)
+	}
+}
+
+// raw is a server answering with respond, on the host network.
+func raw(t *testing.T, respond func(m *Message, send func(*Message))) string {
+	conn, err := stdnet.ListenUDP("udp", &stdnet.UDPAddr{IP: stdnet.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			m, err := ParseMessage(append([]byte(nil), b[:n]...))
+			if err != nil {
+				continue
+			}
+			respond(m, func(r *Message) {
+				out, _ := r.Append(nil)
+				conn.WriteToUDP(out, from)
+			})
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestRetransmit(t *testing.T) {
+	net.UseDriver(loopback.New())
+	var mu sync.Mutex
+	var ids []uint16
+	addr := raw(t, func(m *Message, send func(*Message)) {
+		mu.Lock()
+		ids = append(ids, m.MessageID)
+		n := len(ids)
+		mu.Unlock()
+		if n < 3 {
+			return
+		}
+		send(&Message{Type: Acknowledgement, Code: Content, MessageID: m.MessageID, Token: m.Token, Payload: []byte("ok")})
+	})
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ACKTimeout = 30 * time.Millisecond
+	start := time.Now()
+	resp, err := c.Get("/x")
+	if err != nil || string(resp.Payload) != "ok" {
+		t.Fatal(resp, err)
+	}
+	mu.Lock()
+	if len(ids) != 3 || ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Fatal(ids)
+	}
+	mu.Unlock()
+	// 30+60 ms at least, 45+90 at most, before the third attempt
+	if d := time.Since(start); d < 90*time.Millisecond || d > 300*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	// never answered
+	addr2 := raw(t, func(m *Message, send func(*Message)) {})
+	c2, _ := Dial(addr2)
+	c2.ACKTimeout = 20 * time.Millisecond
+	c2.MaxRetransmit = 1
+	if _, err := c2.Get("/x"); err != ErrTimeout {
+		t.Fatal(err)
+	}
+	addr3 := raw(t, func(m *Message, send func(*Message)) {
+		send(&Message{Type: Reset, MessageID: m.MessageID})
+	})
+	c3, _ := Dial(addr3)
+	if _, err := c3.Get("/x"); err != ErrReset {
+		t.Fatal(err)
+	}
+}
+
+func TestSeparateResponse(t *testing.T) {
+	net.UseDriver(loopback.New())
+	acked := make(chan uint16, 4)
+	addr := raw(t, func(m *Message, send func(*Message)) {
+		if m.Type == Acknowledgement {
+			acked <- m.MessageID
+			return
+		}
+		send(&Message{Type: Acknowledgement, MessageID: m.MessageID})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			// an unrelated message first, then the response
+			send(&Message{Type: NonConfirmable, Code: Content, MessageID: 99, Token: []byte("zz")})
+			send(&Message{Type: Confirmable, Code: Content, MessageID: 7, Token: m.Token, Payload: []byte("late")})
+		}()
+	})
+	c, _ := Dial(addr)
+	c.ACKTimeout = 10 * time.Millisecond
+	resp, err := c.Get("/slow")
+	if err != nil || string(resp.Payload) != "late" {
+		t.Fatal(resp, err)
+	}
+	select {
+	case id := <-acked:
+		if id != 7 {
+			t.Fatal(id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("response not acknowledged")
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	net.UseDriver(loopback.New())
+	port++
+	s, err := Listen("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	calls := 0
+	s.Handle("/count", func(resp, req *Message) {
+		calls++
+		resp.Payload = []byte(strconv.Itoa(calls))
+	})
+	conn, err := stdnet.Dial("udp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := NewRequest(GET, "/count", nil)
+	req.MessageID = 42
+	req.Token = []byte{9}
+	b, _ := req.Append(nil)
+	buf := make([]byte, 100)
+	var answers []string
+	for i := 0; i < 2; i++ {
+		conn.Write(b)
+		time.Sleep(20 * time.Millisecond)
+		if err := s.Poll(); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _ := ParseMessage(buf[:n])
+		answers = append(answers, string(m.Payload))
+	}
+	if calls != 1 || answers[0] != "1" || answers[1] != "1" {
+		t.Fatal(calls, answers)
+	}
+
+	// unknown critical option
+	req.MessageID = 43
+	req.AddOption(OptionID(9), []byte("x"))
+	b, _ = req.Append(nil)
+	conn.Write(b)
+	time.Sleep(20 * time.Millisecond)
+	s.Poll()
+	n, _ := conn.Read(buf)
+	if m, _ := ParseMessage(buf[:n]); m.Code != BadOption {
+		t.Fatal(m.Code)
+	}
+}
+
+// Once the request is acknowledged, it is not sent again when the separate
+// response does not come.
+func TestSeparateTimeout(t *testing.T) {
+	net.UseDriver(loopback.New())
+	var mu sync.Mutex
+	requests := 0
+	addr := raw(t, func(m *Message, send func(*Message)) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		send(&Message{Type: Acknowledgement, MessageID: m.MessageID})
+	})
+	c, _ := Dial(addr)
+	c.ACKTimeout = 20 * time.Millisecond
+	c.ResponseTimeout = 50 * time.Millisecond
+	start := time.Now()
+	if _, err := c.Get("/slow"); err != ErrTimeout {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > 200*time.Millisecond {
+		t.Fatal(d)
+	}
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 1 {
+		t.Fatal(requests, "requests")
+	}
+}
+
+// Notifications older than the latest one are discarded, the sequence
+// numbers wrap around after 24 bits.
+func TestObserveOrder(t *testing.T) {
+	o := &Observation{c: &Client{}}
+	notification := func(seq uint32) *Message {
+		m := &Message{Type: NonConfirmable, Code: Content, Payload: []byte(strconv.Itoa(int(seq)))}
+		m.SetUint(Observe, seq)
+		return m
+	}
+	for _, tt := range []struct {
+		seq  uint32
+		kept bool
+	}{
+		{5, true},
+		{3, false},
+		{6, true},
+		{6, false},
+		{0xFFFFF0, false},
+		{0x800005, true},
+		{0xFFFFF0, true},
+		{2, true},
+	} {
+		o.pending = nil
+		o.notify(notification(tt.seq))
+		if kept := o.pending != nil; kept != tt.kept {
+			t.Fatalf("%#x: kept %v", tt.seq, kept)
+		}
+	}
+
+	// after 128 seconds, any notification is newer
+	o.received = o.received.Add(-129 * time.Second)
+	o.pending = nil
+	o.notify(notification(1))
+	if o.pending == nil {
+		t.Fatal("notification discarded")
+	}
+}
@@ -0,0 +1,431 @@
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidMessage is returned when parsing a datagram that is not a valid
+// CoAP message.
+var ErrInvalidMessage = errors.New("coap: invalid message")
+
+// Type is the type of a message.
+type Type uint8
+
+const (
+	Confirmable     Type = 0 // needs an acknowledgement, retransmitted until then
+	NonConfirmable  Type = 1
+	Acknowledgement Type = 2
+	Reset           Type = 3 // a confirmable message could not be processed
+)
+
+// Code is the method of a request or the response code of a response, in
+// the "c.dd" form of its class and detail.
+type Code uint8
+
+const (
+	Empty Code = 0
+
+	GET    Code = 1
+	POST   Code = 2
+	PUT    Code = 3
+	DELETE Code = 4
+
+	Created  Code = 2<<5 | 1
+	Deleted  Code = 2<<5 | 2
+	Valid    Code = 2<<5 | 3
+	Changed  Code = 2<<5 | 4
+	Content  Code = 2<<5 | 5
+	Continue Code = 2<<5 | 31
+
+	BadRequest               Code = 4<<5 | 0
+	Unauthorized             Code = 4<<5 | 1
+	BadOption                Code = 4<<5 | 2
+	Forbidden                Code = 4<<5 | 3
+	NotFound                 Code = 4<<5 | 4
+	MethodNotAllowed         Code = 4<<5 | 5
+	NotAcceptable            Code = 4<<5 | 6
+	RequestEntityIncomplete  Code = 4<<5 | 8
+	PreconditionFailed       Code = 4<<5 | 12
+	RequestEntityTooLarge    Code = 4<<5 | 13
+	UnsupportedContentFormat Code = 4<<5 | 15
+
+	InternalServerError  Code = 5<<5 | 0
+	NotImplemented       Code = 5<<5 | 1
+	BadGateway           Code = 5<<5 | 2
+	ServiceUnavailable   Code = 5<<5 | 3
+	GatewayTimeout       Code = 5<<5 | 4
+	ProxyingNotSupported Code = 5<<5 | 5
+)
+
+// IsRequest reports if c is the method of a request.
+func (c Code) IsRequest() bool {
+	return c >= 1 && c < 1<<5
+}
+
+// IsSuccess reports if c is a response code of class 2.
+func (c Code) IsSuccess() bool {
+	return c>>5 == 2
+}
+
+// String returns the code in "c.dd" form, such as "2.05".
+func (c Code) String() string {
+	detail := strconv.Itoa(int(c & 0x1F))
+	if len(detail) == 1 {
+		detail = "0" + detail
+	}
+	return strconv.Itoa(int(c>>5)) + "." + detail
+}
+
+// OptionID is the number of an option.
+type OptionID uint16
+
+const (
+	IfMatch       OptionID = 1
+	URIHost       OptionID = 3
+	ETag          OptionID = 4
+	IfNoneMatch   OptionID = 5
+	Observe       OptionID = 6
+	URIPort       OptionID = 7
+	LocationPath  OptionID = 8
+	URIPath       OptionID = 11
+	ContentFormat OptionID = 12
+	MaxAge        OptionID = 14
+	URIQuery      OptionID = 15
+	Accept        OptionID = 17
+	LocationQuery OptionID = 20
+	Block2        OptionID = 23
+	Block1        OptionID = 27
+	Size2         OptionID = 28
+	ProxyURI      OptionID = 35
+	ProxyScheme   OptionID = 39
+	Size1         OptionID = 60
+)
+
+// critical reports if the option must be understood by the receiver.
+func (id OptionID) critical() bool {
+	return id&1 != 0
+}
+
+// MediaType is the value of the ContentFormat and Accept options.
+type MediaType uint16
+
+const (
+	TextPlain   MediaType = 0
+	LinkFormat  MediaType = 40
+	AppXML      MediaType = 41
+	OctetStream MediaType = 42
+	AppEXI      MediaType = 47
+	AppJSON     MediaType = 50
+	AppCBOR     MediaType = 60
+)
+
+// Option is an option of a message.
+type Option struct {
+	ID    OptionID
+	Value []byte
+}
+
+// Message is a CoAP message, either a request or a response.
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+
+	// Token matches a response to its request, it is up to 8 bytes.
+	Token []byte
+
+	// Options are kept in the order they were added or received, they are
+	// sorted when the message is encoded.
+	Options []Option
+
+	Payload []byte
+}
+
+// Option returns the value of the first option id, or nil.
+func (m *Message) Option(id OptionID) []byte {
+	for _, o := range m.Options {
+		if o.ID == id {
+			return o.Value
+		}
+	}
+	return nil
+}
+
+// HasOption reports if the message has the option id.
+func (m *Message) HasOption(id OptionID) bool {
+	for _, o := range m.Options {
+		if o.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// AddOption adds an option, after the options with the same id.
+func (m *Message) AddOption(id OptionID, value []byte) {
+	m.Options = append(m.Options, Option{ID: id, Value: value})
+}
+
+// SetOption replaces the options id with a single one.
+func (m *Message) SetOption(id OptionID, value []byte) {
+	m.RemoveOption(id)
+	m.AddOption(id, value)
+}
+
+// RemoveOption removes all the options id.
+func (m *Message) RemoveOption(id OptionID) {
+	opts := m.Options[:0]
+	for _, o := range m.Options {
+		if o.ID != id {
+			opts = append(opts, o)
+		}
+	}
+	m.Options = opts
+}
+
+// Uint returns the value of the unsigned integer option id.
+func (m *Message) Uint(id OptionID) (uint32, bool) {
+	for _, o := range m.Options {
+		if o.ID == id {
+			var v uint32
+			for _, b := range o.Value {
+				v = v<<8 | uint32(b)
+			}
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// SetUint sets the unsigned integer option id, in as few bytes as possible.
+func (m *Message) SetUint(id OptionID, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	i := 0
+	for i < 4 && b[i] == 0 {
+		i++
+	}
+	m.SetOption(id, append([]byte(nil), b[i:]...))
+}
+
+// Path returns the URIPath options joined with "/", without a leading "/".
+func (m *Message) Path() string {
+	return m.join(URIPath, "/")
+}
+
+// SetPath sets the URIPath options from a path such as "/sensors/temp". A
+// query after "?" is set as URIQuery options.
+func (m *Message) SetPath(path string) {
+	m.RemoveOption(URIPath)
+	m.RemoveOption(URIQuery)
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		for _, q := range strings.Split(path[i+1:], "&") {
+			if q != "" {
+				m.AddOption(URIQuery, []byte(q))
+			}
+		}
+		path = path[:i]
+	}
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			m.AddOption(URIPath, []byte(s))
+		}
+	}
+}
+
+// Query returns the URIQuery options, such as "key=value".
+func (m *Message) Query() []string {
+	var q []string
+	for _, o := range m.Options {
+		if o.ID == URIQuery {
+			q = append(q, string(o.Value))
+		}
+	}
+	return q
+}
+
+// join returns the values of the options id joined with sep.
+func (m *Message) join(id OptionID, sep string) string {
+	s := ""
+	first := true
+	for _, o := range m.Options {
+		if o.ID == id {
+			if !first {
+				s += sep
+			}
+			s += string(o.Value)
+			first = false
+		}
+	}
+	return s
+}
+
+// Block is the value of the Block1 and Block2 options of block-wise
+// transfers: the block number, whether more blocks follow, and the block
+// size, a power of two from 16 to 1024.
+type Block struct {
+	Num  uint32
+	More bool
+	Size int
+}
+
+// Block returns the value of the block option id, Block1 or Block2.
+func (m *Message) Block(id OptionID) (Block, bool) {
+	v, ok := m.Uint(id)
+	if !ok {
+		return Block{}, false
+	}
+	szx := v & 7
+	if szx == 7 {
+		// reserved
+		return Block{}, false
+	}
+	return Block{Num: v >> 4, More: v&8 != 0, Size: 16 << szx}, true
+}
+
+// SetBlock sets the block option id, Block1 or Block2. The size is rounded
+// down to a power of two from 16 to 1024.
+func (m *Message) SetBlock(id OptionID, b Block) {
+	v := b.Num<<4 | uint32(blockSZX(b.Size))
+	if b.More {
+		v |= 8
+	}
+	m.SetUint(id, v)
+}
+
+// blockSZX returns the exponent of a block size.
+func blockSZX(size int) uint8 {
+	szx := uint8(0)
+	for szx < 6 && 32<<szx <= size {
+		szx++
+	}
+	return szx
+}
+
+// Append encodes the message, and appends it to b.
+func (m *Message) Append(b []byte) ([]byte, error) {
+	if len(m.Token) > 8 {
+		return b, errors.New("coap: token longer than 8 bytes")
+	}
+	b = append(b, 1<<6|byte(m.Type)<<4|byte(len(m.Token)), byte(m.Code), byte(m.MessageID>>8), byte(m.MessageID))
+	b = append(b, m.Token...)
+
+	// options are sent sorted, as deltas from the previous option number;
+	// options with the same number keep their order
+	sortOptions(m.Options)
+	prev := OptionID(0)
+	for _, o := range m.Options {
+		if len(o.Value) > 1034 {
+			return b, errors.New("coap: option value too long")
+		}
+		delta, dext := optionNibble(int(o.ID - prev))
+		length, lext := optionNibble(len(o.Value))
+		b = append(b, delta<<4|length)
+		b = append(b, dext...)
+		b = append(b, lext...)
+		b = append(b, o.Value...)
+		prev = o.ID
+	}
+
+	if len(m.Payload) > 0 {
+		b = append(b, 0xFF)
+		b = append(b, m.Payload...)
+	}
+	return b, nil
+}
+
+// sortOptions sorts the options by number with an insertion sort, which is
+// stable and fast enough for the few options of a message.
+func sortOptions(opts []Option) {
+	for i := 1; i < len(opts); i++ {
+		for j := i; j > 0 && opts[j].ID < opts[j-1].ID; j-- {
+			opts[j], opts[j-1] = opts[j-1], opts[j]
+		}
+	}
+}
+
+// optionNibble returns the 4 bit value of an option delta or length, and its
+// extended bytes.
+func optionNibble(v int) (byte, []byte) {
+	switch {
+	case v < 13:
+		return byte(v), nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		v -= 269
+		return 14, []byte{byte(v >> 8), byte(v)}
+	}
+}
+
+// ParseMessage decodes a message. The token, the option values and the
+// payload point into b.
+func ParseMessage(b []byte) (*Message, error) {
+	if len(b) < 4 || b[0]>>6 != 1 {
+		return nil, ErrInvalidMessage
+	}
+	m := &Message{
+		Type:      Type(b[0] >> 4 & 3),
+		Code:      Code(b[1]),
+		MessageID: binary.BigEndian.Uint16(b[2:]),
+	}
+	tkl := int(b[0] & 0xF)
+	b = b[4:]
+	if tkl > 8 || len(b) < tkl {
+		return nil, ErrInvalidMessage
+	}
+	if tkl > 0 {
+		m.Token = b[:tkl]
+	}
+	b = b[tkl:]
+
+	id := 0
+	for len(b) > 0 {
+		if b[0] == 0xFF {
+			if len(b) == 1 {
+				// a payload marker without payload
+				return nil, ErrInvalidMessage
+			}
+			m.Payload = b[1:]
+			break
+		}
+		delta, length := int(b[0]>>4), int(b[0]&0xF)
+		b = b[1:]
+		var ok bool
+		if delta, b, ok = optionExtended(delta, b); !ok {
+			return nil, ErrInvalidMessage
+		}
+		if length, b, ok = optionExtended(length, b); !ok {
+			return nil, ErrInvalidMessage
+		}
+		id += delta
+		if len(b) < length || id > 0xFFFF {
+			return nil, ErrInvalidMessage
+		}
+		m.Options = append(m.Options, Option{ID: OptionID(id), Value: b[:length]})
+		b = b[length:]
+	}
+	return m, nil
+}
+
+// optionExtended decodes the extended bytes of an option delta or length.
+func optionExtended(v int, b []byte) (int, []byte, bool) {
+	switch v {
+	case 13:
+		if len(b) < 1 {
+			return 0, b, false
+		}
+		return int(b[0]) + 13, b[1:], true
+	case 14:
+		if len(b) < 2 {
+			return 0, b, false
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], true
+	case 15:
+		return 0, b, false
+	}
+	return v, b, true
+}
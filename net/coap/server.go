@@ -0,0 +1,428 @@
+package coap
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+)
+
+// HandlerFunc handles a request. The response is prepared with the code
+// Content, the token of the request and the right type and message ID; the
+// handler sets its code, options and payload. The request is only valid
+// during the call.
+type HandlerFunc func(resp, req *Message)
+
+// Router routes the requests to the handler of their path. Its zero value has
+// no routes, all the requests are answered with NotFound.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	path    []string
+	handler HandlerFunc
+}
+
+// Handle registers the handler for a path such as "/sensors/temp". A "*"
+// segment matches any segment, such as "/sensors/*".
+func (r *Router) Handle(path string, handler HandlerFunc) {
+	r.routes = append(r.routes, route{path: splitPath(path), handler: handler})
+}
+
+// lookup returns the handler of the first route that matches path, or nil.
+func (r *Router) lookup(path string) HandlerFunc {
+	segments := splitPath(path)
+	for _, route := range r.routes {
+		if len(route.path) != len(segments) {
+			continue
+		}
+		match := true
+		for i, s := range route.path {
+			if s != "*" && s != segments[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return route.handler
+		}
+	}
+	return nil
+}
+
+func splitPath(path string) []string {
+	var segments []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// A Server answers the requests received on a UDP socket. Routes are added
+// with Handle, before or while serving. It is not safe for concurrent use.
+//
+// Responses to several clients need an adaptor that implements
+// net.UDPDriver; otherwise they are sent to the sender of the last datagram
+// received, which is enough as long as the requests are answered before the
+// next one is received.
+type Server struct {
+	Router
+
+	// BlockSize is the largest payload of a response, larger ones are sent
+	// in blocks with the Block2 option. Zero means 512.
+	BlockSize int
+
+	// MaxBodySize is the maximum size of a request payload received in
+	// blocks with the Block1 option. Zero means 1024.
+	MaxBodySize int
+
+	conn      *net.UDPSerialConn
+	msgID     uint16
+	buf       [MaxMessageSize]byte
+	wbuf      []byte
+	seq       uint32
+	observers []observer
+
+	// responses to the latest confirmable requests, sent again if a request
+	// is retransmitted because its response was lost
+	recent [4]exchange
+	next   int
+
+	// block-wise request being received
+	upload upload
+}
+
+type observer struct {
+	addr  *net.UDPAddr
+	token string
+	path  string
+	// message ID of the latest notification, rejected with a reset when the
+	// client is no longer interested
+	msgID uint16
+}
+
+type exchange struct {
+	addr     string
+	msgID    uint16
+	response []byte
+}
+
+type upload struct {
+	key  string
+	body []byte
+}
+
+// Listen returns a server for the address, in ":port" form. The port is
+// DefaultPort if omitted.
+func Listen(address string) (*Server, error) {
+	if !strings.Contains(address, ":") {
+		address += ":" + strconv.Itoa(DefaultPort)
+	}
+	laddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(conn), nil
+}
+
+// NewServer returns a server answering the requests received on conn.
+func NewServer(conn *net.UDPSerialConn) *Server {
+	return &Server{conn: conn, msgID: uint16(time.Now().UnixNano())}
+}
+
+// Close closes the socket of the server.
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// Serve answers the requests until an error occurs.
+func (s *Server) Serve() error {
+	for {
+		if err := s.Poll(); err != nil {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Poll answers the requests that have been received, if any. It does not
+// block, so it can be called from the main loop of a program.
+func (s *Server) Poll() error {
+	s.conn.SetReadDeadline(time.Time{})
+	for s.conn.IsDataAvailable() {
+		n, addr, err := s.conn.ReadFrom(s.buf[:])
+		if err != nil || n == 0 {
+			return err
+		}
+		m, err := ParseMessage(s.buf[:n])
+		if err != nil {
+			continue
+		}
+		if err := s.handle(m, addr.(*net.UDPAddr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle answers a message received from addr.
+func (s *Server) handle(m *Message, addr *net.UDPAddr) error {
+	switch {
+	case m.Type == Acknowledgement:
+		return nil
+	case m.Type == Reset:
+		// the client is no longer interested in the notifications
+		for i := 0; i < len(s.observers); i++ {
+			if s.observers[i].msgID == m.MessageID && s.observers[i].addr.String() == addr.String() {
+				s.removeObserver(i)
+				i--
+			}
+		}
+		return nil
+	case !m.Code.IsRequest():
+		if m.Type == Confirmable {
+			return s.send((&Message{Type: Reset, MessageID: m.MessageID}), addr)
+		}
+		return nil
+	}
+
+	key := addr.String()
+	if m.Type == Confirmable {
+		for _, e := range s.recent {
+			if e.addr == key && e.msgID == m.MessageID {
+				return s.write(e.response, addr)
+			}
+		}
+	}
+
+	resp := &Message{Type: Acknowledgement, Code: Content, MessageID: m.MessageID, Token: m.Token}
+	if m.Type != Confirmable {
+		s.msgID++
+		resp.Type, resp.MessageID = NonConfirmable, s.msgID
+	}
+	s.serve(resp, m, addr)
+
+	var err error
+	s.wbuf, err = resp.Append(s.wbuf[:0])
+	if err != nil {
+		return err
+	}
+	if m.Type == Confirmable {
+		e := &s.recent[s.next]
+		e.addr, e.msgID, e.response = key, m.MessageID, append(e.response[:0], s.wbuf...)
+		s.next = (s.next + 1) % len(s.recent)
+	}
+	return s.write(s.wbuf, addr)
+}
+
+// serve runs the handler of a request, and prepares resp.
+func (s *Server) serve(resp, req *Message, addr *net.UDPAddr) {
+	for _, o := range req.Options {
+		if o.ID.critical() && !knownOption(o.ID) {
+			resp.Code = BadOption
+			return
+		}
+	}
+
+	if b, ok := req.Block(Block1); ok {
+		if !s.receiveBlock(resp, req, b, addr) {
+			return
+		}
+	}
+
+	handler := s.lookup(req.Path())
+	if handler == nil {
+		resp.Code = NotFound
+		return
+	}
+
+	observe, ok := req.Uint(Observe)
+	if req.Code != GET || len(req.Token) == 0 {
+		ok = false
+	}
+	if ok && observe == 1 {
+		s.removeObservers(addr, req.Token)
+	}
+
+	handler(resp, req)
+
+	if ok && observe == 0 && resp.Code.IsSuccess() {
+		s.removeObservers(addr, req.Token)
+		s.observers = append(s.observers, observer{addr: addr, token: string(req.Token), path: req.Path()})
+		resp.SetUint(Observe, s.seq)
+	}
+	s.sendBlock(resp, req)
+}
+
+// receiveBlock adds a block of a request payload to the upload in progress.
+// It returns true with the whole payload in req once the last block has been
+// received, and false with resp set otherwise.
+func (s *Server) receiveBlock(resp, req *Message, b Block, addr *net.UDPAddr) bool {
+	key := addr.String() + " " + req.Code.String() + " " + req.Path()
+	if b.Num == 0 {
+		s.upload = upload{key: key, body: s.upload.body[:0]}
+	} else if s.upload.key != key || len(s.upload.body) != int(b.Num)*b.Size {
+		resp.Code = RequestEntityIncomplete
+		return false
+	}
+
+	max := s.MaxBodySize
+	if max == 0 {
+		max = 1024
+	}
+	if size, ok := req.Uint(Size1); ok && int(size) > max || len(s.upload.body)+len(req.Payload) > max {
+		s.upload = upload{body: s.upload.body[:0]}
+		resp.Code = RequestEntityTooLarge
+		resp.SetUint(Size1, uint32(max))
+		return false
+	}
+	s.upload.body = append(s.upload.body, req.Payload...)
+	resp.SetBlock(Block1, b)
+	if b.More {
+		resp.Code = Continue
+		return false
+	}
+
+	req.Payload = s.upload.body
+	s.upload.key = ""
+	return true
+}
+
+// sendBlock keeps the block of the response payload requested by the
+// Block2 option, or the first one if the payload is larger than BlockSize.
+func (s *Server) sendBlock(resp, req *Message) {
+	if !resp.Code.IsSuccess() {
+		return
+	}
+	size := s.BlockSize
+	if size == 0 {
+		size = 512
+	}
+	size = 16 << blockSZX(size)
+	b, ok := req.Block(Block2)
+	if !ok && len(resp.Payload) <= size {
+		return
+	}
+	if ok && b.Size < size {
+		size = b.Size
+	}
+
+	offset := int(b.Num) * size
+	if offset > len(resp.Payload) {
+		resp.Code = BadOption
+		resp.Options = nil
+		resp.Payload = nil
+		return
+	}
+	end := offset + size
+	if end > len(resp.Payload) {
+		end = len(resp.Payload)
+	}
+	if offset == 0 {
+		resp.SetUint(Size2, uint32(len(resp.Payload)))
+	}
+	resp.SetBlock(Block2, Block{Num: b.Num, More: end < len(resp.Payload), Size: size})
+	resp.Payload = resp.Payload[offset:end]
+}
+
+// Notify sends a notification with the current state of the resource at
+// path to its observers. The handler of the resource is run once per
+// observer, with a GET request for path. The notifications are
+// non-confirmable; an observer that replies with a reset is removed, as well
+// as all the observers if the handler responds with an error code.
+func (s *Server) Notify(path string) error {
+	s.seq = (s.seq + 1) & 0xFFFFFF
+	for i := 0; i < len(s.observers); i++ {
+		o := &s.observers[i]
+		if o.path != strings.Trim(path, "/") {
+			continue
+		}
+		req := NewRequest(GET, path, nil)
+		req.Token = []byte(o.token)
+		s.msgID++
+		resp := &Message{Type: NonConfirmable, Code: Content, MessageID: s.msgID, Token: req.Token}
+		handler := s.lookup(path)
+		if handler == nil {
+			resp.Code = NotFound
+		} else {
+			handler(resp, req)
+		}
+		if resp.Code.IsSuccess() {
+			resp.SetUint(Observe, s.seq)
+			s.sendBlock(resp, req)
+		}
+		o.msgID = resp.MessageID
+		addr := o.addr
+		if !resp.Code.IsSuccess() {
+			// the observation ends with an error notification
+			s.removeObserver(i)
+			i--
+		}
+		if err := s.send(resp, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Observers returns the number of observers of the resource at path.
+func (s *Server) Observers(path string) int {
+	n := 0
+	for _, o := range s.observers {
+		if o.path == strings.Trim(path, "/") {
+			n++
+		}
+	}
+	return n
+}
+
+// removeObservers removes the observation of a client.
+func (s *Server) removeObservers(addr *net.UDPAddr, token []byte) {
+	for i := 0; i < len(s.observers); i++ {
+		if s.observers[i].token == string(token) && s.observers[i].addr.String() == addr.String() {
+			s.removeObserver(i)
+			i--
+		}
+	}
+}
+
+func (s *Server) removeObserver(i int) {
+	s.observers = append(s.observers[:i], s.observers[i+1:]...)
+}
+
+// send encodes a message and sends it to addr.
+func (s *Server) send(m *Message, addr *net.UDPAddr) error {
+	var err error
+	s.wbuf, err = m.Append(s.wbuf[:0])
+	if err != nil {
+		return err
+	}
+	return s.write(s.wbuf, addr)
+}
+
+// write sends a datagram to addr, or to the sender of the last datagram if
+// the adaptor cannot send to any address.
+func (s *Server) write(b []byte, addr *net.UDPAddr) error {
+	_, err := s.conn.WriteTo(b, addr)
+	if err == net.ErrNotSupported {
+		_, err = s.conn.Write(b)
+	}
+	return err
+}
+
+// knownOption reports if the server understands the critical option id.
+func knownOption(id OptionID) bool {
+	switch id {
+	case IfMatch, URIHost, IfNoneMatch, URIPort, URIPath, URIQuery, Accept, Block2, Block1, Size1, Size2:
+		return true
+	}
+	return false
+}
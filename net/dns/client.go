@@ -0,0 +1,544 @@
+// Package dns implements a DNS resolver, for adaptors that have no lookup of
+// their own, or to look up more than the IPv4 address of a host: IPv6
+// addresses, aliases, services and text records.
+//
+// The queries are sent over UDP to the servers of the Resolver, falling back
+// to TCP for truncated responses, and the answers are cached for their time
+// to live. A Resolver can replace the lookup of the adaptor for
+// net.ResolveTCPAddr, net.ResolveUDPAddr and all the functions that use them:
+//
+//	r := &dns.Resolver{Servers: []string{"192.168.1.1"}}
+//	net.UseResolver(r)
+//
+//	// the address of the service of a domain
+//	srv, err := r.LookupSRV("mqtt", "tcp", "example.com")
+package dns // import "tinygo.org/x/drivers/net/dns"
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+)
+
+// DefaultServers are the servers used by a Resolver without Servers.
+var DefaultServers = []string{"8.8.8.8", "1.1.1.1"}
+
+var (
+	// ErrNotFound is returned when the domain name does not exist, or has
+	// no records of the type looked up.
+	ErrNotFound = errors.New("dns: no such host")
+
+	// ErrServerFailure is returned when all the servers failed to answer
+	// the query.
+	ErrServerFailure = errors.New("dns: server failure")
+
+	// ErrTimeout is returned when none of the servers responded in time.
+	ErrTimeout = errors.New("dns: timeout")
+)
+
+// DefaultResolver is the resolver used by the package-level functions.
+var DefaultResolver = &Resolver{}
+
+// A Resolver looks up domain names. Its zero value is usable, and it is
+// safe for concurrent use. The fields must not be changed once it is in use.
+type Resolver struct {
+	// Servers are the addresses of the DNS servers, tried in order, such as
+	// "192.168.1.1" or "192.168.1.1:53". They must be IP addresses. If
+	// empty, DefaultServers are used.
+	Servers []string
+
+	// Timeout is the time to wait for the response of a server. Zero means
+	// 2 seconds.
+	Timeout time.Duration
+
+	// Attempts is the number of times every server is queried before
+	// giving up. Zero means 2.
+	Attempts int
+
+	// CacheSize is the maximum number of answers kept in the cache. Zero
+	// means 16, use -1 to disable the cache.
+	CacheSize int
+
+	mu    sync.Mutex
+	cache []cacheEntry
+}
+
+// cacheEntry is an answer to a query, or the absence of an answer.
+type cacheEntry struct {
+	name    string
+	typ     Type
+	records []Record
+	expires time.Time
+}
+
+// SRV is a service record.
+type SRV struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// LookupHost returns the IPv4 addresses of a host with DefaultResolver.
+func LookupHost(host string) ([]string, error) {
+	return DefaultResolver.LookupHost(host)
+}
+
+// LookupIPv6 returns the IPv6 addresses of a host with DefaultResolver.
+func LookupIPv6(host string) ([]string, error) {
+	return DefaultResolver.LookupIPv6(host)
+}
+
+// LookupCNAME returns the canonical name of a host with DefaultResolver.
+func LookupCNAME(host string) (string, error) {
+	return DefaultResolver.LookupCNAME(host)
+}
+
+// LookupSRV returns the service records of a domain with DefaultResolver.
+func LookupSRV(service, proto, name string) ([]SRV, error) {
+	return DefaultResolver.LookupSRV(service, proto, name)
+}
+
+// LookupTXT returns the text records of a domain with DefaultResolver.
+func LookupTXT(name string) ([]string, error) {
+	return DefaultResolver.LookupTXT(name)
+}
+
+// LookupHost returns the IPv4 addresses of a host. IPv4 addresses are
+// returned as is.
+func (r *Resolver) LookupHost(host string) ([]string, error) {
+	if _, ok := parseIPv4(host); ok {
+		return []string{host}, nil
+	}
+	return r.lookupIP(host, TypeA)
+}
+
+// LookupIPv6 returns the IPv6 addresses of a host.
+func (r *Resolver) LookupIPv6(host string) ([]string, error) {
+	return r.lookupIP(host, TypeAAAA)
+}
+
+func (r *Resolver) lookupIP(host string, typ Type) ([]string, error) {
+	records, err := r.Lookup(host, typ)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(records))
+	for i, rr := range records {
+		addrs[i] = rr.IP
+	}
+	return addrs, nil
+}
+
+// LookupCNAME returns the canonical name of a host, after following its
+// aliases. It is the host itself if it is not an alias.
+func (r *Resolver) LookupCNAME(host string) (string, error) {
+	records, err := r.Lookup(host, TypeA)
+	if err != nil {
+		return "", err
+	}
+	return records[0].Name, nil
+}
+
+// LookupSRV returns the service records of a domain, such as for the "mqtt"
+// service over "tcp" of "example.com". They are sorted by priority, then by
+// decreasing weight. An empty service and proto look up name directly.
+func (r *Resolver) LookupSRV(service, proto, name string) ([]SRV, error) {
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	records, err := r.Lookup(name, TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	srv := make([]SRV, len(records))
+	for i, rr := range records {
+		srv[i] = SRV{Target: rr.Target, Port: rr.Port, Priority: rr.Priority, Weight: rr.Weight}
+	}
+	// insertion sort, the list is short
+	for i := 1; i < len(srv); i++ {
+		for j := i; j > 0 && (srv[j].Priority < srv[j-1].Priority ||
+			srv[j].Priority == srv[j-1].Priority && srv[j].Weight > srv[j-1].Weight); j-- {
+			srv[j], srv[j-1] = srv[j-1], srv[j]
+		}
+	}
+	return srv, nil
+}
+
+// LookupTXT returns the text records of a domain, the strings of every
+// record are concatenated.
+func (r *Resolver) LookupTXT(name string) ([]string, error) {
+	records, err := r.Lookup(name, TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	txt := make([]string, len(records))
+	for i, rr := range records {
+		txt[i] = strings.Join(rr.Text, "")
+	}
+	return txt, nil
+}
+
+// GetDNS returns the first IPv4 address of a domain name, so that the
+// resolver can be used with net.UseResolver.
+func (r *Resolver) GetDNS(domain string) (string, error) {
+	addrs, err := r.LookupHost(domain)
+	if err != nil {
+		return "", err
+	}
+	return addrs[0], nil
+}
+
+// Lookup returns the records of a type for a domain name, from the cache or
+// from the servers. The aliases are followed, so the name of the records is
+// the canonical name of the domain. It returns ErrNotFound rather than an
+// empty list.
+func (r *Resolver) Lookup(name string, typ Type) ([]Record, error) {
+	name = strings.TrimSuffix(name, ".")
+	for hops := 0; hops < 8; hops++ {
+		records, err := r.cached(name, typ)
+		if err != nil {
+			return nil, err
+		}
+		if records == nil {
+			m, err := r.exchange(name, typ)
+			if err != nil {
+				return nil, err
+			}
+			records = r.answer(m, name, typ)
+		}
+		if len(records) == 0 {
+			return nil, ErrNotFound
+		}
+		if records[0].Type == typ || typ == TypeANY {
+			return records, nil
+		}
+		// the server did not follow the alias
+		name = records[0].Target
+	}
+	return nil, errors.New("dns: too many aliases")
+}
+
+// answer returns the records of the response for the name, and caches them.
+// Aliases are followed, and if there are none of the type at the end of the
+// chain, the last alias is returned.
+func (r *Resolver) answer(m *Message, name string, typ Type) []Record {
+	var records []Record
+	var ttl uint32
+	first := true
+	canonical := name
+	for hops := 0; hops < 8; hops++ {
+		var alias *Record
+		for i := range m.Answers {
+			rr := &m.Answers[i]
+			if !strings.EqualFold(rr.Name, canonical) {
+				continue
+			}
+			if rr.Type == typ || typ == TypeANY {
+				records = append(records, *rr)
+			} else if rr.Type == TypeCNAME {
+				alias = rr
+			} else {
+				continue
+			}
+			if first || rr.TTL < ttl {
+				ttl, first = rr.TTL, false
+			}
+		}
+		if len(records) > 0 || alias == nil {
+			break
+		}
+		records = nil
+		canonical = alias.Target
+		if hops == 7 || !hasName(m.Answers, canonical) {
+			records = []Record{*alias}
+			break
+		}
+	}
+
+	if len(records) == 0 {
+		// negative answer, cached for the minimum TTL of the SOA record of
+		// the zone, if any
+		for _, rr := range m.Authorities {
+			if rr.Type == TypeSOA && len(rr.Data) >= 20 {
+				min := uint32(rr.Data[len(rr.Data)-4])<<24 | uint32(rr.Data[len(rr.Data)-3])<<16 |
+					uint32(rr.Data[len(rr.Data)-2])<<8 | uint32(rr.Data[len(rr.Data)-1])
+				ttl, first = rr.TTL, false
+				if min < ttl {
+					ttl = min
+				}
+			}
+		}
+		if first {
+			return nil
+		}
+	}
+
+	// the data of the records points into the response
+	for i := range records {
+		records[i].Data = append([]byte(nil), records[i].Data...)
+	}
+	r.store(name, typ, records, ttl)
+	return records
+}
+
+func hasName(records []Record, name string) bool {
+	for _, rr := range records {
+		if strings.EqualFold(rr.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// cached returns the records of the cache for name and type, or nil if they
+// are not in the cache. An answer without records is returned as
+// ErrNotFound.
+func (r *Resolver) cached(name string, typ Type) ([]Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for i := range r.cache {
+		e := &r.cache[i]
+		if e.typ != typ || !strings.EqualFold(e.name, name) {
+			continue
+		}
+		if now.After(e.expires) {
+			r.cache = append(r.cache[:i], r.cache[i+1:]...)
+			return nil, nil
+		}
+		if len(e.records) == 0 {
+			return nil, ErrNotFound
+		}
+		// the TTL of the records is what remains of it
+		records := make([]Record, len(e.records))
+		copy(records, e.records)
+		for j := range records {
+			records[j].TTL = uint32(e.expires.Sub(now) / time.Second)
+		}
+		return records, nil
+	}
+	return nil, nil
+}
+
+// store adds an answer to the cache, replacing the one that expires first
+// when the cache is full.
+func (r *Resolver) store(name string, typ Type, records []Record, ttl uint32) {
+	size := r.CacheSize
+	switch {
+	case size == 0:
+		size = 16
+	case size < 0:
+		return
+	}
+	if ttl == 0 {
+		return
+	}
+	e := cacheEntry{name: name, typ: typ, records: records, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.cache {
+		if r.cache[i].typ == typ && strings.EqualFold(r.cache[i].name, name) {
+			r.cache[i] = e
+			return
+		}
+	}
+	if len(r.cache) < size {
+		r.cache = append(r.cache, e)
+		return
+	}
+	oldest := 0
+	for i := range r.cache {
+		if r.cache[i].expires.Before(r.cache[oldest].expires) {
+			oldest = i
+		}
+	}
+	r.cache[oldest] = e
+}
+
+// Flush empties the cache.
+func (r *Resolver) Flush() {
+	r.mu.Lock()
+	r.cache = nil
+	r.mu.Unlock()
+}
+
+// exchange sends a query to the servers until one of them answers.
+func (r *Resolver) exchange(name string, typ Type) (*Message, error) {
+	servers := r.Servers
+	if len(servers) == 0 {
+		servers = DefaultServers
+	}
+	attempts := r.Attempts
+	if attempts == 0 {
+		attempts = 2
+	}
+
+	var id [2]byte
+	rand.Read(id[:])
+	query := &Message{
+		ID:               uint16(id[0])<<8 | uint16(id[1]),
+		RecursionDesired: true,
+		Questions:        []Question{{Name: name, Type: typ, Class: ClassINET}},
+	}
+	b, err := query.Append(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ErrTimeout
+	for i := 0; i < attempts; i++ {
+		for _, server := range servers {
+			m, e := r.query(server, b, query)
+			if e == nil && m.Truncated {
+				m, e = r.queryTCP(server, b, query)
+			}
+			if e != nil {
+				if e != ErrTimeout || err == ErrTimeout {
+					err = e
+				}
+				continue
+			}
+			switch m.RCode {
+			case RCodeSuccess:
+				return m, nil
+			case RCodeNameError:
+				// the name does not exist, cached like an empty answer
+				m.Answers = nil
+				return m, nil
+			default:
+				err = ErrServerFailure
+			}
+		}
+	}
+	return nil, err
+}
+
+// query sends a query over UDP and returns its response.
+func (r *Resolver) query(server string, b []byte, query *Message) (*Message, error) {
+	addr, err := serverAddr(server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: addr.IP, Port: addr.Port})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(r.timeout()))
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err == net.ErrDeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		if err != nil {
+			return nil, err
+		}
+		m, err := ParseMessage(buf[:n])
+		if err != nil && n == len(buf) {
+			// larger than 512 bytes, the whole response is asked over TCP
+			return &Message{Truncated: true}, nil
+		}
+		if err == nil && isResponse(m, query) {
+			return m, nil
+		}
+	}
+}
+
+// queryTCP sends a query over TCP, for the responses that do not fit in a
+// datagram.
+func (r *Resolver) queryTCP(server string, b []byte, query *Message) (*Message, error) {
+	addr, err := serverAddr(server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.timeout()))
+
+	// messages are prefixed with their length over TCP
+	if _, err := conn.Write(append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if err := readFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, int(length[0])<<8|int(length[1]))
+	if err := readFull(conn, resp); err != nil {
+		return nil, err
+	}
+	m, err := ParseMessage(resp)
+	if err != nil {
+		return nil, err
+	}
+	if !isResponse(m, query) {
+		return nil, ErrInvalidMessage
+	}
+	return m, nil
+}
+
+// readFull reads len(b) bytes, waiting for them until the deadline of conn.
+func readFull(conn net.Conn, b []byte) error {
+	for len(b) > 0 {
+		n, err := conn.Read(b)
+		if err == net.ErrDeadlineExceeded {
+			return ErrTimeout
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// isResponse reports if m is the response to the query.
+func isResponse(m, query *Message) bool {
+	if !m.Response || m.ID != query.ID || len(m.Questions) != 1 {
+		return false
+	}
+	q := m.Questions[0]
+	return q.Type == query.Questions[0].Type && strings.EqualFold(q.Name, query.Questions[0].Name)
+}
+
+// serverAddr parses the address of a server, the port is 53 if omitted.
+func serverAddr(server string) (*net.TCPAddr, error) {
+	host, port := server, 53
+	if i := strings.LastIndexByte(server, ':'); i >= 0 {
+		p, err := strconv.Atoi(server[i+1:])
+		if err != nil {
+			return nil, errors.New("dns: invalid server address " + server)
+		}
+		host, port = server[:i], p
+	}
+	if _, ok := parseIPv4(host); !ok {
+		return nil, errors.New("dns: server address is not an IPv4 address: " + server)
+	}
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: port}, nil
+}
+
+func (r *Resolver) timeout() time.Duration {
+	if r.Timeout == 0 {
+		return 2 * time.Second
+	}
+	return r.Timeout
+}
@@ -0,0 +1,258 @@
+package dns
+
+import (
+	"encoding/binary"
+	stdnet "net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+func TestIPv6(t *testing.T) {
+	for _, s := range []string{"2001:db8::1", "::1", "::", "fe80::1:2:3:4", "1:2:3:4:5:6:7:8", "1::", "1:0:0:2::3"} {
+		ip, ok := parseIPv6(s)
+		if !ok {
+			t.Fatal(s)
+		}
+		if f := formatIPv6(ip[:]); f != s {
+			t.Fatal(s, f)
+		}
+	}
+	for _, s := range []string{"1:2", "1::2::3", "g::", "1:2:3:4:5:6:7:8:9"} {
+		if _, ok := parseIPv6(s); ok {
+			t.Fatal(s)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	// response for www.example.com with a CNAME to example.com, compressed
+	b := []byte{0x12, 0x34, 0x81, 0x80, 0, 1, 0, 2, 0, 0, 0, 0}
+	b = append(b, 3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1)
+	b = append(b, 0xC0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xC0, 16)
+	b = append(b, 0xC0, 16, 0, 1, 0, 1, 0, 0, 1, 0, 0, 4, 93, 184, 216, 34)
+	m, err := ParseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 0x1234 || !m.Response || !m.RecursionAvailable || m.Questions[0].Name != "www.example.com" {
+		t.Fatalf("%+v", m)
+	}
+	if m.Answers[0].Target != "example.com" || m.Answers[1].IP != "93.184.216.34" || m.Answers[1].TTL != 256 {
+		t.Fatalf("%+v", m.Answers)
+	}
+	// pointer loop
+	loop := append([]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}, 0xC0, 12, 0, 1, 0, 1)
+	if _, err := ParseMessage(loop); err == nil {
+		t.Fatal("loop")
+	}
+}
+
+// server is a DNS server on the host, answering with the records of zone.
+type server struct {
+	addr    string
+	queries int32
+	tcp     int32
+}
+
+var zone = map[string][]Record{
+	"www.example.com/1":   {{Name: "www.example.com", Type: TypeCNAME, Class: ClassINET, TTL: 300, Target: "example.com"}, {Name: "example.com", Type: TypeA, Class: ClassINET, TTL: 60, IP: "93.184.216.34"}, {Name: "example.com", Type: TypeA, Class: ClassINET, TTL: 120, IP: "93.184.216.35"}},
+	"alias.example.com/1": {{Name: "alias.example.com", Type: TypeCNAME, Class: ClassINET, TTL: 300, Target: "example.com"}},
+	"example.com/1":       {{Name: "example.com", Type: TypeA, Class: ClassINET, TTL: 60, IP: "93.184.216.34"}},
+	"example.com/28":      {{Name: "example.com", Type: TypeAAAA, Class: ClassINET, TTL: 60, IP: "2606:2800:220:1:248:1893:25c8:1946"}},
+	"_mqtt._tcp.example.com/33": {
+		{Name: "_mqtt._tcp.example.com", Type: TypeSRV, Class: ClassINET, TTL: 60, Priority: 20, Weight: 5, Port: 1883, Target: "b.example.com"},
+		{Name: "_mqtt._tcp.example.com", Type: TypeSRV, Class: ClassINET, TTL: 60, Priority: 10, Weight: 1, Port: 1883, Target: "c.example.com"},
+		{Name: "_mqtt._tcp.example.com", Type: TypeSRV, Class: ClassINET, TTL: 60, Priority: 10, Weight: 9, Port: 8883, Target: "a.example.com"},
+	},
+	"example.com/16": {{Name: "example.com", Type: TypeTXT, Class: ClassINET, TTL: 60, Text: []string{"v=spf1 ", "-all"}}},
+	"loop.example.com/1": {
+		{Name: "loop.example.com", Type: TypeCNAME, Class: ClassINET, TTL: 60, Target: "loop2.example.com"},
+		{Name: "loop2.example.com", Type: TypeCNAME, Class: ClassINET, TTL: 60, Target: "loop.example.com"},
+	},
+}
+
+func (s *server) respond(b []byte) []byte {
+	q, err := ParseMessage(b)
+	if err != nil {
+		return nil
+	}
+	m := &Message{ID: q.ID, Response: true, RecursionAvailable: true, Questions: q.Questions}
+	name := q.Questions[0].Name
+	records, ok := zone[name+"/"+strconv.Itoa(int(q.Questions[0].Type))]
+	if name == "big.example.com" {
+		for i := 0; i < 40; i++ {
+			records = append(records, Record{Name: name, Type: TypeA, Class: ClassINET, TTL: 60, IP: "10.0.0." + strconv.Itoa(i)})
+		}
+		ok = true
+	}
+	if !ok {
+		m.RCode = RCodeNameError
+		soa := []byte{1, 'a', 0, 1, 'b', 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0, 30}
+		m.Authorities = []Record{{Name: "example.com", Type: TypeSOA, Class: ClassINET, TTL: 3600, Data: soa}}
+	}
+	m.Answers = records
+	out, err := m.Append(nil)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{}
+	uc, err := stdnet.ListenUDP("udp", &stdnet.UDPAddr{IP: stdnet.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uc.LocalAddr().(*stdnet.UDPAddr).Port
+	tl, err := stdnet.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { uc.Close(); tl.Close() })
+	s.addr = "127.0.0.1:" + strconv.Itoa(port)
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, from, err := uc.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.queries, 1)
+			out := s.respond(b[:n])
+			if len(out) > 512 {
+				m, _ := ParseMessage(out)
+				m.Answers, m.Truncated = nil, true
+				out, _ = m.Append(nil)
+			}
+			uc.WriteToUDP(out, from)
+		}
+	}()
+	go func() {
+		for {
+			c, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.tcp, 1)
+			var l [2]byte
+			c.Read(l[:])
+			b := make([]byte, binary.BigEndian.Uint16(l[:]))
+			c.Read(b)
+			out := s.respond(b)
+			c.Write(append([]byte{byte(len(out) >> 8), byte(len(out))}, out...))
+			c.Close()
+		}
+	}()
+	return s
+}
+
+func TestResolver(t *testing.T) {
+	net.UseDriver(loopback.New())
+	s := newServer(t)
+	r := &Resolver{Servers: []string{s.addr}, Timeout: 500 * time.Millisecond}
+
+	addrs, err := r.LookupHost("www.example.com")
+	if err != nil || len(addrs) != 2 || addrs[0] != "93.184.216.34" {
+		t.Fatal(addrs, err)
+	}
+	addrs, err = r.LookupHost("WWW.example.com.")
+	if err != nil || len(addrs) != 2 || atomic.LoadInt32(&s.queries) != 1 {
+		t.Fatal("cache", addrs, err, s.queries)
+	}
+	recs, _ := r.Lookup("www.example.com", TypeA)
+	if recs[0].TTL > 60 || recs[0].TTL < 58 || recs[0].Name != "example.com" {
+		t.Fatalf("ttl %+v", recs[0])
+	}
+	if cname, err := r.LookupCNAME("www.example.com"); cname != "example.com" || err != nil {
+		t.Fatal(cname, err)
+	}
+
+	// alias without the address in the response
+	addrs, err = r.LookupHost("alias.example.com")
+	if err != nil || len(addrs) != 1 || addrs[0] != "93.184.216.34" {
+		t.Fatal(addrs, err)
+	}
+
+	v6, err := r.LookupIPv6("example.com")
+	if err != nil || v6[0] != "2606:2800:220:1:248:1893:25c8:1946" {
+		t.Fatal(v6, err)
+	}
+	srv, err := r.LookupSRV("mqtt", "tcp", "example.com")
+	if err != nil || len(srv) != 3 || srv[0].Target != "a.example.com" || srv[1].Target != "c.example.com" || srv[2].Port != 1883 {
+		t.Fatal(srv, err)
+	}
+	txt, err := r.LookupTXT("example.com")
+	if err != nil || txt[0] != "v=spf1 -all" {
+		t.Fatal(txt, err)
+	}
+
+	// negative answer, cached
+	q := atomic.LoadInt32(&s.queries)
+	if _, err := r.LookupHost("nope.example.com"); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupHost("nope.example.com"); err != ErrNotFound || atomic.LoadInt32(&s.queries) != q+1 {
+		t.Fatal(err, s.queries, q)
+	}
+
+	// aliases pointing to each other
+	if _, err := r.LookupHost("loop.example.com"); err == nil || err == ErrNotFound {
+		t.Fatal(err)
+	}
+
+	// truncated response
+	addrs, err = r.LookupHost("big.example.com")
+	if err != nil || len(addrs) != 40 || atomic.LoadInt32(&s.tcp) != 1 {
+		t.Fatal(len(addrs), err, s.tcp)
+	}
+
+	if a, _ := r.LookupHost("10.1.2.3"); a[0] != "10.1.2.3" {
+		t.Fatal(a)
+	}
+
+	// pluggable into net
+	net.UseResolver(r)
+	defer net.UseResolver(nil)
+	a, err := net.ResolveTCPAddr("tcp", "example.com:80")
+	if err != nil || a.IP.String() != "93.184.216.34" || a.Port != 80 {
+		t.Fatal(a, err)
+	}
+
+	// cache size
+	r2 := &Resolver{Servers: []string{s.addr}, CacheSize: 1}
+	r2.LookupHost("example.com")
+	r2.LookupIPv6("example.com")
+	if len(r2.cache) != 1 {
+		t.Fatal(len(r2.cache))
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	net.UseDriver(loopback.New())
+	uc, _ := stdnet.ListenUDP("udp", &stdnet.UDPAddr{IP: stdnet.IPv4(127, 0, 0, 1)})
+	defer uc.Close()
+	var n int32
+	go func() {
+		b := make([]byte, 512)
+		for {
+			if _, _, err := uc.ReadFromUDP(b); err != nil {
+				return
+			}
+			atomic.AddInt32(&n, 1)
+		}
+	}()
+	r := &Resolver{Servers: []string{uc.LocalAddr().String()}, Timeout: 50 * time.Millisecond, Attempts: 3}
+	if _, err := r.LookupHost("example.com"); err != ErrTimeout {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&n) != 3 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,472 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidMessage is returned when parsing a message that is malformed.
+var ErrInvalidMessage = errors.New("dns: invalid message")
+
+// Type is the type of a record or question.
+type Type uint16
+
+const (
+	TypeA     Type = 1
+	TypeNS    Type = 2
+	TypeCNAME Type = 5
+	TypeSOA   Type = 6
+	TypePTR   Type = 12
+	TypeTXT   Type = 16
+	TypeAAAA  Type = 28
+	TypeSRV   Type = 33
+	TypeANY   Type = 255
+)
+
+// Class is the class of a record or question. Multicast DNS uses its top
+// bit as the unicast-response bit of questions, and as the cache-flush bit of
+// records.
+type Class uint16
+
+const ClassINET Class = 1
+
+// RCode is the response code of a message.
+type RCode uint8
+
+const (
+	RCodeSuccess        RCode = 0
+	RCodeFormatError    RCode = 1
+	RCodeServerFailure  RCode = 2
+	RCodeNameError      RCode = 3 // the domain name does not exist
+	RCodeNotImplemented RCode = 4
+	RCodeRefused        RCode = 5
+)
+
+// Message is a DNS query or response.
+type Message struct {
+	ID                 uint16
+	Response           bool
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	RCode              RCode
+
+	Questions   []Question
+	Answers     []Record
+	Authorities []Record
+	Additionals []Record
+}
+
+// Question is an entry of the question section.
+type Question struct {
+	Name  string
+	Type  Type
+	Class Class
+}
+
+// Record is a resource record. Names are written without the trailing dot,
+// such as "example.com".
+//
+// The data of the A, AAAA, CNAME, NS, PTR, SRV and TXT records is decoded
+// into the fields below, and encoded from them. Data holds the raw data of
+// the records received, and is encoded for the other types.
+type Record struct {
+	Name  string
+	Type  Type
+	Class Class
+	TTL   uint32
+
+	// IP is the address of A and AAAA records, in string form like net.IP.
+	IP string
+
+	// Target is the domain name of CNAME, NS, PTR and SRV records.
+	Target string
+
+	// Priority, Weight and Port are the fields of SRV records.
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+
+	// Text is the strings of TXT records.
+	Text []string
+
+	Data []byte
+}
+
+// Append encodes the message, and appends it to b. Names are not
+// compressed.
+func (m *Message) Append(b []byte) ([]byte, error) {
+	var flags uint16
+	if m.Response {
+		flags |= 1 << 15
+	}
+	if m.Authoritative {
+		flags |= 1 << 10
+	}
+	if m.Truncated {
+		flags |= 1 << 9
+	}
+	if m.RecursionDesired {
+		flags |= 1 << 8
+	}
+	if m.RecursionAvailable {
+		flags |= 1 << 7
+	}
+	flags |= uint16(m.RCode & 0xF)
+	b = appendUint16(b, m.ID)
+	b = appendUint16(b, flags)
+	b = appendUint16(b, uint16(len(m.Questions)))
+	b = appendUint16(b, uint16(len(m.Answers)))
+	b = appendUint16(b, uint16(len(m.Authorities)))
+	b = appendUint16(b, uint16(len(m.Additionals)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return b, err
+		}
+		b = appendUint16(b, uint16(q.Type))
+		b = appendUint16(b, uint16(q.Class))
+	}
+	for _, section := range [][]Record{m.Answers, m.Authorities, m.Additionals} {
+		for i := range section {
+			if b, err = section[i].append(b); err != nil {
+				return b, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// append encodes the record, and appends it to b.
+func (r *Record) append(b []byte) ([]byte, error) {
+	b, err := appendName(b, r.Name)
+	if err != nil {
+		return b, err
+	}
+	b = appendUint16(b, uint16(r.Type))
+	b = appendUint16(b, uint16(r.Class))
+	b = appendUint16(b, uint16(r.TTL>>16))
+	b = appendUint16(b, uint16(r.TTL))
+
+	// the length of the data is set once it has been appended
+	b = appendUint16(b, 0)
+	start := len(b)
+	switch r.Type {
+	case TypeA:
+		ip, ok := parseIPv4(r.IP)
+		if !ok {
+			return b, errors.New("dns: invalid IPv4 address " + r.IP)
+		}
+		b = append(b, ip[:]...)
+	case TypeAAAA:
+		ip, ok := parseIPv6(r.IP)
+		if !ok {
+			return b, errors.New("dns: invalid IPv6 address " + r.IP)
+		}
+		b = append(b, ip[:]...)
+	case TypeCNAME, TypeNS, TypePTR:
+		b, err = appendName(b, r.Target)
+	case TypeSRV:
+		b = appendUint16(b, r.Priority)
+		b = appendUint16(b, r.Weight)
+		b = appendUint16(b, r.Port)
+		b, err = appendName(b, r.Target)
+	case TypeTXT:
+		for _, s := range r.Text {
+			if len(s) > 255 {
+				return b, errors.New("dns: TXT string longer than 255 bytes")
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+		if len(r.Text) == 0 {
+			// a TXT record has at least one string
+			b = append(b, 0)
+		}
+	default:
+		b = append(b, r.Data...)
+	}
+	if err != nil {
+		return b, err
+	}
+	binary.BigEndian.PutUint16(b[start-2:], uint16(len(b)-start))
+	return b, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// appendName encodes a domain name as a sequence of labels.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return b, errors.New("dns: name too long")
+	}
+	for name != "" {
+		label := name
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			label, name = name[:i], name[i+1:]
+		} else {
+			name = ""
+		}
+		if len(label) == 0 || len(label) > 63 {
+			return b, errors.New("dns: invalid label in name")
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// ParseMessage decodes a message. The Data of the records point into b.
+func ParseMessage(b []byte) (*Message, error) {
+	if len(b) < 12 {
+		return nil, ErrInvalidMessage
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	m := &Message{
+		ID:                 binary.BigEndian.Uint16(b),
+		Response:           flags&(1<<15) != 0,
+		Authoritative:      flags&(1<<10) != 0,
+		Truncated:          flags&(1<<9) != 0,
+		RecursionDesired:   flags&(1<<8) != 0,
+		RecursionAvailable: flags&(1<<7) != 0,
+		RCode:              RCode(flags & 0xF),
+	}
+	var counts [4]int
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(b[4+2*i:]))
+	}
+
+	offset := 12
+	for i := 0; i < counts[0]; i++ {
+		name, n, err := parseName(b, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = n
+		if len(b) < offset+4 {
+			return nil, ErrInvalidMessage
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  Type(binary.BigEndian.Uint16(b[offset:])),
+			Class: Class(binary.BigEndian.Uint16(b[offset+2:])),
+		})
+		offset += 4
+	}
+	sections := []*[]Record{&m.Answers, &m.Authorities, &m.Additionals}
+	for i, section := range sections {
+		for j := 0; j < counts[i+1]; j++ {
+			r, n, err := parseRecord(b, offset)
+			if err != nil {
+				return nil, err
+			}
+			*section = append(*section, r)
+			offset = n
+		}
+	}
+	return m, nil
+}
+
+// parseRecord decodes the record at offset, and returns the offset of the
+// next one.
+func parseRecord(b []byte, offset int) (Record, int, error) {
+	var r Record
+	name, offset, err := parseName(b, offset)
+	if err != nil {
+		return r, 0, err
+	}
+	if len(b) < offset+10 {
+		return r, 0, ErrInvalidMessage
+	}
+	r.Name = name
+	r.Type = Type(binary.BigEndian.Uint16(b[offset:]))
+	r.Class = Class(binary.BigEndian.Uint16(b[offset+2:]))
+	r.TTL = binary.BigEndian.Uint32(b[offset+4:])
+	length := int(binary.BigEndian.Uint16(b[offset+8:]))
+	offset += 10
+	end := offset + length
+	if len(b) < end {
+		return r, 0, ErrInvalidMessage
+	}
+	r.Data = b[offset:end]
+
+	switch r.Type {
+	case TypeA:
+		if length != 4 {
+			return r, 0, ErrInvalidMessage
+		}
+		r.IP = formatIPv4(r.Data)
+	case TypeAAAA:
+		if length != 16 {
+			return r, 0, ErrInvalidMessage
+		}
+		r.IP = formatIPv6(r.Data)
+	case TypeCNAME, TypeNS, TypePTR:
+		if r.Target, _, err = parseName(b[:end], offset); err != nil {
+			return r, 0, err
+		}
+	case TypeSRV:
+		if length < 7 {
+			return r, 0, ErrInvalidMessage
+		}
+		r.Priority = binary.BigEndian.Uint16(b[offset:])
+		r.Weight = binary.BigEndian.Uint16(b[offset+2:])
+		r.Port = binary.BigEndian.Uint16(b[offset+4:])
+		if r.Target, _, err = parseName(b[:end], offset+6); err != nil {
+			return r, 0, err
+		}
+	case TypeTXT:
+		for data := r.Data; len(data) > 0; {
+			n := int(data[0])
+			if len(data) < 1+n {
+				return r, 0, ErrInvalidMessage
+			}
+			r.Text = append(r.Text, string(data[1:1+n]))
+			data = data[1+n:]
+		}
+	}
+	return r, end, nil
+}
+
+// parseName decodes the name at offset, following the compression pointers,
+// and returns the offset after it.
+func parseName(b []byte, offset int) (string, int, error) {
+	var name []byte
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(b) {
+			return "", 0, ErrInvalidMessage
+		}
+		n := int(b[offset])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return string(name), next, nil
+		case n&0xC0 == 0xC0:
+			// pointer to a previous name
+			if offset+1 >= len(b) || jumps > 10 {
+				return "", 0, ErrInvalidMessage
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(b[offset:]) & 0x3FFF)
+			jumps++
+		case n <= 63:
+			if offset+1+n > len(b) || len(name)+n > 254 {
+				return "", 0, ErrInvalidMessage
+			}
+			if len(name) > 0 {
+				name = append(name, '.')
+			}
+			name = append(name, b[offset+1:offset+1+n]...)
+			offset += 1 + n
+		default:
+			return "", 0, ErrInvalidMessage
+		}
+	}
+}
+
+// parseIPv4 parses an IPv4 address in dotted decimal form.
+func parseIPv4(s string) (ip [4]byte, ok bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return ip, false
+	}
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 || v > 255 || len(p) > 3 {
+			return ip, false
+		}
+		ip[i] = byte(v)
+	}
+	return ip, true
+}
+
+func formatIPv4(b []byte) string {
+	return strconv.Itoa(int(b[0])) + "." + strconv.Itoa(int(b[1])) + "." +
+		strconv.Itoa(int(b[2])) + "." + strconv.Itoa(int(b[3]))
+}
+
+// parseIPv6 parses an IPv6 address, such as "2001:db8::1".
+func parseIPv6(s string) (ip [16]byte, ok bool) {
+	var head, tail []string
+	if i := strings.Index(s, "::"); i >= 0 {
+		if s[:i] != "" {
+			head = strings.Split(s[:i], ":")
+		}
+		if s[i+2:] != "" {
+			tail = strings.Split(s[i+2:], ":")
+		}
+		if len(head)+len(tail) > 7 {
+			return ip, false
+		}
+	} else {
+		head = strings.Split(s, ":")
+		if len(head) != 8 {
+			return ip, false
+		}
+	}
+	groups := make([]string, 8)
+	copy(groups, head)
+	copy(groups[8-len(tail):], tail)
+	for i, g := range groups {
+		if g == "" {
+			if i < len(head) || i >= 8-len(tail) {
+				return ip, false
+			}
+			continue
+		}
+		v, err := strconv.ParseUint(g, 16, 16)
+		if err != nil {
+			return ip, false
+		}
+		ip[2*i], ip[2*i+1] = byte(v>>8), byte(v)
+	}
+	return ip, true
+}
+
+// formatIPv6 formats an IPv6 address, with the longest run of zero groups
+// replaced by "::".
+func formatIPv6(b []byte) string {
+	var groups [8]uint16
+	for i := range groups {
+		groups[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	start, length := -1, 0
+	for i := 0; i < 8; {
+		j := i
+		for j < 8 && groups[j] == 0 {
+			j++
+		}
+		if j-i > length && j-i > 1 {
+			start, length = i, j-i
+		}
+		if j == i {
+			j++
+		}
+		i = j
+	}
+	s := ""
+	for i := 0; i < 8; i++ {
+		if i == start {
+			s += "::"
+			i += length - 1
+			continue
+		}
+		if s != "" && !strings.HasSuffix(s, ":") {
+			s += ":"
+		}
+		s += strconv.FormatUint(uint64(groups[i]), 16)
+	}
+	return s
+}
@@ -1,27 +1,143 @@
 package net
 
+import (
+	"errors"
+	"time"
+)
+
+// Socket is a handle to a socket managed by a DeviceDriver. Its value is
+// only meaningful to the driver that returned it.
+type Socket int
+
+// NoSocket is the value of a Socket that is not open.
+const NoSocket Socket = -1
+
+// Protocol is the transport protocol of a socket.
+type Protocol uint8
+
+const (
+	ProtocolTCP Protocol = iota
+	ProtocolUDP
+	ProtocolTLS
+)
+
+var (
+	// ErrNoDriver is returned when no DeviceDriver has been set with
+	// UseDriver.
+	ErrNoDriver = errors.New("net: no device driver set")
+
+	// ErrNoSocketAvail is returned by OpenSocket when all the sockets of the
+	// device are already in use.
+	ErrNoSocketAvail = errors.New("net: no socket available")
+
+	// ErrInvalidSocket is returned when a socket handle is not open.
+	ErrInvalidSocket = errors.New("net: invalid socket")
+
+	// ErrNotSupported is returned when the DeviceDriver does not implement
+	// an optional interface needed by the operation, such as UDPDriver.
+	ErrNotSupported = errors.New("net: operation not supported by the device driver")
+)
+
+// DeviceDriver is the interface implemented by network adaptors, such as
+// the espat and wifinina drivers, to be used by this package.
+//
+// Sockets are referred to by the handle returned from OpenSocket, so any
+// number of connections can be open at the same time, up to the limit of the
+// device.
 type DeviceDriver interface {
+	// GetDNS returns the IP address for a domain name.
 	GetDNS(domain string) (string, error)
-	ConnectTCPSocket(addr, port string) error
-	ConnectSSLSocket(addr, port string) error
-	ConnectUDPSocket(addr, sendport, listenport string) error
-	DisconnectSocket() error
-	StartSocketSend(size int) error
-	Write(b []byte) (n int, err error)
-	ReadSocket(b []byte) (n int, err error)
-	IsSocketDataAvailable() bool
-
-	// FIXME: this is really specific to espat, and maybe shouldn't be part
-	// of the driver interface
-	Response(timeout int) ([]byte, error)
+
+	// OpenSocket allocates a new socket for the given protocol.
+	OpenSocket(protocol Protocol) (Socket, error)
+
+	// ConnectSocket connects the socket to the remote address and port.
+	// For UDP sockets localPort is the port that will be listened to in
+	// order to receive incoming messages, it is ignored otherwise.
+	ConnectSocket(sock Socket, addr string, port, localPort int) error
+
+	// SendSocket sends data over the socket.
+	SendSocket(sock Socket, b []byte) (n int, err error)
+
+	// RecvSocket reads the data that has been received by the socket. It
+	// does not block, and returns 0 if no data is available yet. UDP sockets
+	// return the data of a single datagram, the rest of a datagram larger
+	// than b is returned by the next calls.
+	RecvSocket(sock Socket, b []byte) (n int, err error)
+
+	// IsSocketDataAvailable returns if there is data available to be read
+	// from the socket.
+	IsSocketDataAvailable(sock Socket) bool
+
+	// SocketRemoteAddr returns the address and port of the sender of the
+	// last datagram received by a UDP socket.
+	SocketRemoteAddr(sock Socket) (addr string, port int, err error)
+
+	// ListenSocket opens a new socket that listens for incoming connections
+	// on the local port.
+	ListenSocket(protocol Protocol, port int) (Socket, error)
+
+	// AcceptSocket returns a new socket for the next incoming connection of
+	// a listening socket. It does not block, and returns NoSocket if no
+	// connection is waiting yet.
+	AcceptSocket(sock Socket) (Socket, error)
+
+	// CloseSocket closes the socket and releases it.
+	CloseSocket(sock Socket) error
+}
+
+// UDPDriver is implemented by the DeviceDriver of adaptors that can send a
+// datagram from a UDP socket to any address, rather than only to the remote
+// address of the socket. It is needed by UDP servers that answer several
+// clients.
+type UDPDriver interface {
+	// SendSocketTo sends a datagram over the UDP socket to the address and
+	// port.
+	SendSocketTo(sock Socket, b []byte, addr string, port int) (n int, err error)
+}
+
+// DeadlineDriver is implemented by the DeviceDriver of adaptors whose socket
+// operations wait for the device, such as for the confirmation that data has
+// been sent, so that they give up at the deadline of the connection.
+type DeadlineDriver interface {
+	// SetSocketDeadline sets the time after which the operations on the
+	// socket return ErrDeadlineExceeded rather than wait for the device. A
+	// zero value for t means they do not time out.
+	SetSocketDeadline(sock Socket, t time.Time) error
 }
 
+// MulticastDriver is implemented by the DeviceDriver of adaptors that can
+// receive the datagrams sent to a multicast group, such as for multicast DNS.
+type MulticastDriver interface {
+	// ListenMulticastSocket opens a new UDP socket that receives the
+	// datagrams sent to the group address and port. The datagrams sent over
+	// the socket with SendSocketTo can be addressed to the group.
+	ListenMulticastSocket(group string, port int) (Socket, error)
+}
+
+// ActiveDevice is the driver used by Dial, Listen and the other functions of
+// this package.
 var ActiveDevice DeviceDriver
 
+// UseDriver sets the driver used by this package. Connections that are
+// already open keep using the driver they were created with.
 func UseDriver(driver DeviceDriver) {
-	// TODO: rethink and refactor this
-	if ActiveDevice != nil {
-		panic("net.ActiveDevice is already set")
-	}
 	ActiveDevice = driver
 }
+
+// Resolver looks up the IP address of a domain name, for ResolveTCPAddr and
+// ResolveUDPAddr. Every DeviceDriver is one, using the lookup of the adaptor.
+type Resolver interface {
+	// GetDNS returns the IP address for a domain name.
+	GetDNS(domain string) (string, error)
+}
+
+// ActiveResolver is the resolver used by ResolveTCPAddr and ResolveUDPAddr,
+// such as the one of the net/dns package. If nil, the ActiveDevice is used.
+var ActiveResolver Resolver
+
+// UseResolver sets the resolver used by this package instead of the lookup
+// of the adaptor, or restores it if r is nil.
+func UseResolver(r Resolver) {
+	ActiveResolver = r
+}
@@ -0,0 +1,178 @@
+// Package http is a minimal HTTP/1.1 client built on the net and net/tls
+// packages of the drivers. It tries to provide a mostly compatible interface
+// to the client of the Go standard library's net/http package.
+//
+// Memory use is kept bounded: requests and responses are streamed through
+// small fixed size buffers, and every header line must fit in the read
+// buffer. Connections are not reused, every request asks the server to close
+// the connection after the response.
+package http // import "tinygo.org/x/drivers/net/http"
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/tls"
+)
+
+// ReadBufferSize is the size of the buffer used to read responses, which is
+// also the maximum length of a header line.
+const ReadBufferSize = 512
+
+// WriteBufferSize is the size of the buffer used to write requests.
+const WriteBufferSize = 256
+
+// ErrTooManyRedirects is returned when a request is redirected more than
+// Client.MaxRedirects times.
+var ErrTooManyRedirects = errors.New("http: too many redirects")
+
+// A Client is an HTTP client. Its zero value is a usable client that follows
+// up to 10 redirects, without timeout.
+type Client struct {
+	// Timeout specifies a time limit for each request made by this Client,
+	// including reading the response headers. The body must be read before
+	// the deadline too. A Timeout of zero means no timeout.
+	Timeout time.Duration
+
+	// MaxRedirects is the number of redirects followed before giving up.
+	// Zero means 10, use -1 to not follow redirects.
+	MaxRedirects int
+
+	// TLSConfig is used for https URLs. ServerName defaults to the host of
+	// each request, if it is not an IP address.
+	TLSConfig *tls.Config
+}
+
+// DefaultClient is the default Client and is used by Get and Post.
+var DefaultClient = &Client{}
+
+// Get issues a GET to the specified URL with the DefaultClient.
+func Get(url string) (*Response, error) {
+	return DefaultClient.Get(url)
+}
+
+// Post issues a POST to the specified URL with the DefaultClient.
+func Post(url, contentType string, body io.Reader) (*Response, error) {
+	return DefaultClient.Post(url, contentType, body)
+}
+
+// Get issues a GET to the specified URL.
+func (c *Client) Get(url string) (*Response, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post issues a POST to the specified URL.
+func (c *Client) Post(url, contentType string, body io.Reader) (*Response, error) {
+	req, err := NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// Do sends an HTTP request and returns an HTTP response, following
+// redirects. 301, 302 and 303 redirects are followed with a GET request
+// without body. 307 and 308 redirects are only followed for requests
+// without body, as the body cannot be sent again. The Authorization and
+// Cookie headers are not sent to another host.
+//
+// The caller must close the response body when done reading from it.
+func (c *Client) Do(req *Request) (*Response, error) {
+	max := c.MaxRedirects
+	if max == 0 {
+		max = 10
+	}
+
+	for redirects := 0; ; redirects++ {
+		resp, err := c.send(req)
+		if err != nil {
+			return nil, err
+		}
+
+		location := resp.Header.Get("Location")
+		switch resp.StatusCode {
+		case 301, 302, 303:
+		case 307, 308:
+			if req.Body != nil {
+				return resp, nil
+			}
+		default:
+			return resp, nil
+		}
+		if location == "" || max < 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if redirects >= max {
+			return nil, ErrTooManyRedirects
+		}
+
+		u, err := req.URL.resolve(location)
+		if err != nil {
+			return nil, err
+		}
+		next := &Request{Method: req.Method, URL: u, Header: req.Header, Body: req.Body, ContentLength: req.ContentLength}
+		if !strings.EqualFold(u.Hostname(), req.URL.Hostname()) {
+			// the credentials are not sent to another host
+			next.Header = make(Header, len(req.Header))
+			for k, v := range req.Header {
+				next.Header[k] = v
+			}
+			for _, k := range sensitiveHeaders {
+				next.Header.Del(k)
+			}
+		}
+		if resp.StatusCode <= 303 && req.Method != "HEAD" {
+			next.Method = "GET"
+			next.Body = nil
+			next.ContentLength = 0
+		}
+		req = next
+	}
+}
+
+// sensitiveHeaders are the headers removed from requests redirected to
+// another host.
+var sensitiveHeaders = []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"}
+
+// send makes a single request on a new connection.
+func (c *Client) send(req *Request) (*Response, error) {
+	var conn net.Conn
+	var err error
+	address := req.URL.Hostname() + ":" + req.URL.Port()
+	switch req.URL.Scheme {
+	case "https":
+		// tls.Dial sets the server name to the host of the request
+		conn, err = tls.Dial("tcp", address, c.TLSConfig)
+	default:
+		conn, err = net.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	err = req.write(bufio.NewWriterSize(conn, WriteBufferSize))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := readResponse(bufio.NewReaderSize(conn, ReadBufferSize), conn, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return resp, nil
+}
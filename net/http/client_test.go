@@ -0,0 +1,267 @@
+package http_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	stdnet "net"
+	stdhttp "net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/http"
+	"tinygo.org/x/drivers/net/loopback"
+	"tinygo.org/x/drivers/net/tls"
+)
+
+// serve answers the requests received by address with handler.
+func serve(d *loopback.Driver, address string, handler func(w io.Writer, req *stdhttp.Request)) {
+	d.Handle(address, func(conn stdnet.Conn) {
+		defer conn.Close()
+		req, err := stdhttp.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		handler(conn, req)
+	})
+}
+
+func TestRedirectHeaders(t *testing.T) {
+	d := loopback.New()
+	net.UseDriver(d)
+	defer net.UseDriver(nil)
+
+	got := make(map[string]stdhttp.Header)
+	redirect := func(location string) func(w io.Writer, req *stdhttp.Request) {
+		return func(w io.Writer, req *stdhttp.Request) {
+			got[req.Host+req.URL.Path] = req.Header
+			io.WriteString(w, "HTTP/1.1 302 Found\r\nLocation: "+location+"\r\nContent-Length: 0\r\n\r\n")
+		}
+	}
+	serve(d, "a.test:80", func(w io.Writer, req *stdhttp.Request) {
+		if req.URL.Path == "/" {
+			redirect("/same")(w, req)
+			return
+		}
+		redirect("http://b.test/other")(w, req)
+	})
+	serve(d, "b.test:80", func(w io.Writer, req *stdhttp.Request) {
+		got[req.Host+req.URL.Path] = req.Header
+		io.WriteString(w, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	})
+
+	req, err := http.NewRequest("GET", "http://a.test/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("X-Trace", "42")
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal(resp.StatusCode)
+	}
+
+	for _, path := range []string{"a.test/", "a.test/same"} {
+		h := got[path]
+		if h.Get("Authorization") != "Bearer secret" || h.Get("Cookie") != "session=1" {
+			t.Errorf("%s: credentials not sent to the same host: %v", path, h)
+		}
+	}
+	h := got["b.test/other"]
+	if h == nil || h.Get("Authorization") != "" || h.Get("Cookie") != "" || h.Get("X-Trace") != "42" {
+		t.Errorf("headers sent to another host: %v", h)
+	}
+	if req.Header.Get("Authorization") == "" {
+		t.Error("headers of the caller modified")
+	}
+}
+
+// driver records the TLS configuration of the sockets, and fails the
+// connections.
+type driver struct {
+	*loopback.Driver
+	config *tls.Config
+}
+
+var errStop = errors.New("stop")
+
+func (d *driver) ConfigureTLS(sock net.Socket, config *tls.Config) error {
+	d.config = config
+	return errStop
+}
+
+func TestTLSServerName(t *testing.T) {
+	d := &driver{Driver: loopback.New()}
+	net.UseDriver(d)
+	defer net.UseDriver(nil)
+
+	config := &tls.Config{RootCAs: []byte("ca")}
+	c := &http.Client{TLSConfig: config}
+	for _, host := range []string{"secure.test", "other.test"} {
+		d.Hosts[host] = "127.0.0.1"
+		if _, err := c.Get("https://" + host + "/"); err != errStop {
+			t.Fatal(err)
+		}
+		if d.config.ServerName != host || string(d.config.RootCAs) != "ca" {
+			t.Errorf("got %+v, want server name %s", d.config, host)
+		}
+	}
+	if config.ServerName != "" {
+		t.Error("configuration of the client modified")
+	}
+
+	// IP addresses are not sent in the SNI extension
+	if _, err := c.Get("https://127.0.0.1/"); err != errStop {
+		t.Fatal(err)
+	}
+	if d.config.ServerName != "" {
+		t.Errorf("got server name %q for an IP address", d.config.ServerName)
+	}
+}
+
+func TestResponseBody(t *testing.T) {
+	long := strings.Repeat("x", http.ReadBufferSize)
+	tests := []struct {
+		name   string
+		method string
+		// parts of the response, written with a pause in between so that
+		// the client reads them separately
+		response []string
+		body     string
+		length   int64
+		err      error
+	}{
+		{
+			name:     "content length",
+			response: []string{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"},
+			body:     "hello",
+			length:   5,
+		},
+		{
+			name:     "content length with extra data",
+			response: []string{"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nhello"},
+			body:     "hel",
+			length:   3,
+		},
+		{
+			name:     "until close",
+			response: []string{"HTTP/1.0 200 OK\r\n\r\n", "hello ", "world"},
+			body:     "hello world",
+			length:   -1,
+		},
+		{
+			name:     "chunked",
+			response: []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"},
+			body:     "hello world",
+			length:   -1,
+		},
+		{
+			name:     "chunk extensions and trailer",
+			response: []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: Chunked\r\n\r\n5;name=value\r\nhello\r\nA ; x\r\n0123456789\r\n0\r\nExpires: never\r\nX-Sum: 1\r\n\r\n"},
+			body:     "hello0123456789",
+			length:   -1,
+		},
+		{
+			name:     "chunked split reads",
+			response: []string{"HTTP/1.1 200 OK\r\nTransfer-", "Encoding: chunked\r\n\r\n", "5\r", "\nhel", "lo\r\n", "1", "\r\n!\r\n0\r\n", "\r\n"},
+			body:     "hello!",
+			length:   -1,
+		},
+		{
+			name:     "truncated content length",
+			response: []string{"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello"},
+			body:     "hello",
+			length:   10,
+			err:      io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "truncated chunk",
+			response: []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"},
+			body:     "hel",
+			length:   -1,
+			err:      io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "missing last chunk",
+			response: []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n"},
+			body:     "hello",
+			length:   -1,
+			err:      io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "truncated header",
+			response: []string{"HTTP/1.1 200 OK\r\nContent-Le"},
+			err:      io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "header line too long",
+			response: []string{"HTTP/1.1 200 OK\r\nX-Long: " + long + "\r\n\r\n"},
+			err:      http.ErrLineTooLong,
+		},
+		{
+			name:     "chunk size line too long",
+			response: []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;" + long + "\r\nhello\r\n0\r\n\r\n"},
+			length:   -1,
+			err:      http.ErrLineTooLong,
+		},
+		{
+			name:     "HEAD",
+			method:   "HEAD",
+			response: []string{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"},
+		},
+		{
+			name:     "no content",
+			response: []string{"HTTP/1.1 204 No Content\r\nTransfer-Encoding: chunked\r\n\r\n"},
+		},
+		{
+			name:     "not modified",
+			response: []string{"HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := loopback.New()
+			net.UseDriver(d)
+			defer net.UseDriver(nil)
+			serve(d, "server.test:80", func(w io.Writer, req *stdhttp.Request) {
+				for i, part := range tt.response {
+					if i > 0 {
+						time.Sleep(20 * time.Millisecond)
+					}
+					io.WriteString(w, part)
+				}
+			})
+
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			req, err := http.NewRequest(method, "http://server.test/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := (&http.Client{Timeout: 2 * time.Second}).Do(req)
+			if err != nil {
+				if !errors.Is(err, tt.err) || tt.body != "" {
+					t.Fatalf("got %v, want %v", err, tt.err)
+				}
+				return
+			}
+			defer resp.Body.Close()
+			if resp.ContentLength != tt.length {
+				t.Errorf("got length %d, want %d", resp.ContentLength, tt.length)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if string(b) != tt.body || !errors.Is(err, tt.err) {
+				t.Errorf("got %q, %v, want %q, %v", b, err, tt.body, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"io"
+	"strings"
+)
+
+// Header represents the key-value pairs in an HTTP header. The keys are kept
+// in canonical form, as returned by CanonicalHeaderKey.
+type Header map[string][]string
+
+// Add adds the key, value pair to the header. It appends to any existing
+// values associated with key.
+func (h Header) Add(key, value string) {
+	key = CanonicalHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Set sets the header entries associated with key to the single element
+// value. It replaces any existing values associated with key.
+func (h Header) Set(key, value string) {
+	h[CanonicalHeaderKey(key)] = []string{value}
+}
+
+// Get gets the first value associated with the given key. If there are no
+// values associated with the key, Get returns "".
+func (h Header) Get(key string) string {
+	if v := h[CanonicalHeaderKey(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Del deletes the values associated with key.
+func (h Header) Del(key string) {
+	delete(h, CanonicalHeaderKey(key))
+}
+
+// write writes the header in wire format.
+func (h Header) write(w io.Writer) error {
+	for key, values := range h {
+		for _, v := range values {
+			if _, err := io.WriteString(w, key+": "+v+"\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CanonicalHeaderKey returns the canonical format of the header key s. The
+// canonicalization converts the first letter and any letter following a
+// hyphen to upper case; the rest are converted to lowercase. For example,
+// the canonical key for "accept-encoding" is "Accept-Encoding".
+func CanonicalHeaderKey(s string) string {
+	// avoid the allocation when the key is already canonical
+	upper := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if upper && 'a' <= c && c <= 'z' || !upper && 'A' <= c && c <= 'Z' {
+			return canonicalHeaderKey(s)
+		}
+		upper = c == '-'
+	}
+	return s
+}
+
+func canonicalHeaderKey(s string) string {
+	b := []byte(strings.ToLower(s))
+	upper := true
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
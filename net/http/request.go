@@ -0,0 +1,214 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// URL is a parsed http or https URL. It only holds the parts needed to make
+// a request.
+type URL struct {
+	Scheme   string // "http" or "https"
+	Host     string // host or host:port
+	Path     string // always starts with "/"
+	RawQuery string // encoded query values, without '?'
+}
+
+// ParseURL parses an absolute http or https URL.
+func ParseURL(rawURL string) (*URL, error) {
+	u := &URL{}
+	i := strings.Index(rawURL, "://")
+	if i < 0 {
+		return nil, errors.New("http: missing scheme in URL")
+	}
+	u.Scheme = strings.ToLower(rawURL[:i])
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("http: unsupported scheme " + u.Scheme)
+	}
+	rest := rawURL[i+3:]
+
+	// the fragment is never sent to the server
+	if i := strings.IndexByte(rest, '#'); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		u.RawQuery = rest[i+1:]
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		u.Host, u.Path = rest[:i], rest[i:]
+	} else {
+		u.Host, u.Path = rest, "/"
+	}
+	if u.Host == "" {
+		return nil, errors.New("http: missing host in URL")
+	}
+	return u, nil
+}
+
+// Hostname returns the host of the URL, without any port number.
+func (u *URL) Hostname() string {
+	if i := strings.LastIndexByte(u.Host, ':'); i >= 0 {
+		return u.Host[:i]
+	}
+	return u.Host
+}
+
+// Port returns the port of the URL, or the default port of its scheme.
+func (u *URL) Port() string {
+	if i := strings.LastIndexByte(u.Host, ':'); i >= 0 {
+		return u.Host[i+1:]
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// RequestURI returns the encoded path?query string used in the request
+// line.
+func (u *URL) RequestURI() string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	return u.Path + "?" + u.RawQuery
+}
+
+// String reassembles the URL into a valid URL string.
+func (u *URL) String() string {
+	return u.Scheme + "://" + u.Host + u.RequestURI()
+}
+
+// resolve returns the URL of a redirect location, which may be relative to u.
+func (u *URL) resolve(location string) (*URL, error) {
+	switch {
+	case strings.Contains(location, "://"):
+		return ParseURL(location)
+	case strings.HasPrefix(location, "//"):
+		return ParseURL(u.Scheme + ":" + location)
+	case strings.HasPrefix(location, "/"):
+		return ParseURL(u.Scheme + "://" + u.Host + location)
+	default:
+		dir := u.Path[:strings.LastIndexByte(u.Path, '/')+1]
+		return ParseURL(u.Scheme + "://" + u.Host + dir + location)
+	}
+}
+
+// A Request represents an HTTP request to be sent by a client.
+type Request struct {
+	// Method specifies the HTTP method (GET, POST, PUT, etc.).
+	Method string
+
+	// URL specifies the URI being requested.
+	URL *URL
+
+	// Header contains the request header fields to be sent, in addition
+	// to Host, Content-Length or Transfer-Encoding, and Connection.
+	Header Header
+
+	// Body is the request's body. A nil body means the request has no body.
+	Body io.Reader
+
+	// ContentLength records the length of Body. If -1, the length is unknown
+	// and the body is sent with the chunked transfer encoding.
+	ContentLength int64
+
+	// Host optionally overrides the Host header to send. If empty, the host
+	// of the URL is used.
+	Host string
+}
+
+// NewRequest returns a new Request given a method, URL, and optional body.
+// The ContentLength is set for bodies of type *bytes.Buffer, *bytes.Reader
+// and *strings.Reader, other bodies are sent chunked.
+func NewRequest(method, url string, body io.Reader) (*Request, error) {
+	if method == "" {
+		method = "GET"
+	}
+	u, err := ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{Method: method, URL: u, Header: make(Header), Body: body}
+	if body != nil {
+		switch v := body.(type) {
+		case *bytes.Buffer:
+			req.ContentLength = int64(v.Len())
+		case *bytes.Reader:
+			req.ContentLength = int64(v.Len())
+		case *strings.Reader:
+			req.ContentLength = int64(v.Len())
+		default:
+			req.ContentLength = -1
+		}
+	}
+	return req, nil
+}
+
+// write writes the request in wire format. The connection is always closed
+// by the server after the response, so that the end of bodies without
+// length can be found.
+func (r *Request) write(w *bufio.Writer) error {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	w.WriteString(r.Method + " " + r.URL.RequestURI() + " HTTP/1.1\r\n")
+	w.WriteString("Host: " + host + "\r\n")
+	if r.Header.Get("User-Agent") == "" {
+		w.WriteString("User-Agent: TinyGo\r\n")
+	}
+	w.WriteString("Connection: close\r\n")
+
+	chunked := r.Body != nil && r.ContentLength < 0
+	switch {
+	case chunked:
+		w.WriteString("Transfer-Encoding: chunked\r\n")
+	case r.Body != nil || r.Method == "POST" || r.Method == "PUT":
+		w.WriteString("Content-Length: " + strconv.FormatInt(r.ContentLength, 10) + "\r\n")
+	}
+	if err := r.Header.write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+
+	if r.Body != nil {
+		var err error
+		if chunked {
+			err = writeChunked(w, r.Body)
+		} else {
+			_, err = io.CopyN(w, r.Body, r.ContentLength)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// writeChunked copies body to w with the chunked transfer encoding.
+func writeChunked(w *bufio.Writer, body io.Reader) error {
+	var buf [128]byte
+	for {
+		n, err := body.Read(buf[:])
+		if n > 0 {
+			w.WriteString(strconv.FormatInt(int64(n), 16) + "\r\n")
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString("0\r\n\r\n")
+	return err
+}
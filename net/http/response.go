@@ -0,0 +1,179 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrLineTooLong is returned when a line of the response header, or a chunk
+// size line, does not fit in the read buffer of the client.
+var ErrLineTooLong = errors.New("http: header line too long")
+
+// Response represents the response from an HTTP request.
+type Response struct {
+	Status     string // e.g. "200 OK"
+	StatusCode int    // e.g. 200
+	Proto      string // e.g. "HTTP/1.1"
+
+	// Header maps header keys to values.
+	Header Header
+
+	// Body represents the response body. The body is streamed from the
+	// connection as it is read, and must be closed by the caller to close
+	// the connection.
+	Body io.ReadCloser
+
+	// ContentLength records the length of the body, or -1 if it is unknown.
+	ContentLength int64
+
+	// Request is the request that was sent to obtain this Response.
+	Request *Request
+}
+
+// readResponse reads the status line and the header of a response from r,
+// and sets up the body reader. closer is closed with the body.
+func readResponse(r *bufio.Reader, closer io.Closer, req *Request) (*Response, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{Header: make(Header), ContentLength: -1, Request: req}
+
+	// status line, such as "HTTP/1.1 200 OK"
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return nil, errors.New("http: malformed status line: " + line)
+	}
+	resp.Proto, resp.Status = line[:i], strings.TrimLeft(line[i+1:], " ")
+	code := resp.Status
+	if i := strings.IndexByte(code, ' '); i >= 0 {
+		code = code[:i]
+	}
+	resp.StatusCode, err = strconv.Atoi(code)
+	if err != nil || len(code) != 3 {
+		return nil, errors.New("http: malformed status code: " + code)
+	}
+
+	// header
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, errors.New("http: malformed header line: " + line)
+		}
+		resp.Header.Add(line[:i], strings.TrimSpace(line[i+1:]))
+	}
+
+	// body
+	body := &body{r: r, closer: closer, remaining: -1}
+	switch {
+	case req.Method == "HEAD" || resp.StatusCode == 204 || resp.StatusCode == 304:
+		body.remaining = 0
+		resp.ContentLength = 0
+	case strings.EqualFold(resp.Header.Get("Transfer-Encoding"), "chunked"):
+		body.chunked = true
+	case resp.Header.Get("Content-Length") != "":
+		n, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		if err != nil || n < 0 {
+			return nil, errors.New("http: bad Content-Length")
+		}
+		body.remaining = n
+		resp.ContentLength = n
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// readLine reads a line without the trailing "\r\n". The line must fit in
+// the buffer of r, so that the memory used for the header is bounded.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", ErrLineTooLong
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// body reads a response body with a known length, chunked, or until the
+// connection is closed when remaining is -1.
+type body struct {
+	r         *bufio.Reader
+	closer    io.Closer
+	remaining int64
+	chunked   bool
+	done      bool
+}
+
+func (b *body) Read(p []byte) (n int, err error) {
+	if b.done || b.remaining == 0 && !b.chunked {
+		return 0, io.EOF
+	}
+
+	if b.chunked && b.remaining <= 0 {
+		// end of the previous chunk, read the size of the next one
+		if b.remaining == 0 {
+			if _, err := readLine(b.r); err != nil {
+				return 0, err
+			}
+		}
+		line, err := readLine(b.r)
+		if err != nil {
+			return 0, err
+		}
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i] // chunk extensions
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if err != nil || size < 0 {
+			return 0, errors.New("http: malformed chunk size")
+		}
+		if size == 0 {
+			// skip the trailer
+			for {
+				line, err := readLine(b.r)
+				if err != nil || line == "" {
+					break
+				}
+			}
+			b.done = true
+			return 0, io.EOF
+		}
+		b.remaining = size
+	}
+
+	if b.remaining > 0 && int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err = b.r.Read(p)
+	if b.remaining > 0 {
+		b.remaining -= int64(n)
+		if err == io.EOF && b.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if b.remaining == 0 && !b.chunked {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// Close closes the connection of the response.
+func (b *body) Close() error {
+	b.done = true
+	return b.closer.Close()
+}
@@ -0,0 +1,456 @@
+// Package loopback implements a net.DeviceDriver on top of the networking of
+// the host, using the Go standard library. It is meant to test code built on
+// the net, net/tls and net/mqtt packages with go test, without an ESP8266/ESP32
+// or WiFiNINA adaptor.
+//
+// Sockets are bridged to real TCP and UDP sockets of the host, or to
+// in-process servers registered with Handle:
+//
+//	d := loopback.New()
+//	d.Handle("broker.test:1883", func(conn stdnet.Conn) {
+//		// act as the MQTT broker on conn
+//	})
+//	net.UseDriver(d)
+//
+// Data received from the network is buffered by a goroutine for every socket,
+// so that RecvSocket and IsSocketDataAvailable never block, like on the real
+// adaptors.
+package loopback // import "tinygo.org/x/drivers/net/loopback"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	stdnet "net"
+	"strconv"
+	"sync"
+
+	"tinygo.org/x/drivers/net"
+	drivertls "tinygo.org/x/drivers/net/tls"
+)
+
+var (
+	_ net.DeviceDriver       = (*Driver)(nil)
+	_ net.UDPDriver          = (*Driver)(nil)
+	_ net.MulticastDriver    = (*Driver)(nil)
+	_ drivertls.DeviceDriver = (*Driver)(nil)
+)
+
+// Driver is a net.DeviceDriver using the network of the host. It is safe for
+// concurrent use.
+type Driver struct {
+	// Hosts maps domain names to the addresses returned by GetDNS. Other
+	// names are looked up with the resolver of the host.
+	Hosts map[string]string
+
+	mu       sync.Mutex
+	sockets  map[net.Socket]*socket
+	next     net.Socket
+	handlers map[string]func(conn stdnet.Conn)
+}
+
+// New returns a new loopback driver without any in-process servers.
+func New() *Driver {
+	return &Driver{
+		Hosts:    make(map[string]string),
+		sockets:  make(map[net.Socket]*socket),
+		handlers: make(map[string]func(conn stdnet.Conn)),
+	}
+}
+
+// Handle registers an in-process server for address, in "host:port" form.
+// Every TCP or TLS connection made to it calls handler in a new goroutine,
+// with the server end of a net.Pipe. GetDNS returns the host as is, so any
+// name can be used without touching the network of the host.
+func (d *Driver) Handle(address string, handler func(conn stdnet.Conn)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[address] = handler
+}
+
+// socket is the state of a socket. The connection fields are set once the
+// socket is connected; the received data is guarded by mu.
+type socket struct {
+	protocol net.Protocol
+	tls      *drivertls.Config
+
+	conn     stdnet.Conn
+	udp      *stdnet.UDPConn
+	listener stdnet.Listener
+	incoming chan stdnet.Conn
+
+	// UDP destination, nil to reply to the sender of the last datagram
+	remote *stdnet.UDPAddr
+
+	mu     sync.Mutex
+	data   []byte
+	err    error
+	sender *stdnet.UDPAddr
+
+	// UDP datagrams received, data holds the one being read
+	datagrams []datagram
+}
+
+type datagram struct {
+	data []byte
+	from *stdnet.UDPAddr
+}
+
+func (d *Driver) socket(sock net.Socket) (*socket, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.sockets[sock]
+	if !ok {
+		return nil, net.ErrInvalidSocket
+	}
+	return s, nil
+}
+
+func (d *Driver) addSocket(s *socket) net.Socket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sock := d.next
+	d.next++
+	d.sockets[sock] = s
+	return sock
+}
+
+// GetDNS implements net.DeviceDriver.
+func (d *Driver) GetDNS(domain string) (string, error) {
+	d.mu.Lock()
+	if addr, ok := d.Hosts[domain]; ok {
+		d.mu.Unlock()
+		return addr, nil
+	}
+	for address := range d.handlers {
+		if host, _, err := stdnet.SplitHostPort(address); err == nil && host == domain {
+			d.mu.Unlock()
+			return domain, nil
+		}
+	}
+	d.mu.Unlock()
+
+	if stdnet.ParseIP(domain) != nil {
+		return domain, nil
+	}
+	addrs, err := stdnet.LookupHost(domain)
+	if err != nil {
+		return "", err
+	}
+	return addrs[0], nil
+}
+
+// OpenSocket implements net.DeviceDriver.
+func (d *Driver) OpenSocket(protocol net.Protocol) (net.Socket, error) {
+	return d.addSocket(&socket{protocol: protocol}), nil
+}
+
+// ConfigureTLS implements tls.DeviceDriver.
+func (d *Driver) ConfigureTLS(sock net.Socket, config *drivertls.Config) error {
+	s, err := d.socket(sock)
+	if err != nil {
+		return err
+	}
+	s.tls = config
+	return nil
+}
+
+// ConnectSocket implements net.DeviceDriver. UDP sockets always listen to
+// localPort, 0 picks a free port.
+func (d *Driver) ConnectSocket(sock net.Socket, addr string, port, localPort int) error {
+	s, err := d.socket(sock)
+	if err != nil {
+		return err
+	}
+	address := stdnet.JoinHostPort(addr, strconv.Itoa(port))
+
+	switch s.protocol {
+	case net.ProtocolTCP, net.ProtocolTLS:
+		conn, err := d.dial(address)
+		if err != nil {
+			return err
+		}
+		if s.protocol == net.ProtocolTLS {
+			conn, err = tlsClient(conn, addr, s.tls)
+			if err != nil {
+				return err
+			}
+		}
+		s.conn = conn
+		go s.readConn()
+	case net.ProtocolUDP:
+		// net.ListenUDP uses "0" as the remote address, replies are then
+		// sent to the sender of the last datagram received
+		if addr != "0" && addr != "" {
+			s.remote, err = stdnet.ResolveUDPAddr("udp", address)
+			if err != nil {
+				return err
+			}
+		}
+		s.udp, err = stdnet.ListenUDP("udp", &stdnet.UDPAddr{Port: localPort})
+		if err != nil {
+			return err
+		}
+		go s.readUDP()
+	default:
+		return errors.New("loopback: unknown protocol")
+	}
+	return nil
+}
+
+// dial connects to an in-process server, or to the network of the host.
+func (d *Driver) dial(address string) (stdnet.Conn, error) {
+	d.mu.Lock()
+	handler := d.handlers[address]
+	d.mu.Unlock()
+
+	if handler == nil {
+		return stdnet.Dial("tcp", address)
+	}
+	client, server := stdnet.Pipe()
+	go handler(server)
+	return client, nil
+}
+
+// tlsClient runs the TLS handshake over conn.
+func tlsClient(conn stdnet.Conn, addr string, c *drivertls.Config) (stdnet.Conn, error) {
+	config := &tls.Config{ServerName: addr}
+	if c != nil {
+		if c.ServerName != "" {
+			config.ServerName = c.ServerName
+		}
+		if c.RootCAs != nil {
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(c.RootCAs) {
+				conn.Close()
+				return nil, errors.New("loopback: invalid root certificates")
+			}
+		}
+		if c.Certificate != nil || c.PrivateKey != nil {
+			cert, err := tls.X509KeyPair(c.Certificate, c.PrivateKey)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	tc := tls.Client(conn, config)
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// readConn buffers the data received by a TCP or TLS connection, until it
+// fails or is closed.
+func (s *socket) readConn() {
+	buf := make([]byte, 1024)
+	for {
+		n, err := s.conn.Read(buf)
+		s.mu.Lock()
+		s.data = append(s.data, buf[:n]...)
+		s.err = err
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readUDP buffers the datagrams received by a UDP socket, until it is
+// closed.
+func (s *socket) readUDP() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.udp.ReadFromUDP(buf)
+		s.mu.Lock()
+		if n > 0 {
+			s.datagrams = append(s.datagrams, datagram{append([]byte(nil), buf[:n]...), addr})
+		}
+		s.err = err
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SendSocket implements net.DeviceDriver.
+func (d *Driver) SendSocket(sock net.Socket, b []byte) (int, error) {
+	s, err := d.socket(sock)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case s.conn != nil:
+		return s.conn.Write(b)
+	case s.udp != nil:
+		addr := s.remote
+		if addr == nil {
+			s.mu.Lock()
+			addr = s.sender
+			s.mu.Unlock()
+		}
+		if addr == nil {
+			return 0, errors.New("loopback: no remote address")
+		}
+		return s.udp.WriteToUDP(b, addr)
+	default:
+		return 0, net.ErrInvalidSocket
+	}
+}
+
+// SendSocketTo implements net.UDPDriver.
+func (d *Driver) SendSocketTo(sock net.Socket, b []byte, addr string, port int) (int, error) {
+	s, err := d.socket(sock)
+	if err != nil {
+		return 0, err
+	}
+	if s.udp == nil {
+		return 0, net.ErrInvalidSocket
+	}
+	raddr, err := stdnet.ResolveUDPAddr("udp", stdnet.JoinHostPort(addr, strconv.Itoa(port)))
+	if err != nil {
+		return 0, err
+	}
+	return s.udp.WriteToUDP(b, raddr)
+}
+
+// RecvSocket implements net.DeviceDriver. Once all the data has been read,
+// the error that stopped the connection is returned, such as io.EOF. UDP
+// sockets return a single datagram at a time.
+func (d *Driver) RecvSocket(sock net.Socket, b []byte) (int, error) {
+	s, err := d.socket(sock)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.data) == 0 && len(s.datagrams) > 0 {
+		s.data, s.sender = s.datagrams[0].data, s.datagrams[0].from
+		s.datagrams = s.datagrams[1:]
+	}
+	n := copy(b, s.data)
+	s.data = s.data[:copy(s.data, s.data[n:])]
+	if n == 0 && len(b) > 0 && s.err != nil {
+		return 0, s.err
+	}
+	return n, nil
+}
+
+// IsSocketDataAvailable implements net.DeviceDriver.
+func (d *Driver) IsSocketDataAvailable(sock net.Socket) bool {
+	s, err := d.socket(sock)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data) > 0 || len(s.datagrams) > 0
+}
+
+// SocketRemoteAddr implements net.DeviceDriver.
+func (d *Driver) SocketRemoteAddr(sock net.Socket) (string, int, error) {
+	s, err := d.socket(sock)
+	if err != nil {
+		return "", 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sender == nil {
+		return "", 0, errors.New("loopback: no datagram received")
+	}
+	return s.sender.IP.String(), s.sender.Port, nil
+}
+
+// ListenSocket implements net.DeviceDriver, listening on all the interfaces
+// of the host.
+func (d *Driver) ListenSocket(protocol net.Protocol, port int) (net.Socket, error) {
+	if protocol != net.ProtocolTCP {
+		return net.NoSocket, errors.New("loopback: only TCP can listen")
+	}
+	l, err := stdnet.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return net.NoSocket, err
+	}
+
+	s := &socket{protocol: protocol, listener: l, incoming: make(chan stdnet.Conn, 4)}
+	go func() {
+		defer close(s.incoming)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.incoming <- conn
+		}
+	}()
+	return d.addSocket(s), nil
+}
+
+// ListenMulticastSocket implements net.MulticastDriver, joining the group on
+// the default multicast interface of the host.
+func (d *Driver) ListenMulticastSocket(group string, port int) (net.Socket, error) {
+	gaddr, err := stdnet.ResolveUDPAddr("udp4", stdnet.JoinHostPort(group, strconv.Itoa(port)))
+	if err != nil {
+		return net.NoSocket, err
+	}
+	conn, err := stdnet.ListenMulticastUDP("udp4", nil, gaddr)
+	if err != nil {
+		return net.NoSocket, err
+	}
+	s := &socket{protocol: net.ProtocolUDP, udp: conn}
+	go s.readUDP()
+	return d.addSocket(s), nil
+}
+
+// AcceptSocket implements net.DeviceDriver.
+func (d *Driver) AcceptSocket(sock net.Socket) (net.Socket, error) {
+	s, err := d.socket(sock)
+	if err != nil {
+		return net.NoSocket, err
+	}
+	if s.listener == nil {
+		return net.NoSocket, net.ErrInvalidSocket
+	}
+
+	select {
+	case conn, ok := <-s.incoming:
+		if !ok {
+			return net.NoSocket, net.ErrInvalidSocket
+		}
+		c := &socket{protocol: net.ProtocolTCP, conn: conn}
+		go c.readConn()
+		return d.addSocket(c), nil
+	default:
+		return net.NoSocket, nil
+	}
+}
+
+// CloseSocket implements net.DeviceDriver.
+func (d *Driver) CloseSocket(sock net.Socket) error {
+	d.mu.Lock()
+	s, ok := d.sockets[sock]
+	delete(d.sockets, sock)
+	d.mu.Unlock()
+	if !ok {
+		return net.ErrInvalidSocket
+	}
+
+	switch {
+	case s.conn != nil:
+		return s.conn.Close()
+	case s.udp != nil:
+		return s.udp.Close()
+	case s.listener != nil:
+		return s.listener.Close()
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package loopback_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdtls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+	"tinygo.org/x/drivers/net/mqtt"
+	"tinygo.org/x/drivers/net/tls"
+)
+
+// readAll reads from conn until an error, or fails the test after 2
+// seconds.
+func readAll(t *testing.T, conn io.Reader) ([]byte, error) {
+	var got []byte
+	buf := make([]byte, 64)
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		n, err := conn.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			return got, err
+		}
+		if n == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	t.Fatalf("%q: timeout", got)
+	return nil, nil
+}
+
+// echo answers the first line received, and closes the connection.
+func echo(conn stdnet.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 64)
+	n, _ := conn.Read(buf)
+	conn.Write(append([]byte("echo "), buf[:n]...))
+}
+
+func TestHandle(t *testing.T) {
+	d := loopback.New()
+	d.Handle("echo.test:7", echo)
+	net.UseDriver(d)
+	defer net.UseDriver(nil)
+
+	conn, err := net.Dial("tcp", "echo.test:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(t, conn)
+	if string(got) != "echo hello" || err != io.EOF {
+		t.Fatalf("%q %v", got, err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	d := loopback.New()
+	d.Handle("slow.test:7", func(conn stdnet.Conn) {
+		defer conn.Close()
+		time.Sleep(200 * time.Millisecond)
+		conn.Write([]byte("late"))
+		time.Sleep(100 * time.Millisecond)
+	})
+	net.UseDriver(d)
+	defer net.UseDriver(nil)
+
+	conn, err := net.Dial("tcp", "slow.test:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 64)
+
+	// the read gives up at the deadline
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := conn.Read(buf); n != 0 || err != net.ErrDeadlineExceeded {
+		t.Fatal(n, err)
+	}
+
+	// without a deadline, it waits for the data, then for the close
+	conn.SetReadDeadline(time.Time{})
+	if n, err := conn.Read(buf); string(buf[:n]) != "late" || err != nil {
+		t.Fatalf("%q %v", buf[:n], err)
+	}
+	if n, err := conn.Read(buf); n != 0 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	cert, ca := certificate(t, "secure.test")
+	d := loopback.New()
+	names := make(chan string, 1)
+	d.Handle("secure.test:443", func(conn stdnet.Conn) {
+		tc := stdtls.Server(conn, &stdtls.Config{
+			Certificates: []stdtls.Certificate{cert},
+			GetConfigForClient: func(hello *stdtls.ClientHelloInfo) (*stdtls.Config, error) {
+				names <- hello.ServerName
+				return nil, nil
+			},
+		})
+		echo(tc)
+	})
+	net.UseDriver(d)
+	defer net.UseDriver(nil)
+
+	// the server name is the host of the address by default
+	conn, err := tls.Dial("tcp", "secure.test:443", &tls.Config{RootCAs: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if name := <-names; name != "secure.test" {
+		t.Fatal(name)
+	}
+	conn.Write([]byte("hello"))
+	got, err := readAll(t, conn)
+	if string(got) != "echo hello" || err != io.EOF {
+		t.Fatalf("%q %v", got, err)
+	}
+
+	// the certificate of the server is checked
+	if _, err := tls.Dial("tcp", "secure.test:443", &tls.Config{RootCAs: ca, ServerName: "other.test"}); err == nil {
+		t.Fatal("wrong server name accepted")
+	}
+}
+
+func TestUDP(t *testing.T) {
+	pc, err := stdnet.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 64)
+		n, addr, err := pc.ReadFrom(buf)
+		if err == nil {
+			pc.WriteTo([]byte("one"), addr)
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	net.UseDriver(loopback.New())
+	defer net.UseDriver(nil)
+
+	raddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: pc.LocalAddr().(*stdnet.UDPAddr).Port}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("two")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the datagrams are read one at a time
+	buf := make([]byte, 64)
+	for _, want := range []string{"one", "two"} {
+		var n int
+		for i := 0; i < 200 && n == 0; i++ {
+			if n, err = conn.Read(buf); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if string(buf[:n]) != want {
+			t.Fatalf("got %q, want %q", buf[:n], want)
+		}
+	}
+}
+
+// certificate returns a self-signed certificate for host, and the PEM
+// encoding of the certificate to trust it.
+func certificate(t *testing.T, host string) (stdtls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := stdtls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// broker is an MQTT broker stand-in for a single client: it accepts the
+// connection, acknowledges the subscriptions and the QoS 1 messages, and
+// sends back the messages published to a subscribed topic.
+func broker(connects chan<- *packets.ConnectPacket) func(stdnet.Conn) {
+	return func(conn stdnet.Conn) {
+		defer conn.Close()
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		connects <- p.(*packets.ConnectPacket)
+		packets.NewControlPacket(packets.Connack).Write(conn)
+		topics := map[string]bool{}
+		for {
+			p, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			switch p := p.(type) {
+			case *packets.SubscribePacket:
+				a := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+				a.MessageID = p.MessageID
+				for i, topic := range p.Topics {
+					topics[topic] = true
+					a.ReturnCodes = append(a.ReturnCodes, p.Qoss[i])
+				}
+				a.Write(conn)
+			case *packets.PublishPacket:
+				if p.Qos == 1 {
+					a := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+					a.MessageID = p.MessageID
+					a.Write(conn)
+				}
+				if topics[p.TopicName] {
+					m := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+					m.TopicName = p.TopicName
+					m.Payload = p.Payload
+					m.Write(conn)
+				}
+			case *packets.PingreqPacket:
+				packets.NewControlPacket(packets.Pingresp).Write(conn)
+			case *packets.DisconnectPacket:
+				return
+			}
+		}
+	}
+}
+
+func TestMQTT(t *testing.T) {
+	d := loopback.New()
+	connects := make(chan *packets.ConnectPacket, 1)
+	d.Handle("broker.test:1883", broker(connects))
+	net.UseDriver(d)
+	defer net.UseDriver(nil)
+
+	opts := mqtt.NewClientOptions().AddBroker("tcp://broker.test:1883").SetClientID("tinygo-test")
+	c := mqtt.NewClient(opts)
+	if tok := c.Connect(); !tok.WaitTimeout(2*time.Second) || tok.Error() != nil {
+		t.Fatal("connect:", tok.Error())
+	}
+	defer c.Disconnect(0)
+	select {
+	case p := <-connects:
+		if p.ClientIdentifier != "tinygo-test" || p.ProtocolName != "MQTT" {
+			t.Fatalf("%+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no CONNECT")
+	}
+
+	got := make(chan mqtt.Message, 1)
+	tok := c.Subscribe("sensors/temp", 1, func(c mqtt.Client, m mqtt.Message) { got <- m })
+	if !tok.WaitTimeout(2*time.Second) || tok.Error() != nil {
+		t.Fatal("subscribe:", tok.Error())
+	}
+	tok = c.Publish("sensors/temp", 1, false, "21.5")
+	if !tok.WaitTimeout(2*time.Second) || tok.Error() != nil {
+		t.Fatal("publish:", tok.Error())
+	}
+	select {
+	case m := <-got:
+		if m.Topic() != "sensors/temp" || string(m.Payload()) != "21.5" {
+			t.Fatalf("%s %q", m.Topic(), m.Payload())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received")
+	}
+}
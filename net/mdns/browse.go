@@ -0,0 +1,227 @@
+package mdns
+
+import (
+	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/dns"
+)
+
+// Instance is an instance of a service found by Browse.
+type Instance struct {
+	// Name is the user-friendly name of the instance, such as
+	// "Living room sensor".
+	Name string
+
+	// Service is the type of service, such as "_http._tcp".
+	Service string
+
+	// Host is the name of the device, such as "sensor-42.local", IP its IPv4
+	// address if it was received, and Port the port of the service.
+	Host string
+	IP   string
+	Port uint16
+
+	// Text is the "key=value" pairs of the TXT record of the instance.
+	Text []string
+}
+
+// Browse finds the instances of a service, such as "_http._tcp", advertised
+// on the local network, by listening to the responses to a query for
+// timeout. Only the instances whose host and port were received are
+// returned.
+func Browse(service string, timeout time.Duration) ([]Instance, error) {
+	conn, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	b := &browser{conn: conn}
+	return b.browse(service, timeout)
+}
+
+// Browse finds the instances of a service like the Browse function, with
+// the socket of the responder. The queries received in the meantime are
+// answered.
+func (r *Responder) Browse(service string, timeout time.Duration) ([]Instance, error) {
+	b := &browser{conn: r.conn, handle: r.handle}
+	return b.browse(service, timeout)
+}
+
+type browser struct {
+	conn   *net.UDPSerialConn
+	handle func(m *dns.Message, addr *net.UDPAddr) error
+
+	instances []Instance
+	hosts     []dns.Record
+	buf       [MaxMessageSize]byte
+	wbuf      []byte
+}
+
+func (b *browser) browse(service string, timeout time.Duration) ([]Instance, error) {
+	ptrName := service + ".local"
+	if err := b.query(dns.Question{Name: ptrName, Type: dns.TypePTR, Class: dns.ClassINET}); err != nil {
+		return nil, err
+	}
+
+	// halfway, the records that are still missing are asked for, in case
+	// the responders did not send them as additional records
+	deadline := time.Now().Add(timeout)
+	followUp := time.Now().Add(timeout / 2)
+	for {
+		if !followUp.IsZero() && !time.Now().Before(followUp) {
+			followUp = time.Time{}
+			if err := b.query(b.missing()...); err != nil {
+				return nil, err
+			}
+		}
+		until := deadline
+		if !followUp.IsZero() {
+			until = followUp
+		}
+		b.conn.SetReadDeadline(until)
+		n, addr, err := b.conn.ReadFrom(b.buf[:])
+		if err == net.ErrDeadlineExceeded {
+			if followUp.IsZero() {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			b.conn.SetReadDeadline(time.Time{})
+			return nil, err
+		}
+		m, err := dns.ParseMessage(b.buf[:n])
+		if err != nil {
+			continue
+		}
+		if !m.Response {
+			if b.handle != nil {
+				if err := b.handle(m, addr.(*net.UDPAddr)); err != nil {
+					b.conn.SetReadDeadline(time.Time{})
+					return nil, err
+				}
+			}
+			continue
+		}
+		for _, section := range [][]dns.Record{m.Answers, m.Additionals} {
+			for i := range section {
+				b.add(&section[i], service)
+			}
+		}
+	}
+	b.conn.SetReadDeadline(time.Time{})
+
+	var found []Instance
+	for _, in := range b.instances {
+		if in.Host == "" {
+			continue
+		}
+		for _, h := range b.hosts {
+			if strings.EqualFold(h.Name, in.Host) {
+				in.IP = h.IP
+			}
+		}
+		found = append(found, in)
+	}
+	return found, nil
+}
+
+// add keeps a record of a response about service.
+func (b *browser) add(rr *dns.Record, service string) {
+	suffix := "." + service + ".local"
+	switch rr.Type {
+	case dns.TypePTR:
+		if !strings.EqualFold(rr.Name, service+".local") {
+			return
+		}
+		if rr.TTL == 0 {
+			// the instance is gone
+			for i := range b.instances {
+				if strings.EqualFold(b.instances[i].Name+suffix, rr.Target) {
+					b.instances = append(b.instances[:i], b.instances[i+1:]...)
+					return
+				}
+			}
+			return
+		}
+		b.instance(rr.Target, suffix)
+	case dns.TypeSRV:
+		if in := b.instance(rr.Name, suffix); in != nil {
+			in.Host, in.Port = rr.Target, rr.Port
+		}
+	case dns.TypeTXT:
+		if in := b.instance(rr.Name, suffix); in != nil {
+			in.Text = rr.Text
+			if len(in.Text) == 1 && in.Text[0] == "" {
+				in.Text = nil
+			}
+		}
+	case dns.TypeA:
+		if rr.TTL == 0 {
+			return
+		}
+		for i := range b.hosts {
+			if strings.EqualFold(b.hosts[i].Name, rr.Name) {
+				b.hosts[i] = *rr
+				return
+			}
+		}
+		b.hosts = append(b.hosts, *rr)
+	}
+}
+
+// instance returns the instance with the full name, added if needed, or nil
+// if the name is not an instance of the service.
+func (b *browser) instance(name, suffix string) *Instance {
+	if len(name) <= len(suffix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return nil
+	}
+	short := name[:len(name)-len(suffix)]
+	for i := range b.instances {
+		if strings.EqualFold(b.instances[i].Name, short) {
+			return &b.instances[i]
+		}
+	}
+	b.instances = append(b.instances, Instance{Name: short, Service: suffix[1 : len(suffix)-len(".local")]})
+	return &b.instances[len(b.instances)-1]
+}
+
+// missing returns the questions for the SRV and TXT records of the instances
+// and the addresses of the hosts that have not been received.
+func (b *browser) missing() []dns.Question {
+	var questions []dns.Question
+	for _, in := range b.instances {
+		name := in.Name + "." + in.Service + ".local"
+		if in.Host == "" {
+			questions = append(questions, dns.Question{Name: name, Type: dns.TypeANY, Class: dns.ClassINET})
+			continue
+		}
+		found := false
+		for _, h := range b.hosts {
+			found = found || strings.EqualFold(h.Name, in.Host)
+		}
+		for _, q := range questions {
+			found = found || strings.EqualFold(q.Name, in.Host)
+		}
+		if !found {
+			questions = append(questions, dns.Question{Name: in.Host, Type: dns.TypeA, Class: dns.ClassINET})
+		}
+	}
+	return questions
+}
+
+// query sends a query with questions to the group, if any.
+func (b *browser) query(questions ...dns.Question) error {
+	if len(questions) == 0 {
+		return nil
+	}
+	var err error
+	b.wbuf, err = (&dns.Message{Questions: questions}).Append(b.wbuf[:0])
+	if err != nil {
+		return err
+	}
+	_, err = b.conn.WriteTo(b.wbuf, groupAddr())
+	return err
+}
@@ -0,0 +1,377 @@
+// Package mdns implements a Multicast DNS responder (RFC 6762), so that a
+// device can be reached as "<hostname>.local" on the local network, and
+// advertises its services with DNS-Based Service Discovery (RFC 6763), such
+// as a web server for the "_http._tcp" service type. Browse finds the
+// instances of a service advertised by the other devices.
+//
+// It needs an adaptor that implements net.MulticastDriver and
+// net.UDPDriver:
+//
+//	r, err := mdns.Listen("sensor-42", ip.String())
+//	if err != nil {
+//		return err
+//	}
+//	r.AddService(mdns.Service{Instance: "Sensor 42", Service: "_http._tcp", Port: 80})
+//	for {
+//		r.Poll()
+//		// ...
+//	}
+//
+// The responder does not probe for conflicts with the names used by other
+// devices, the hostname must be unique on the network.
+package mdns // import "tinygo.org/x/drivers/net/mdns"
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/dns"
+)
+
+const (
+	// Port is the UDP port of multicast DNS.
+	Port = 5353
+
+	// Group is the IPv4 multicast address of multicast DNS.
+	Group = "224.0.0.251"
+)
+
+// MaxMessageSize is the size of the buffer used to receive messages, larger
+// ones are ignored.
+const MaxMessageSize = 1500
+
+// time to live of the records, from RFC 6762 section 10: the records that
+// depend on the address of the device are refreshed more often
+const (
+	hostTTL  = 120
+	otherTTL = 4500
+)
+
+// top bit of the class, the cache-flush bit of the records that are unique
+// to the device, and the unicast-response bit of questions
+const classUnique = 0x8000
+
+// name of the PTR records that list the service types of a device
+const servicesName = "_services._dns-sd._udp.local"
+
+// ErrInvalidName is returned for a hostname, instance or service name that
+// cannot be used in a DNS name.
+var ErrInvalidName = errors.New("mdns: invalid name")
+
+// Service is a service advertised with DNS-SD.
+type Service struct {
+	// Instance is the user-friendly name of the instance, such as
+	// "Living room sensor". It must not contain dots.
+	Instance string
+
+	// Service is the type of service and its transport protocol, such as
+	// "_http._tcp".
+	Service string
+
+	// Port is the port of the service on the device.
+	Port uint16
+
+	// Text is the "key=value" pairs of the TXT record of the instance.
+	Text []string
+}
+
+// name returns the full name of the instance, such as
+// "Living room sensor._http._tcp.local".
+func (s *Service) name() string {
+	return s.Instance + "." + s.Service + ".local"
+}
+
+// A Responder answers the multicast DNS queries for the hostname and the
+// services of the device. It is not safe for concurrent use.
+type Responder struct {
+	hostname string
+	ip       string
+	services []Service
+
+	conn *net.UDPSerialConn
+	buf  [MaxMessageSize]byte
+	wbuf []byte
+}
+
+// Listen starts a responder for hostname, given without the ".local"
+// suffix, at the IPv4 address ip of the device, and announces it.
+//
+// The hostname is also set on the adaptor if it supports it, like the
+// wifinina driver, for the next DHCP requests; it should be set on the
+// device before connecting as well, for the DHCP server to know it at once.
+func Listen(hostname, ip string) (*Responder, error) {
+	if hostname == "" || len(hostname) > 63 || strings.ContainsAny(hostname, ".") {
+		return nil, ErrInvalidName
+	}
+	if h, ok := net.ActiveDevice.(interface{ SetHostname(string) error }); ok {
+		if err := h.SetHostname(hostname); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	r := &Responder{hostname: hostname + ".local", ip: ip, conn: conn}
+	if err := r.announce([]dns.Record{r.hostRecord(hostTTL)}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// listen opens a socket on the multicast DNS group.
+func listen() (*net.UDPSerialConn, error) {
+	return net.ListenMulticastUDP("udp", &net.UDPAddr{IP: net.ParseIP(Group), Port: Port})
+}
+
+// Hostname returns the name of the device, such as "sensor-42.local".
+func (r *Responder) Hostname() string {
+	return r.hostname
+}
+
+// AddService advertises a service, and announces it.
+func (r *Responder) AddService(s Service) error {
+	if s.Instance == "" || len(s.Instance) > 63 || strings.ContainsAny(s.Instance, ".") ||
+		!strings.HasPrefix(s.Service, "_") || !strings.Contains(s.Service, "._") {
+		return ErrInvalidName
+	}
+	r.RemoveService(s.Instance, s.Service)
+	r.services = append(r.services, s)
+	records := r.serviceRecords(&r.services[len(r.services)-1], otherTTL, hostTTL)
+	return r.announce(append(records, r.hostRecord(hostTTL)))
+}
+
+// RemoveService stops advertising a service, and tells the other devices
+// it is gone.
+func (r *Responder) RemoveService(instance, service string) error {
+	for i := range r.services {
+		s := &r.services[i]
+		if strings.EqualFold(s.Instance, instance) && strings.EqualFold(s.Service, service) {
+			records := r.serviceRecords(s, 0, 0)
+			r.services = append(r.services[:i], r.services[i+1:]...)
+			return r.announce(records)
+		}
+	}
+	return nil
+}
+
+// Close tells the other devices that the hostname and the services are gone,
+// and closes the socket.
+func (r *Responder) Close() error {
+	records := []dns.Record{r.hostRecord(0)}
+	for i := range r.services {
+		records = append(records, r.serviceRecords(&r.services[i], 0, 0)...)
+	}
+	r.announce(records)
+	return r.conn.Close()
+}
+
+// Serve answers the queries until an error occurs.
+func (r *Responder) Serve() error {
+	for {
+		if err := r.Poll(); err != nil {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Poll answers the queries that have been received, if any. It does not
+// block, so it can be called from the main loop of a program.
+func (r *Responder) Poll() error {
+	r.conn.SetReadDeadline(time.Time{})
+	for r.conn.IsDataAvailable() {
+		n, addr, err := r.conn.ReadFrom(r.buf[:])
+		if err != nil || n == 0 {
+			return err
+		}
+		m, err := dns.ParseMessage(r.buf[:n])
+		if err != nil {
+			continue
+		}
+		if err := r.handle(m, addr.(*net.UDPAddr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle answers a query received from addr.
+func (r *Responder) handle(m *dns.Message, addr *net.UDPAddr) error {
+	if m.Response {
+		return nil
+	}
+	var answers []dns.Record
+	unicast := false
+	for _, q := range m.Questions {
+		unicast = unicast || q.Class&classUnique != 0
+		answers = r.appendAnswers(answers, q)
+	}
+
+	// known-answer suppression: the records the querier already has with
+	// more than half their TTL left are not sent again
+	for i := 0; i < len(answers); i++ {
+		for _, known := range m.Answers {
+			if sameRecord(&known, &answers[i]) && known.TTL >= answers[i].TTL/2 {
+				answers = append(answers[:i], answers[i+1:]...)
+				i--
+				break
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+
+	resp := &dns.Message{Response: true, Authoritative: true, Answers: answers}
+	resp.Additionals = r.additionals(answers)
+	if addr.Port != Port {
+		// legacy unicast query from a resolver that is not a full mDNS
+		// querier: it needs the ID and question, short TTLs and no
+		// cache-flush bits
+		resp.ID, resp.Questions = m.ID, m.Questions
+		for _, section := range [][]dns.Record{resp.Answers, resp.Additionals} {
+			for i := range section {
+				section[i].Class &^= classUnique
+				if section[i].TTL > 10 {
+					section[i].TTL = 10
+				}
+			}
+		}
+		return r.send(resp, addr)
+	}
+	if unicast {
+		return r.send(resp, addr)
+	}
+	return r.send(resp, groupAddr())
+}
+
+// appendAnswers appends the records that answer q.
+func (r *Responder) appendAnswers(answers []dns.Record, q dns.Question) []dns.Record {
+	match := func(typ dns.Type) bool {
+		return q.Type == typ || q.Type == dns.TypeANY
+	}
+	if strings.EqualFold(q.Name, r.hostname) && match(dns.TypeA) {
+		answers = append(answers, r.hostRecord(hostTTL))
+	}
+	if strings.EqualFold(q.Name, servicesName) && match(dns.TypePTR) {
+		for i := range r.services {
+			s := &r.services[i]
+			rr := dns.Record{Name: servicesName, Type: dns.TypePTR, Class: dns.ClassINET, TTL: otherTTL, Target: s.Service + ".local"}
+			if !hasRecord(answers, &rr) {
+				answers = append(answers, rr)
+			}
+		}
+	}
+	for i := range r.services {
+		s := &r.services[i]
+		if strings.EqualFold(q.Name, s.Service+".local") && match(dns.TypePTR) {
+			answers = append(answers, r.serviceRecords(s, otherTTL, hostTTL)[0])
+		}
+		if strings.EqualFold(q.Name, s.name()) {
+			records := r.serviceRecords(s, otherTTL, hostTTL)
+			if match(dns.TypeSRV) {
+				answers = append(answers, records[1])
+			}
+			if match(dns.TypeTXT) {
+				answers = append(answers, records[2])
+			}
+		}
+	}
+	return answers
+}
+
+// additionals returns the records that the querier will need next: the SRV
+// and TXT records of the instances, and the address of the device.
+func (r *Responder) additionals(answers []dns.Record) []dns.Record {
+	var additionals []dns.Record
+	add := func(rr dns.Record) {
+		if !hasRecord(answers, &rr) && !hasRecord(additionals, &rr) {
+			additionals = append(additionals, rr)
+		}
+	}
+	for _, a := range answers {
+		switch a.Type {
+		case dns.TypePTR:
+			for i := range r.services {
+				if records := r.serviceRecords(&r.services[i], otherTTL, hostTTL); strings.EqualFold(a.Target, records[1].Name) {
+					add(records[1])
+					add(records[2])
+					add(r.hostRecord(hostTTL))
+				}
+			}
+		case dns.TypeSRV:
+			add(r.hostRecord(hostTTL))
+		}
+	}
+	return additionals
+}
+
+// hostRecord returns the A record of the device.
+func (r *Responder) hostRecord(ttl uint32) dns.Record {
+	return dns.Record{Name: r.hostname, Type: dns.TypeA, Class: dns.ClassINET | classUnique, TTL: ttl, IP: r.ip}
+}
+
+// serviceRecords returns the PTR, SRV and TXT records of a service.
+func (r *Responder) serviceRecords(s *Service, ttl, srvTTL uint32) []dns.Record {
+	name := s.name()
+	text := s.Text
+	if len(text) == 0 {
+		text = []string{""}
+	}
+	return []dns.Record{
+		{Name: s.Service + ".local", Type: dns.TypePTR, Class: dns.ClassINET, TTL: ttl, Target: name},
+		{Name: name, Type: dns.TypeSRV, Class: dns.ClassINET | classUnique, TTL: srvTTL, Target: r.hostname, Port: s.Port},
+		{Name: name, Type: dns.TypeTXT, Class: dns.ClassINET | classUnique, TTL: ttl, Text: text},
+	}
+}
+
+// announce sends an unsolicited response with records to the group.
+func (r *Responder) announce(records []dns.Record) error {
+	return r.send(&dns.Message{Response: true, Authoritative: true, Answers: records}, groupAddr())
+}
+
+// send encodes a message and sends it to addr.
+func (r *Responder) send(m *dns.Message, addr *net.UDPAddr) error {
+	var err error
+	r.wbuf, err = m.Append(r.wbuf[:0])
+	if err != nil {
+		return err
+	}
+	_, err = r.conn.WriteTo(r.wbuf, addr)
+	return err
+}
+
+func groupAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: net.ParseIP(Group), Port: Port}
+}
+
+// sameRecord reports if two records have the same name, type and data.
+func sameRecord(a, b *dns.Record) bool {
+	if a.Type != b.Type || !strings.EqualFold(a.Name, b.Name) {
+		return false
+	}
+	switch a.Type {
+	case dns.TypeA, dns.TypeAAAA:
+		return a.IP == b.IP
+	case dns.TypePTR, dns.TypeCNAME:
+		return strings.EqualFold(a.Target, b.Target)
+	case dns.TypeSRV:
+		return strings.EqualFold(a.Target, b.Target) && a.Port == b.Port
+	case dns.TypeTXT:
+		return strings.Join(a.Text, "\x00") == strings.Join(b.Text, "\x00")
+	}
+	return false
+}
+
+func hasRecord(records []dns.Record, rr *dns.Record) bool {
+	for i := range records {
+		if sameRecord(&records[i], rr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package mdns
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/dns"
+)
+
+type datagram struct {
+	b    []byte
+	ip   string
+	port int
+}
+
+type sock struct {
+	ip        string
+	port      int
+	multicast bool
+	queue     []datagram
+	from      datagram
+}
+
+// bus is a fake adaptor: every socket has its own address 10.0.0.<n>, and
+// the datagrams sent to the group reach all the multicast sockets.
+type bus struct {
+	mu       sync.Mutex
+	socks    map[net.Socket]*sock
+	next     net.Socket
+	hostname string
+}
+
+func newBus() *bus { return &bus{socks: map[net.Socket]*sock{}} }
+
+func (d *bus) SetHostname(h string) error { d.mu.Lock(); d.hostname = h; d.mu.Unlock(); return nil }
+
+func (d *bus) GetDNS(string) (string, error) { return "", net.ErrNotSupported }
+func (d *bus) OpenSocket(p net.Protocol) (net.Socket, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.next++
+	d.socks[d.next] = &sock{ip: "10.0.0." + string(rune('0'+d.next))}
+	return d.next, nil
+}
+func (d *bus) ConnectSocket(s net.Socket, addr string, port, localPort int) error {
+	d.mu.Lock()
+	d.socks[s].port = localPort
+	d.mu.Unlock()
+	return nil
+}
+func (d *bus) SendSocket(s net.Socket, b []byte) (int, error) { return 0, net.ErrNotSupported }
+func (d *bus) SendSocketTo(s net.Socket, b []byte, addr string, port int) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	src := d.socks[s]
+	for _, o := range d.socks {
+		if addr == Group && o.multicast && o.port == port || o.ip == addr && o.port == port {
+			o.queue = append(o.queue, datagram{append([]byte(nil), b...), src.ip, src.port})
+		}
+	}
+	return len(b), nil
+}
+func (d *bus) RecvSocket(s net.Socket, b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	o := d.socks[s]
+	if o == nil {
+		return 0, net.ErrInvalidSocket
+	}
+	if len(o.queue) == 0 {
+		return 0, nil
+	}
+	o.from = o.queue[0]
+	o.queue = o.queue[1:]
+	return copy(b, o.from.b), nil
+}
+func (d *bus) IsSocketDataAvailable(s net.Socket) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	o := d.socks[s]
+	return o != nil && len(o.queue) > 0
+}
+func (d *bus) SocketRemoteAddr(s net.Socket) (string, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.socks[s].from.ip, d.socks[s].from.port, nil
+}
+func (d *bus) ListenSocket(p net.Protocol, port int) (net.Socket, error) {
+	return 0, net.ErrNotSupported
+}
+func (d *bus) AcceptSocket(s net.Socket) (net.Socket, error) { return 0, net.ErrNotSupported }
+func (d *bus) CloseSocket(s net.Socket) error {
+	d.mu.Lock()
+	delete(d.socks, s)
+	d.mu.Unlock()
+	return nil
+}
+func (d *bus) ListenMulticastSocket(group string, port int) (net.Socket, error) {
+	s, _ := d.OpenSocket(net.ProtocolUDP)
+	d.mu.Lock()
+	d.socks[s].port = port
+	d.socks[s].multicast = true
+	d.mu.Unlock()
+	return s, nil
+}
+
+// raw opens a plain UDP socket on port to send queries and read responses.
+func raw(t *testing.T, port int) *net.UDPSerialConn {
+	conn, err := net.DialUDP("udp", &net.UDPAddr{Port: port}, &net.UDPAddr{IP: net.ParseIP(Group), Port: Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func exchange(t *testing.T, conn *net.UDPSerialConn, r *Responder, q *dns.Message) *dns.Message {
+	b, _ := q.Append(nil)
+	if _, err := conn.WriteTo(b, &net.UDPAddr{IP: net.ParseIP(Group), Port: Port}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err == net.ErrDeadlineExceeded {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := dns.ParseMessage(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Response {
+			return m
+		}
+	}
+}
+
+func TestResponder(t *testing.T) {
+	d := newBus()
+	net.UseDriver(d)
+	if _, err := Listen("a.b", "10.1.1.1"); err != ErrInvalidName {
+		t.Fatal(err)
+	}
+	r, err := Listen("dev-a", "10.1.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.hostname != "dev-a" || r.Hostname() != "dev-a.local" {
+		t.Fatal(d.hostname, r.Hostname())
+	}
+	if err := r.AddService(Service{Instance: "Sensor A", Service: "_http._tcp", Port: 80, Text: []string{"path=/"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddService(Service{Instance: "x.y", Service: "_http._tcp"}); err != ErrInvalidName {
+		t.Fatal(err)
+	}
+
+	// legacy unicast
+	legacy := raw(t, 40000)
+	m := exchange(t, legacy, r, &dns.Message{ID: 0x1234, Questions: []dns.Question{{Name: "DEV-A.local", Type: dns.TypeA, Class: dns.ClassINET}}})
+	if m == nil || m.ID != 0x1234 || len(m.Questions) != 1 || len(m.Answers) != 1 {
+		t.Fatalf("%+v", m)
+	}
+	if a := m.Answers[0]; a.IP != "10.1.1.1" || a.TTL != 10 || a.Class != dns.ClassINET {
+		t.Fatalf("%+v", a)
+	}
+	if m := exchange(t, legacy, r, &dns.Message{Questions: []dns.Question{{Name: "other.local", Type: dns.TypeA, Class: dns.ClassINET}}}); m != nil {
+		t.Fatalf("%+v", m)
+	}
+
+	// multicast querier on port 5353 with QU bit
+	q := raw(t, Port)
+	m = exchange(t, q, r, &dns.Message{Questions: []dns.Question{{Name: servicesName, Type: dns.TypePTR, Class: dns.ClassINET | classUnique}}})
+	if m == nil || len(m.Answers) != 1 || m.Answers[0].Target != "_http._tcp.local" {
+		t.Fatalf("%+v", m)
+	}
+	m = exchange(t, q, r, &dns.Message{Questions: []dns.Question{{Name: "_http._tcp.local", Type: dns.TypePTR, Class: dns.ClassINET | classUnique}}})
+	if m == nil || len(m.Answers) != 1 || len(m.Additionals) != 3 {
+		t.Fatalf("%+v", m)
+	}
+	if m.Answers[0].Target != "Sensor A._http._tcp.local" || m.Additionals[0].Port != 80 || m.Additionals[0].Target != "dev-a.local" ||
+		m.Additionals[1].Text[0] != "path=/" || m.Additionals[2].IP != "10.1.1.1" || m.Additionals[0].Class != dns.ClassINET|classUnique {
+		t.Fatalf("%+v", m)
+	}
+	// known answer suppression
+	known := m.Answers[0]
+	m = exchange(t, q, r, &dns.Message{Questions: []dns.Question{{Name: "_http._tcp.local", Type: dns.TypePTR, Class: dns.ClassINET | classUnique}}, Answers: []dns.Record{known}})
+	if m != nil {
+		t.Fatalf("%+v", m)
+	}
+	known.TTL = 100
+	m = exchange(t, q, r, &dns.Message{Questions: []dns.Question{{Name: "_http._tcp.local", Type: dns.TypePTR, Class: dns.ClassINET | classUnique}}, Answers: []dns.Record{known}})
+	if m == nil {
+		t.Fatal("not answered")
+	}
+
+	// browse from another device while r serves
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			r.Poll()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	found, err := Browse("_http._tcp", 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Name != "Sensor A" || found[0].Service != "_http._tcp" || found[0].Host != "dev-a.local" ||
+		found[0].IP != "10.1.1.1" || found[0].Port != 80 || strings.Join(found[0].Text, ",") != "path=/" {
+		t.Fatalf("%+v", found)
+	}
+	r2, err := Listen("dev-b", "10.1.1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2.AddService(Service{Instance: "Sensor B", Service: "_http._tcp", Port: 8080})
+	found, err = r2.Browse("_http._tcp", 200*time.Millisecond)
+	if err != nil || len(found) != 2 {
+		t.Fatalf("%+v %v", found, err)
+	}
+	found, err = r2.Browse("_coap._udp", 100*time.Millisecond)
+	if err != nil || len(found) != 0 {
+		t.Fatalf("%+v %v", found, err)
+	}
+	close(done)
+	wg.Wait()
+
+	// goodbye
+	q, err = listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	r.Close()
+	n, _, _ := q.ReadFrom(buf)
+	m, err = dns.ParseMessage(buf[:n])
+	if err != nil || len(m.Answers) != 4 {
+		t.Fatalf("%+v %v", m, err)
+	}
+	for _, a := range m.Answers {
+		if a.TTL != 0 {
+			t.Fatalf("%+v", a)
+		}
+	}
+	r2.Close()
+}
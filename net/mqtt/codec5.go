@@ -0,0 +1,383 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// maxTopicAliases limits the number of topic aliases the client assigns to
+// the topics it publishes to, whatever the broker allows, to bound the memory
+// used.
+const maxTopicAliases = 16
+
+// DefaultMaxPacketSize is the size of the largest MQTT 5 packet accepted
+// from the broker when ClientOptions.MaxPacketSize is 0.
+const DefaultMaxPacketSize = 4096
+
+// ErrPacketTooLarge is returned when the broker sends an MQTT 5 packet larger
+// than ClientOptions.MaxPacketSize. The connection is closed, as the rest of
+// the packet cannot be skipped safely.
+var ErrPacketTooLarge = &ReasonCodeError{Code: 0x95}
+
+// ReasonCodeError is the error of an MQTT 5 packet with a reason code
+// signalling a failure, such as a CONNACK, a PUBACK or a DISCONNECT.
+type ReasonCodeError struct {
+	Code byte
+
+	// Reason is the reason string sent by the broker, if any.
+	Reason string
+}
+
+var reasonTexts = [...]string{
+	"unspecified error", "malformed packet", "protocol error", "implementation specific error",
+	"unsupported protocol version", "client identifier not valid", "bad user name or password",
+	"not authorized", "server unavailable", "server busy", "banned", "server shutting down",
+	"bad authentication method", "keep alive timeout", "session taken over", "topic filter invalid",
+	"topic name invalid", "packet identifier in use", "packet identifier not found",
+	"receive maximum exceeded", "topic alias invalid", "packet too large", "message rate too high",
+	"quota exceeded", "administrative action", "payload format invalid", "retain not supported",
+	"QoS not supported", "use another server", "server moved", "shared subscriptions not supported",
+	"connection rate exceeded", "maximum connect time", "subscription identifiers not supported",
+	"wildcard subscriptions not supported",
+}
+
+func (e *ReasonCodeError) Error() string {
+	s := "mqtt: reason code 0x" + strconv.FormatUint(uint64(e.Code), 16)
+	if i := int(e.Code) - 0x80; i >= 0 && i < len(reasonTexts) {
+		s += " (" + reasonTexts[i] + ")"
+	}
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// reasonError returns the error of a reason code, nil for the codes that
+// signal a success.
+func reasonError(code byte, props *Properties) error {
+	if code < 0x80 {
+		return nil
+	}
+	err := &ReasonCodeError{Code: code}
+	if props != nil {
+		err.Reason = props.ReasonString
+	}
+	return err
+}
+
+// incoming is a packet read from the broker, with its MQTT 5 reason code and
+// properties.
+type incoming struct {
+	packet packets.ControlPacket
+	reason byte
+	props  *Properties
+}
+
+// codec5 encodes and decodes the MQTT 5 packets of a connection. Packets are
+// kept in the types of the paho packets library, their reason codes and
+// properties travel beside them. The buffers are reused for every packet so
+// that reading and writing does not allocate once they have grown.
+type codec5 struct {
+	wbuf []byte
+	rbuf []byte
+
+	// topic aliases of the topics published, up to aliasMax
+	aliasMax uint16
+	aliases  map[string]uint16
+
+	// topics of the aliases used by the broker
+	topics []string
+
+	// size of the largest packet read, including the fixed header
+	maxPacketSize uint32
+}
+
+// newCodec5 returns a codec accepting up to topicAliasMaximum topic aliases
+// and packets of up to maxPacketSize bytes from the broker, 0 meaning
+// DefaultMaxPacketSize.
+func newCodec5(topicAliasMaximum uint16, maxPacketSize uint32) *codec5 {
+	if maxPacketSize == 0 {
+		maxPacketSize = DefaultMaxPacketSize
+	}
+	return &codec5{topics: make([]string, topicAliasMaximum), maxPacketSize: maxPacketSize}
+}
+
+// setAliasMaximum sets the number of topic aliases the broker accepts, as
+// received in the CONNACK.
+func (c *codec5) setAliasMaximum(n uint16) {
+	if n > maxTopicAliases {
+		n = maxTopicAliases
+	}
+	c.aliasMax = n
+	c.aliases = make(map[string]uint16, n)
+}
+
+// write encodes p with the properties props, and writes it to w at once.
+func (c *codec5) write(w io.Writer, p packets.ControlPacket, props *Properties) error {
+	// leave room for the largest fixed header
+	b := append(c.wbuf[:0], 0, 0, 0, 0, 0)
+	var header byte
+
+	switch p := p.(type) {
+	case *packets.ConnectPacket:
+		header = 0x10
+		b = appendString(b, "MQTT")
+		var flags byte
+		if p.UsernameFlag {
+			flags |= 0x80
+		}
+		if p.PasswordFlag {
+			flags |= 0x40
+		}
+		if p.WillFlag {
+			flags |= 0x04 | p.WillQos<<3
+			if p.WillRetain {
+				flags |= 0x20
+			}
+		}
+		if p.CleanSession {
+			flags |= 0x02
+		}
+		b = append(b, 5, flags)
+		b = appendUint16(b, p.Keepalive)
+		b = appendProperties(b, props)
+		b = appendString(b, p.ClientIdentifier)
+		if p.WillFlag {
+			b = appendProperties(b, nil)
+			b = appendString(b, p.WillTopic)
+			b = appendBinary(b, p.WillMessage)
+		}
+		if p.UsernameFlag {
+			b = appendString(b, p.Username)
+		}
+		if p.PasswordFlag {
+			b = appendBinary(b, p.Password)
+		}
+	case *packets.PublishPacket:
+		header = 0x30 | p.Qos<<1
+		if p.Dup {
+			header |= 0x08
+		}
+		if p.Retain {
+			header |= 0x01
+		}
+		topic := p.TopicName
+		if c.aliasMax > 0 {
+			// send the topic once with its alias, then only the alias
+			alias, ok := c.aliases[topic]
+			if ok {
+				topic = ""
+			} else if len(c.aliases) < int(c.aliasMax) {
+				alias = uint16(len(c.aliases) + 1)
+				c.aliases[topic] = alias
+			}
+			if alias != 0 {
+				var q Properties
+				if props != nil {
+					q = *props
+				}
+				q.TopicAlias = alias
+				props = &q
+			}
+		}
+		b = appendString(b, topic)
+		if p.Qos > 0 {
+			b = appendUint16(b, p.MessageID)
+		}
+		b = appendProperties(b, props)
+		b = append(b, p.Payload...)
+	case *packets.SubscribePacket:
+		header = 0x82
+		b = appendUint16(b, p.MessageID)
+		b = appendProperties(b, props)
+		for i, topic := range p.Topics {
+			b = appendString(b, topic)
+			b = append(b, p.Qoss[i])
+		}
+	case *packets.UnsubscribePacket:
+		header = 0xA2
+		b = appendUint16(b, p.MessageID)
+		b = appendProperties(b, props)
+		for _, topic := range p.Topics {
+			b = appendString(b, topic)
+		}
+	default:
+		// PINGREQ, DISCONNECT and acks with the success reason code are
+		// the same as in MQTT 3.1.1
+		return p.Write(w)
+	}
+
+	// fixed header, right before the variable header
+	var h [5]byte
+	fixed := appendVarint(append(h[:0], header), uint32(len(b)-5))
+	start := 5 - len(fixed)
+	copy(b[start:], fixed)
+	c.wbuf = b
+	_, err := w.Write(b[start:])
+	return err
+}
+
+// read reads the next packet from r.
+func (c *codec5) read(r io.Reader) (incoming, error) {
+	var h [1]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return incoming{}, err
+	}
+	header := h[0]
+
+	var n uint32
+	i := 0
+	for ; ; i++ {
+		if i == 4 {
+			return incoming{}, errMalformed
+		}
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return incoming{}, err
+		}
+		n |= uint32(h[0]&0x7F) << (7 * i)
+		if h[0]&0x80 == 0 {
+			break
+		}
+	}
+
+	// the buffer is only allocated for the packets the broker may send, it
+	// knows the limit from the CONNECT properties; the size includes the
+	// fixed header
+	if uint64(n)+uint64(i)+2 > uint64(c.maxPacketSize) {
+		return incoming{}, ErrPacketTooLarge
+	}
+	if cap(c.rbuf) < int(n) {
+		c.rbuf = make([]byte, n)
+	}
+	b := c.rbuf[:n]
+	if _, err := io.ReadFull(r, b); err != nil {
+		return incoming{}, err
+	}
+	return c.parse(header, b)
+}
+
+// parse decodes the variable header and the payload b of a packet.
+func (c *codec5) parse(header byte, b []byte) (in incoming, err error) {
+	typ := header >> 4
+	switch typ {
+	case packets.Connack:
+		if len(b) < 2 {
+			return in, errMalformed
+		}
+		ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+		ack.SessionPresent = b[0]&0x01 != 0
+		ack.ReturnCode = b[1]
+		in.packet, in.reason = ack, b[1]
+		if len(b) > 2 {
+			in.props, _, err = parseProperties(b[2:])
+		}
+	case packets.Publish:
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.Dup = header&0x08 != 0
+		pub.Qos = (header >> 1) & 0x03
+		pub.Retain = header&0x01 != 0
+		var topic []byte
+		if topic, b, err = parseBinary(b); err != nil {
+			return in, err
+		}
+		if pub.Qos > 0 {
+			if len(b) < 2 {
+				return in, errMalformed
+			}
+			pub.MessageID, b = binary.BigEndian.Uint16(b), b[2:]
+		}
+		if in.props, b, err = parseProperties(b); err != nil {
+			return in, err
+		}
+		if pub.TopicName, err = c.topic(topic, in.props); err != nil {
+			return in, err
+		}
+		pub.Payload = append([]byte(nil), b...)
+		in.packet = pub
+	case packets.Puback, packets.Pubrec, packets.Pubrel, packets.Pubcomp, packets.Suback, packets.Unsuback:
+		if len(b) < 2 {
+			return in, errMalformed
+		}
+		id := binary.BigEndian.Uint16(b)
+		b = b[2:]
+		switch typ {
+		case packets.Suback, packets.Unsuback:
+			if in.props, b, err = parseProperties(b); err != nil {
+				return in, err
+			}
+		default:
+			if len(b) > 0 {
+				in.reason = b[0]
+			}
+			if len(b) > 1 {
+				in.props, _, err = parseProperties(b[1:])
+			}
+		}
+
+		switch typ {
+		case packets.Puback:
+			p := packets.NewControlPacket(typ).(*packets.PubackPacket)
+			p.MessageID, in.packet = id, p
+		case packets.Pubrec:
+			p := packets.NewControlPacket(typ).(*packets.PubrecPacket)
+			p.MessageID, in.packet = id, p
+		case packets.Pubrel:
+			p := packets.NewControlPacket(typ).(*packets.PubrelPacket)
+			p.MessageID, in.packet = id, p
+		case packets.Pubcomp:
+			p := packets.NewControlPacket(typ).(*packets.PubcompPacket)
+			p.MessageID, in.packet = id, p
+		case packets.Suback:
+			p := packets.NewControlPacket(typ).(*packets.SubackPacket)
+			p.MessageID, in.packet = id, p
+			p.ReturnCodes = append([]byte(nil), b...)
+		case packets.Unsuback:
+			p := packets.NewControlPacket(typ).(*packets.UnsubackPacket)
+			p.MessageID, in.packet = id, p
+			for _, code := range b {
+				if code >= 0x80 {
+					in.reason = code
+					break
+				}
+			}
+		}
+	case packets.Pingresp:
+		in.packet = packets.NewControlPacket(packets.Pingresp)
+	case packets.Disconnect:
+		in.packet = packets.NewControlPacket(packets.Disconnect)
+		if len(b) > 0 {
+			in.reason = b[0]
+		}
+		if len(b) > 1 {
+			in.props, _, err = parseProperties(b[1:])
+		}
+	default:
+		return in, errors.New("mqtt: unexpected packet type " + strconv.Itoa(int(typ)))
+	}
+	return in, err
+}
+
+// topic returns the topic of a PUBLISH, resolving its topic alias.
+func (c *codec5) topic(topic []byte, props *Properties) (string, error) {
+	if props == nil || props.TopicAlias == 0 {
+		if len(topic) == 0 {
+			return "", errMalformed
+		}
+		return string(topic), nil
+	}
+
+	alias := int(props.TopicAlias)
+	if alias > len(c.topics) {
+		return "", &ReasonCodeError{Code: 0x94}
+	}
+	if len(topic) > 0 {
+		c.topics[alias-1] = string(topic)
+	} else if c.topics[alias-1] == "" {
+		return "", &ReasonCodeError{Code: 0x94}
+	}
+	return c.topics[alias-1], nil
+}
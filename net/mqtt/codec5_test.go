@@ -0,0 +1,237 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	stdnet "net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	dnet "tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+func rawRead(r io.Reader) (byte, []byte, error) {
+	var h [1]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	header := h[0]
+	var n uint32
+	for i := 0; ; i++ {
+		io.ReadFull(r, h[:])
+		n |= uint32(h[0]&0x7F) << (7 * i)
+		if h[0]&0x80 == 0 {
+			break
+		}
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return header, b, err
+}
+
+func rawWrite(w io.Writer, header byte, body []byte) {
+	w.Write(append(appendVarint([]byte{header}, uint32(len(body))), body...))
+}
+
+func TestV5(t *testing.T) {
+	d := loopback.New()
+	dnet.UseDriver(d)
+	errs := make(chan error, 20)
+	check := func(ok bool, msg string) {
+		if !ok {
+			errs <- errors.New(msg)
+		}
+	}
+	d.Handle("broker:1883", func(conn stdnet.Conn) {
+		h, b, _ := rawRead(conn)
+		check(h == 0x10 && b[6] == 5, "connect header")
+		props, _, err := parseProperties(b[10:])
+		check(err == nil && props != nil && props.SessionExpiry == 300 && props.TopicAliasMaximum == 3 && props.MaximumPacketSize == 2048, "connect props")
+
+		body := []byte{0, 0}
+		body = appendProperties(body, &Properties{TopicAliasMaximum: 5, AssignedClientID: "abc", ServerKeepAlive: 60})
+		rawWrite(conn, 0x20, body)
+
+		dec := newCodec5(16, 0)
+		for i := 0; i < 3; i++ {
+			h, b, err := rawRead(conn)
+			if err != nil {
+				return
+			}
+			in, err := dec.parse(h, b)
+			check(err == nil, "parse publish")
+			topicLen := int(b[0])<<8 | int(b[1])
+			pub := in.packet.(*packets.PublishPacket)
+			check(pub.TopicName == "a/b", "topic resolved")
+			switch i {
+			case 0:
+				check(topicLen == 3 && in.props.TopicAlias == 1, "first publish with topic and alias")
+				check(in.props.ResponseTopic == "reply" && in.props.Get("k") == "v", "publish props")
+			case 1:
+				check(topicLen == 0 && in.props.TopicAlias == 1, "second publish alias only")
+			case 2:
+				// refuse the QoS 1 message
+				ack := appendUint16(nil, pub.MessageID)
+				ack = append(ack, 0x87)
+				ack = appendProperties(ack, &Properties{ReasonString: "nope"})
+				rawWrite(conn, 0x40, ack)
+			}
+		}
+
+		// two messages with a topic alias, then go away
+		for i, topic := range []string{"x/y", ""} {
+			body := appendString(nil, topic)
+			body = appendProperties(body, &Properties{TopicAlias: 2, UserProperties: []UserProperty{{"n", "1"}}})
+			body = append(body, byte('0'+i))
+			rawWrite(conn, 0x30, body)
+		}
+		time.Sleep(300 * time.Millisecond)
+		rawWrite(conn, 0xE0, appendProperties([]byte{0x8B}, nil))
+	})
+
+	lost := make(chan error, 1)
+	got := make(chan Message, 2)
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").SetProtocolVersion(5).
+		SetSessionExpiryInterval(300).SetTopicAliasMaximum(3).SetMaxPacketSize(2048).SetAutoReconnect(false).
+		SetDefaultPublishHandler(func(c Client, m Message) { got <- m }).
+		SetConnectionLostHandler(func(c Client, err error) { lost <- err })
+	opts.Adaptor = d
+	opts.Order = true
+	c := NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	if opts.ClientID != "abc" {
+		t.Error("assigned client id", opts.ClientID)
+	}
+	props := (&Properties{ResponseTopic: "reply"}).Add("k", "v")
+	c.PublishWithProperties("a/b", 0, false, "1", props)
+	c.Publish("a/b", 0, false, "2")
+	tok := c.Publish("a/b", 1, false, "3")
+	tok.Wait()
+	var rerr *ReasonCodeError
+	if !errors.As(tok.Error(), &rerr) || rerr.Code != 0x87 || rerr.Reason != "nope" {
+		t.Error("puback reason", tok.Error())
+	} else {
+		t.Log(tok.Error())
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-got:
+			if m.Topic() != "x/y" || m.Properties().Get("n") != "1" || string(m.Payload()) != string(rune('0'+i)) {
+				t.Error("message", m.Topic(), m.Properties(), string(m.Payload()))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no message")
+		}
+	}
+	select {
+	case err := <-lost:
+		t.Log(err)
+		if !errors.As(err, &rerr) || rerr.Code != 0x8B {
+			t.Error("disconnect reason", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no disconnect")
+	}
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestV5Resend(t *testing.T) {
+	d := loopback.New()
+	dnet.UseDriver(d)
+	defer dnet.UseDriver(nil)
+	// the header of the packets received by the broker on every connection
+	got := make(chan []byte, 4)
+	var conns int32
+	d.Handle("broker:1883", func(conn stdnet.Conn) {
+		defer conn.Close()
+		reconnected := atomic.AddInt32(&conns, 1) > 1
+		rawRead(conn)
+		var flags byte
+		if reconnected {
+			flags = 1 // session present
+		}
+		rawWrite(conn, 0x20, appendProperties([]byte{flags, 0}, nil))
+		var headers []byte
+		for {
+			if !reconnected {
+				conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			}
+			h, b, err := rawRead(conn)
+			if err != nil {
+				got <- headers
+				return
+			}
+			headers = append(headers, h)
+			if reconnected && len(headers) == 1 {
+				// acknowledge the message sent again after reconnecting
+				rawWrite(conn, 0x40, b[3:5])
+				got <- headers
+			}
+		}
+	})
+
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").SetProtocolVersion(5).
+		SetRetryInterval(100 * time.Millisecond).SetAutoReconnect(true).
+		SetConnectRetryInterval(10 * time.Millisecond)
+	opts.Adaptor = d
+	c := NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	defer c.Disconnect(0)
+	tok := c.Publish("a", 1, false, "x")
+
+	// the PUBLISH is not sent again on the same connection, even after
+	// several retry intervals
+	if headers := <-got; len(headers) != 1 || headers[0] != 0x32 {
+		t.Fatalf("first connection got % x, want a single PUBLISH", headers)
+	}
+
+	// it is sent again with the DUP flag when the client reconnects
+	select {
+	case headers := <-got:
+		if len(headers) != 1 || headers[0] != 0x3A {
+			t.Fatalf("second connection got % x, want a PUBLISH with DUP", headers)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not reconnected")
+	}
+	if !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatal("token not completed by the PUBACK", tok.Error())
+	}
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	c := newCodec5(0, 0)
+	if c.maxPacketSize != DefaultMaxPacketSize {
+		t.Fatal(c.maxPacketSize)
+	}
+
+	// the declared length is checked before the buffer is allocated
+	if _, err := c.read(bytes.NewReader([]byte{0x30, 0xFF, 0xFF, 0xFF, 0x7F})); err != ErrPacketTooLarge {
+		t.Fatal(err)
+	}
+	if cap(c.rbuf) != 0 {
+		t.Fatal(cap(c.rbuf))
+	}
+
+	// the size includes the fixed header
+	c = newCodec5(0, 10)
+	ack := []byte{0x40, 8, 0, 1, 0x10, 4, 0x1F, 0, 1, 'x'}
+	if in, err := c.read(bytes.NewReader(ack)); err != nil || in.packet.Details().MessageID != 1 {
+		t.Fatal(in, err)
+	}
+	ack = []byte{0x40, 9, 0, 1, 0x10, 5, 0x1F, 0, 2, 'x', 'y'}
+	if _, err := c.read(bytes.NewReader(ack)); err != ErrPacketTooLarge {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,240 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// ReadWriterAt is the storage used by EEPROMStore, such as an at24cx.Device.
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Layout of a slot of an EEPROMStore: a used marker, the key as a prefix
+// byte and a message id, the sequence number of the first put of the key
+// giving the order of the keys, the sequence number of the write, the length
+// of the packet and the packet in wire format.
+const (
+	slotUsed       = 0xA5
+	slotHeaderSize = 14
+)
+
+// EEPROMStore implements the Store interface on top of an io.ReaderAt and
+// io.WriterAt, such as an EEPROM, so that the session of the client survives
+// a reboot. The storage is split in slots of a fixed size, every message uses
+// one slot. Messages that do not fit in a slot, or that are put when all the
+// slots are used, are not persisted.
+type EEPROMStore struct {
+	mu       sync.Mutex
+	rw       ReadWriterAt
+	offset   int64
+	slotSize int
+	keys     []string // key of every slot, "" when free
+	orders   []uint32 // sequence number of the first put of the key
+	seqs     []uint32 // sequence number of the write of the slot
+	seq      uint32
+}
+
+// NewEEPROMStore returns a store using size bytes of rw starting at offset,
+// in slots of slotSize bytes. A slot must hold the whole packet plus 14
+// bytes, for example 128 bytes slots are enough for PUBLISH packets with a
+// topic and a payload of about 100 bytes. The store is not ready to use until
+// Open() has been called on it.
+func NewEEPROMStore(rw ReadWriterAt, offset, size int64, slotSize int) *EEPROMStore {
+	n := int(size / int64(slotSize))
+	return &EEPROMStore{
+		rw:       rw,
+		offset:   offset,
+		slotSize: slotSize,
+		keys:     make([]string, n),
+		orders:   make([]uint32, n),
+		seqs:     make([]uint32, n),
+	}
+}
+
+func (s *EEPROMStore) slotOffset(i int) int64 {
+	return s.offset + int64(i)*int64(s.slotSize)
+}
+
+// Open reads the keys stored in the slots. Slots that cannot be read are
+// considered free. When a key is in two slots, because the power failed
+// before Put freed the previous slot, the last written one is kept and the
+// other one is freed.
+func (s *EEPROMStore) Open() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var header [slotHeaderSize]byte
+	s.seq = 0
+	for i := range s.keys {
+		s.keys[i] = ""
+		if _, err := s.rw.ReadAt(header[:], s.slotOffset(i)); err != nil || header[0] != slotUsed {
+			continue
+		}
+		id := binary.BigEndian.Uint16(header[2:])
+		switch header[1] {
+		case 'i':
+			s.keys[i] = inboundKey(id)
+		case 'o':
+			s.keys[i] = outboundKey(id)
+		default:
+			continue
+		}
+		s.orders[i] = binary.BigEndian.Uint32(header[4:])
+		s.seqs[i] = binary.BigEndian.Uint32(header[8:])
+		if s.seqs[i] > s.seq {
+			s.seq = s.seqs[i]
+		}
+		if j := s.find(s.keys[i]); j < i {
+			if s.seqs[j] > s.seqs[i] {
+				j = i
+			}
+			s.free(j)
+		}
+	}
+}
+
+// find returns the slot of key, or of the first free slot when key is "".
+func (s *EEPROMStore) find(key string) int {
+	for i, k := range s.keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Put writes the message in a free slot, and then frees the previous slot of
+// the key, so that a copy survives if the power fails while writing. The
+// message replaces the previous one in place when there is no free slot.
+func (s *EEPROMStore) Put(key string, message packets.ControlPacket) {
+	prefix, id, ok := parseKey(key)
+	if !ok {
+		return
+	}
+
+	buf := bytes.NewBuffer(make([]byte, slotHeaderSize, s.slotSize))
+	if err := message.Write(buf); err != nil || buf.Len() > s.slotSize {
+		return
+	}
+	data := buf.Bytes()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.find(key)
+	i := s.find("")
+	if i < 0 {
+		i = old
+	}
+	if i < 0 {
+		return
+	}
+	seq := s.seq + 1
+	order := seq
+	if old >= 0 {
+		order = s.orders[old]
+	}
+
+	// the slot is marked as used once completely written
+	data[0] = 0
+	data[1] = prefix[0]
+	binary.BigEndian.PutUint16(data[2:], id)
+	binary.BigEndian.PutUint32(data[4:], order)
+	binary.BigEndian.PutUint32(data[8:], seq)
+	binary.BigEndian.PutUint16(data[12:], uint16(len(data)-slotHeaderSize))
+	if _, err := s.rw.WriteAt(data, s.slotOffset(i)); err != nil {
+		return
+	}
+	if _, err := s.rw.WriteAt([]byte{slotUsed}, s.slotOffset(i)); err != nil {
+		return
+	}
+	if old >= 0 && old != i {
+		s.free(old)
+	}
+	s.keys[i] = key
+	s.orders[i] = order
+	s.seqs[i] = seq
+	s.seq = seq
+}
+
+// Get reads the message of key, or returns nil if it is not in the store.
+func (s *EEPROMStore) Get(key string) packets.ControlPacket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(key)
+	if key == "" || i < 0 {
+		return nil
+	}
+	data := make([]byte, s.slotSize)
+	if _, err := s.rw.ReadAt(data, s.slotOffset(i)); err != nil {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(data[12:]))
+	if slotHeaderSize+n > len(data) {
+		return nil
+	}
+	p, err := packets.ReadPacket(bytes.NewReader(data[slotHeaderSize : slotHeaderSize+n]))
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// All returns the keys of the store, in the order they were first put.
+func (s *EEPROMStore) All() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var used []int
+	for i, k := range s.keys {
+		if k != "" {
+			used = append(used, i)
+		}
+	}
+	sort.Slice(used, func(a, b int) bool {
+		return s.orders[used[a]] < s.orders[used[b]]
+	})
+	keys := make([]string, len(used))
+	for j, i := range used {
+		keys[j] = s.keys[i]
+	}
+	return keys
+}
+
+// Del frees the slot of key.
+func (s *EEPROMStore) Del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(key); key != "" && i >= 0 {
+		s.free(i)
+	}
+}
+
+// free marks slot i as free. s.mu must be held.
+func (s *EEPROMStore) free(i int) {
+	s.rw.WriteAt([]byte{0}, s.slotOffset(i))
+	s.keys[i] = ""
+}
+
+// Close does nothing, every change is written when it is made.
+func (s *EEPROMStore) Close() {}
+
+// Reset frees all the slots of the store.
+func (s *EEPROMStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, k := range s.keys {
+		if k != "" {
+			s.free(i)
+		}
+	}
+	s.seq = 0
+}
@@ -1,10 +1,17 @@
 // Package mqtt is intended to provide compatible interfaces with the
 // Paho mqtt library.
+//
+// Unlike Paho, the options returned by NewClientOptions leave AutoReconnect
+// disabled, as in the earlier versions of this package: a lost connection
+// stays closed. Call SetAutoReconnect(true) to open it again.
 package mqtt
 
 import (
 	"errors"
+	"io"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
@@ -17,8 +24,23 @@ import (
 // on it before it may be used. This is to make sure resources (such as a net
 // connection) are created before the application is actually ready.
 func NewClient(o *ClientOptions) Client {
-	c := &mqttclient{opts: o, adaptor: o.Adaptor}
+	c := &mqttclient{
+		opts:            o,
+		adaptor:         o.Adaptor,
+		mid:             1,
+		inbound:         make(chan incoming, 10),
+		incomingPubChan: make(chan incoming, 10),
+		inflight:        make(map[uint16]*inflight),
+		received:        make(map[uint16]bool),
+		subs:            make(map[string]byte),
+		store:           o.Store,
+	}
+	if c.store == nil {
+		c.store = NewMemoryStore()
+	}
 	c.msgRouter, c.stopRouter = newRouter()
+	c.msgRouter.setDefaultHandler(o.DefaultPublishHandler)
+	c.msgRouter.matchAndDispatch(c.incomingPubChan, c.opts.Order, c)
 	return c
 }
 
@@ -28,59 +50,118 @@ type mqttclient struct {
 	connected       bool
 	opts            *ClientOptions
 	mid             uint16
-	inbound         chan packets.ControlPacket
+	inbound         chan incoming
 	stop            chan struct{}
 	msgRouter       *router
 	stopRouter      chan bool
-	incomingPubChan chan *packets.PublishPacket
+	incomingPubChan chan incoming
+
+	// writeMu serializes the packets written to conn, with the MQTT 5 codec
+	// of the connection.
+	writeMu   sync.Mutex
+	lastSent  time.Time
+	codec     *codec5
+	keepAlive time.Duration
+
+	// first byte of the packet being read, only used by readMessages
+	first prefixReader
+
+	// mu guards the connection state, the message ids, the in-flight
+	// messages, the store and the subscriptions.
+	mu       sync.Mutex
+	closed   bool
+	opened   bool
+	store    Store
+	inflight map[uint16]*inflight
+	received map[uint16]bool
+	subs     map[string]byte
+	queue    []queued
+	flushing bool
+}
+
+// inflight is a message sent to the broker that has not been acknowledged
+// yet: a PUBLISH waiting for its PUBACK or PUBREC, a PUBREL waiting for its
+// PUBCOMP, or a SUBSCRIBE or UNSUBSCRIBE waiting for its ack.
+type inflight struct {
+	packet packets.ControlPacket
+	props  *Properties
+	token  *mqtttoken
+	sent   time.Time
 }
 
 // AddRoute allows you to add a handler for messages on a specific topic
 // without making a subscription. For example having a different handler
 // for parts of a wildcard subscription
 func (c *mqttclient) AddRoute(topic string, callback MessageHandler) {
-	return
+	if callback != nil {
+		c.msgRouter.addRoute(topic, callback)
+	}
 }
 
 // IsConnected returns a bool signifying whether
 // the client is connected or not.
 func (c *mqttclient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.connected
 }
 
 // IsConnectionOpen return a bool signifying whether the client has an active
 // connection to mqtt broker, i.e not in disconnected or reconnect mode
 func (c *mqttclient) IsConnectionOpen() bool {
-	return c.connected
+	return c.IsConnected()
 }
 
-// Connect will create a connection to the message broker.
+// Connect will create a connection to the message broker. Once connected,
+// the connection is kept alive with PINGREQ packets, and is opened again when
+// it is lost if AutoReconnect is set.
 func (c *mqttclient) Connect() Token {
+	c.mu.Lock()
+	c.closed = false
+	if !c.opened {
+		c.opened = true
+		c.store.Open()
+		if c.opts.CleanSession {
+			c.store.Reset()
+		} else {
+			c.restore()
+		}
+	}
+	c.mu.Unlock()
+
+	sessionPresent, err := c.connect()
+	if err != nil {
+		return &mqtttoken{err: err}
+	}
+	c.resume(sessionPresent)
+	c.flush()
+	if c.opts.OnConnect != nil {
+		go c.opts.OnConnect(c)
+	}
+	return &mqtttoken{}
+}
+
+// connect opens the connection to the broker, and starts the goroutines
+// handling it once the broker accepted the CONNECT packet. It returns whether
+// the broker resumed the session of the client.
+func (c *mqttclient) connect() (bool, error) {
+	var conn net.Conn
 	var err error
 
 	// make connection
 	if strings.Contains(c.opts.Servers, "ssl://") {
 		url := strings.TrimPrefix(c.opts.Servers, "ssl://")
-		c.conn, err = tls.Dial("tcp", url, nil)
-		if err != nil {
-			return &mqtttoken{err: err}
-		}
+		conn, err = tls.Dial("tcp", url, c.opts.TLSConfig)
 	} else if strings.Contains(c.opts.Servers, "tcp://") {
 		url := strings.TrimPrefix(c.opts.Servers, "tcp://")
-		c.conn, err = net.Dial("tcp", url)
-		if err != nil {
-			return &mqtttoken{err: err}
-		}
+		conn, err = net.Dial("tcp", url)
 	} else {
 		// invalid protocol
-		return &mqtttoken{err: errors.New("invalid protocol")}
+		err = errors.New("invalid protocol")
+	}
+	if err != nil {
+		return false, err
 	}
-
-	c.mid = 1
-	c.inbound = make(chan packets.ControlPacket, 10)
-	c.stop = make(chan struct{})
-	c.incomingPubChan = make(chan *packets.PublishPacket, 10)
-	c.msgRouter.matchAndDispatch(c.incomingPubChan, c.opts.Order, c)
 
 	// send the MQTT connect message
 	connectPkt := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
@@ -98,53 +179,288 @@ func (c *mqttclient) Connect() Token {
 	connectPkt.ClientIdentifier = c.opts.ClientID
 	connectPkt.ProtocolVersion = byte(c.opts.ProtocolVersion)
 	connectPkt.ProtocolName = "MQTT"
-	connectPkt.Keepalive = 60
-
-	err = connectPkt.Write(c.conn)
+	connectPkt.CleanSession = c.opts.CleanSession
+	connectPkt.Keepalive = uint16(c.opts.KeepAlive)
+
+	var codec *codec5
+	if c.opts.ProtocolVersion == 5 {
+		codec = newCodec5(c.opts.TopicAliasMaximum, c.opts.MaxPacketSize)
+		err = codec.write(conn, connectPkt, &Properties{
+			SessionExpiry:     c.opts.SessionExpiryInterval,
+			TopicAliasMaximum: c.opts.TopicAliasMaximum,
+			MaximumPacketSize: codec.maxPacketSize,
+		})
+	} else {
+		err = connectPkt.Write(conn)
+	}
 	if err != nil {
-		return &mqtttoken{err: err}
+		conn.Close()
+		return false, err
 	}
 
-	// TODO: handle timeout as ReadPacket blocks until it gets a packet.
-	// CONNECT response.
-	packet, err := packets.ReadPacket(c.conn)
+	// CONNECT response, give up after ConnectTimeout.
+	if c.opts.ConnectTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(c.opts.ConnectTimeout))
+	}
+	var in incoming
+	if codec != nil {
+		in, err = codec.read(conn)
+	} else {
+		in.packet, err = packets.ReadPacket(conn)
+	}
+	conn.SetReadDeadline(time.Time{})
 	if err != nil {
-		return &mqtttoken{err: err}
+		conn.Close()
+		return false, err
+	}
+	ack, ok := in.packet.(*packets.ConnackPacket)
+	if !ok {
+		conn.Close()
+		return false, errors.New("CONNACK expected")
+	}
+	if ack.ReturnCode != 0 {
+		conn.Close()
+		if codec != nil {
+			return false, reasonError(ack.ReturnCode, in.props)
+		}
+		return false, errors.New(ack.String())
+	}
+
+	keepAlive := time.Duration(c.opts.KeepAlive) * time.Second
+	if in.props != nil {
+		if in.props.ServerKeepAlive != 0 {
+			keepAlive = time.Duration(in.props.ServerKeepAlive) * time.Second
+		}
+		if in.props.AssignedClientID != "" {
+			// resume the same session on reconnect
+			c.opts.ClientID = in.props.AssignedClientID
+		}
+		codec.setAliasMaximum(in.props.TopicAliasMaximum)
+	}
+
+	stop := make(chan struct{})
+	c.writeMu.Lock()
+	c.mu.Lock()
+	closed := c.closed
+	if !closed {
+		c.conn = conn
+		c.codec = codec
+		c.keepAlive = keepAlive
+		c.lastSent = time.Now()
+		c.connected = true
+		c.stop = stop
+	}
+	c.mu.Unlock()
+	c.writeMu.Unlock()
+	if closed {
+		// Disconnect was called while connecting
+		conn.Close()
+		return false, errors.New("MQTT client disconnected")
+	}
+
+	go readMessages(c, stop)
+	go processInbound(c, stop)
+
+	return ack.SessionPresent, nil
+}
+
+// restore loads the session kept in the store, after a reboot: the messages
+// in flight are sent again once connected, and the message ids continue after
+// the largest one stored. c.mu must be held.
+func (c *mqttclient) restore() {
+	var last uint16
+	for i, key := range c.store.All() {
+		prefix, id, ok := parseKey(key)
+		if !ok {
+			continue
+		}
+		if prefix == inboundPrefix {
+			c.received[id] = true
+			continue
+		}
+		p := c.store.Get(key)
+		if !persisted(p) {
+			c.store.Del(key)
+			continue
+		}
+		// keep the order of the store, resume sorts by sent time
+		c.inflight[id] = &inflight{packet: p, token: newToken(), sent: time.Time{}.Add(time.Duration(i))}
+		if id > last {
+			last = id
+		}
+	}
+	// the keys are not in the order of the ids, the next id follows the
+	// largest one in flight
+	if last != 0 {
+		c.mid = last + 1
+	}
+}
+
+// persisted reports if an outgoing packet is kept in the store: only the
+// PUBLISH and PUBREL packets are sent again in a new session, the
+// subscriptions are sent again from memory.
+func persisted(p packets.ControlPacket) bool {
+	switch p.(type) {
+	case *packets.PublishPacket, *packets.PubrelPacket:
+		return true
+	}
+	return false
+}
+
+// connectionLost closes a connection that failed, and opens it again if
+// AutoReconnect is set. It does nothing if the connection was already closed
+// by Disconnect.
+func (c *mqttclient) connectionLost(stop chan struct{}, err error) {
+	c.mu.Lock()
+	if !c.connected || c.stop != stop {
+		c.mu.Unlock()
+		return
+	}
+	c.connected = false
+	close(stop)
+	conn := c.conn
+	c.mu.Unlock()
+
+	conn.Close()
+	if c.opts.OnConnectionLost != nil {
+		go c.opts.OnConnectionLost(c, err)
 	}
-	if packet != nil {
-		ack, ok := packet.(*packets.ConnackPacket)
-		if ok {
-			if ack.ReturnCode != 0 {
-				return &mqtttoken{err: errors.New(packet.String())}
+	if c.opts.AutoReconnect {
+		c.reconnect()
+	}
+}
+
+// reconnect connects to the broker until it succeeds or Disconnect is
+// called. It waits ConnectRetryInterval after the first failed attempt, then
+// doubles the wait after every attempt up to MaxReconnectInterval.
+func (c *mqttclient) reconnect() {
+	delay := c.opts.ConnectRetryInterval
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+
+		sessionPresent, err := c.connect()
+		if err == nil {
+			c.resume(sessionPresent)
+			c.flush()
+			if c.opts.OnConnect != nil {
+				go c.opts.OnConnect(c)
 			}
-			c.connected = true
+			return
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+		if c.opts.MaxReconnectInterval > 0 && delay > c.opts.MaxReconnectInterval {
+			delay = c.opts.MaxReconnectInterval
 		}
 	}
+}
 
-	go readMessages(c)
-	go processInbound(c)
+// resume sends again the in-flight messages after a reconnect, in the order
+// they were first sent, and subscribes again to the active topics unless the
+// broker kept the session.
+func (c *mqttclient) resume(sessionPresent bool) {
+	c.mu.Lock()
+	pending := make([]*inflight, 0, len(c.inflight))
+	for _, m := range c.inflight {
+		pending = append(pending, m)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].sent.Before(pending[j].sent)
+	})
+	now := time.Now()
+	for _, m := range pending {
+		if p, ok := m.packet.(*packets.PublishPacket); ok {
+			p.Dup = true
+		}
+		m.sent = now
+	}
 
-	return &mqtttoken{}
+	var sub *packets.SubscribePacket
+	if !sessionPresent && len(c.subs) > 0 {
+		sub = packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+		for topic, qos := range c.subs {
+			sub.Topics = append(sub.Topics, topic)
+			sub.Qoss = append(sub.Qoss, qos)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, m := range pending {
+		if err := c.writeProps(m.packet, m.props); err != nil {
+			return
+		}
+	}
+	if sub != nil {
+		c.send(sub, nil)
+	}
 }
 
 // Disconnect will end the connection with the server, but not before waiting
 // the specified number of milliseconds to wait for existing work to be
 // completed.
 func (c *mqttclient) Disconnect(quiesce uint) {
-	c.conn.Close()
-	return
+	c.mu.Lock()
+	c.closed = true
+	c.drop(ErrQueueClosed)
+	connected := c.connected
+	c.mu.Unlock()
+	if !connected {
+		return
+	}
+
+	// wait for the in-flight messages to be acknowledged
+	end := time.Now().Add(time.Duration(quiesce) * time.Millisecond)
+	for time.Now().Before(end) {
+		c.mu.Lock()
+		n := len(c.inflight)
+		c.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.write(packets.NewControlPacket(packets.Disconnect))
+
+	c.mu.Lock()
+	connected = c.connected
+	if connected {
+		c.connected = false
+		close(c.stop)
+	}
+	conn := c.conn
+	c.mu.Unlock()
+	if connected {
+		conn.Close()
+	}
 }
 
 // Publish will publish a message with the specified QoS and content
 // to the specified topic.
-// Returns a token to track delivery of the message to the broker
+// Returns a token to track delivery of the message to the broker. For QoS 1
+// and 2 the token completes once the broker acknowledged the message, which
+// is sent again with the DUP flag every RetryInterval until then. With an
+// OfflineQueueDepth, messages published while the client is not connected are
+// queued, and sent once it is connected again.
 func (c *mqttclient) Publish(topic string, qos byte, retained bool, payload interface{}) Token {
-	if !c.IsConnected() {
-		return &mqtttoken{err: errors.New("MQTT client not connected")}
-	}
+	return c.PublishWithProperties(topic, qos, retained, payload, nil)
+}
 
+// PublishWithProperties publishes a message like Publish, with MQTT 5
+// properties such as a message expiry, a response topic or user properties.
+// The properties are ignored with MQTT 3.1.1, and are not kept in the Store.
+func (c *mqttclient) PublishWithProperties(topic string, qos byte, retained bool, payload interface{}, properties *Properties) Token {
 	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
 	pub.Qos = qos
+	pub.Retain = retained
 	pub.TopicName = topic
 	switch payload.(type) {
 	case string:
@@ -154,19 +470,39 @@ func (c *mqttclient) Publish(topic string, qos byte, retained bool, payload inte
 	default:
 		return &mqtttoken{err: errors.New("Unknown payload type")}
 	}
-	pub.MessageID = c.mid
-	c.mid++
 
-	err := pub.Write(c.conn)
-	if err != nil {
+	token := newToken()
+	if c.enqueue(pub, properties, token, false) {
+		return token
+	}
+	if !c.IsConnected() {
+		return &mqtttoken{err: errors.New("MQTT client not connected")}
+	}
+	if err := c.publish(pub, properties, token); err != nil {
+		if c.enqueue(pub, properties, token, true) {
+			return token
+		}
 		return &mqtttoken{err: err}
 	}
+	return token
+}
 
-	return &mqtttoken{}
+// publish writes a PUBLISH packet. The token of a QoS 0 message completes
+// once written.
+func (c *mqttclient) publish(pub *packets.PublishPacket, props *Properties, token *mqtttoken) error {
+	if pub.Qos > 0 {
+		return c.transmit(pub, props, token)
+	}
+	if err := c.writeProps(pub, props); err != nil {
+		return err
+	}
+	token.complete(nil)
+	return nil
 }
 
 // Subscribe starts a new subscription. Provide a MessageHandler to be executed when
-// a message is published on the topic provided.
+// a message is published on the topic provided. The token completes when the
+// broker acknowledged the subscription.
 func (c *mqttclient) Subscribe(topic string, qos byte, callback MessageHandler) Token {
 	if !c.IsConnected() {
 		return &mqtttoken{err: errors.New("MQTT client not connected")}
@@ -180,29 +516,73 @@ func (c *mqttclient) Subscribe(topic string, qos byte, callback MessageHandler)
 		c.msgRouter.addRoute(topic, callback)
 	}
 
-	sub.MessageID = c.mid
-	c.mid++
+	// subscribe again after a reconnect
+	c.mu.Lock()
+	c.subs[topic] = qos
+	c.mu.Unlock()
 
-	// drop in the channel to send
-	err := sub.Write(c.conn)
-	if err != nil {
-		return &mqtttoken{err: err}
-	}
-
-	return &mqtttoken{}
+	return c.send(sub, nil)
 }
 
 // SubscribeMultiple starts a new subscription for multiple topics. Provide a MessageHandler to
-// be executed when a message is published on one of the topics provided.
+// be executed when a message is published on one of the topics provided. The
+// topics are sent in a single SUBSCRIBE, in sorted order, and the token
+// completes when the broker acknowledged it.
 func (c *mqttclient) SubscribeMultiple(filters map[string]byte, callback MessageHandler) Token {
-	return &mqtttoken{}
+	if !c.IsConnected() {
+		return &mqtttoken{err: errors.New("MQTT client not connected")}
+	}
+	if len(filters) == 0 {
+		return &mqtttoken{err: errors.New("MQTT no topic to subscribe to")}
+	}
+
+	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	for topic := range filters {
+		sub.Topics = append(sub.Topics, topic)
+	}
+	sort.Strings(sub.Topics)
+	for _, topic := range sub.Topics {
+		sub.Qoss = append(sub.Qoss, filters[topic])
+	}
+
+	if callback != nil {
+		for _, topic := range sub.Topics {
+			c.msgRouter.addRoute(topic, callback)
+		}
+	}
+
+	// subscribe again after a reconnect
+	c.mu.Lock()
+	for topic, qos := range filters {
+		c.subs[topic] = qos
+	}
+	c.mu.Unlock()
+
+	return c.send(sub, nil)
 }
 
 // Unsubscribe will end the subscription from each of the topics provided.
 // Messages published to those topics from other clients will no longer be
-// received.
+// received. The routes of the topics are removed, and the token completes
+// when the broker acknowledged the UNSUBSCRIBE.
 func (c *mqttclient) Unsubscribe(topics ...string) Token {
-	return &mqtttoken{}
+	if !c.IsConnected() {
+		return &mqtttoken{err: errors.New("MQTT client not connected")}
+	}
+
+	unsub := packets.NewControlPacket(packets.Unsubscribe).(*packets.UnsubscribePacket)
+	unsub.Topics = topics
+
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.subs, topic)
+	}
+	c.mu.Unlock()
+	for _, topic := range topics {
+		c.msgRouter.deleteRoute(topic)
+	}
+
+	return c.send(unsub, nil)
 }
 
 // OptionsReader returns a ClientOptionsReader which is a copy of the clientoptions
@@ -212,30 +592,237 @@ func (c *mqttclient) OptionsReader() ClientOptionsReader {
 	return r
 }
 
-func processInbound(c *mqttclient) {
+// send writes a packet that expects an ack from the broker, and returns the
+// token completed by the ack.
+func (c *mqttclient) send(p packets.ControlPacket, props *Properties) Token {
+	token := newToken()
+	if err := c.transmit(p, props, token); err != nil {
+		return &mqtttoken{err: err}
+	}
+	return token
+}
+
+// transmit writes a packet that expects an ack with a new message id, and
+// keeps it in flight until the ack completes token.
+func (c *mqttclient) transmit(p packets.ControlPacket, props *Properties, token *mqtttoken) error {
+	c.mu.Lock()
+	id, err := c.nextID()
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	switch p := p.(type) {
+	case *packets.PublishPacket:
+		p.MessageID = id
+	case *packets.SubscribePacket:
+		p.MessageID = id
+	case *packets.UnsubscribePacket:
+		p.MessageID = id
+	}
+	c.inflight[id] = &inflight{packet: p, props: props, token: token, sent: time.Now()}
+	if persisted(p) {
+		c.store.Put(outboundKey(id), p)
+	}
+	c.mu.Unlock()
+
+	if err := c.writeProps(p, props); err != nil {
+		c.mu.Lock()
+		delete(c.inflight, id)
+		c.store.Del(outboundKey(id))
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// nextID returns a message id that is not in flight. c.mu must be held.
+func (c *mqttclient) nextID() (uint16, error) {
+	for i := 0; i < 0xFFFF; i++ {
+		if c.mid == 0 {
+			c.mid = 1
+		}
+		id := c.mid
+		c.mid++
+		if _, used := c.inflight[id]; !used {
+			return id, nil
+		}
+	}
+	return 0, errors.New("no message ID available")
+}
+
+// write writes a packet to the connection. It is safe to call from any
+// goroutine.
+func (c *mqttclient) write(p packets.ControlPacket) error {
+	return c.writeProps(p, nil)
+}
+
+// writeProps writes a packet with its MQTT 5 properties.
+func (c *mqttclient) writeProps(p packets.ControlPacket, props *Properties) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	var err error
+	if c.codec != nil {
+		err = c.codec.write(c.conn, p, props)
+	} else {
+		err = p.Write(c.conn)
+	}
+	if err != nil {
+		return err
+	}
+	c.lastSent = time.Now()
+	return nil
+}
+
+// ping sends a PINGREQ when nothing has been sent or received for the
+// keep alive interval, and fails when the PINGRESP, or any other packet, has
+// not been received within PingTimeout.
+func (c *mqttclient) ping(lastReceived time.Time, pingSent *time.Time) error {
+	c.writeMu.Lock()
+	lastSent, interval := c.lastSent, c.keepAlive
+	c.writeMu.Unlock()
+	if interval <= 0 {
+		return nil
+	}
+	now := time.Now()
+	if !pingSent.IsZero() {
+		if c.opts.PingTimeout > 0 && now.Sub(*pingSent) > c.opts.PingTimeout {
+			return errors.New("pingresp not received, disconnecting")
+		}
+		return nil
+	}
+
+	if now.Sub(lastSent) < interval && now.Sub(lastReceived) < interval {
+		return nil
+	}
+	*pingSent = now
+	return c.write(packets.NewControlPacket(packets.Pingreq))
+}
+
+// acknowledge removes the in-flight message id, and completes its token.
+func (c *mqttclient) acknowledge(id uint16, err error) {
+	c.mu.Lock()
+	m, ok := c.inflight[id]
+	delete(c.inflight, id)
+	if ok {
+		c.store.Del(outboundKey(id))
+	}
+	c.mu.Unlock()
+	if ok {
+		m.token.complete(err)
+	}
+}
+
+// release answers the PUBREC of a QoS 2 message with a PUBREL, which stays in
+// flight until the PUBCOMP.
+func (c *mqttclient) release(id uint16) {
+	pr := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+	pr.MessageID = id
+
+	c.mu.Lock()
+	if m, ok := c.inflight[id]; ok {
+		m.packet = pr
+		m.sent = time.Now()
+		c.store.Put(outboundKey(id), pr)
+	}
+	c.mu.Unlock()
+	c.write(pr)
+}
+
+// resend writes again the PUBLISH and PUBREL packets that have not been
+// acknowledged within RetryInterval. PUBLISH packets are sent with the DUP
+// flag set. MQTT 5 only allows to send them again when reconnecting
+// (MQTT-4.4.0-1), so nothing is sent with the MQTT 5 codec.
+func (c *mqttclient) resend() {
+	if c.opts.RetryInterval <= 0 {
+		return
+	}
+	now := time.Now()
+	var due []inflight
+
+	c.mu.Lock()
+	if c.codec != nil {
+		c.mu.Unlock()
+		return
+	}
+	for _, m := range c.inflight {
+		if now.Sub(m.sent) < c.opts.RetryInterval {
+			continue
+		}
+		switch p := m.packet.(type) {
+		case *packets.PublishPacket:
+			p.Dup = true
+		case *packets.PubrelPacket:
+		default:
+			continue
+		}
+		m.sent = now
+		due = append(due, *m)
+	}
+	c.mu.Unlock()
+
+	for _, m := range due {
+		if err := c.writeProps(m.packet, m.props); err != nil {
+			return
+		}
+	}
+}
+
+func processInbound(c *mqttclient, stop chan struct{}) {
 	for {
 		select {
-		case msg := <-c.inbound:
-			switch m := msg.(type) {
+		case in := <-c.inbound:
+			switch m := in.packet.(type) {
 			case *packets.PingrespPacket:
-				// TODO: handle this
+				// handled by the keepalive of readMessages
 			case *packets.SubackPacket:
-				// TODO: handle this
+				var err error
+				for _, code := range m.ReturnCodes {
+					if code >= 0x80 {
+						err = reasonError(code, in.props)
+					}
+				}
+				c.acknowledge(m.MessageID, err)
 			case *packets.UnsubackPacket:
-				// TODO: handle this
+				c.acknowledge(m.MessageID, reasonError(in.reason, in.props))
 			case *packets.PublishPacket:
-				// TODO: handle Qos
-				c.incomingPubChan <- m
+				if m.Qos == 2 {
+					// deliver a QoS 2 message only once, until it is released
+					c.mu.Lock()
+					dup := c.received[m.MessageID]
+					if !dup {
+						c.received[m.MessageID] = true
+						rec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+						rec.MessageID = m.MessageID
+						c.store.Put(inboundKey(m.MessageID), rec)
+					}
+					c.mu.Unlock()
+					if dup {
+						c.ackFunc(m)()
+						continue
+					}
+				}
+				c.incomingPubChan <- in
 			case *packets.PubackPacket:
-				// TODO: handle this
+				c.acknowledge(m.MessageID, reasonError(in.reason, in.props))
 			case *packets.PubrecPacket:
-				// TODO: handle this
+				if err := reasonError(in.reason, in.props); err != nil {
+					// the broker refused the message, there is no PUBREL
+					c.acknowledge(m.MessageID, err)
+					continue
+				}
+				c.release(m.MessageID)
 			case *packets.PubrelPacket:
-				// TODO: handle this
+				c.mu.Lock()
+				delete(c.received, m.MessageID)
+				c.store.Del(inboundKey(m.MessageID))
+				c.mu.Unlock()
+				pc := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+				pc.MessageID = m.MessageID
+				c.write(pc)
 			case *packets.PubcompPacket:
-				// TODO: handle this
+				c.acknowledge(m.MessageID, reasonError(in.reason, in.props))
 			}
-		case <-c.stop:
+		case <-stop:
 			return
 		}
 	}
@@ -243,49 +830,64 @@ func processInbound(c *mqttclient) {
 
 // readMessages reads incoming messages off the wire.
 // incoming messages are then send into inbound channel.
-func readMessages(c *mqttclient) {
-	var err error
-	var cp packets.ControlPacket
+// It also keeps the connection alive, and retransmits the messages that have
+// not been acknowledged, until stop is closed or the connection is lost.
+func readMessages(c *mqttclient, stop chan struct{}) {
+	var pingSent time.Time
+	lastReceived := time.Now()
 
-PROCESS:
 	for {
-		if cp, err = c.ReadPacket(); err != nil {
-			break PROCESS
+		select {
+		case <-stop:
+			return
+		default:
 		}
-		if cp != nil {
-			c.inbound <- cp
-			// TODO: Notify keepalive logic that we recently received a packet
+
+		in, err := c.readIncoming()
+		if err != nil {
+			c.connectionLost(stop, err)
+			return
+		}
+		if _, ok := in.packet.(*packets.DisconnectPacket); ok {
+			// MQTT 5 brokers tell why they close the connection
+			err = reasonError(in.reason, in.props)
+			if err == nil {
+				err = errors.New("disconnected by the broker")
+			}
+			c.connectionLost(stop, err)
+			return
+		}
+		if in.packet != nil {
+			lastReceived = time.Now()
+			pingSent = time.Time{}
+			select {
+			case c.inbound <- in:
+			case <-stop:
+				return
+			}
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		if err := c.ping(lastReceived, &pingSent); err != nil {
+			c.connectionLost(stop, err)
+			return
+		}
+		c.resend()
 	}
-
-	// TODO: handle if we received an error on read.
-	// If disconnect is in progress, swallow error and return
 }
 
+// ackFunc returns the function that acknowledges a received message, once it
+// has been handled: a PUBACK for QoS 1, or a PUBREC for QoS 2.
 func (c *mqttclient) ackFunc(packet *packets.PublishPacket) func() {
 	return func() {
 		switch packet.Qos {
 		case 2:
-			// pr := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
-			// pr.MessageID = packet.MessageID
-			// DEBUG.Println(NET, "putting pubrec msg on obound")
-			// select {
-			// case c.oboundP <- &PacketAndToken{p: pr, t: nil}:
-			// case <-c.stop:
-			// }
-			// DEBUG.Println(NET, "done putting pubrec msg on obound")
+			pr := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+			pr.MessageID = packet.MessageID
+			c.write(pr)
 		case 1:
-			// pa := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
-			// pa.MessageID = packet.MessageID
-			// DEBUG.Println(NET, "putting puback msg on obound")
-			// persistOutbound(c.persist, pa)
-			// select {
-			// case c.oboundP <- &PacketAndToken{p: pa, t: nil}:
-			// case <-c.stop:
-			// }
-			// DEBUG.Println(NET, "done putting puback msg on obound")
+			pa := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+			pa.MessageID = packet.MessageID
+			c.write(pa)
 		case 0:
 			// do nothing, since there is no need to send an ack packet back
 		}
@@ -293,11 +895,52 @@ func (c *mqttclient) ackFunc(packet *packets.PublishPacket) func() {
 }
 
 // ReadPacket tries to read the next incoming packet from the MQTT broker.
-// If there is no data yet but also is no error, it returns nil for both values.
+// If no packet arrives within 100 ms, it returns nil for both values.
 func (c *mqttclient) ReadPacket() (packets.ControlPacket, error) {
-	// check for data first...
-	if net.ActiveDevice.IsSocketDataAvailable() {
-		return packets.ReadPacket(c.conn)
+	in, err := c.readIncoming()
+	return in.packet, err
+}
+
+// pollInterval is how long readIncoming waits for a packet, so that the
+// keep alive and the retransmissions are checked in between.
+const pollInterval = 100 * time.Millisecond
+
+// readIncoming reads the next packet with its MQTT 5 reason code and
+// properties, or returns a nil packet if none arrives within pollInterval.
+// Waiting for the first byte of the packet, rather than checking whether
+// data is available, also notices a connection closed by the broker.
+func (c *mqttclient) readIncoming() (incoming, error) {
+	r := &c.first
+	c.conn.SetReadDeadline(time.Now().Add(pollInterval))
+	n, err := c.conn.Read(r.b[:])
+	c.conn.SetReadDeadline(time.Time{})
+	if err == net.ErrDeadlineExceeded || n == 0 && err == nil {
+		return incoming{}, nil
+	}
+	if err != nil {
+		return incoming{}, err
+	}
+	r.n, r.r = n, c.conn
+
+	if c.codec != nil {
+		return c.codec.read(r)
+	}
+	p, err := packets.ReadPacket(r)
+	return incoming{packet: p}, err
+}
+
+// prefixReader returns the byte b before the rest of r.
+type prefixReader struct {
+	b [1]byte
+	n int
+	r io.Reader
+}
+
+func (p *prefixReader) Read(b []byte) (int, error) {
+	if p.n > 0 && len(b) > 0 {
+		b[0] = p.b[0]
+		p.n = 0
+		return 1, nil
 	}
-	return nil, nil
+	return p.r.Read(b)
 }
@@ -22,10 +22,12 @@ package mqtt
 
 import (
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/tls"
 )
 
 const (
@@ -72,6 +74,9 @@ type Client interface {
 	// to the specified topic.
 	// Returns a token to track delivery of the message to the broker
 	Publish(topic string, qos byte, retained bool, payload interface{}) Token
+	// PublishWithProperties publishes a message like Publish, with MQTT 5
+	// properties. The properties are ignored with MQTT 3.1.1.
+	PublishWithProperties(topic string, qos byte, retained bool, payload interface{}, properties *Properties) Token
 	// Subscribe starts a new subscription. Provide a MessageHandler to be executed when
 	// a message is published on the topic provided, or nil for the default handler
 	Subscribe(topic string, qos byte, callback MessageHandler) Token
@@ -105,6 +110,17 @@ type Token interface {
 // to which the client is subscribed.
 type MessageHandler func(Client, Message)
 
+// OnConnectHandler is a callback that is called when the client
+// state changes from unconnected/disconnected to connected. Both
+// at initial connection and on reconnection
+type OnConnectHandler func(Client)
+
+// ConnectionLostHandler is a callback type which can be set to be
+// executed upon an unintended disconnection from the MQTT broker.
+// Disconnects caused by calling Disconnect will not cause an
+// OnConnectionLost callback to execute.
+type ConnectionLostHandler func(Client, error)
+
 // Message defines the externals that a message implementation must support
 // these are received messages that are passed to the callbacks, not internal
 // messages
@@ -115,6 +131,8 @@ type Message interface {
 	Topic() string
 	MessageID() uint16
 	Payload() []byte
+	// Properties returns the MQTT 5 properties of the message, or nil.
+	Properties() *Properties
 	Ack()
 }
 
@@ -125,7 +143,9 @@ type message struct {
 	topic     string
 	messageID uint16
 	payload   []byte
+	props     *Properties
 	ack       func()
+	once      sync.Once
 }
 
 func (m *message) Duplicate() bool {
@@ -152,11 +172,18 @@ func (m *message) Payload() []byte {
 	return m.payload
 }
 
+func (m *message) Properties() *Properties {
+	return m.props
+}
+
+// Ack sends the acknowledgement of a QoS 1 or 2 message to the broker. It is
+// called once the handlers of the message returned, and only the first call
+// has an effect.
 func (m *message) Ack() {
-	return
+	m.once.Do(m.ack)
 }
 
-func messageFromPublish(p *packets.PublishPacket, ack func()) Message {
+func messageFromPublish(p *packets.PublishPacket, props *Properties, ack func()) Message {
 	return &message{
 		duplicate: p.Dup,
 		qos:       p.Qos,
@@ -164,6 +191,7 @@ func messageFromPublish(p *packets.PublishPacket, ack func()) Message {
 		topic:     p.TopicName,
 		messageID: p.MessageID,
 		payload:   p.Payload,
+		props:     props,
 		ack:       ack,
 	}
 }
@@ -192,25 +220,46 @@ type ClientOptions struct {
 	WillRetained            bool
 	ProtocolVersion         uint
 	protocolVersionExplicit bool
-	//TLSConfig               *tls.Config
-	KeepAlive            int64
-	PingTimeout          time.Duration
-	ConnectTimeout       time.Duration
-	MaxReconnectInterval time.Duration
-	AutoReconnect        bool
-	//Store                   Store
-	//DefaultPublishHandler   MessageHandler
-	//OnConnect               OnConnectHandler
-	//OnConnectionLost        ConnectionLostHandler
-	WriteTimeout        time.Duration
-	MessageChannelDepth uint
-	ResumeSubs          bool
+	TLSConfig               *tls.Config
+	KeepAlive               int64
+	PingTimeout             time.Duration
+	ConnectTimeout          time.Duration
+	MaxReconnectInterval    time.Duration
+	AutoReconnect           bool
+	ConnectRetryInterval    time.Duration
+	Store                   Store
+	DefaultPublishHandler   MessageHandler
+	OnConnect               OnConnectHandler
+	OnConnectionLost        ConnectionLostHandler
+	WriteTimeout            time.Duration
+	RetryInterval           time.Duration
+	MessageChannelDepth     uint
+	ResumeSubs              bool
+
+	// offline queue
+	OfflineQueueDepth int
+	OfflineDropPolicy DropPolicy
+
+	// MQTT 5 options
+	SessionExpiryInterval uint32
+	TopicAliasMaximum     uint16
+	MaxPacketSize         uint32
 	//HTTPHeaders             http.Header
 }
 
 // NewClientOptions returns a new ClientOptions struct.
 func NewClientOptions() *ClientOptions {
-	return &ClientOptions{Adaptor: net.ActiveDevice, ProtocolVersion: 4}
+	return &ClientOptions{
+		Adaptor:              net.ActiveDevice,
+		ProtocolVersion:      4,
+		KeepAlive:            60,
+		PingTimeout:          10 * time.Second,
+		ConnectTimeout:       30 * time.Second,
+		MaxReconnectInterval: 10 * time.Minute,
+		AutoReconnect:        false,
+		ConnectRetryInterval: time.Second,
+		RetryInterval:        20 * time.Second,
+	}
 }
 
 // AddBroker adds a broker URI to the list of brokers to be used. The format should be
@@ -233,6 +282,72 @@ func (o *ClientOptions) AddBroker(server string) *ClientOptions {
 	return o
 }
 
+// SetOfflineQueueDepth sets how many messages published while the client is
+// not connected are queued, to be sent in order once it is connected again.
+// Messages that cannot be written because the connection was just lost are
+// queued too. The queue is kept in memory only, the tokens of the messages
+// still queued when Disconnect is called complete with ErrQueueClosed.
+// Default 0, Publish fails when the client is not connected.
+func (o *ClientOptions) SetOfflineQueueDepth(depth int) *ClientOptions {
+	o.OfflineQueueDepth = depth
+	return o
+}
+
+// SetOfflineDropPolicy selects the message dropped when a message is
+// published while the offline queue is full. The token of the dropped message
+// completes with ErrQueueFull. Default DropNewest.
+func (o *ClientOptions) SetOfflineDropPolicy(policy DropPolicy) *ClientOptions {
+	o.OfflineDropPolicy = policy
+	return o
+}
+
+// SetProtocolVersion sets the MQTT version to be used to connect to the
+// broker: 4 for MQTT 3.1.1, the default, or 5 for MQTT 5.
+func (o *ClientOptions) SetProtocolVersion(pv uint) *ClientOptions {
+	if pv == 4 || pv == 5 {
+		o.ProtocolVersion = pv
+		o.protocolVersionExplicit = true
+	}
+	return o
+}
+
+// SetSessionExpiryInterval sets how long, in seconds, the MQTT 5 broker keeps
+// the session after the connection is closed. It must be set with
+// CleanSession false for the session to survive a reconnect. Default 0, the
+// session ends with the connection.
+func (o *ClientOptions) SetSessionExpiryInterval(seconds uint32) *ClientOptions {
+	o.SessionExpiryInterval = seconds
+	return o
+}
+
+// SetTopicAliasMaximum sets how many topic aliases the MQTT 5 broker may use
+// for the messages it sends to the client, to shorten the PUBLISH packets.
+// Each alias keeps its topic in memory for the time of the connection.
+// Default 0, no aliases. The client also uses up to 16 aliases for the topics
+// it publishes to, if the broker accepts them.
+func (o *ClientOptions) SetTopicAliasMaximum(max uint16) *ClientOptions {
+	o.TopicAliasMaximum = max
+	return o
+}
+
+// SetMaxPacketSize sets the size, in bytes, of the largest MQTT 5 packet the
+// client accepts from the broker. It is sent to the broker in the CONNECT
+// packet, so that larger messages are not sent to the client, and bounds the
+// memory used to read a packet. A larger packet fails the connection with
+// ErrPacketTooLarge. Default 0, DefaultMaxPacketSize.
+func (o *ClientOptions) SetMaxPacketSize(size uint32) *ClientOptions {
+	o.MaxPacketSize = size
+	return o
+}
+
+// SetTLSConfig will set an SSL/TLS configuration to be used when connecting
+// to an MQTT broker. Please read the official Go documentation for more
+// information.
+func (o *ClientOptions) SetTLSConfig(t *tls.Config) *ClientOptions {
+	o.TLSConfig = t
+	return o
+}
+
 // SetClientID will set the client id to be used by this client when
 // connecting to the MQTT broker. According to the MQTT v3.1 specification,
 // a client id mus be no longer than 23 characters.
@@ -257,6 +372,95 @@ func (o *ClientOptions) SetPassword(p string) *ClientOptions {
 	return o
 }
 
+// SetConnectTimeout limits how long the client will wait when trying to open a connection
+// to an MQTT server before timing out. A duration of 0 never times out.
+// Default 30 seconds.
+func (o *ClientOptions) SetConnectTimeout(t time.Duration) *ClientOptions {
+	o.ConnectTimeout = t
+	return o
+}
+
+// SetKeepAlive will set the amount of time (rounded to seconds) that the client
+// should wait before sending a PING request to the broker. This will
+// allow the client to know that a connection has not been lost with the
+// server. Default 60 seconds.
+func (o *ClientOptions) SetKeepAlive(k time.Duration) *ClientOptions {
+	o.KeepAlive = int64(k / time.Second)
+	return o
+}
+
+// SetPingTimeout will set the amount of time that the client
+// will wait after sending a PING request to the broker, before deciding
+// that the connection has been lost. Default is 10 seconds.
+func (o *ClientOptions) SetPingTimeout(k time.Duration) *ClientOptions {
+	o.PingTimeout = k
+	return o
+}
+
+// SetAutoReconnect sets whether the automatic reconnection logic should be
+// used when the connection is lost, even if disabled the ConnectionLostHandler
+// is still called. Default false.
+func (o *ClientOptions) SetAutoReconnect(a bool) *ClientOptions {
+	o.AutoReconnect = a
+	return o
+}
+
+// SetConnectRetryInterval sets the time that will be waited after the first
+// failed attempt to reconnect. The wait doubles after every failed attempt,
+// up to MaxReconnectInterval. Default 1 second.
+func (o *ClientOptions) SetConnectRetryInterval(t time.Duration) *ClientOptions {
+	o.ConnectRetryInterval = t
+	return o
+}
+
+// SetMaxReconnectInterval sets the maximum time that will be waited
+// between reconnection attempts when connection is lost. Default 10 minutes.
+func (o *ClientOptions) SetMaxReconnectInterval(t time.Duration) *ClientOptions {
+	o.MaxReconnectInterval = t
+	return o
+}
+
+// SetStore will set the implementation of the Store interface
+// used to provide message persistence in cases where QoS levels
+// QoS_ONE or QoS_TWO are used. If no store is provided, then the
+// client will use MemoryStore by default. Use an EEPROMStore with
+// CleanSession false to resend the messages in flight after a reboot.
+func (o *ClientOptions) SetStore(s Store) *ClientOptions {
+	o.Store = s
+	return o
+}
+
+// SetDefaultPublishHandler sets the MessageHandler that will be called when a
+// message is received that does not match any known subscriptions.
+func (o *ClientOptions) SetDefaultPublishHandler(defaultHandler MessageHandler) *ClientOptions {
+	o.DefaultPublishHandler = defaultHandler
+	return o
+}
+
+// SetOnConnectHandler sets the function to be called when the client is
+// connected, both at initial connection time and upon automatic reconnect.
+func (o *ClientOptions) SetOnConnectHandler(onConn OnConnectHandler) *ClientOptions {
+	o.OnConnect = onConn
+	return o
+}
+
+// SetConnectionLostHandler will set the OnConnectionLost callback to be
+// executed in the case where the client unexpectedly loses connection with
+// the MQTT broker.
+func (o *ClientOptions) SetConnectionLostHandler(onLost ConnectionLostHandler) *ClientOptions {
+	o.OnConnectionLost = onLost
+	return o
+}
+
+// SetRetryInterval sets how long the client waits for the broker to
+// acknowledge a QoS 1 or 2 message before sending it again. A duration of 0
+// never sends it again on the same connection. It is not used with MQTT 5,
+// which sends the messages again only when reconnecting. Default 20 seconds.
+func (o *ClientOptions) SetRetryInterval(t time.Duration) *ClientOptions {
+	o.RetryInterval = t
+	return o
+}
+
 // SetWill accepts a string will message to be set. When the client connects,
 // it will give this will message to the broker, which will then publish the
 // provided payload (the will) to any clients that are subscribed to the provided
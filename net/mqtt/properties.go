@@ -0,0 +1,298 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// MQTT 5 property identifiers.
+const (
+	propPayloadFormat          = 0x01
+	propMessageExpiry          = 0x02
+	propContentType            = 0x03
+	propResponseTopic          = 0x08
+	propCorrelationData        = 0x09
+	propSubscriptionIdentifier = 0x0B
+	propSessionExpiry          = 0x11
+	propAssignedClientID       = 0x12
+	propServerKeepAlive        = 0x13
+	propAuthMethod             = 0x15
+	propAuthData               = 0x16
+	propRequestProblemInfo     = 0x17
+	propWillDelay              = 0x18
+	propRequestResponseInfo    = 0x19
+	propResponseInfo           = 0x1A
+	propServerReference        = 0x1C
+	propReasonString           = 0x1F
+	propReceiveMaximum         = 0x21
+	propTopicAliasMaximum      = 0x22
+	propTopicAlias             = 0x23
+	propMaximumQoS             = 0x24
+	propRetainAvailable        = 0x25
+	propUserProperty           = 0x26
+	propMaximumPacketSize      = 0x27
+	propWildcardSubAvailable   = 0x28
+	propSubIDAvailable         = 0x29
+	propSharedSubAvailable     = 0x2A
+)
+
+var errMalformed = errors.New("mqtt: malformed packet")
+
+// Properties are the MQTT 5 properties of a packet. Zero values are not
+// sent. Only the properties useful to the client are kept when decoding a
+// packet, the others are skipped.
+type Properties struct {
+	// PUBLISH properties
+	PayloadFormat          byte   // 1 if the payload is UTF-8 text
+	MessageExpiry          uint32 // seconds
+	ContentType            string
+	ResponseTopic          string
+	CorrelationData        []byte
+	SubscriptionIdentifier uint32
+
+	// CONNACK properties
+	SessionExpiry     uint32 // seconds
+	AssignedClientID  string
+	ServerKeepAlive   uint16 // seconds
+	ReceiveMaximum    uint16
+	TopicAliasMaximum uint16
+	MaximumPacketSize uint32 // also sent in the CONNECT
+
+	// set by the client on PUBLISH packets, it should not be set by users
+	TopicAlias uint16
+
+	// ReasonString describes the reason code of an ack or a DISCONNECT.
+	ReasonString string
+
+	UserProperties []UserProperty
+}
+
+// UserProperty is a name and value pair sent with a packet.
+type UserProperty struct {
+	Key, Value string
+}
+
+// Get returns the value of the first user property with the given key, or ""
+// if there is none.
+func (p *Properties) Get(key string) string {
+	if p == nil {
+		return ""
+	}
+	for _, u := range p.UserProperties {
+		if u.Key == key {
+			return u.Value
+		}
+	}
+	return ""
+}
+
+// Add appends a user property.
+func (p *Properties) Add(key, value string) *Properties {
+	p.UserProperties = append(p.UserProperties, UserProperty{key, value})
+	return p
+}
+
+// appendProperties appends the property length and the properties of p to
+// b. A nil p is encoded as an empty property list.
+func appendProperties(b []byte, p *Properties) []byte {
+	start := len(b)
+	if p != nil {
+		if p.PayloadFormat != 0 {
+			b = append(b, propPayloadFormat, p.PayloadFormat)
+		}
+		if p.MessageExpiry != 0 {
+			b = appendUint32(append(b, propMessageExpiry), p.MessageExpiry)
+		}
+		if p.ContentType != "" {
+			b = appendString(append(b, propContentType), p.ContentType)
+		}
+		if p.ResponseTopic != "" {
+			b = appendString(append(b, propResponseTopic), p.ResponseTopic)
+		}
+		if p.CorrelationData != nil {
+			b = appendBinary(append(b, propCorrelationData), p.CorrelationData)
+		}
+		if p.SubscriptionIdentifier != 0 {
+			b = appendVarint(append(b, propSubscriptionIdentifier), p.SubscriptionIdentifier)
+		}
+		if p.SessionExpiry != 0 {
+			b = appendUint32(append(b, propSessionExpiry), p.SessionExpiry)
+		}
+		if p.AssignedClientID != "" {
+			b = appendString(append(b, propAssignedClientID), p.AssignedClientID)
+		}
+		if p.ServerKeepAlive != 0 {
+			b = appendUint16(append(b, propServerKeepAlive), p.ServerKeepAlive)
+		}
+		if p.ReceiveMaximum != 0 {
+			b = appendUint16(append(b, propReceiveMaximum), p.ReceiveMaximum)
+		}
+		if p.TopicAliasMaximum != 0 {
+			b = appendUint16(append(b, propTopicAliasMaximum), p.TopicAliasMaximum)
+		}
+		if p.MaximumPacketSize != 0 {
+			b = appendUint32(append(b, propMaximumPacketSize), p.MaximumPacketSize)
+		}
+		if p.TopicAlias != 0 {
+			b = appendUint16(append(b, propTopicAlias), p.TopicAlias)
+		}
+		if p.ReasonString != "" {
+			b = appendString(append(b, propReasonString), p.ReasonString)
+		}
+		for _, u := range p.UserProperties {
+			b = appendString(append(b, propUserProperty), u.Key)
+			b = appendString(b, u.Value)
+		}
+	}
+
+	// insert the length in front of the properties
+	var n [4]byte
+	size := len(appendVarint(n[:0], uint32(len(b)-start)))
+	b = append(b, n[:size]...)
+	copy(b[start+size:], b[start:len(b)-size])
+	copy(b[start:], n[:size])
+	return b
+}
+
+// parseProperties parses the property length and the properties at the start
+// of b. It returns nil when there are no properties, and the rest of b.
+func parseProperties(b []byte) (*Properties, []byte, error) {
+	n, b, err := parseVarint(b)
+	if err != nil || int(n) > len(b) {
+		return nil, nil, errMalformed
+	}
+	if n == 0 {
+		return nil, b, nil
+	}
+	props, rest := b[:n], b[n:]
+
+	p := &Properties{}
+	for len(props) > 0 {
+		id := props[0]
+		props = props[1:]
+		var v uint32
+		var s, s2 []byte
+		switch id {
+		case propPayloadFormat, propRequestProblemInfo, propRequestResponseInfo, propMaximumQoS,
+			propRetainAvailable, propWildcardSubAvailable, propSubIDAvailable, propSharedSubAvailable:
+			if len(props) < 1 {
+				return nil, nil, errMalformed
+			}
+			v, props = uint32(props[0]), props[1:]
+		case propServerKeepAlive, propReceiveMaximum, propTopicAliasMaximum, propTopicAlias:
+			if len(props) < 2 {
+				return nil, nil, errMalformed
+			}
+			v, props = uint32(binary.BigEndian.Uint16(props)), props[2:]
+		case propMessageExpiry, propSessionExpiry, propWillDelay, propMaximumPacketSize:
+			if len(props) < 4 {
+				return nil, nil, errMalformed
+			}
+			v, props = binary.BigEndian.Uint32(props), props[4:]
+		case propSubscriptionIdentifier:
+			v, props, err = parseVarint(props)
+		case propContentType, propResponseTopic, propCorrelationData, propAssignedClientID, propAuthMethod,
+			propAuthData, propResponseInfo, propServerReference, propReasonString:
+			s, props, err = parseBinary(props)
+		case propUserProperty:
+			s, props, err = parseBinary(props)
+			if err == nil {
+				s2, props, err = parseBinary(props)
+			}
+		default:
+			return nil, nil, errors.New("mqtt: unknown property " + strconv.Itoa(int(id)))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch id {
+		case propPayloadFormat:
+			p.PayloadFormat = byte(v)
+		case propMessageExpiry:
+			p.MessageExpiry = v
+		case propContentType:
+			p.ContentType = string(s)
+		case propResponseTopic:
+			p.ResponseTopic = string(s)
+		case propCorrelationData:
+			p.CorrelationData = append([]byte(nil), s...)
+		case propSubscriptionIdentifier:
+			p.SubscriptionIdentifier = v
+		case propSessionExpiry:
+			p.SessionExpiry = v
+		case propAssignedClientID:
+			p.AssignedClientID = string(s)
+		case propServerKeepAlive:
+			p.ServerKeepAlive = uint16(v)
+		case propReceiveMaximum:
+			p.ReceiveMaximum = uint16(v)
+		case propTopicAliasMaximum:
+			p.TopicAliasMaximum = uint16(v)
+		case propMaximumPacketSize:
+			p.MaximumPacketSize = v
+		case propTopicAlias:
+			p.TopicAlias = uint16(v)
+		case propReasonString:
+			p.ReasonString = string(s)
+		case propUserProperty:
+			p.UserProperties = append(p.UserProperties, UserProperty{string(s), string(s2)})
+		}
+	}
+	return p, rest, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint16(b, uint16(len(s))), s...)
+}
+
+func appendBinary(b []byte, data []byte) []byte {
+	return append(appendUint16(b, uint16(len(data))), data...)
+}
+
+// appendVarint appends a variable byte integer, as used for lengths.
+func appendVarint(b []byte, v uint32) []byte {
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if v > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func parseVarint(b []byte) (uint32, []byte, error) {
+	var v uint32
+	for i := 0; i < 4 && i < len(b); i++ {
+		v |= uint32(b[i]&0x7F) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return v, b[i+1:], nil
+		}
+	}
+	return 0, nil, errMalformed
+}
+
+// parseBinary parses a string or binary data prefixed by its 16 bit length.
+func parseBinary(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, nil, errMalformed
+	}
+	return b[2 : 2+n], b[2+n:], nil
+}
@@ -0,0 +1,166 @@
+package mqtt
+
+import (
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	dnet "tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+// qosBroker acknowledges the QoS 1 and 2 messages published by the client,
+// but drops the first PUBACK and the first PUBCOMP to force retransmissions.
+// The packets it receives are sent to got.
+func qosBroker(got chan<- packets.ControlPacket) func(stdnet.Conn) {
+	return func(conn stdnet.Conn) {
+		defer conn.Close()
+		packets.ReadPacket(conn)
+		packets.NewControlPacket(packets.Connack).Write(conn)
+		droppedPuback, droppedPubcomp := false, false
+		for {
+			p, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			got <- p
+			switch p := p.(type) {
+			case *packets.PublishPacket:
+				if p.Qos == 1 {
+					if !droppedPuback {
+						droppedPuback = true
+						continue
+					}
+					a := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+					a.MessageID = p.MessageID
+					a.Write(conn)
+				} else if p.Qos == 2 {
+					a := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+					a.MessageID = p.MessageID
+					a.Write(conn)
+				}
+			case *packets.PubrelPacket:
+				if !droppedPubcomp {
+					droppedPubcomp = true
+					continue
+				}
+				a := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+				a.MessageID = p.MessageID
+				a.Write(conn)
+			case *packets.SubscribePacket:
+				a := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+				a.MessageID = p.MessageID
+				a.ReturnCodes = []byte{2}
+				a.Write(conn)
+
+				// the same QoS 2 message twice, the second time as a
+				// retransmission
+				for i := 0; i < 2; i++ {
+					pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+					pub.Qos = 2
+					pub.MessageID = 7
+					pub.TopicName = "in"
+					pub.Payload = []byte("x")
+					pub.Dup = i > 0
+					pub.Write(conn)
+				}
+			case *packets.PubrecPacket:
+				a := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+				a.MessageID = p.MessageID
+				a.Write(conn)
+			}
+		}
+	}
+}
+
+// next returns the next packet received by the broker.
+func next(t *testing.T, got <-chan packets.ControlPacket) packets.ControlPacket {
+	t.Helper()
+	select {
+	case p := <-got:
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("no packet received by the broker")
+		return nil
+	}
+}
+
+func TestQoS(t *testing.T) {
+	d := loopback.New()
+	got := make(chan packets.ControlPacket, 20)
+	d.Handle("broker:1883", qosBroker(got))
+	dnet.UseDriver(d)
+	defer dnet.UseDriver(nil)
+
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").SetRetryInterval(300 * time.Millisecond)
+	opts.Adaptor = d
+	c := NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	defer c.Disconnect(0)
+
+	// QoS 1: the PUBACK is dropped, the message is sent again with the DUP
+	// flag after the retry interval
+	tok := c.Publish("a", 1, false, "one")
+	first, ok := next(t, got).(*packets.PublishPacket)
+	if !ok || first.Qos != 1 || first.Dup || string(first.Payload) != "one" {
+		t.Fatalf("got %v", first)
+	}
+	if tok.WaitTimeout(100 * time.Millisecond) {
+		t.Fatal("QoS 1 token completed before the PUBACK")
+	}
+	again, ok := next(t, got).(*packets.PublishPacket)
+	if !ok || !again.Dup || again.MessageID != first.MessageID || string(again.Payload) != "one" {
+		t.Fatalf("got %v, want a retransmission of %v", again, first)
+	}
+	if !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatal("QoS 1 token not completed by the PUBACK", tok.Error())
+	}
+
+	// QoS 2: PUBLISH, PUBREC, PUBREL, the PUBCOMP is dropped so the PUBREL
+	// is sent again
+	tok = c.Publish("a", 2, false, "two")
+	pub, ok := next(t, got).(*packets.PublishPacket)
+	if !ok || pub.Qos != 2 || pub.Dup {
+		t.Fatalf("got %v", pub)
+	}
+	for i := 0; i < 2; i++ {
+		rel, ok := next(t, got).(*packets.PubrelPacket)
+		if !ok || rel.MessageID != pub.MessageID {
+			t.Fatalf("got %v, want PUBREL %d", rel, pub.MessageID)
+		}
+		if i == 0 && tok.WaitTimeout(100*time.Millisecond) {
+			t.Fatal("QoS 2 token completed before the PUBCOMP")
+		}
+	}
+	if !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatal("QoS 2 token not completed by the PUBCOMP", tok.Error())
+	}
+
+	// incoming QoS 2: the retransmission is acknowledged but not delivered
+	// again
+	delivered := make(chan Message, 2)
+	tok = c.Subscribe("in", 2, func(c Client, m Message) { delivered <- m })
+	if !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatal("no SUBACK", tok.Error())
+	}
+	next(t, got) // SUBSCRIBE
+	for i := 0; i < 2; i++ {
+		if rec, ok := next(t, got).(*packets.PubrecPacket); !ok || rec.MessageID != 7 {
+			t.Fatalf("got %v, want PUBREC 7", rec)
+		}
+	}
+	if comp, ok := next(t, got).(*packets.PubcompPacket); !ok || comp.MessageID != 7 {
+		t.Fatalf("got %v, want PUBCOMP 7", comp)
+	}
+	if m := <-delivered; m.Topic() != "in" || string(m.Payload()) != "x" {
+		t.Fatal(m.Topic(), string(m.Payload()))
+	}
+	select {
+	case <-delivered:
+		t.Fatal("QoS 2 message delivered twice")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
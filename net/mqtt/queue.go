@@ -0,0 +1,114 @@
+package mqtt
+
+import (
+	"errors"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// DropPolicy selects the message dropped when a message is published while
+// the offline queue is full.
+type DropPolicy uint8
+
+const (
+	// DropNewest drops the message being published.
+	DropNewest DropPolicy = iota
+
+	// DropOldest drops the oldest message of the queue to make room.
+	DropOldest
+)
+
+// ErrQueueFull is the error of the token of a message dropped because the
+// offline queue was full.
+var ErrQueueFull = errors.New("mqtt: offline queue full, message dropped")
+
+// ErrQueueClosed is the error of the token of a queued message dropped
+// because Disconnect was called before it could be sent.
+var ErrQueueClosed = errors.New("mqtt: client disconnected, queued message dropped")
+
+// queued is a message published while the client was not connected.
+type queued struct {
+	packet *packets.PublishPacket
+	props  *Properties
+	token  *mqtttoken
+}
+
+// enqueue queues a message to publish once connected, and returns whether
+// the message has been taken by the queue. Messages are queued while the
+// client is not connected, and while older messages have not been flushed so
+// that the order is kept. failed queues a message that could not be written
+// whatever the state.
+func (c *mqttclient) enqueue(pub *packets.PublishPacket, props *Properties, token *mqtttoken, failed bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	depth := c.opts.OfflineQueueDepth
+	if depth <= 0 || c.closed {
+		return false
+	}
+	if !failed && c.connected && !c.flushing && len(c.queue) == 0 {
+		return false
+	}
+
+	if len(c.queue) >= depth {
+		if c.opts.OfflineDropPolicy == DropNewest {
+			token.complete(ErrQueueFull)
+			return true
+		}
+		c.queue[0].token.complete(ErrQueueFull)
+		c.pop()
+	}
+	c.queue = append(c.queue, queued{packet: pub, props: props, token: token})
+	return true
+}
+
+// pop removes the oldest message of the queue. c.mu must be held.
+func (c *mqttclient) pop() queued {
+	m := c.queue[0]
+	copy(c.queue, c.queue[1:])
+	c.queue[len(c.queue)-1] = queued{}
+	c.queue = c.queue[:len(c.queue)-1]
+	return m
+}
+
+// drop completes the tokens of all the queued messages with err, and empties
+// the queue. c.mu must be held.
+func (c *mqttclient) drop(err error) {
+	for _, m := range c.queue {
+		m.token.complete(err)
+	}
+	c.queue = nil
+}
+
+// flush publishes the queued messages in order, once connected. A message
+// that cannot be written stays first in the queue for the next connection,
+// unless the client has been disconnected.
+func (c *mqttclient) flush() {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 || !c.connected {
+			c.flushing = false
+			c.mu.Unlock()
+			return
+		}
+		c.flushing = true
+		m := c.pop()
+		c.mu.Unlock()
+
+		if err := c.publish(m.packet, m.props, m.token); err != nil {
+			c.mu.Lock()
+			if c.closed {
+				m.token.complete(ErrQueueClosed)
+				c.flushing = false
+				c.mu.Unlock()
+				return
+			}
+			c.queue = append(c.queue, queued{})
+			copy(c.queue[1:], c.queue)
+			c.queue[0] = m
+			c.flushing = false
+			c.mu.Unlock()
+			return
+		}
+	}
+}
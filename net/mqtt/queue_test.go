@@ -0,0 +1,170 @@
+package mqtt
+
+import (
+	stdnet "net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	dnet "tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+// queueBroker closes the first connection after the CONNACK, and refuses
+// the next ones until up is closed. The payloads of the messages it receives
+// are sent to pubs.
+func queueBroker(up <-chan bool, pubs chan<- string) func(stdnet.Conn) {
+	var conns int32
+	return func(conn stdnet.Conn) {
+		defer conn.Close()
+		n := atomic.AddInt32(&conns, 1)
+		if n > 1 {
+			select {
+			case <-up:
+			default:
+				return
+			}
+		}
+		packets.ReadPacket(conn)
+		packets.NewControlPacket(packets.Connack).Write(conn)
+		if n == 1 {
+			return
+		}
+		for {
+			p, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			if p, ok := p.(*packets.PublishPacket); ok {
+				pubs <- string(p.Payload)
+				if p.Qos == 1 {
+					a := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+					a.MessageID = p.MessageID
+					a.Write(conn)
+				}
+			}
+		}
+	}
+}
+
+func TestOfflineQueue(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		policy  DropPolicy
+		dropped []int    // messages whose token completes with ErrQueueFull
+		sent    []string // messages flushed once connected again
+	}{
+		{"DropNewest", DropNewest, []int{3, 4}, []string{"0", "1", "2"}},
+		{"DropOldest", DropOldest, []int{0, 1}, []string{"2", "3", "4"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := loopback.New()
+			up := make(chan bool)
+			pubs := make(chan string, 10)
+			d.Handle("broker:1883", queueBroker(up, pubs))
+			dnet.UseDriver(d)
+			defer dnet.UseDriver(nil)
+
+			lost := make(chan error, 1)
+			opts := NewClientOptions().AddBroker("tcp://broker:1883").
+				SetOfflineQueueDepth(3).SetOfflineDropPolicy(tc.policy).
+				SetAutoReconnect(true).SetConnectRetryInterval(50 * time.Millisecond).
+				SetMaxReconnectInterval(50 * time.Millisecond).
+				SetConnectionLostHandler(func(c Client, err error) { lost <- err })
+			opts.Adaptor = d
+			c := NewClient(opts)
+			if tok := c.Connect(); tok.Error() != nil {
+				t.Fatal(tok.Error())
+			}
+			defer c.Disconnect(0)
+			select {
+			case <-lost:
+			case <-time.After(2 * time.Second):
+				t.Fatal("connection not lost")
+			}
+
+			// the messages published while offline are queued, the ones that
+			// do not fit are dropped according to the policy
+			var toks []Token
+			for _, payload := range []string{"0", "1", "2", "3", "4"} {
+				toks = append(toks, c.Publish("t", 1, false, payload))
+			}
+			dropped := map[int]bool{}
+			for _, i := range tc.dropped {
+				dropped[i] = true
+				if !toks[i].WaitTimeout(100*time.Millisecond) || toks[i].Error() != ErrQueueFull {
+					t.Fatalf("message %d not dropped: %v", i, toks[i].Error())
+				}
+			}
+			for i, tok := range toks {
+				if !dropped[i] && tok.WaitTimeout(100*time.Millisecond) {
+					t.Fatalf("message %d completed while offline: %v", i, tok.Error())
+				}
+			}
+
+			// they are sent in order once connected again
+			close(up)
+			for _, want := range tc.sent {
+				select {
+				case got := <-pubs:
+					if got != want {
+						t.Fatalf("sent %s, want %s", got, want)
+					}
+				case <-time.After(3 * time.Second):
+					t.Fatalf("%s not sent", want)
+				}
+			}
+			for i, tok := range toks {
+				if !dropped[i] && (!tok.WaitTimeout(time.Second) || tok.Error() != nil) {
+					t.Fatalf("message %d not acknowledged: %v", i, tok.Error())
+				}
+			}
+
+			// once the queue is flushed, messages are sent at once
+			if tok := c.Publish("t", 0, false, "5"); !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+				t.Fatal("not sent", tok.Error())
+			}
+			if got := <-pubs; got != "5" {
+				t.Fatalf("sent %s, want 5", got)
+			}
+		})
+	}
+}
+
+func TestOfflineQueueDisconnect(t *testing.T) {
+	d := loopback.New()
+	d.Handle("broker:1883", queueBroker(nil, nil))
+	dnet.UseDriver(d)
+	defer dnet.UseDriver(nil)
+
+	lost := make(chan error, 1)
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").SetOfflineQueueDepth(3).
+		SetConnectionLostHandler(func(c Client, err error) { lost <- err })
+	opts.Adaptor = d
+	c := NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	select {
+	case <-lost:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not lost")
+	}
+
+	toks := []Token{c.Publish("t", 0, false, "0"), c.Publish("t", 1, false, "1")}
+	if toks[0].WaitTimeout(100 * time.Millisecond) {
+		t.Fatal("completed while offline", toks[0].Error())
+	}
+
+	// Disconnect fails the queued messages
+	c.Disconnect(0)
+	for i, tok := range toks {
+		if !tok.WaitTimeout(time.Second) || tok.Error() != ErrQueueClosed {
+			t.Fatalf("message %d: %v, want ErrQueueClosed", i, tok.Error())
+		}
+	}
+	if tok := c.Publish("t", 0, false, "2"); tok.Error() == nil {
+		t.Fatal("queued after Disconnect")
+	}
+}
@@ -0,0 +1,200 @@
+package mqtt
+
+import (
+	stdnet "net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	dnet "tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+func TestReconnect(t *testing.T) {
+	d := loopback.New()
+	var mu sync.Mutex
+	var attempts []time.Time
+	pings := make(chan time.Time, 4)
+	subs := make(chan []string, 4)
+	d.Handle("broker:1883", func(conn stdnet.Conn) {
+		defer conn.Close()
+		mu.Lock()
+		attempts = append(attempts, time.Now())
+		n := len(attempts)
+		mu.Unlock()
+		if n >= 2 && n <= 4 {
+			// the first three attempts to reconnect fail
+			return
+		}
+		packets.ReadPacket(conn)
+		packets.NewControlPacket(packets.Connack).Write(conn)
+		for ping := 0; ; {
+			p, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			switch p := p.(type) {
+			case *packets.PingreqPacket:
+				pings <- time.Now()
+				// the second PINGREQ of the first connection is not
+				// answered, the client must close the connection
+				if ping++; n > 1 || ping == 1 {
+					packets.NewControlPacket(packets.Pingresp).Write(conn)
+				}
+			case *packets.SubscribePacket:
+				subs <- p.Topics
+				a := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+				a.MessageID = p.MessageID
+				a.ReturnCodes = []byte{0}
+				a.Write(conn)
+				if n == 5 {
+					// the broker closes the connection once the client
+					// has subscribed again
+					return
+				}
+			case *packets.DisconnectPacket:
+				return
+			}
+		}
+	})
+	dnet.UseDriver(d)
+	defer dnet.UseDriver(nil)
+
+	lost := make(chan error, 2)
+	connected := make(chan time.Time, 4)
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").
+		SetKeepAlive(time.Second).SetPingTimeout(500 * time.Millisecond).
+		SetAutoReconnect(true).
+		SetConnectRetryInterval(100 * time.Millisecond).SetMaxReconnectInterval(300 * time.Millisecond).
+		SetOnConnectHandler(func(Client) { connected <- time.Now() }).
+		SetConnectionLostHandler(func(c Client, err error) { lost <- err })
+	opts.Adaptor = d
+	c := NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	start := <-connected
+	if tok := c.Subscribe("a/b", 0, nil); !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatal("no SUBACK", tok.Error())
+	}
+	<-subs
+
+	// a PINGREQ is sent after the keep alive interval without traffic
+	for i := 0; i < 2; i++ {
+		select {
+		case at := <-pings:
+			if wait := at.Sub(start); wait < 900*time.Millisecond || wait > 1500*time.Millisecond {
+				t.Errorf("PINGREQ %d sent after %v, want about 1s", i+1, wait)
+			}
+			start = at
+		case <-time.After(3 * time.Second):
+			t.Fatalf("PINGREQ %d not sent", i+1)
+		}
+	}
+
+	// the connection is closed when the PINGRESP does not come in time
+	select {
+	case err := <-lost:
+		if err == nil || !strings.Contains(err.Error(), "pingresp") {
+			t.Errorf("connection lost with %v", err)
+		}
+		if wait := time.Since(start); wait < 400*time.Millisecond || wait > 1200*time.Millisecond {
+			t.Errorf("connection lost %v after the PINGREQ, want about 500ms", wait)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection not lost")
+	}
+
+	// the client reconnects with an exponential backoff, and subscribes
+	// again to the topics
+	reconnected := func() {
+		t.Helper()
+		select {
+		case <-connected:
+		case <-time.After(5 * time.Second):
+			t.Fatal("not reconnected")
+		}
+		select {
+		case topics := <-subs:
+			if len(topics) != 1 || topics[0] != "a/b" {
+				t.Errorf("subscribed again to %v", topics)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("not subscribed again")
+		}
+	}
+	reconnected()
+
+	// the connection closed by the broker is noticed at once
+	select {
+	case err := <-lost:
+		t.Log("connection lost:", err)
+	case <-time.After(time.Second):
+		t.Fatal("connection closed by the broker not noticed")
+	}
+	reconnected()
+
+	mu.Lock()
+	if len(attempts) != 6 {
+		t.Fatalf("%d connections, want 6", len(attempts))
+	}
+	for i, want := range []time.Duration{100, 200, 300} {
+		want *= time.Millisecond
+		if wait := attempts[i+2].Sub(attempts[i+1]); wait < want*9/10 || wait > want*2 {
+			t.Errorf("attempt %d after %v, want %v", i+3, wait, want)
+		}
+	}
+	mu.Unlock()
+	if !c.IsConnected() {
+		t.Fatal("not connected")
+	}
+
+	c.Disconnect(100)
+	if c.IsConnected() {
+		t.Fatal("still connected")
+	}
+	select {
+	case err := <-lost:
+		t.Fatal("connection lost after Disconnect:", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNoAutoReconnect(t *testing.T) {
+	d := loopback.New()
+	conns := make(chan bool, 2)
+	d.Handle("broker:1883", func(conn stdnet.Conn) {
+		defer conn.Close()
+		conns <- true
+		packets.ReadPacket(conn)
+		packets.NewControlPacket(packets.Connack).Write(conn)
+	})
+	dnet.UseDriver(d)
+	defer dnet.UseDriver(nil)
+
+	lost := make(chan error, 1)
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").
+		SetConnectRetryInterval(10 * time.Millisecond).
+		SetConnectionLostHandler(func(c Client, err error) { lost <- err })
+	opts.Adaptor = d
+	c := NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	<-conns
+	select {
+	case <-lost:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not lost")
+	}
+	select {
+	case <-conns:
+		t.Fatal("reconnected without AutoReconnect")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if c.IsConnected() {
+		t.Fatal("still connected")
+	}
+}
@@ -0,0 +1,261 @@
+package mqtt
+
+import (
+	stdnet "net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	dnet "tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+// routeBroker publishes the topics sent to publish whether the client has
+// subscribed to them or not, and acknowledges the subscriptions. The
+// unsubscriptions are sent to unsubs and acknowledged only when their message
+// id is sent to unsuback.
+func routeBroker(subs chan<- *packets.SubscribePacket, unsubs chan<- *packets.UnsubscribePacket,
+	publish <-chan string, unsuback <-chan uint16) func(stdnet.Conn) {
+	return func(conn stdnet.Conn) {
+		defer conn.Close()
+		packets.ReadPacket(conn)
+		packets.NewControlPacket(packets.Connack).Write(conn)
+		in := make(chan packets.ControlPacket)
+		go func() {
+			defer close(in)
+			for {
+				p, err := packets.ReadPacket(conn)
+				if err != nil {
+					return
+				}
+				in <- p
+			}
+		}()
+		for {
+			select {
+			case p, ok := <-in:
+				if !ok {
+					return
+				}
+				switch p := p.(type) {
+				case *packets.SubscribePacket:
+					a := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+					a.MessageID = p.MessageID
+					a.ReturnCodes = p.Qoss
+					a.Write(conn)
+					subs <- p
+				case *packets.UnsubscribePacket:
+					unsubs <- p
+				case *packets.DisconnectPacket:
+					return
+				}
+			case topic := <-publish:
+				pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				pub.TopicName = topic
+				pub.Payload = []byte(topic)
+				pub.Write(conn)
+			case id := <-unsuback:
+				a := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+				a.MessageID = id
+				a.Write(conn)
+			}
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	d := loopback.New()
+	subs := make(chan *packets.SubscribePacket, 4)
+	unsubs := make(chan *packets.UnsubscribePacket, 4)
+	publish := make(chan string)
+	unsuback := make(chan uint16)
+	d.Handle("broker:1883", routeBroker(subs, unsubs, publish, unsuback))
+	dnet.UseDriver(d)
+	defer dnet.UseDriver(nil)
+
+	got := make(chan string, 10)
+	handler := func(name string) MessageHandler {
+		return func(c Client, m Message) { got <- m.Topic() + " " + name }
+	}
+	// expect publishes the topics and checks the handlers called, in any
+	// order
+	expect := func(topics []string, want ...string) {
+		t.Helper()
+		for _, topic := range topics {
+			publish <- topic
+		}
+		var calls []string
+		for range want {
+			select {
+			case call := <-got:
+				calls = append(calls, call)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("got %q, want %q", calls, want)
+			}
+		}
+		select {
+		case call := <-got:
+			t.Fatalf("unexpected call %q", call)
+		case <-time.After(100 * time.Millisecond):
+		}
+		sort.Strings(calls)
+		sort.Strings(want)
+		if !reflect.DeepEqual(calls, want) {
+			t.Fatalf("got %q, want %q", calls, want)
+		}
+	}
+
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").SetDefaultPublishHandler(handler("default"))
+	opts.Adaptor = d
+	c := NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	defer c.Disconnect(0)
+
+	// a route added without a subscription is used without sending a
+	// SUBSCRIBE
+	c.AddRoute("s/temp", handler("temp"))
+	expect([]string{"s/temp", "other"}, "s/temp temp", "other default")
+	select {
+	case p := <-subs:
+		t.Fatal("AddRoute sent", p)
+	default:
+	}
+
+	// a message is dispatched to all the matching routes, and to the default
+	// handler only when none match
+	if tok := c.Subscribe("s/+", 0, handler("wild")); !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatal("no SUBACK", tok.Error())
+	}
+	<-subs
+	expect([]string{"s/temp", "s/hum", "other"},
+		"s/temp temp", "s/temp wild", "s/hum wild", "other default")
+
+	// SubscribeMultiple sends all the topics in a single SUBSCRIBE
+	tok := c.SubscribeMultiple(map[string]byte{"m/b": 0, "m/a": 1}, handler("multi"))
+	if !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatal("no SUBACK", tok.Error())
+	}
+	if p := <-subs; !reflect.DeepEqual(p.Topics, []string{"m/a", "m/b"}) || !reflect.DeepEqual(p.Qoss, []byte{1, 0}) {
+		t.Fatalf("SUBSCRIBE %q %v", p.Topics, p.Qoss)
+	}
+	expect([]string{"m/a", "m/b"}, "m/a multi", "m/b multi")
+
+	// Unsubscribe completes with the UNSUBACK, and removes the route of the
+	// topic only
+	tok = c.Unsubscribe("s/+")
+	var unsub *packets.UnsubscribePacket
+	select {
+	case unsub = <-unsubs:
+	case <-time.After(time.Second):
+		t.Fatal("no UNSUBSCRIBE")
+	}
+	if !reflect.DeepEqual(unsub.Topics, []string{"s/+"}) {
+		t.Fatalf("UNSUBSCRIBE %q", unsub.Topics)
+	}
+	if tok.WaitTimeout(200 * time.Millisecond) {
+		t.Fatal("Unsubscribe completed before the UNSUBACK")
+	}
+	unsuback <- unsub.MessageID
+	if !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatal("Unsubscribe not completed by the UNSUBACK", tok.Error())
+	}
+	expect([]string{"s/temp", "s/hum"}, "s/temp temp", "s/hum default")
+}
+
+func TestDispatchOrder(t *testing.T) {
+	const n = 10
+	// run publishes n messages to handler and calls check before
+	// disconnecting
+	run := func(t *testing.T, order bool, handler MessageHandler, check func()) {
+		d := loopback.New()
+		subs := make(chan *packets.SubscribePacket, 1)
+		publish := make(chan string)
+		d.Handle("broker:1883", routeBroker(subs, nil, publish, nil))
+		dnet.UseDriver(d)
+		defer dnet.UseDriver(nil)
+
+		opts := NewClientOptions().AddBroker("tcp://broker:1883")
+		opts.Adaptor = d
+		opts.Order = order
+		c := NewClient(opts)
+		if tok := c.Connect(); tok.Error() != nil {
+			t.Fatal(tok.Error())
+		}
+		defer c.Disconnect(0)
+		if tok := c.Subscribe("n/#", 0, handler); !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+			t.Fatal("no SUBACK", tok.Error())
+		}
+		<-subs
+		for i := 0; i < n; i++ {
+			publish <- "n/" + strconv.Itoa(i)
+		}
+		check()
+	}
+
+	t.Run("ordered", func(t *testing.T) {
+		got := make(chan string, n)
+		var running int32
+		run(t, true, func(c Client, m Message) {
+			if atomic.AddInt32(&running, 1) > 1 {
+				t.Error("handlers run concurrently")
+			}
+			if m.Topic() == "n/0" {
+				// a slow handler does not let the next messages overtake
+				// the first one
+				time.Sleep(50 * time.Millisecond)
+			}
+			atomic.AddInt32(&running, -1)
+			got <- m.Topic()
+		}, func() {
+			for i := 0; i < n; i++ {
+				select {
+				case topic := <-got:
+					if want := "n/" + strconv.Itoa(i); topic != want {
+						t.Fatalf("got %s, want %s", topic, want)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("message %d not delivered", i)
+				}
+			}
+		})
+	})
+
+	t.Run("unordered", func(t *testing.T) {
+		got := make(chan string, n)
+		last := make(chan bool)
+		run(t, false, func(c Client, m Message) {
+			switch m.Topic() {
+			case "n/0":
+				// the first handler waits for the last one, which needs
+				// the handlers to run concurrently
+				select {
+				case <-last:
+				case <-time.After(time.Second):
+					t.Error("handlers not run concurrently")
+				}
+			case "n/" + strconv.Itoa(n-1):
+				close(last)
+			}
+			got <- m.Topic()
+		}, func() {
+			seen := map[string]bool{}
+			for i := 0; i < n; i++ {
+				select {
+				case topic := <-got:
+					seen[topic] = true
+				case <-time.After(2 * time.Second):
+					t.Fatalf("%d messages delivered, want %d", i, n)
+				}
+			}
+			if len(seen) != n {
+				t.Fatalf("delivered %v", seen)
+			}
+		})
+	})
+}
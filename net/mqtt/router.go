@@ -21,6 +21,7 @@ package mqtt
 import (
 	"container/list"
 	"strings"
+	"sync"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 )
@@ -86,7 +87,7 @@ func (r *route) match(topic string) bool {
 }
 
 type router struct {
-	//sync.RWMutex
+	sync.RWMutex
 	routes         *list.List
 	defaultHandler MessageHandler
 	messages       chan *packets.PublishPacket
@@ -102,11 +103,13 @@ func newRouter() (*router, chan bool) {
 }
 
 // addRoute takes a topic string and MessageHandler callback. It looks in the current list of
-// routes to see if there is already a matching Route. If there is it replaces the current
+// routes to see if there is already a Route for the same topic. If there is it replaces the current
 // callback with the new one. If not it add a new entry to the list of Routes.
 func (r *router) addRoute(topic string, callback MessageHandler) {
+	r.Lock()
+	defer r.Unlock()
 	for e := r.routes.Front(); e != nil; e = e.Next() {
-		if e.Value.(*route).match(topic) {
+		if e.Value.(*route).topic == topic {
 			r := e.Value.(*route)
 			r.callback = callback
 			return
@@ -115,11 +118,13 @@ func (r *router) addRoute(topic string, callback MessageHandler) {
 	r.routes.PushBack(&route{topic: topic, callback: callback})
 }
 
-// deleteRoute takes a route string, looks for a matching Route in the list of Routes. If
+// deleteRoute takes a route string, looks for the Route of the same topic in the list of Routes. If
 // found it removes the Route from the list.
 func (r *router) deleteRoute(topic string) {
+	r.Lock()
+	defer r.Unlock()
 	for e := r.routes.Front(); e != nil; e = e.Next() {
-		if e.Value.(*route).match(topic) {
+		if e.Value.(*route).topic == topic {
 			r.routes.Remove(e)
 			return
 		}
@@ -129,6 +134,8 @@ func (r *router) deleteRoute(topic string) {
 // setDefaultHandler assigns a default callback that will be called if no matching Route
 // is found for an incoming Publish.
 func (r *router) setDefaultHandler(handler MessageHandler) {
+	r.Lock()
+	defer r.Unlock()
 	r.defaultHandler = handler
 }
 
@@ -136,14 +143,16 @@ func (r *router) setDefaultHandler(handler MessageHandler) {
 // takes messages off the channel, matches them against the internal route list and calls the
 // associated callback (or the defaultHandler, if one exists and no other route matched). If
 // anything is sent down the stop channel the function will end.
-func (r *router) matchAndDispatch(messages <-chan *packets.PublishPacket, order bool, client *mqttclient) {
+func (r *router) matchAndDispatch(messages <-chan incoming, order bool, client *mqttclient) {
 	go func() {
 		for {
 			select {
-			case message := <-messages:
+			case in := <-messages:
+				message := in.packet.(*packets.PublishPacket)
 				sent := false
-				m := messageFromPublish(message, client.ackFunc(message))
+				m := messageFromPublish(message, in.props, client.ackFunc(message))
 				handlers := []MessageHandler{}
+				r.RLock()
 				for e := r.routes.Front(); e != nil; e = e.Next() {
 					if e.Value.(*route).match(message.TopicName) {
 						if order {
@@ -152,26 +161,32 @@ func (r *router) matchAndDispatch(messages <-chan *packets.PublishPacket, order
 							hd := e.Value.(*route).callback
 							go func() {
 								hd(client, m)
-								//TODO: m.Ack()
+								m.Ack()
 							}()
 						}
 						sent = true
 					}
 				}
-				if !sent && r.defaultHandler != nil {
+				dh := r.defaultHandler
+				r.RUnlock()
+				if !sent && dh != nil {
 					if order {
-						handlers = append(handlers, r.defaultHandler)
+						handlers = append(handlers, dh)
 					} else {
 						go func() {
-							r.defaultHandler(client, m)
-							//TODO: m.Ack()
+							dh(client, m)
+							m.Ack()
 						}()
 					}
 				}
+				if !sent && dh == nil {
+					// nobody wants it, but the broker still needs the ack
+					m.Ack()
+				}
 				for _, handler := range handlers {
 					func() {
 						handler(client, m)
-						//TODO: m.Ack()
+						m.Ack()
 					}()
 				}
 			case <-r.stop:
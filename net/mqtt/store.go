@@ -0,0 +1,136 @@
+package mqtt
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+const (
+	inboundPrefix  = "i."
+	outboundPrefix = "o."
+)
+
+// Store is an interface which can be used to provide implementations
+// for message persistence.
+// Because we may have to store distinct messages with the same
+// message ID, we need a unique key for each message. This is
+// possible by prepending "i." or "o." to each message id.
+//
+// The client keeps in the store the QoS 1 and 2 messages it sent that have
+// not been acknowledged yet, under "o." keys, and the ids of the QoS 2
+// messages it received that have not been released yet, under "i." keys. All
+// must return the keys in the order they were first put, so that the client
+// can resend the messages in order and continue with the next message id.
+type Store interface {
+	Open()
+	Put(key string, message packets.ControlPacket)
+	Get(key string) packets.ControlPacket
+	All() []string
+	Del(key string)
+	Close()
+	Reset()
+}
+
+// inboundKey returns a string of the form "i.[id]".
+func inboundKey(id uint16) string {
+	return inboundPrefix + strconv.Itoa(int(id))
+}
+
+// outboundKey returns a string of the form "o.[id]".
+func outboundKey(id uint16) string {
+	return outboundPrefix + strconv.Itoa(int(id))
+}
+
+// parseKey returns the prefix and the message id of a key.
+func parseKey(key string) (prefix string, id uint16, ok bool) {
+	if len(key) < 3 {
+		return "", 0, false
+	}
+	n, err := strconv.ParseUint(key[2:], 10, 16)
+	if err != nil {
+		return "", 0, false
+	}
+	return key[:2], uint16(n), true
+}
+
+// MemoryStore implements the Store interface to provide a "persistence"
+// mechanism wholly stored in memory. This is only useful for as long as the
+// client instance exists, and is the store used when ClientOptions.Store is
+// nil.
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages map[string]packets.ControlPacket
+	keys     []string
+}
+
+// NewMemoryStore returns a pointer to a new instance of MemoryStore, the
+// instance is not initialized and ready to use until Open() has been called
+// on it.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Open initializes a MemoryStore instance.
+func (s *MemoryStore) Open() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages == nil {
+		s.messages = make(map[string]packets.ControlPacket)
+	}
+}
+
+// Put takes a key and a pointer to a Message and stores the message.
+func (s *MemoryStore) Put(key string, message packets.ControlPacket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.messages[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.messages[key] = message
+}
+
+// Get takes a key and looks in the store for a matching Message returning
+// either the Message pointer or nil.
+func (s *MemoryStore) Get(key string) packets.ControlPacket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages[key]
+}
+
+// All returns a slice of strings containing all the keys currently in the
+// MemoryStore, in the order they were first put.
+func (s *MemoryStore) All() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.keys...)
+}
+
+// Del takes a key, searches the MemoryStore and if the key is found deletes
+// the Message pointer associated with it.
+func (s *MemoryStore) Del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.messages[key]; !ok {
+		return
+	}
+	delete(s.messages, key)
+	for i, k := range s.keys {
+		if k == key {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Close does nothing, the messages stay in memory until Reset is called.
+func (s *MemoryStore) Close() {}
+
+// Reset eliminates all persisted message data in the store.
+func (s *MemoryStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = make(map[string]packets.ControlPacket)
+	s.keys = nil
+}
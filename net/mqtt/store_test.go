@@ -0,0 +1,232 @@
+package mqtt
+
+import (
+	"errors"
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	dnet "tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+type mem []byte
+
+func (m mem) ReadAt(b []byte, off int64) (int, error)  { return copy(b, m[off:]), nil }
+func (m mem) WriteAt(b []byte, off int64) (int, error) { return copy(m[off:], b), nil }
+
+func TestEEPROMStore(t *testing.T) {
+	m := make(mem, 1024)
+	s := NewEEPROMStore(m, 100, 512, 64)
+	s.Open()
+	for _, id := range []uint16{5, 3, 9} {
+		p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		p.Qos, p.MessageID, p.TopicName, p.Payload = 1, id, "t", []byte("hello")
+		s.Put(outboundKey(id), p)
+	}
+	rel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+	rel.MessageID = 5
+	s.Put(outboundKey(5), rel)
+	s.Del(outboundKey(3))
+	big := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	big.Qos, big.MessageID, big.TopicName, big.Payload = 1, 7, "t", make([]byte, 100)
+	s.Put(outboundKey(7), big)
+
+	s2 := NewEEPROMStore(m, 100, 512, 64)
+	s2.Open()
+	keys := s2.All()
+	if len(keys) != 2 || keys[0] != "o.5" || keys[1] != "o.9" {
+		t.Fatal(keys)
+	}
+	if _, ok := s2.Get("o.5").(*packets.PubrelPacket); !ok {
+		t.Fatal(s2.Get("o.5"))
+	}
+	if p := s2.Get("o.9").(*packets.PublishPacket); string(p.Payload) != "hello" {
+		t.Fatal(p)
+	}
+	s2.Reset()
+	s3 := NewEEPROMStore(m, 100, 512, 64)
+	s3.Open()
+	if len(s3.All()) != 0 {
+		t.Fatal("reset")
+	}
+}
+
+// cut is a storage that loses power after n writes.
+type cut struct {
+	mem
+	n int
+}
+
+func (c *cut) WriteAt(b []byte, off int64) (int, error) {
+	if c.n == 0 {
+		return 0, errors.New("power failure")
+	}
+	c.n--
+	return c.mem.WriteAt(b, off)
+}
+
+func TestEEPROMStoreHalfWritten(t *testing.T) {
+	publish := func(id uint16) packets.ControlPacket {
+		p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		p.Qos, p.MessageID, p.TopicName, p.Payload = 2, id, "t", []byte("hello")
+		return p
+	}
+	rel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+	rel.MessageID = 5
+
+	for _, tc := range []struct {
+		name   string
+		writes int  // writes of the Put of the PUBREL before the power fails
+		rel    bool // whether the PUBREL survives
+	}{
+		{"before the used marker", 1, false},
+		{"before the free of the previous slot", 2, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := make(mem, 512)
+			s := NewEEPROMStore(m, 0, 512, 64)
+			s.Open()
+			s.Put(outboundKey(5), publish(5))
+			s.Put(outboundKey(9), publish(9))
+
+			s = NewEEPROMStore(&cut{m, tc.writes}, 0, 512, 64)
+			s.Open()
+			s.Put(outboundKey(5), rel)
+
+			s = NewEEPROMStore(m, 0, 512, 64)
+			s.Open()
+			if keys := s.All(); len(keys) != 2 || keys[0] != "o.5" || keys[1] != "o.9" {
+				t.Fatal(keys)
+			}
+			if _, ok := s.Get("o.5").(*packets.PubrelPacket); ok != tc.rel {
+				t.Fatal(s.Get("o.5"))
+			}
+
+			// the stale copy was freed too, and not found again by Open
+			s.Del("o.5")
+			s = NewEEPROMStore(m, 0, 512, 64)
+			s.Open()
+			if keys := s.All(); len(keys) != 1 || keys[0] != "o.9" {
+				t.Fatal(keys)
+			}
+		})
+	}
+}
+
+func TestSessionReboot(t *testing.T) {
+	d := loopback.New()
+	pubs := make(chan *packets.PublishPacket, 10)
+	d.Handle("broker:1883", func(conn stdnet.Conn) {
+		packets.ReadPacket(conn)
+		packets.NewControlPacket(packets.Connack).Write(conn)
+		for {
+			p, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			if p, ok := p.(*packets.PublishPacket); ok {
+				pubs <- p
+			}
+		}
+	})
+	dnet.UseDriver(d)
+	m := make(mem, 2048)
+
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").SetStore(NewEEPROMStore(m, 0, 2048, 128))
+	opts.Adaptor = d
+	c := NewClient(opts)
+	c.Connect()
+	c.Publish("a", 1, false, "one")
+	c.Publish("a", 2, false, "two")
+	<-pubs
+	<-pubs
+	c.Disconnect(0) // "reboot" without acks
+
+	opts = NewClientOptions().AddBroker("tcp://broker:1883").SetStore(NewEEPROMStore(m, 0, 2048, 128))
+	opts.Adaptor = d
+	c = NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-pubs:
+			t.Log(p.String())
+			if !p.Dup || p.MessageID != uint16(i+1) {
+				t.Fatal("not resent")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	c.Publish("a", 1, false, "three")
+	if p := <-pubs; p.MessageID != 3 {
+		t.Fatal("id", p.MessageID)
+	}
+}
+
+func TestRestoreIDs(t *testing.T) {
+	d := loopback.New()
+	pubs := make(chan packets.ControlPacket, 10)
+	d.Handle("broker:1883", func(conn stdnet.Conn) {
+		packets.ReadPacket(conn)
+		packets.NewControlPacket(packets.Connack).Write(conn)
+		for {
+			p, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			pubs <- p
+		}
+	})
+	dnet.UseDriver(d)
+
+	// the ids of the store are not in order, and a store written by an older
+	// version may hold a SUBSCRIBE
+	s := NewMemoryStore()
+	s.Open()
+	for _, id := range []uint16{9, 3} {
+		p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		p.Qos, p.MessageID, p.TopicName, p.Payload = 1, id, "t", []byte("hello")
+		s.Put(outboundKey(id), p)
+	}
+	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	sub.MessageID, sub.Topics, sub.Qoss = 4, []string{"t"}, []byte{1}
+	s.Put(outboundKey(4), sub)
+
+	opts := NewClientOptions().AddBroker("tcp://broker:1883").SetStore(s)
+	opts.Adaptor = d
+	c := NewClient(opts)
+	if tok := c.Connect(); tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	var resent []uint16
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-pubs:
+			resent = append(resent, p.Details().MessageID)
+			if _, ok := p.(*packets.PublishPacket); !ok {
+				t.Fatal(p.String())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	if resent[0] != 9 || resent[1] != 3 {
+		t.Fatal(resent)
+	}
+	if keys := s.All(); len(keys) != 2 {
+		t.Fatal(keys)
+	}
+
+	c.Subscribe("u", 1, nil)
+	if p := <-pubs; p.Details().MessageID != 10 {
+		t.Fatal(p.String())
+	}
+	if keys := s.All(); len(keys) != 2 {
+		t.Fatalf("subscription stored: %v", keys)
+	}
+	c.Disconnect(0)
+}
@@ -1,19 +1,65 @@
 package mqtt
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
+// mqtttoken is completed when the action it tracks is done. Tokens created
+// without a done channel, such as &mqtttoken{err: err}, are already complete.
 type mqtttoken struct {
-	err error
+	once sync.Once
+	done chan struct{}
+	err  error
 }
 
+func newToken() *mqtttoken {
+	return &mqtttoken{done: make(chan struct{})}
+}
+
+// complete marks the token as done, with the error the action failed with if
+// any. Only the first call has an effect.
+func (t *mqtttoken) complete(err error) {
+	t.once.Do(func() {
+		t.err = err
+		if t.done != nil {
+			close(t.done)
+		}
+	})
+}
+
+// Wait will wait indefinitely for the token to complete.
 func (t *mqtttoken) Wait() bool {
+	if t.done != nil {
+		<-t.done
+	}
 	return true
 }
 
-func (t *mqtttoken) WaitTimeout(time.Duration) bool {
-	return true
+// WaitTimeout takes a time.Duration to wait for the token to complete and
+// returns false if the timeout expired first.
+func (t *mqtttoken) WaitTimeout(d time.Duration) bool {
+	if t.done == nil {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
 }
 
+// Error returns the error the action failed with, once the token is complete.
 func (t *mqtttoken) Error() error {
+	if t.done != nil {
+		select {
+		case <-t.done:
+		default:
+			return nil
+		}
+	}
 	return t.err
 }
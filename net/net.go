@@ -6,6 +6,7 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,57 +14,77 @@ import (
 // be sent to, and laddr is the port that will be listened to in order to
 // receive incoming messages.
 func DialUDP(network string, laddr, raddr *UDPAddr) (*UDPSerialConn, error) {
-	addr := raddr.IP.String()
-	sendport := strconv.Itoa(raddr.Port)
-	listenport := strconv.Itoa(laddr.Port)
-
-	// disconnect any old socket
-	ActiveDevice.DisconnectSocket()
-
-	// connect new socket
-	err := ActiveDevice.ConnectUDPSocket(addr, sendport, listenport)
+	if laddr == nil {
+		laddr = &UDPAddr{}
+	}
+	c, err := DialSocket(ProtocolUDP, raddr.IP.String(), raddr.Port, laddr.Port)
 	if err != nil {
 		return nil, err
 	}
-
-	return &UDPSerialConn{SerialConn: SerialConn{Adaptor: ActiveDevice}, laddr: laddr, raddr: raddr}, nil
+	return &UDPSerialConn{SerialConn: c, laddr: laddr, raddr: raddr}, nil
 }
 
 // ListenUDP listens for UDP connections on the port listed in laddr.
 func ListenUDP(network string, laddr *UDPAddr) (*UDPSerialConn, error) {
-	addr := "0"
-	sendport := "0"
-	listenport := strconv.Itoa(laddr.Port)
-
-	// disconnect any old socket
-	ActiveDevice.DisconnectSocket()
-
-	// connect new socket
-	err := ActiveDevice.ConnectUDPSocket(addr, sendport, listenport)
+	c, err := DialSocket(ProtocolUDP, "0", 0, laddr.Port)
 	if err != nil {
 		return nil, err
 	}
+	return &UDPSerialConn{SerialConn: c, laddr: laddr}, nil
+}
 
-	return &UDPSerialConn{SerialConn: SerialConn{Adaptor: ActiveDevice}, laddr: laddr}, nil
+// ListenMulticastUDP listens for the UDP datagrams sent to the group address
+// gaddr. Unlike the standard library, there is no interface to choose, the
+// adaptors have only one. It returns ErrNotSupported if the adaptor does not
+// implement MulticastDriver.
+func ListenMulticastUDP(network string, gaddr *UDPAddr) (*UDPSerialConn, error) {
+	adaptor := ActiveDevice
+	if adaptor == nil {
+		return nil, ErrNoDriver
+	}
+	d, ok := adaptor.(MulticastDriver)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	sock, err := d.ListenMulticastSocket(gaddr.IP.String(), gaddr.Port)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPSerialConn{SerialConn: SerialConn{Adaptor: adaptor, Socket: sock}, laddr: gaddr}, nil
 }
 
 // DialTCP makes a TCP network connection. raadr is the port that the messages will
 // be sent to, and laddr is the port that will be listened to in order to
 // receive incoming messages.
 func DialTCP(network string, laddr, raddr *TCPAddr) (*TCPSerialConn, error) {
-	addr := raddr.IP.String()
-	sendport := strconv.Itoa(raddr.Port)
+	c, err := DialSocket(ProtocolTCP, raddr.IP.String(), raddr.Port, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPSerialConn{SerialConn: c, laddr: laddr, raddr: raddr}, nil
+}
 
-	// disconnect any old socket?
-	//ActiveDevice.DisconnectSocket()
+// DialSocket opens a new socket for protocol on the ActiveDevice and
+// connects it to the remote address. It is intended for packages such as
+// tls that need a protocol other than plain TCP or UDP.
+func DialSocket(protocol Protocol, addr string, port, localPort int) (SerialConn, error) {
+	adaptor := ActiveDevice
+	if adaptor == nil {
+		return SerialConn{}, ErrNoDriver
+	}
 
-	// connect new socket
-	err := ActiveDevice.ConnectTCPSocket(addr, sendport)
+	sock, err := adaptor.OpenSocket(protocol)
 	if err != nil {
-		return nil, err
+		return SerialConn{}, err
+	}
+
+	err = adaptor.ConnectSocket(sock, addr, port, localPort)
+	if err != nil {
+		adaptor.CloseSocket(sock)
+		return SerialConn{}, err
 	}
 
-	return &TCPSerialConn{SerialConn: SerialConn{Adaptor: ActiveDevice}, laddr: laddr, raddr: raddr}, nil
+	return SerialConn{Adaptor: adaptor, Socket: sock}, nil
 }
 
 // Dial connects to the address on the named network.
@@ -92,9 +113,132 @@ func Dial(network, address string) (Conn, error) {
 	}
 }
 
-// SerialConn is a loosely net.Conn compatible implementation
+// Listen announces on the local network address. The only network supported
+// is "tcp", and the host part of the address, if any, is ignored.
+// It tries to provide a mostly compatible interface to net.Listen().
+func Listen(network, address string) (Listener, error) {
+	switch network {
+	case "tcp":
+		laddr, err := resolveLocalTCPAddr(address)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := ListenTCP(network, laddr)
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	default:
+		return nil, errors.New("invalid network for listen")
+	}
+}
+
+// ListenTCP listens for incoming TCP connections on the port listed in laddr.
+func ListenTCP(network string, laddr *TCPAddr) (*TCPListener, error) {
+	adaptor := ActiveDevice
+	if adaptor == nil {
+		return nil, ErrNoDriver
+	}
+
+	sock, err := adaptor.ListenSocket(ProtocolTCP, laddr.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TCPListener{adaptor: adaptor, sock: sock, laddr: laddr}, nil
+}
+
+// resolveLocalTCPAddr parses a local address such as ":80" without looking
+// up the host.
+func resolveLocalTCPAddr(address string) (*TCPAddr, error) {
+	i := strings.LastIndex(address, ":")
+	if i < 0 {
+		return nil, errors.New("missing port in address")
+	}
+	port, err := strconv.Atoi(address[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	return &TCPAddr{IP: ParseIP(address[:i]), Port: port}, nil
+}
+
+// TCPListener is a loosely net.TCPListener compatible implementation,
+// which accepts connections on a listening socket of the adaptor.
+type TCPListener struct {
+	adaptor  DeviceDriver
+	sock     Socket
+	laddr    *TCPAddr
+	deadline time.Time
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *TCPListener) Accept() (Conn, error) {
+	c, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AcceptTCP waits for the next incoming connection and returns it as a
+// TCPSerialConn. Every connection uses its own socket and must be closed
+// independently of the listener.
+func (l *TCPListener) AcceptTCP() (*TCPSerialConn, error) {
+	for {
+		if l.sock == NoSocket {
+			return nil, ErrInvalidSocket
+		}
+		if !l.deadline.IsZero() && !time.Now().Before(l.deadline) {
+			return nil, ErrDeadlineExceeded
+		}
+		sock, err := l.adaptor.AcceptSocket(l.sock)
+		if err != nil {
+			return nil, err
+		}
+		if sock != NoSocket {
+			return NewTCPSerialConn(SerialConn{Adaptor: l.adaptor, Socket: sock}, l.laddr, nil), nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Close stops listening on the TCP address. Already accepted connections
+// are not closed.
+func (l *TCPListener) Close() error {
+	if l.sock == NoSocket {
+		return ErrInvalidSocket
+	}
+	err := l.adaptor.CloseSocket(l.sock)
+	l.sock = NoSocket
+	return err
+}
+
+// Addr returns the listener's network address.
+func (l *TCPListener) Addr() Addr {
+	return l.laddr.opAddr()
+}
+
+// SetDeadline sets the deadline associated with the listener.
+// A zero time value disables the deadline.
+func (l *TCPListener) SetDeadline(t time.Time) error {
+	l.deadline = t
+	return nil
+}
+
+// SerialConn is a loosely net.Conn compatible implementation. Each
+// SerialConn refers to its own socket on the adaptor, so several of them can
+// be used at the same time.
 type SerialConn struct {
 	Adaptor DeviceDriver
+	Socket  Socket
+
+	// closed is set by Close, which may be called while another goroutine
+	// waits in Read
+	closed int32
+
+	readDeadline  time.Time
+	writeDeadline time.Time
 }
 
 // UDPSerialConn is a loosely net.Conn compatible intended to support
@@ -107,7 +251,7 @@ type UDPSerialConn struct {
 
 // NewUDPSerialConn returns a new UDPSerialConn/
 func NewUDPSerialConn(c SerialConn, laddr, raddr *UDPAddr) *UDPSerialConn {
-	return &UDPSerialConn{SerialConn: c, raddr: raddr}
+	return &UDPSerialConn{SerialConn: c, laddr: laddr, raddr: raddr}
 }
 
 // TCPSerialConn is a loosely net.Conn compatible intended to support
@@ -120,46 +264,110 @@ type TCPSerialConn struct {
 
 // NewTCPSerialConn returns a new TCPSerialConn/
 func NewTCPSerialConn(c SerialConn, laddr, raddr *TCPAddr) *TCPSerialConn {
-	return &TCPSerialConn{SerialConn: c, raddr: raddr}
+	return &TCPSerialConn{SerialConn: c, laddr: laddr, raddr: raddr}
 }
 
 // Read reads data from the connection.
-// TODO: implement the full method functionality:
 // Read can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetReadDeadline.
+// Read waits until data is received, the connection is closed or the
+// deadline is exceeded. Use IsDataAvailable to poll the connection without
+// waiting.
 func (c *SerialConn) Read(b []byte) (n int, err error) {
-	// read only the data that has been received via "+IPD" socket
-	return c.Adaptor.ReadSocket(b)
+	for {
+		if !c.open() {
+			return 0, ErrInvalidSocket
+		}
+		if c.deadlineExceeded(c.readDeadline) {
+			return 0, ErrDeadlineExceeded
+		}
+		if err := c.setAdaptorDeadline(c.readDeadline); err != nil {
+			return 0, err
+		}
+		n, err = c.Adaptor.RecvSocket(c.Socket, b)
+		if n > 0 || err != nil || len(b) == 0 {
+			return n, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 }
 
 // Write writes data to the connection.
-// TODO: implement the full method functionality for timeouts.
 // Write can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
+// The deadline is also passed to adaptors that implement DeadlineDriver, the
+// others may take their own time to confirm the data has been sent.
 func (c *SerialConn) Write(b []byte) (n int, err error) {
-	// specify that is a data transfer to the
-	// currently open socket, not commands to the ESP8266/ESP32.
-	err = c.Adaptor.StartSocketSend(len(b))
-	if err != nil {
-		return
+	if !c.open() {
+		return 0, ErrInvalidSocket
 	}
-	n, err = c.Adaptor.Write(b)
-	if err != nil {
-		return n, err
+	if c.deadlineExceeded(c.writeDeadline) {
+		return 0, ErrDeadlineExceeded
 	}
-	/* TODO(bcg): this is kind of specific to espat, should maybe refactor */
-	_, err = c.Adaptor.Response(1000)
-	if err != nil {
-		return n, err
+	if err := c.setAdaptorDeadline(c.writeDeadline); err != nil {
+		return 0, err
+	}
+	return c.Adaptor.SendSocket(c.Socket, b)
+}
+
+// IsDataAvailable returns if there is data available to be read from the
+// connection without waiting.
+func (c *SerialConn) IsDataAvailable() bool {
+	if !c.open() {
+		return false
 	}
-	return n, err
+	return c.Adaptor.IsSocketDataAvailable(c.Socket)
 }
 
-// Close closes the connection.
-// Currently only supports a single Read or Write operations without blocking.
+// Close closes the connection. A Read waiting for data in another goroutine
+// returns ErrInvalidSocket.
 func (c *SerialConn) Close() error {
-	c.Adaptor.DisconnectSocket()
-	return nil
+	if c.Socket == NoSocket || !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return ErrInvalidSocket
+	}
+	return c.Adaptor.CloseSocket(c.Socket)
+}
+
+// open reports whether the connection has a socket and has not been closed.
+func (c *SerialConn) open() bool {
+	return c.Socket != NoSocket && atomic.LoadInt32(&c.closed) == 0
+}
+
+// ReadFrom reads data from the connection, and returns the address of the
+// sender of the last datagram received.
+func (c *UDPSerialConn) ReadFrom(b []byte) (n int, addr Addr, err error) {
+	n, err = c.Read(b)
+	if err != nil || n == 0 {
+		return n, nil, err
+	}
+	ip, port, err := c.Adaptor.SocketRemoteAddr(c.Socket)
+	if err != nil {
+		return n, nil, err
+	}
+	return n, &UDPAddr{IP: ParseIP(ip), Port: port}, nil
+}
+
+// WriteTo sends a datagram to addr, which must be a *UDPAddr. It returns
+// ErrNotSupported if the adaptor does not implement UDPDriver.
+func (c *UDPSerialConn) WriteTo(b []byte, addr Addr) (n int, err error) {
+	if !c.open() {
+		return 0, ErrInvalidSocket
+	}
+	a, ok := addr.(*UDPAddr)
+	if !ok {
+		return 0, errors.New("net: WriteTo needs a *UDPAddr")
+	}
+	d, ok := c.Adaptor.(UDPDriver)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	if c.deadlineExceeded(c.writeDeadline) {
+		return 0, ErrDeadlineExceeded
+	}
+	if err := c.setAdaptorDeadline(c.writeDeadline); err != nil {
+		return 0, err
+	}
+	return d.SendSocketTo(c.Socket, b, a.IP.String(), a.Port)
 }
 
 // LocalAddr returns the local network address.
@@ -169,7 +377,7 @@ func (c *UDPSerialConn) LocalAddr() Addr {
 
 // RemoteAddr returns the remote network address.
 func (c *UDPSerialConn) RemoteAddr() Addr {
-	return c.laddr.opAddr()
+	return c.raddr.opAddr()
 }
 
 func (c *UDPSerialConn) opConn() Conn {
@@ -186,7 +394,7 @@ func (c *TCPSerialConn) LocalAddr() Addr {
 
 // RemoteAddr returns the remote network address.
 func (c *TCPSerialConn) RemoteAddr() Addr {
-	return c.laddr.opAddr()
+	return c.raddr.opAddr()
 }
 
 func (c *TCPSerialConn) opConn() Conn {
@@ -212,6 +420,8 @@ func (c *TCPSerialConn) opConn() Conn {
 //
 // A zero value for t means I/O operations will not time out.
 func (c *SerialConn) SetDeadline(t time.Time) error {
+	c.readDeadline = t
+	c.writeDeadline = t
 	return nil
 }
 
@@ -219,6 +429,7 @@ func (c *SerialConn) SetDeadline(t time.Time) error {
 // and any currently-blocked Read call.
 // A zero value for t means Read will not time out.
 func (c *SerialConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline = t
 	return nil
 }
 
@@ -228,6 +439,20 @@ func (c *SerialConn) SetReadDeadline(t time.Time) error {
 // some of the data was successfully written.
 // A zero value for t means Write will not time out.
 func (c *SerialConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return nil
+}
+
+func (c *SerialConn) deadlineExceeded(t time.Time) bool {
+	return !t.IsZero() && !time.Now().Before(t)
+}
+
+// setAdaptorDeadline passes the deadline of the operation about to start to
+// the adaptor, if it implements DeadlineDriver.
+func (c *SerialConn) setAdaptorDeadline(t time.Time) error {
+	if d, ok := c.Adaptor.(DeadlineDriver); ok {
+		return d.SetSocketDeadline(c.Socket, t)
+	}
 	return nil
 }
 
@@ -239,11 +464,10 @@ func ResolveTCPAddr(network, address string) (*TCPAddr, error) {
 	// TODO: make sure network is 'tcp'
 	// separate domain from port, if any
 	r := strings.Split(address, ":")
-	addr, err := ActiveDevice.GetDNS(r[0])
+	ip, err := lookup(r[0])
 	if err != nil {
 		return nil, err
 	}
-	ip := IP(addr)
 	if len(r) > 1 {
 		port, e := strconv.Atoi(r[1])
 		if e != nil {
@@ -262,11 +486,10 @@ func ResolveUDPAddr(network, address string) (*UDPAddr, error) {
 	// TODO: make sure network is 'udp'
 	// separate domain from port, if any
 	r := strings.Split(address, ":")
-	addr, err := ActiveDevice.GetDNS(r[0])
+	ip, err := lookup(r[0])
 	if err != nil {
 		return nil, err
 	}
-	ip := IP(addr)
 	if len(r) > 1 {
 		port, e := strconv.Atoi(r[1])
 		if e != nil {
@@ -278,6 +501,23 @@ func ResolveUDPAddr(network, address string) (*UDPAddr, error) {
 	return &UDPAddr{IP: ip}, nil
 }
 
+// lookup returns the IP address of host with the ActiveResolver, or the
+// ActiveDevice.
+func lookup(host string) (IP, error) {
+	r := ActiveResolver
+	if r == nil {
+		if ActiveDevice == nil {
+			return nil, ErrNoDriver
+		}
+		r = ActiveDevice
+	}
+	addr, err := r.GetDNS(host)
+	if err != nil {
+		return nil, err
+	}
+	return IP(addr), nil
+}
+
 // The following definitions are here to support a Golang standard package
 // net-compatible interface for IP until TinyGo can compile the net package.
 
@@ -403,6 +643,38 @@ type Conn interface {
 	SetWriteDeadline(t time.Time) error
 }
 
+// Listener is a generic network listener for stream-oriented protocols.
+// This interface is from the Go standard library.
+type Listener interface {
+	// Accept waits for and returns the next connection to the listener.
+	Accept() (Conn, error)
+
+	// Close closes the listener.
+	// Any blocked Accept operations will be unblocked and return errors.
+	Close() error
+
+	// Addr returns the listener's network address.
+	Addr() Addr
+}
+
+// Error represents a network error.
+// This interface is from the Go standard library.
+type Error interface {
+	error
+	Timeout() bool   // Is the error a timeout?
+	Temporary() bool // Is the error temporary?
+}
+
+// ErrDeadlineExceeded is returned by the connections when a read or write
+// deadline has been exceeded. It is a net.Error with Timeout() == true.
+var ErrDeadlineExceeded error = &timeoutError{}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
 // Addr represents a network end point address.
 type Addr interface {
 	Network() string // name of the network (for example, "tcp", "udp")
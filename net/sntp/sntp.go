@@ -0,0 +1,291 @@
+// Package sntp is a Simple Network Time Protocol (RFC 4330) client built on
+// the net package of the drivers, to get the time on boards that have no
+// real-time clock or whose clock has not been set.
+//
+// The time can be applied to the clock of the board, on TinyGo with
+// runtime.AdjustTimeOffset(resp.ClockOffset), or to a real-time clock such as
+// a DS3231 or a DS1307 with SetRTC:
+//
+//	if err := sntp.SetRTC(rtc); err != nil {
+//		println(err.Error())
+//	}
+package sntp // import "tinygo.org/x/drivers/net/sntp"
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+)
+
+// DefaultServer is the server used when none is set.
+const DefaultServer = "pool.ntp.org"
+
+// Port is the UDP port of NTP servers.
+const Port = 123
+
+const (
+	packetSize = 48
+
+	// seconds from the NTP epoch, 1900, to the Unix epoch
+	ntpEpochOffset = 2208988800
+
+	modeClient = 3
+	modeServer = 4
+	version    = 4
+
+	leapNotSynchronized = 3
+)
+
+var (
+	// ErrTimeout is returned when the server did not respond to any of the
+	// requests.
+	ErrTimeout = errors.New("sntp: timeout")
+
+	// ErrInvalidResponse is returned when the response of the server cannot
+	// be used, such as when it is not from a server or has no transmit time.
+	ErrInvalidResponse = errors.New("sntp: invalid response")
+
+	// ErrNotSynchronized is returned when the clock of the server is not
+	// synchronized itself.
+	ErrNotSynchronized = errors.New("sntp: server not synchronized")
+)
+
+// KissOfDeathError is returned when the server responds with a
+// Kiss-o'-Death packet, a response of stratum 0 telling the client to stop
+// sending requests. The most common codes are "RATE", when the client sends
+// requests too often and must reduce its rate, and "DENY" or "RSTR", when
+// the client must stop using the server.
+type KissOfDeathError struct {
+	Code string
+}
+
+func (e *KissOfDeathError) Error() string {
+	return "sntp: kiss-o'-death " + e.Code
+}
+
+// A Client is an SNTP client. Its zero value is a usable client that queries
+// DefaultServer.
+type Client struct {
+	// Server is the host name or IP address of the server, with an optional
+	// port. Empty means DefaultServer.
+	Server string
+
+	// Timeout is the time to wait for a response to each request. Zero means
+	// 5 seconds.
+	Timeout time.Duration
+
+	// Retries is the number of requests sent again after a timeout. Zero
+	// means 2, use -1 to send a single request.
+	Retries int
+}
+
+// DefaultClient is the default Client and is used by Query and SetRTC.
+var DefaultClient = &Client{}
+
+// Response is the time received from a server.
+type Response struct {
+	// Time is the time at which the server sent the response.
+	Time time.Time
+
+	// ClockOffset is the offset of the local clock from the server: the time
+	// of the server is time.Now().Add(ClockOffset).
+	ClockOffset time.Duration
+
+	// RTT is the round-trip delay of the request, not counting the time spent
+	// by the server.
+	RTT time.Duration
+
+	// Stratum is the distance of the server from its reference clock, 1 for
+	// a server with its own reference clock.
+	Stratum uint8
+
+	// Leap is the leap indicator: 1 if the last minute of the day has 61
+	// seconds, 2 if it has 59 seconds.
+	Leap uint8
+
+	// ReferenceID identifies the reference clock of a stratum 1 server, or
+	// the IPv4 address of the upstream server.
+	ReferenceID uint32
+
+	// RootDelay and RootDispersion are the round-trip delay and the error
+	// to the reference clock.
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+}
+
+// Now returns the current time of the server, as estimated from the local
+// clock.
+func (r *Response) Now() time.Time {
+	return time.Now().Add(r.ClockOffset)
+}
+
+// RTC is a real-time clock, such as ds3231.Device or ds1307.Device.
+type RTC interface {
+	SetTime(t time.Time) error
+}
+
+// Query gets the time from the server of the DefaultClient.
+func Query() (*Response, error) {
+	return DefaultClient.Query()
+}
+
+// SetRTC sets rtc to the time of the server of the DefaultClient.
+func SetRTC(rtc RTC) error {
+	return DefaultClient.SetRTC(rtc)
+}
+
+// SetRTC sets rtc to the time of the server, in UTC.
+func (c *Client) SetRTC(rtc RTC) error {
+	resp, err := c.Query()
+	if err != nil {
+		return err
+	}
+	return rtc.SetTime(resp.Now().UTC())
+}
+
+// Query gets the time from the server. Requests that time out are sent again
+// up to Retries times, but a Kiss-o'-Death is returned right away as a
+// *KissOfDeathError.
+func (c *Client) Query() (*Response, error) {
+	addr, err := net.ResolveUDPAddr("udp", c.address())
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	attempts := c.Retries + 1
+	switch {
+	case c.Retries == 0:
+		attempts = 3
+	case c.Retries < 0:
+		attempts = 1
+	}
+
+	for i := 0; i < attempts; i++ {
+		resp, err := query(conn, timeout)
+		if err != ErrTimeout {
+			return resp, err
+		}
+	}
+	return nil, ErrTimeout
+}
+
+// address returns the address of the server, with the default port if it has
+// none.
+func (c *Client) address() string {
+	server := c.Server
+	if server == "" {
+		server = DefaultServer
+	}
+	if !strings.Contains(server, ":") {
+		server += ":" + strconv.Itoa(Port)
+	}
+	return server
+}
+
+// query sends a request and waits for its response. Responses that do not
+// match the request, such as the late response to a previous request, are
+// skipped.
+func query(conn *net.UDPSerialConn, timeout time.Duration) (*Response, error) {
+	var req, buf [packetSize]byte
+	req[0] = version<<3 | modeClient
+
+	// the transmit time of the request is returned by the server as the
+	// origin time of the response
+	sent := time.Now()
+	origin := toNTP(sent)
+	binary.BigEndian.PutUint64(req[40:], origin)
+
+	conn.SetDeadline(sent.Add(timeout))
+	if _, err := conn.Write(req[:]); err != nil {
+		return nil, err
+	}
+	for {
+		n, err := conn.Read(buf[:])
+		if err == net.ErrDeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		if err != nil {
+			return nil, err
+		}
+		received := time.Now()
+		if n < packetSize || binary.BigEndian.Uint64(buf[24:]) != origin {
+			continue
+		}
+		return parse(buf[:], sent, received)
+	}
+}
+
+// parse parses the response to a request sent at t1 and received at t4.
+func parse(b []byte, t1, t4 time.Time) (*Response, error) {
+	resp := &Response{
+		Leap:           b[0] >> 6,
+		Stratum:        b[1],
+		RootDelay:      fromShort(binary.BigEndian.Uint32(b[4:])),
+		RootDispersion: fromShort(binary.BigEndian.Uint32(b[8:])),
+		ReferenceID:    binary.BigEndian.Uint32(b[12:]),
+	}
+	if mode := b[0] & 0x7; mode != modeServer {
+		return nil, ErrInvalidResponse
+	}
+	if resp.Stratum == 0 {
+		return nil, &KissOfDeathError{Code: strings.TrimRight(string(b[12:16]), "\x00")}
+	}
+	if resp.Leap == leapNotSynchronized {
+		return nil, ErrNotSynchronized
+	}
+	transmit := binary.BigEndian.Uint64(b[40:])
+	if transmit == 0 {
+		return nil, ErrInvalidResponse
+	}
+
+	// t2 and t3 are the times the server received the request and sent the
+	// response
+	t2 := fromNTP(binary.BigEndian.Uint64(b[32:]))
+	t3 := fromNTP(transmit)
+	resp.Time = t3
+	resp.ClockOffset = (t2.Sub(t1) + t3.Sub(t4)) / 2
+	resp.RTT = t4.Sub(t1) - t3.Sub(t2)
+	if resp.RTT < 0 {
+		resp.RTT = 0
+	}
+	return resp, nil
+}
+
+// toNTP returns t as an NTP timestamp: 32 bits of seconds since 1900 and 32
+// bits of fraction.
+func toNTP(t time.Time) uint64 {
+	secs := uint64(t.Unix()+ntpEpochOffset) & 0xFFFFFFFF
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+// fromNTP returns the time of an NTP timestamp. As per RFC 4330, timestamps
+// with the most significant bit unset are after 2036, when the seconds wrap
+// around.
+func fromNTP(ts uint64) time.Time {
+	secs := int64(ts >> 32)
+	if secs&0x80000000 == 0 {
+		secs += 1 << 32
+	}
+	nsecs := int64((ts & 0xFFFFFFFF) * 1e9 >> 32)
+	return time.Unix(secs-ntpEpochOffset, nsecs)
+}
+
+// fromShort returns the duration of an NTP short format value: 16 bits of
+// seconds and 16 bits of fraction.
+func fromShort(v uint32) time.Duration {
+	return time.Duration(uint64(v) * uint64(time.Second) >> 16)
+}
@@ -0,0 +1,115 @@
+package sntp
+
+import (
+	"encoding/binary"
+	stdnet "net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+type rtc struct{ t time.Time }
+
+func (r *rtc) SetTime(t time.Time) error { r.t = t; return nil }
+
+func server(t *testing.T, offset time.Duration, respond func(req, resp []byte) bool) string {
+	c, err := stdnet.ListenUDP("udp", &stdnet.UDPAddr{IP: stdnet.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		b := make([]byte, 64)
+		for {
+			n, from, err := c.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			now := time.Now().Add(offset)
+			resp := make([]byte, 48)
+			resp[0] = 0<<6 | 4<<3 | 4
+			resp[1] = 2
+			copy(resp[24:32], b[40:48])
+			binary.BigEndian.PutUint64(resp[32:], toNTP(now))
+			binary.BigEndian.PutUint64(resp[40:], toNTP(now.Add(time.Millisecond)))
+			if respond != nil && !respond(b[:n], resp) {
+				continue
+			}
+			c.WriteToUDP(resp, from)
+		}
+	}()
+	return "127.0.0.1:" + strconv.Itoa(c.LocalAddr().(*stdnet.UDPAddr).Port)
+}
+
+func TestQuery(t *testing.T) {
+	net.UseDriver(loopback.New())
+	addr := server(t, time.Hour, nil)
+	c := &Client{Server: addr, Timeout: time.Second}
+	resp, err := c.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := resp.ClockOffset - time.Hour; d > 50*time.Millisecond || d < -50*time.Millisecond {
+		t.Fatal(resp.ClockOffset)
+	}
+	if resp.Stratum != 2 || resp.RTT > time.Second {
+		t.Fatal(resp)
+	}
+	var r rtc
+	if err := c.SetRTC(&r); err != nil {
+		t.Fatal(err)
+	}
+	if d := r.t.Sub(time.Now().Add(time.Hour)); d > time.Second || d < -time.Second || r.t.Location() != time.UTC {
+		t.Fatal(r.t)
+	}
+}
+
+func TestRetryAndKoD(t *testing.T) {
+	net.UseDriver(loopback.New())
+	var count int32
+	addr := server(t, 0, func(req, resp []byte) bool {
+		return atomic.AddInt32(&count, 1) > 1
+	})
+	c := &Client{Server: addr, Timeout: 200 * time.Millisecond}
+	if _, err := c.Query(); err != nil {
+		t.Fatal(err)
+	}
+	c.Retries = -1
+	atomic.StoreInt32(&count, -10)
+	if _, err := c.Query(); err != ErrTimeout {
+		t.Fatal(err)
+	}
+
+	addr = server(t, 0, func(req, resp []byte) bool {
+		resp[1] = 0
+		copy(resp[12:], "RATE")
+		return true
+	})
+	_, err := (&Client{Server: addr}).Query()
+	if k, ok := err.(*KissOfDeathError); !ok || k.Code != "RATE" {
+		t.Fatal(err)
+	}
+
+	addr = server(t, 0, func(req, resp []byte) bool {
+		resp[0] = 4<<3 | modeClient
+		return true
+	})
+	if _, err := (&Client{Server: addr}).Query(); err != ErrInvalidResponse {
+		t.Fatal(err)
+	}
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Date(2040, 1, 2, 3, 4, 5, 500000000, time.UTC)
+	if got := fromNTP(toNTP(now)); got.Sub(now) > time.Microsecond || now.Sub(got) > time.Microsecond {
+		t.Fatal(got)
+	}
+	now = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if got := fromNTP(toNTP(now)); !got.Equal(now) {
+		t.Fatal(got)
+	}
+}
@@ -3,36 +3,117 @@
 package tls
 
 import (
+	"errors"
 	"strconv"
+	"strings"
 
 	"tinygo.org/x/drivers/net"
 )
 
+// ErrNotSupported is returned by Dial when the config sets certificates and
+// the adaptor cannot be configured.
+var ErrNotSupported = errors.New("tls: config not supported by the device driver")
+
+// DeviceDriver is implemented by the net.DeviceDriver of adaptors that can
+// set up the TLS connections they make, such as espat and wifinina.
+type DeviceDriver interface {
+	// ConfigureTLS sets up a socket opened for net.ProtocolTLS before it is
+	// connected.
+	ConfigureTLS(sock net.Socket, config *Config) error
+}
+
 // Dial makes a TLS network connection. It tries to provide a mostly compatible interface
 // to tls.Dial().
-// Dial connects to the given network address.
+// Dial connects to the given network address. As with the standard library,
+// a nil config is the zero configuration, and an empty ServerName defaults
+// to the host of address, unless it is an IP address (RFC 6066 does not
+// allow IP addresses in the SNI extension).
 func Dial(network, address string, config *Config) (*net.TCPSerialConn, error) {
+	adaptor := net.ActiveDevice
+	if adaptor == nil {
+		return nil, net.ErrNoDriver
+	}
 	raddr, err := net.ResolveTCPAddr(network, address)
 	if err != nil {
 		return nil, err
 	}
 
-	addr := raddr.IP.String()
-	sendport := strconv.Itoa(raddr.Port)
+	c := Config{}
+	if config != nil {
+		c = *config
+	}
+	if host := hostname(address); c.ServerName == "" && !isIP(host) {
+		c.ServerName = host
+	}
 
-	// disconnect any old socket
-	net.ActiveDevice.DisconnectSocket()
+	// open new socket
+	sock, err := adaptor.OpenSocket(net.ProtocolTLS)
+	if err != nil {
+		return nil, err
+	}
 
-	// connect new socket
-	err = net.ActiveDevice.ConnectSSLSocket(addr, sendport)
+	// configure and connect it
+	if d, ok := adaptor.(DeviceDriver); ok {
+		err = d.ConfigureTLS(sock, &c)
+	} else if c.RootCAs != nil || c.Certificate != nil || c.PrivateKey != nil {
+		err = ErrNotSupported
+	}
+	if err == nil {
+		err = adaptor.ConnectSocket(sock, raddr.IP.String(), raddr.Port, 0)
+	}
 	if err != nil {
+		adaptor.CloseSocket(sock)
 		return nil, err
 	}
 
-	return net.NewTCPSerialConn(net.SerialConn{Adaptor: net.ActiveDevice}, nil, raddr), nil
+	return net.NewTCPSerialConn(net.SerialConn{Adaptor: adaptor, Socket: sock}, nil, raddr), nil
 }
 
-// Config is a placeholder for future compatibility with
-// tls.Config.
+// hostname returns the host of an address in "host:port" form.
+func hostname(address string) string {
+	if i := strings.LastIndexByte(address, ':'); i >= 0 {
+		address = address[:i]
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+}
+
+// isIP reports whether host is an IPv4 or IPv6 address rather than a domain
+// name.
+func isIP(host string) bool {
+	if strings.IndexByte(host, ':') >= 0 {
+		return true
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) != 4 {
+		return false
+	}
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || p[0] == '+' || p[0] == '-' || n > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// Config is a subset of the standard tls.Config. The certificates are handed
+// over to the adaptor, which does all the TLS processing, so their format
+// depends on it: the wifinina driver takes them PEM encoded, while the espat
+// driver needs them converted with the AtPKI.py tool of ESP-AT, and returns
+// an error for PEM data.
 type Config struct {
+	// ServerName is sent to the server in the SNI extension and used to
+	// verify its certificate. Dial defaults it to the host it connects to,
+	// if that is not an IP address.
+	ServerName string
+
+	// RootCAs holds the certificates of the authorities used to verify the
+	// server. If nil, the adaptor uses its defaults. The wifinina driver
+	// does not support it, its firmware always uses its own store.
+	RootCAs []byte
+
+	// Certificate and PrivateKey hold the client certificate and its key,
+	// to authenticate to servers requiring mutual TLS.
+	Certificate []byte
+	PrivateKey  []byte
 }
@@ -0,0 +1,87 @@
+package tls_test
+
+import (
+	"testing"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/tls"
+)
+
+// driver is a fake adaptor that records the TLS configuration of its
+// sockets. Its connections do nothing.
+type driver struct {
+	config *tls.Config
+	closed int
+}
+
+func (d *driver) GetDNS(domain string) (string, error)                 { return "10.0.0.1", nil }
+func (d *driver) OpenSocket(protocol net.Protocol) (net.Socket, error) { return 0, nil }
+func (d *driver) ConnectSocket(sock net.Socket, addr string, port, localPort int) error {
+	return nil
+}
+func (d *driver) SendSocket(sock net.Socket, b []byte) (int, error)     { return len(b), nil }
+func (d *driver) RecvSocket(sock net.Socket, b []byte) (int, error)     { return 0, nil }
+func (d *driver) IsSocketDataAvailable(sock net.Socket) bool            { return false }
+func (d *driver) SocketRemoteAddr(sock net.Socket) (string, int, error) { return "", 0, nil }
+func (d *driver) ListenSocket(protocol net.Protocol, port int) (net.Socket, error) {
+	return net.NoSocket, net.ErrNotSupported
+}
+func (d *driver) AcceptSocket(sock net.Socket) (net.Socket, error) { return net.NoSocket, nil }
+func (d *driver) CloseSocket(sock net.Socket) error                { d.closed++; return nil }
+
+type tlsDriver struct{ driver }
+
+func (d *tlsDriver) ConfigureTLS(sock net.Socket, config *tls.Config) error {
+	d.config = config
+	return nil
+}
+
+func TestDialNoDriver(t *testing.T) {
+	net.UseDriver(nil)
+	if _, err := tls.Dial("tcp", "10.0.0.1:443", nil); err != net.ErrNoDriver {
+		t.Fatalf("got %v, want %v", err, net.ErrNoDriver)
+	}
+}
+
+func TestDialServerName(t *testing.T) {
+	d := &tlsDriver{}
+	net.UseDriver(d)
+	defer net.UseDriver(nil)
+
+	tests := []struct {
+		address string
+		config  *tls.Config
+		want    string
+	}{
+		{"example.com:443", nil, "example.com"},
+		{"example.com:443", &tls.Config{RootCAs: []byte("ca")}, "example.com"},
+		{"example.com:443", &tls.Config{ServerName: "other.test"}, "other.test"},
+		{"10.0.0.2:443", nil, ""},
+		{"10.0.0.256:443", nil, "10.0.0.256"},
+	}
+	for _, tt := range tests {
+		if _, err := tls.Dial("tcp", tt.address, tt.config); err != nil {
+			t.Fatal(err)
+		}
+		if d.config == nil || d.config.ServerName != tt.want {
+			t.Errorf("%s: got %+v, want server name %q", tt.address, d.config, tt.want)
+		}
+		if tt.config != nil && d.config == tt.config {
+			t.Errorf("%s: config of the caller modified", tt.address)
+		}
+	}
+}
+
+func TestDialNotSupported(t *testing.T) {
+	d := &driver{}
+	net.UseDriver(d)
+	defer net.UseDriver(nil)
+
+	if _, err := tls.Dial("tcp", "example.com:443", nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := tls.Dial("tcp", "example.com:443", &tls.Config{Certificate: []byte("cert"), PrivateKey: []byte("key")})
+	if err != tls.ErrNotSupported || d.closed != 1 {
+		t.Fatalf("got %v and %d closed sockets, want %v", err, d.closed, tls.ErrNotSupported)
+	}
+}
@@ -0,0 +1,217 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/http"
+	"tinygo.org/x/drivers/net/tls"
+)
+
+// ErrBadHandshake is returned when the server does not accept the opening
+// handshake, or responds with invalid handshake headers.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// key appended to the Sec-WebSocket-Key to compute the Sec-WebSocket-Accept of
+// the server
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Config holds the options of the opening handshake. A nil *Config is valid
+// and uses the defaults.
+type Config struct {
+	// Header is added to the handshake request, such as an Origin or an
+	// Authorization header.
+	Header http.Header
+
+	// Protocols are the subprotocols offered to the server, in order of
+	// preference. Conn.Subprotocol returns the one it selected.
+	Protocols []string
+
+	// TLSConfig is used by Dial for "wss" URLs. If nil, the host of the URL
+	// is used as ServerName.
+	TLSConfig *tls.Config
+
+	// HandshakeTimeout is the time limit of the opening handshake. Zero means
+	// 10 seconds.
+	HandshakeTimeout time.Duration
+}
+
+// Dial connects to a "ws" or "wss" URL with net.Dial or tls.Dial, and runs
+// the opening handshake.
+func Dial(url string, config *Config) (*Conn, error) {
+	u, err := parseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	address := u.Hostname() + ":" + u.Port()
+	if u.Scheme == "https" {
+		var tlsConfig *tls.Config
+		if config != nil {
+			tlsConfig = config.TLSConfig
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: u.Hostname()}
+		}
+		conn, err = tls.Dial("tcp", address, tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewClient(conn, url, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient runs the opening handshake for url over an established
+// connection, such as one returned by net.Dial or tls.Dial. The connection is
+// not closed if the handshake fails.
+func NewClient(conn net.Conn, url string, config *Config) (*Conn, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	u, err := parseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	timeout := config.HandshakeTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	c := &Conn{conn: conn}
+	c.r = bufio.NewReaderSize(conn, ReadBufferSize)
+
+	w := bufio.NewWriterSize(conn, WriteBufferSize)
+	w.WriteString("GET " + u.RequestURI() + " HTTP/1.1\r\n")
+	w.WriteString("Host: " + u.Host + "\r\n")
+	w.WriteString("Upgrade: websocket\r\n")
+	w.WriteString("Connection: Upgrade\r\n")
+	w.WriteString("Sec-WebSocket-Key: " + key + "\r\n")
+	w.WriteString("Sec-WebSocket-Version: 13\r\n")
+	if len(config.Protocols) > 0 {
+		w.WriteString("Sec-WebSocket-Protocol: " + strings.Join(config.Protocols, ", ") + "\r\n")
+	}
+	for k, values := range config.Header {
+		for _, v := range values {
+			w.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	w.WriteString("\r\n")
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	if err := c.readHandshake(key, config.Protocols); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readHandshake reads the response of the server to the opening handshake.
+func (c *Conn) readHandshake(key string, protocols []string) error {
+	line, err := readLine(c.r)
+	if err != nil {
+		return err
+	}
+	// status line, such as "HTTP/1.1 101 Switching Protocols"
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+		return errors.New("websocket: malformed status line: " + line)
+	}
+	if code, _ := strconv.Atoi(fields[1]); code != 101 {
+		return ErrBadHandshake
+	}
+
+	header := make(http.Header)
+	for {
+		line, err := readLine(c.r)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return errors.New("websocket: malformed header line: " + line)
+		}
+		header.Add(line[:i], strings.TrimSpace(line[i+1:]))
+	}
+
+	h := sha1.New()
+	io.WriteString(h, key+acceptGUID)
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(header.Get("Connection")), "upgrade") ||
+		header.Get("Sec-WebSocket-Accept") != accept {
+		return ErrBadHandshake
+	}
+
+	// the server must select one of the subprotocols offered, if any
+	c.subprotocol = header.Get("Sec-WebSocket-Protocol")
+	if c.subprotocol != "" {
+		found := false
+		for _, p := range protocols {
+			found = found || p == c.subprotocol
+		}
+		if !found {
+			return ErrBadHandshake
+		}
+	}
+	return nil
+}
+
+// parseURL parses a "ws" or "wss" URL as an "http" or "https" one.
+func parseURL(url string) (*http.URL, error) {
+	i := strings.Index(url, "://")
+	if i < 0 {
+		return nil, errors.New("websocket: missing scheme in URL")
+	}
+	switch strings.ToLower(url[:i]) {
+	case "ws":
+		return http.ParseURL("http" + url[i:])
+	case "wss":
+		return http.ParseURL("https" + url[i:])
+	default:
+		return nil, errors.New("websocket: unsupported scheme " + url[:i])
+	}
+}
+
+// readLine reads a line without the trailing "\r\n". The line must fit in
+// the buffer of r, so that the memory used for the header is bounded.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errors.New("websocket: header line too long")
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
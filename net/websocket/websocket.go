@@ -0,0 +1,484 @@
+// Package websocket is a WebSocket (RFC 6455) client built on the net and
+// net/tls packages of the drivers.
+//
+// The API is message oriented: WriteMessage sends a message in a single
+// frame and ReadMessage reads a whole message into a buffer of the caller.
+// Messages that do not fit in memory can be streamed as fragments with
+// NextWriter and read in parts with NextReader. Pings from the server are
+// answered while reading, and Close runs the closing handshake:
+//
+//	conn, err := websocket.Dial("wss://example.com/telemetry", nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer conn.Close()
+//	err = conn.WriteMessage(websocket.TextMessage, []byte(`{"temp":21.5}`))
+//
+// Memory use is bounded by the fixed size read and write buffers, no frame
+// is ever held in memory as a whole. A Conn is not safe for concurrent use.
+package websocket // import "tinygo.org/x/drivers/net/websocket"
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+)
+
+// ReadBufferSize is the size of the buffer used to read from the
+// connection, which is also the maximum length of a handshake header line.
+const ReadBufferSize = 512
+
+// WriteBufferSize is the size of the buffer used to mask the frames sent,
+// every write to the connection is at most this size.
+const WriteBufferSize = 256
+
+// CloseTimeout is how long Close waits for the server to close the
+// connection.
+const CloseTimeout = 2 * time.Second
+
+// MessageType is the type of a data message.
+type MessageType uint8
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// Opcodes of the frames.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes of the closing handshake.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const maxControlPayload = 125
+
+var (
+	// ErrProtocol is returned when the server violates the protocol. The
+	// connection is closed.
+	ErrProtocol = errors.New("websocket: protocol error")
+
+	// ErrMessageTooBig is returned by ReadMessage when the message does not
+	// fit in the buffer. The rest of the message is discarded.
+	ErrMessageTooBig = errors.New("websocket: message too big")
+
+	// ErrClosed is returned when writing after Close, or after the server
+	// started the closing handshake.
+	ErrClosed = errors.New("websocket: connection closed")
+)
+
+// CloseError is returned by the reads once the server has closed the
+// connection, with the code and reason it sent.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	s := "websocket: closed with code " + strconv.Itoa(e.Code)
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// Conn is a WebSocket connection, returned by Dial or NewClient.
+type Conn struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	wbuf        [WriteBufferSize]byte
+	ctrl        [maxControlPayload]byte
+	subprotocol string
+
+	// PongHandler, if not nil, is called with the payload of the pongs
+	// received while reading.
+	PongHandler func(data []byte)
+
+	// state of the message being read: the bytes left in the current frame,
+	// and whether it is the last frame of the message
+	reading   bool
+	readLeft  int64
+	readFinal bool
+
+	// err is returned by the reads once the connection failed or was closed
+	// by the server
+	err       error
+	closeSent bool
+	closed    bool
+}
+
+// Subprotocol returns the subprotocol selected by the server, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetReadDeadline sets the deadline of the reads from the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of the writes to the connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// WriteMessage sends a message in a single frame.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	return c.writeFrame(byte(typ), true, data)
+}
+
+// NextWriter returns a writer for a message sent in fragments: every Write
+// sends a frame, and Close sends the final frame. No other message may be
+// sent until the writer is closed.
+func (c *Conn) NextWriter(typ MessageType) io.WriteCloser {
+	return &messageWriter{c: c, op: byte(typ)}
+}
+
+type messageWriter struct {
+	c  *Conn
+	op byte
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.c.writeFrame(w.op, false, p); err != nil {
+		return 0, err
+	}
+	w.op = opContinuation
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	return w.c.writeFrame(w.op, true, nil)
+}
+
+// Ping sends a ping with a payload of up to 125 bytes. The pong of the
+// server is passed to PongHandler while reading.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return ErrProtocol
+	}
+	return c.writeFrame(opPing, true, data)
+}
+
+// ReadMessage reads the next data message into buf, and returns its type and
+// length. If the message does not fit, buf is filled, the rest is discarded
+// and ErrMessageTooBig is returned.
+func (c *Conn) ReadMessage(buf []byte) (MessageType, int, error) {
+	typ, r, err := c.NextReader()
+	if err != nil {
+		return 0, 0, err
+	}
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err == io.EOF {
+			return typ, n, nil
+		}
+		if err != nil {
+			return typ, n, err
+		}
+	}
+	discarded, err := c.discard()
+	if err != nil {
+		return typ, n, err
+	}
+	if discarded > 0 {
+		return typ, n, ErrMessageTooBig
+	}
+	return typ, n, nil
+}
+
+// NextReader returns the type of the next data message, and a reader for its
+// payload, which returns io.EOF at the end of the message. The rest of the
+// previous message is discarded.
+func (c *Conn) NextReader() (MessageType, io.Reader, error) {
+	if _, err := c.discard(); err != nil {
+		return 0, nil, err
+	}
+	op, err := c.nextFrame()
+	if err != nil {
+		return 0, nil, err
+	}
+	if op == opContinuation {
+		return 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+	}
+	c.reading = true
+	return MessageType(op), messageReader{c}, nil
+}
+
+type messageReader struct {
+	c *Conn
+}
+
+func (r messageReader) Read(p []byte) (int, error) {
+	c := r.c
+	if !c.reading {
+		return 0, io.EOF
+	}
+	for c.readLeft == 0 {
+		if c.readFinal {
+			c.reading = false
+			return 0, io.EOF
+		}
+		op, err := c.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+		if op != opContinuation {
+			return 0, c.fail(CloseProtocolError, ErrProtocol)
+		}
+	}
+
+	if int64(len(p)) > c.readLeft {
+		p = p[:c.readLeft]
+	}
+	n, err := c.r.Read(p)
+	c.readLeft -= int64(n)
+	if err != nil {
+		return n, c.readError(err)
+	}
+	return n, nil
+}
+
+// discard reads the rest of the message being read, and returns its length.
+func (c *Conn) discard() (int, error) {
+	total := 0
+	for c.reading {
+		n, err := messageReader{c}.Read(c.ctrl[:])
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// nextFrame reads frame headers until a data frame, handling the control
+// frames on the way, and returns its opcode.
+func (c *Conn) nextFrame() (byte, error) {
+	for {
+		if c.err != nil {
+			return 0, c.err
+		}
+		var hdr [8]byte
+		if _, err := io.ReadFull(c.r, hdr[:2]); err != nil {
+			return 0, c.readError(err)
+		}
+		final := hdr[0]&0x80 != 0
+		op := hdr[0] & 0x0F
+		size := int64(hdr[1] & 0x7F)
+
+		// no extension is negotiated, and servers must not mask their frames
+		if hdr[0]&0x70 != 0 || hdr[1]&0x80 != 0 {
+			return 0, c.fail(CloseProtocolError, ErrProtocol)
+		}
+		switch size {
+		case 126:
+			if _, err := io.ReadFull(c.r, hdr[:2]); err != nil {
+				return 0, c.readError(err)
+			}
+			size = int64(binary.BigEndian.Uint16(hdr[:2]))
+		case 127:
+			if _, err := io.ReadFull(c.r, hdr[:8]); err != nil {
+				return 0, c.readError(err)
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[:8]))
+			if size < 0 {
+				return 0, c.fail(CloseProtocolError, ErrProtocol)
+			}
+		}
+
+		switch op {
+		case opContinuation, opText, opBinary:
+			c.readLeft, c.readFinal = size, final
+			return op, nil
+		case opClose, opPing, opPong:
+			if !final || size > maxControlPayload {
+				return 0, c.fail(CloseProtocolError, ErrProtocol)
+			}
+			payload := c.ctrl[:size]
+			if _, err := io.ReadFull(c.r, payload); err != nil {
+				return 0, c.readError(err)
+			}
+			if err := c.handleControl(op, payload); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, c.fail(CloseProtocolError, ErrProtocol)
+		}
+	}
+}
+
+// handleControl answers pings and close frames.
+func (c *Conn) handleControl(op byte, payload []byte) error {
+	switch op {
+	case opPing:
+		if c.closeSent {
+			return nil
+		}
+		return c.writeFrame(opPong, true, payload)
+	case opPong:
+		if c.PongHandler != nil {
+			c.PongHandler(payload)
+		}
+		return nil
+	}
+
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, ErrProtocol)
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+	}
+	if !c.closeSent {
+		// echo the status code of the server
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.writeFrame(opClose, true, payload)
+		c.closeSent = true
+	}
+	c.err = closeErr
+	return closeErr
+}
+
+// readError records the error of a read from the connection, other than a
+// timeout after which reading can go on.
+func (c *Conn) readError(err error) error {
+	if err == net.ErrDeadlineExceeded {
+		return err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return err
+}
+
+// fail starts the closing handshake with code after a protocol violation of
+// the server, and closes the connection.
+func (c *Conn) fail(code int, err error) error {
+	if !c.closeSent {
+		c.writeClose(code, "")
+	}
+	c.err = err
+	c.closed = true
+	c.conn.Close()
+	return err
+}
+
+// Close runs the closing handshake: it sends a close frame with
+// CloseNormalClosure, and waits up to CloseTimeout for the server to close
+// the connection. The messages received meanwhile are discarded.
+func (c *Conn) Close() error {
+	return c.CloseWithReason(CloseNormalClosure, "")
+}
+
+// CloseWithReason runs the closing handshake like Close, with the given code
+// and reason of up to 123 bytes.
+func (c *Conn) CloseWithReason(code int, reason string) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if !c.closeSent {
+		c.writeClose(code, reason)
+	}
+	if c.err == nil {
+		c.conn.SetReadDeadline(time.Now().Add(CloseTimeout))
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				break
+			}
+		}
+	}
+	c.closed = true
+	c.err = ErrClosed
+	return c.conn.Close()
+}
+
+// writeClose sends a close frame.
+func (c *Conn) writeClose(code int, reason string) error {
+	var payload [maxControlPayload]byte
+	binary.BigEndian.PutUint16(payload[:], uint16(code))
+	n := 2 + copy(payload[2:], reason)
+	err := c.writeFrame(opClose, true, payload[:n])
+	c.closeSent = true
+	return err
+}
+
+// writeFrame sends a frame, masked through the write buffer.
+func (c *Conn) writeFrame(op byte, final bool, data []byte) error {
+	if c.closeSent || c.closed {
+		return ErrClosed
+	}
+
+	b := c.wbuf[:2]
+	b[0] = op
+	if final {
+		b[0] |= 0x80
+	}
+	switch {
+	case len(data) < 126:
+		b[1] = 0x80 | byte(len(data))
+	case len(data) <= 0xFFFF:
+		b[1] = 0x80 | 126
+		b = b[:4]
+		binary.BigEndian.PutUint16(b[2:], uint16(len(data)))
+	default:
+		b[1] = 0x80 | 127
+		b = b[:10]
+		binary.BigEndian.PutUint64(b[2:], uint64(len(data)))
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	b = append(b, mask[:]...)
+
+	// the header is sent with the first part of the payload
+	for i := 0; ; {
+		for len(b) < len(c.wbuf) && i < len(data) {
+			b = append(b, data[i]^mask[i&3])
+			i++
+		}
+		if _, err := c.conn.Write(b); err != nil {
+			return err
+		}
+		if i == len(data) {
+			return nil
+		}
+		b = c.wbuf[:0]
+	}
+}
@@ -0,0 +1,272 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	stdnet "net"
+	"strings"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/loopback"
+)
+
+type frame struct {
+	fin     bool
+	op      byte
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: h[0]&0x80 != 0, op: h[0] & 0xF}
+	if h[1]&0x80 == 0 {
+		return f, errors.New("client frame not masked")
+	}
+	n := int(h[1] & 0x7F)
+	if n == 126 {
+		var b [2]byte
+		io.ReadFull(r, b[:])
+		n = int(binary.BigEndian.Uint16(b[:]))
+	} else if n == 127 {
+		var b [8]byte
+		io.ReadFull(r, b[:])
+		n = int(binary.BigEndian.Uint64(b[:]))
+	}
+	var mask [4]byte
+	io.ReadFull(r, mask[:])
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i&3]
+	}
+	return f, nil
+}
+
+func writeFrame(w io.Writer, fin bool, op byte, p []byte) {
+	b := []byte{op, 0}
+	if fin {
+		b[0] |= 0x80
+	}
+	switch {
+	case len(p) < 126:
+		b[1] = byte(len(p))
+	case len(p) <= 0xFFFF:
+		b[1] = 126
+		b = binary.BigEndian.AppendUint16(b, uint16(len(p)))
+	default:
+		b[1] = 127
+		b = binary.BigEndian.AppendUint64(b, uint64(len(p)))
+	}
+	w.Write(append(b, p...))
+}
+
+// server runs the handshake then calls handler.
+func server(t *testing.T, proto string, handler func(r *bufio.Reader, w stdnet.Conn)) string {
+	d := loopback.New()
+	net.UseDriver(d)
+	d.Handle("ws.test:80", func(conn stdnet.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var key string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			if strings.HasPrefix(line, "Sec-WebSocket-Key:") {
+				key = strings.TrimSpace(line[len("Sec-WebSocket-Key:"):])
+			}
+			if strings.HasPrefix(line, "X-Test:") && line != "X-Test: 1" {
+				t.Error(line)
+			}
+		}
+		h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n"
+		if proto != "" {
+			resp += "Sec-WebSocket-Protocol: " + proto + "\r\n"
+		}
+		conn.Write([]byte(resp + "\r\n"))
+		handler(r, conn)
+	})
+	return "ws://ws.test/echo"
+}
+
+func TestEcho(t *testing.T) {
+	url := server(t, "chat", func(r *bufio.Reader, w stdnet.Conn) {
+		for {
+			f, err := readFrame(r)
+			if err != nil {
+				if err != io.EOF {
+					t.Error(err)
+				}
+				return
+			}
+			switch f.op {
+			case 8:
+				writeFrame(w, true, 8, f.payload)
+				return
+			case 9:
+				writeFrame(w, true, 10, f.payload)
+			case 10:
+			default:
+				if !f.fin || f.op == 0 {
+					writeFrame(w, f.fin, f.op, f.payload)
+					continue
+				}
+				// echo fragmented with a ping in between
+				writeFrame(w, false, f.op, f.payload[:len(f.payload)/2])
+				writeFrame(w, true, 9, []byte("hb"))
+				writeFrame(w, true, 0, f.payload[len(f.payload)/2:])
+			}
+		}
+	})
+	c, err := Dial(url, &Config{Protocols: []string{"chat"}, Header: map[string][]string{"X-Test": {"1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subprotocol() != "chat" {
+		t.Fatal(c.Subprotocol())
+	}
+	var pong []byte
+	c.PongHandler = func(b []byte) { pong = append([]byte(nil), b...) }
+
+	buf := make([]byte, 70000)
+	for _, size := range []int{5, 200, 1000, 70000} {
+		msg := bytes.Repeat([]byte("x"), size)
+		msg[0] = 'a'
+		if err := c.WriteMessage(BinaryMessage, msg); err != nil {
+			t.Fatal(err)
+		}
+		typ, n, err := c.ReadMessage(buf)
+		if err != nil || typ != BinaryMessage || !bytes.Equal(buf[:n], msg) {
+			t.Fatal(size, typ, n, err)
+		}
+	}
+
+	// fragmented write (the server echoes each frame)
+	w := c.NextWriter(TextMessage)
+	w.Write([]byte("hello "))
+	w.Write([]byte("world"))
+	w.Close()
+	typ, n, err := c.ReadMessage(buf)
+	if err != nil || typ != TextMessage || string(buf[:n]) != "hello world" {
+		t.Fatalf("%v %q %v", typ, buf[:n], err)
+	}
+	t.Log("fragments ok")
+
+	if err := c.Ping([]byte("p1")); err != nil {
+		t.Fatal(err)
+	}
+	c.WriteMessage(TextMessage, []byte("toolongmessage"))
+	_, n, err = c.ReadMessage(buf[:4])
+	if err != ErrMessageTooBig || string(buf[:n]) != "tool" {
+		t.Fatal(n, err)
+	}
+	if string(pong) != "p1" {
+		t.Fatalf("pong %q", pong)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("x")); err != ErrClosed {
+		t.Fatal(err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	got := make(chan frame, 1)
+	url := server(t, "", func(r *bufio.Reader, w stdnet.Conn) {
+		writeFrame(w, true, 1, []byte("bye"))
+		writeFrame(w, true, 8, append([]byte{0x03, 0xE9}, "going"...))
+		f, _ := readFrame(r)
+		got <- f
+	})
+	c, err := Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if _, n, err := c.ReadMessage(buf); err != nil || string(buf[:n]) != "bye" {
+		t.Fatal(err)
+	}
+	_, _, err = c.ReadMessage(buf)
+	ce, ok := err.(*CloseError)
+	if !ok || ce.Code != CloseGoingAway || ce.Reason != "going" {
+		t.Fatal(err)
+	}
+	select {
+	case f := <-got:
+		if f.op != 8 || !bytes.Equal(f.payload, []byte{0x03, 0xE9}) {
+			t.Fatal(f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no close echo")
+	}
+	c.Close()
+}
+
+func TestBadHandshake(t *testing.T) {
+	d := loopback.New()
+	net.UseDriver(d)
+	d.Handle("bad.test:80", func(conn stdnet.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+		conn.Close()
+	})
+	if _, err := Dial("ws://bad.test/", nil); err != ErrBadHandshake {
+		t.Fatal(err)
+	}
+	url := server(t, "other", func(r *bufio.Reader, w stdnet.Conn) {})
+	if _, err := Dial(url, &Config{Protocols: []string{"chat"}}); err != ErrBadHandshake {
+		t.Fatal(err)
+	}
+
+	// the accept key must be derived from the key sent
+	d.Handle("ws.test:80", func(conn stdnet.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		h := sha1.Sum([]byte("dGhlIHNhbXBsZSBub25jZQ==258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+			base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n"))
+		conn.Close()
+	})
+	net.UseDriver(d)
+	if _, err := Dial(url, nil); err != ErrBadHandshake {
+		t.Fatal(err)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	got := make(chan frame, 1)
+	url := server(t, "", func(r *bufio.Reader, w stdnet.Conn) {
+		writeFrame(w, true, 0, []byte("cont"))
+		f, _ := readFrame(r)
+		got <- f
+	})
+	c, err := Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ReadMessage(make([]byte, 8)); err != ErrProtocol {
+		t.Fatal(err)
+	}
+	f := <-got
+	if f.op != 8 || binary.BigEndian.Uint16(f.payload) != CloseProtocolError {
+		t.Fatal(f)
+	}
+}
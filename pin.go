@@ -0,0 +1,16 @@
+package drivers
+
+// Pin represents a GPIO pin. It is notably implemented by the machine.Pin
+// type, but can also be implemented by a pin of an I/O expander or a fake pin
+// for testing. Pins that are not a machine.Pin must be configured as an input
+// or an output by the caller.
+type Pin interface {
+	// Get returns the current level of the pin, true for high.
+	Get() bool
+
+	// High sets the pin to high, when configured as an output.
+	High()
+
+	// Low sets the pin to low, when configured as an output.
+	Low()
+}
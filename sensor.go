@@ -0,0 +1,63 @@
+package drivers
+
+// The interfaces in this file describe common sensor capabilities, so that
+// application code can work with any sensor that provides a given kind of
+// measurement. All values are returned as fixed-point integers in the units
+// documented on each method, to avoid floating point math on small
+// microcontrollers.
+
+// Thermometer is a sensor that measures temperature.
+type Thermometer interface {
+	// ReadTemperature returns the temperature in celsius milli degrees
+	// (°C/1000).
+	ReadTemperature() (int32, error)
+}
+
+// Barometer is a sensor that measures atmospheric pressure.
+type Barometer interface {
+	// ReadPressure returns the pressure in milli pascals (mPa).
+	ReadPressure() (int32, error)
+}
+
+// Hygrometer is a sensor that measures relative humidity.
+type Hygrometer interface {
+	// ReadHumidity returns the relative humidity in hundredths of a percent.
+	ReadHumidity() (int32, error)
+}
+
+// Accelerometer is a sensor that measures acceleration along three axes.
+type Accelerometer interface {
+	// ReadAcceleration returns the acceleration in µg (micro-gravity). When
+	// one of the axes is pointing straight to Earth and the sensor is not
+	// moving the returned value will be around 1000000 or -1000000.
+	ReadAcceleration() (x, y, z int32, err error)
+}
+
+// Gyroscope is a sensor that measures rotation along three axes.
+type Gyroscope interface {
+	// ReadRotation returns the rotation in µ°/s (micro-degrees/sec). This
+	// means that if you were to do a complete rotation along one axis and
+	// while doing so integrate all values over time, you would get a value
+	// close to 360000000.
+	ReadRotation() (x, y, z int32, err error)
+}
+
+// Magnetometer is a sensor that measures the magnetic field along three
+// axes.
+type Magnetometer interface {
+	// ReadMagneticField returns the magnetic field in nT (nanotesla). The
+	// magnetic field of the Earth is between 25000 and 65000 nT.
+	ReadMagneticField() (x, y, z int32, err error)
+}
+
+// Luxmeter is a sensor that measures illuminance.
+type Luxmeter interface {
+	// ReadIlluminance returns the illuminance in mlx (milli lux).
+	ReadIlluminance() (int32, error)
+}
+
+// DistanceSensor is a sensor that measures the distance to an object.
+type DistanceSensor interface {
+	// ReadDistance returns the distance in mm (millimeters).
+	ReadDistance() (int32, error)
+}
@@ -7,13 +7,19 @@
 package sht3x // import "tinygo.org/x/drivers/sht3x"
 
 import (
-	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
+)
+
+var (
+	_ drivers.Thermometer = (*Device)(nil)
+	_ drivers.Hygrometer  = (*Device)(nil)
 )
 
 // Device wraps an I2C connection to a SHT31 device.
 type Device struct {
-	bus     machine.I2C
+	bus     drivers.I2C
 	Address uint16
 }
 
@@ -22,7 +28,7 @@ type Device struct {
 //
 // This function only creates the Device object, it does not initialize the device.
 // You must call Configure() first in order to use the device itself.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{
 		bus:     bus,
 		Address: AddressA,
@@ -36,31 +42,37 @@ func (d *Device) ReadTemperature() (tempMilliCelsius int32, err error) {
 }
 
 // Read returns the relative humidity in hundredths of a percent.
-func (d *Device) ReadHumidity() (relativeHumidity int16, err error) {
+func (d *Device) ReadHumidity() (relativeHumidity int32, err error) {
 	_, relativeHumidity, err = d.ReadTemperatureHumidity()
 	return relativeHumidity, err
 }
 
 // Read returns both the temperature and relative humidity.
-func (d *Device) ReadTemperatureHumidity() (tempMilliCelsius int32, relativeHumidity int16, err error) {
+func (d *Device) ReadTemperatureHumidity() (tempMilliCelsius int32, relativeHumidity int32, err error) {
 	var rawTemp, rawHum, errx = d.rawReadings()
 	if errx != nil {
 		err = errx
 		return
 	}
 	tempMilliCelsius = (35000 * int32(rawTemp) / 13107) - 45000
-	relativeHumidity = int16(2000 * int32(rawHum) / 13107)
+	relativeHumidity = 2000 * int32(rawHum) / 13107
 	return tempMilliCelsius, relativeHumidity, err
 }
 
 // rawReadings returns the sensor's raw values of the temperature and humidity
 func (d *Device) rawReadings() (uint16, uint16, error) {
-	d.bus.Tx(d.Address, []byte{MEASUREMENT_COMMAND_MSB, MEASUREMENT_COMMAND_LSB}, nil)
+	err := d.bus.Tx(d.Address, []byte{MEASUREMENT_COMMAND_MSB, MEASUREMENT_COMMAND_LSB}, nil)
+	if err != nil {
+		return 0, 0, err
+	}
 
 	time.Sleep(17 * time.Millisecond)
 
 	var data [5]byte
-	d.bus.Tx(d.Address, []byte{}, data[:])
+	err = d.bus.Tx(d.Address, []byte{}, data[:])
+	if err != nil {
+		return 0, 0, err
+	}
 	// ignore crc for now
 
 	return readUint(data[0], data[1]), readUint(data[3], data[4]), nil
@@ -0,0 +1,55 @@
+package sht3x_test
+
+import (
+	"testing"
+
+	"tinygo.org/x/drivers/sht3x"
+	"tinygo.org/x/drivers/tester"
+)
+
+func TestRead(t *testing.T) {
+	bus := tester.NewI2CBus(t)
+	fake := tester.NewI2CCommandDevice(t, sht3x.AddressA)
+	bus.AddDevice(fake)
+
+	// raw temperature 0x6666 and humidity 0x8000, each followed by its CRC
+	fake.SetReply([]byte{sht3x.MEASUREMENT_COMMAND_MSB, sht3x.MEASUREMENT_COMMAND_LSB}, 0x66, 0x66, 0x93, 0x80, 0x00, 0xA2)
+
+	dev := sht3x.New(bus)
+	temp, hum, err := dev.ReadTemperatureHumidity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.AssertCommand(sht3x.MEASUREMENT_COMMAND_MSB, sht3x.MEASUREMENT_COMMAND_LSB)
+	if temp != 25000 {
+		t.Errorf("temperature: got %d, want 25000", temp)
+	}
+	if hum != 5000 {
+		t.Errorf("humidity: got %d, want 5000", hum)
+	}
+
+	if temp, err := dev.ReadTemperature(); err != nil || temp != 25000 {
+		t.Errorf("temperature: got %d, %v; want 25000", temp, err)
+	}
+	if hum, err := dev.ReadHumidity(); err != nil || hum != 5000 {
+		t.Errorf("humidity: got %d, %v; want 5000", hum, err)
+	}
+	if n := len(fake.Commands()); n != 3 {
+		t.Errorf("got %d measurements, want 3", n)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	bus := tester.NewI2CBus(t)
+	fake := tester.NewI2CCommandDevice(t, sht3x.AddressB)
+	bus.AddDevice(fake)
+
+	dev := sht3x.New(bus)
+	if _, err := dev.ReadTemperature(); err != tester.ErrNACK {
+		t.Errorf("got %v from the default address, want %v", err, tester.ErrNACK)
+	}
+	dev.Address = sht3x.AddressB
+	if _, err := dev.ReadTemperature(); err != nil {
+		t.Error(err)
+	}
+}
@@ -7,8 +7,10 @@ package ssd1306 // import "tinygo.org/x/drivers/ssd1306"
 import (
 	"errors"
 	"image/color"
-	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 )
 
 // Device wraps an SPI connection.
@@ -30,15 +32,15 @@ type Config struct {
 }
 
 type I2CBus struct {
-	wire    machine.I2C
+	wire    drivers.I2C
 	Address uint16
 }
 
 type SPIBus struct {
-	wire     machine.SPI
-	dcPin    machine.Pin
-	resetPin machine.Pin
-	csPin    machine.Pin
+	wire     drivers.SPI
+	dcPin    drivers.Pin
+	resetPin drivers.Pin
+	csPin    drivers.Pin
 }
 
 type Buser interface {
@@ -50,7 +52,7 @@ type Buser interface {
 type VccMode uint8
 
 // NewI2C creates a new SSD1306 connection. The I2C wire must already be configured.
-func NewI2C(bus machine.I2C) Device {
+func NewI2C(bus drivers.I2C) Device {
 	return Device{
 		bus: &I2CBus{
 			wire:    bus,
@@ -60,10 +62,10 @@ func NewI2C(bus machine.I2C) Device {
 }
 
 // NewSPI creates a new SSD1306 connection. The SPI wire must already be configured.
-func NewSPI(bus machine.SPI, dcPin, resetPin, csPin machine.Pin) Device {
-	dcPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	resetPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	csPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
+func NewSPI(bus drivers.SPI, dcPin, resetPin, csPin drivers.Pin) Device {
+	pin.ConfigureOutput(dcPin)
+	pin.ConfigureOutput(resetPin)
+	pin.ConfigureOutput(csPin)
 	return Device{
 		bus: &SPIBus{
 			wire:     bus,
@@ -6,10 +6,11 @@ package ssd1331 // import "tinygo.org/x/drivers/ssd1331"
 
 import (
 	"image/color"
-	"machine"
-
 	"errors"
 	"time"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 )
 
 type Model uint8
@@ -17,10 +18,10 @@ type Rotation uint8
 
 // Device wraps an SPI connection.
 type Device struct {
-	bus         machine.SPI
-	dcPin       machine.Pin
-	resetPin    machine.Pin
-	csPin       machine.Pin
+	bus         drivers.SPI
+	dcPin       drivers.Pin
+	resetPin    drivers.Pin
+	csPin       drivers.Pin
 	width       int16
 	height      int16
 	batchLength int16
@@ -35,10 +36,10 @@ type Config struct {
 }
 
 // New creates a new SSD1331 connection. The SPI wire must already be configured.
-func New(bus machine.SPI, resetPin, dcPin, csPin machine.Pin) Device {
-	dcPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	resetPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	csPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
+func New(bus drivers.SPI, resetPin, dcPin, csPin drivers.Pin) Device {
+	pin.ConfigureOutput(dcPin)
+	pin.ConfigureOutput(resetPin)
+	pin.ConfigureOutput(csPin)
 	return Device{
 		bus:      bus,
 		dcPin:    dcPin,
@@ -250,7 +251,11 @@ func (d *Device) Data(data uint8) {
 
 // Tx sends data to the display
 func (d *Device) Tx(data []byte, isCommand bool) {
-	d.dcPin.Set(!isCommand)
+	if isCommand {
+		d.dcPin.Low()
+	} else {
+		d.dcPin.High()
+	}
 	d.bus.Tx(data, nil)
 }
 
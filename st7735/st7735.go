@@ -6,10 +6,11 @@ package st7735 // import "tinygo.org/x/drivers/st7735"
 
 import (
 	"image/color"
-	"machine"
 	"time"
-
 	"errors"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 )
 
 type Model uint8
@@ -17,11 +18,11 @@ type Rotation uint8
 
 // Device wraps an SPI connection.
 type Device struct {
-	bus          machine.SPI
-	dcPin        machine.Pin
-	resetPin     machine.Pin
-	csPin        machine.Pin
-	blPin        machine.Pin
+	bus          drivers.SPI
+	dcPin        drivers.Pin
+	resetPin     drivers.Pin
+	csPin        drivers.Pin
+	blPin        drivers.Pin
 	width        int16
 	height       int16
 	columnOffset int16
@@ -44,11 +45,11 @@ type Config struct {
 }
 
 // New creates a new ST7735 connection. The SPI wire must already be configured.
-func New(bus machine.SPI, resetPin, dcPin, csPin, blPin machine.Pin) Device {
-	dcPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	resetPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	csPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	blPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
+func New(bus drivers.SPI, resetPin, dcPin, csPin, blPin drivers.Pin) Device {
+	pin.ConfigureOutput(dcPin)
+	pin.ConfigureOutput(resetPin)
+	pin.ConfigureOutput(csPin)
+	pin.ConfigureOutput(blPin)
 	return Device{
 		bus:      bus,
 		dcPin:    dcPin,
@@ -371,7 +372,11 @@ func (d *Device) Data(data uint8) {
 
 // Tx sends data to the display
 func (d *Device) Tx(data []byte, isCommand bool) {
-	d.dcPin.Set(!isCommand)
+	if isCommand {
+		d.dcPin.Low()
+	} else {
+		d.dcPin.High()
+	}
 	d.bus.Tx(data, nil)
 }
 
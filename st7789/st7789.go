@@ -6,20 +6,21 @@ package st7789 // import "tinygo.org/x/drivers/st7789"
 
 import (
 	"image/color"
-	"machine"
 	"time"
-
 	"errors"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 )
 
 type Rotation uint8
 
 // Device wraps an SPI connection.
 type Device struct {
-	bus             machine.SPI
-	dcPin           machine.Pin
-	resetPin        machine.Pin
-	blPin           machine.Pin
+	bus             drivers.SPI
+	dcPin           drivers.Pin
+	resetPin        drivers.Pin
+	blPin           drivers.Pin
 	width           int16
 	height          int16
 	columnOffsetCfg int16
@@ -41,10 +42,10 @@ type Config struct {
 }
 
 // New creates a new ST7789 connection. The SPI wire must already be configured.
-func New(bus machine.SPI, resetPin, dcPin, blPin machine.Pin) Device {
-	dcPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	resetPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	blPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
+func New(bus drivers.SPI, resetPin, dcPin, blPin drivers.Pin) Device {
+	pin.ConfigureOutput(dcPin)
+	pin.ConfigureOutput(resetPin)
+	pin.ConfigureOutput(blPin)
 	return Device{
 		bus:      bus,
 		dcPin:    dcPin,
@@ -0,0 +1,807 @@
+package tester
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ESPATNetwork is an access point seen by the ESPAT simulator.
+type ESPATNetwork struct {
+	SSID     string
+	Password string
+	BSSID    string
+	RSSI     int
+	Channel  int
+
+	// Encryption is the <ecn> of AT+CWLAP, 0 for an open network.
+	Encryption int
+}
+
+// ESPAT simulates the AT firmware of an ESP8266/ESP32 attached to a UART, so
+// that the espat driver can be tested without the hardware. It implements
+// the commands used by the driver: AT, ATE, AT+RST, AT+GMR, AT+CWMODE,
+// AT+CWJAP, AT+CWQAP, AT+CWLAP, AT+CIPSTA, AT+CIFSR, AT+CIPMUX, AT+CIPDINFO,
+// AT+CIPDOMAIN, AT+CIPSTART, AT+CIPSEND, AT+CIPCLOSE, AT+CIPSERVER,
+// AT+CIPSSLCSNI, AT+CIPSSLCCONF and AT+SYSFLASH. Other commands respond
+// "ERROR".
+//
+// The connections are bridged to the network of the host: TCP, UDP and SSL
+// connections are opened with Dial, and the data received is sent to the
+// driver in "+IPD" messages from a goroutine for every connection, like the
+// real firmware does.
+//
+// The exported fields must be set before the driver sends its first command.
+type ESPAT struct {
+	// Networks are listed by AT+CWLAP. AT+CWJAP connects to the network
+	// with the same SSID and Password.
+	Networks []ESPATNetwork
+
+	// IP, Gateway, Netmask and MAC are the station configuration once
+	// connected to a network.
+	IP, Gateway, Netmask, MAC string
+
+	// Hosts maps domain names to the addresses returned by AT+CIPDOMAIN.
+	// Other names are looked up with the resolver of the host.
+	Hosts map[string]string
+
+	// Dial opens the connections of AT+CIPSTART, net.Dial by default.
+	// TLSConfig is used on top of it for SSL connections, with the server
+	// name set by AT+CIPSSLCSNI or else the address connected to.
+	Dial      func(network, address string) (net.Conn, error)
+	TLSConfig *tls.Config
+
+	// Listen opens the TCP server of AT+CIPSERVER, by default net.Listen
+	// on 127.0.0.1 and the port of the command. ServerAddr returns the
+	// address it listens on.
+	Listen func(network, address string) (net.Listener, error)
+
+	// Busy makes every command respond "busy p...".
+	Busy bool
+
+	// Flash holds the data written to every partition with AT+SYSFLASH.
+	Flash map[string][]byte
+
+	c    Failer
+	uart *UART
+
+	mu       sync.Mutex
+	line     []byte
+	commands []string
+	echo     bool
+	mux      bool
+	dinfo    bool
+	mode     int
+	network  *ESPATNetwork
+	links    [espatMaxLinks]*espatLink
+	sni      [espatMaxLinks]string
+	server   net.Listener
+
+	// data expected after the ">" prompt: for the link sendLink, or for
+	// the flash partition sendFlash
+	sendLeft  int
+	sendLink  int
+	sendFlash string
+	sendBuf   []byte
+	sendTo    *net.UDPAddr
+}
+
+const espatMaxLinks = 5
+
+// espatLink is a connection of the simulator.
+type espatLink struct {
+	id   int
+	conn net.Conn
+
+	// UDP connections only, the destination of the data sent, replaced by
+	// the sender of every datagram received
+	udp    *net.UDPConn
+	remote *net.UDPAddr
+}
+
+// NewESPAT returns a simulator attached to uart, with echo on and one open
+// network named "tinygo". Call Close at the end of the test to close the
+// connections of the host it opened.
+func NewESPAT(c Failer, uart *UART) *ESPAT {
+	e := &ESPAT{
+		Networks: []ESPATNetwork{{SSID: "tinygo", BSSID: "a0:b1:c2:d3:e4:f5", RSSI: -50, Channel: 6}},
+		IP:       "192.168.1.100",
+		Gateway:  "192.168.1.1",
+		Netmask:  "255.255.255.0",
+		MAC:      "18:fe:34:00:00:01",
+		Hosts:    make(map[string]string),
+		Dial:     net.Dial,
+		Flash:    make(map[string][]byte),
+		c:        c,
+		uart:     uart,
+		echo:     true,
+		mode:     1,
+	}
+	uart.SetDevice(e)
+	return e
+}
+
+// Commands returns the command lines received so far, without "\r\n".
+func (e *ESPAT) Commands() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.commands...)
+}
+
+// AssertCommand fails the test if the command line was not received.
+func (e *ESPAT) AssertCommand(cmd string) {
+	for _, c := range e.Commands() {
+		if c == cmd {
+			return
+		}
+	}
+	e.c.Helper()
+	e.c.Fatalf("command %q not received, got %q", cmd, e.Commands())
+}
+
+// ServerAddr returns the address of the TCP server started by AT+CIPSERVER,
+// or nil if it is not running.
+func (e *ESPAT) ServerAddr() net.Addr {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.server == nil {
+		return nil
+	}
+	return e.server.Addr()
+}
+
+// Close closes all the connections and the TCP server.
+func (e *ESPAT) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closeAll()
+}
+
+// Receive implements UARTDevice.
+func (e *ESPAT) Receive(b []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for len(b) > 0 {
+		if e.sendLeft > 0 {
+			n := len(b)
+			if n > e.sendLeft {
+				n = e.sendLeft
+			}
+			e.sendBuf = append(e.sendBuf, b[:n]...)
+			e.sendLeft -= n
+			b = b[n:]
+			if e.sendLeft == 0 {
+				e.sent()
+			}
+			continue
+		}
+
+		c := b[0]
+		b = b[1:]
+		if e.echo {
+			e.uart.Send([]byte{c})
+		}
+		if c != '\n' {
+			e.line = append(e.line, c)
+			continue
+		}
+		line := strings.TrimRight(string(e.line), "\r")
+		e.line = e.line[:0]
+		if line != "" {
+			e.commands = append(e.commands, line)
+			e.command(line)
+		}
+	}
+}
+
+// send sends the lines to the driver at once.
+func (e *ESPAT) send(lines ...string) {
+	var b []byte
+	for _, line := range lines {
+		b = append(b, line...)
+		b = append(b, '\r', '\n')
+	}
+	e.uart.Send(b)
+}
+
+// ok sends the information lines of a response and "OK".
+func (e *ESPAT) ok(info ...string) {
+	e.send(append(info, "", "OK")...)
+}
+
+// fail sends the information lines of a response and "ERROR".
+func (e *ESPAT) fail(info ...string) {
+	e.send(append(info, "", "ERROR")...)
+}
+
+// linkEvent returns a connection event, prefixed by the link ID in multiple
+// connection mode.
+func (e *ESPAT) linkEvent(id int, event string) string {
+	if e.mux {
+		return strconv.Itoa(id) + "," + event
+	}
+	return event
+}
+
+// command executes a command line.
+func (e *ESPAT) command(line string) {
+	if !strings.HasPrefix(line, "AT") {
+		e.fail()
+		return
+	}
+	if e.Busy {
+		e.send("busy p...")
+		return
+	}
+
+	name, query, args := line[2:], false, []string(nil)
+	if i := strings.IndexAny(name, "=?"); i >= 0 {
+		query = name[i] == '?'
+		if !query {
+			args = espatParams(name[i+1:])
+		}
+		name = name[:i]
+	}
+
+	switch name {
+	case "":
+		e.ok()
+	case "E0", "E1":
+		e.echo = name == "E1"
+		e.ok()
+	case "+RST":
+		e.closeAll()
+		e.echo, e.mux, e.dinfo, e.network = true, false, false, nil
+		e.ok()
+		e.send("", "ready")
+	case "+GMR":
+		e.ok("AT version:1.7.4.0(simulated)", "SDK version:3.0.4")
+	case "+CWMODE":
+		e.cwmode(query, args)
+	case "+CWJAP":
+		e.cwjap(query, args)
+	case "+CWQAP":
+		if e.network != nil {
+			e.network = nil
+			e.send("WIFI DISCONNECT")
+		}
+		e.ok()
+	case "+CWLAP":
+		var info []string
+		for _, n := range e.Networks {
+			info = append(info, "+CWLAP:("+strconv.Itoa(n.Encryption)+","+espatQuote(n.SSID)+","+
+				strconv.Itoa(n.RSSI)+","+espatQuote(n.BSSID)+","+strconv.Itoa(n.Channel)+")")
+		}
+		e.ok(info...)
+	case "+CIPSTA":
+		ip, gateway, netmask := "0.0.0.0", "0.0.0.0", "0.0.0.0"
+		if e.network != nil {
+			ip, gateway, netmask = e.IP, e.Gateway, e.Netmask
+		}
+		e.ok("+CIPSTA:ip:"+espatQuote(ip), "+CIPSTA:gateway:"+espatQuote(gateway),
+			"+CIPSTA:netmask:"+espatQuote(netmask))
+	case "+CIFSR":
+		ip := "0.0.0.0"
+		if e.network != nil {
+			ip = e.IP
+		}
+		e.ok("+CIFSR:STAIP,"+espatQuote(ip), "+CIFSR:STAMAC,"+espatQuote(e.MAC))
+	case "+CIPMUX":
+		e.cipmux(query, args)
+	case "+CIPDINFO":
+		if len(args) != 1 {
+			e.fail()
+			return
+		}
+		e.dinfo = args[0] == "1"
+		e.ok()
+	case "+CIPDOMAIN":
+		e.cipdomain(args)
+	case "+CIPSTART":
+		e.cipstart(args)
+	case "+CIPSEND":
+		e.cipsend(args)
+	case "+CIPCLOSE":
+		e.cipclose(args)
+	case "+CIPSERVER":
+		e.cipserver(args)
+	case "+CIPSSLCSNI":
+		id, ok := e.linkID(args)
+		if !ok || len(args) < 2 {
+			e.fail()
+			return
+		}
+		e.sni[id] = args[len(args)-1]
+		e.ok()
+	case "+CIPSSLCCONF":
+		e.ok()
+	case "+SYSFLASH":
+		e.sysflash(args)
+	default:
+		e.fail()
+	}
+}
+
+func (e *ESPAT) cwmode(query bool, args []string) {
+	if query {
+		e.ok("+CWMODE:" + strconv.Itoa(e.mode))
+		return
+	}
+	mode, err := strconv.Atoi(firstArg(args))
+	if err != nil || mode < 0 || mode > 3 {
+		e.fail()
+		return
+	}
+	e.mode = mode
+	e.ok()
+}
+
+func (e *ESPAT) cwjap(query bool, args []string) {
+	if query {
+		n := e.network
+		if n == nil {
+			e.ok("No AP")
+			return
+		}
+		e.ok("+CWJAP:" + espatQuote(n.SSID) + "," + espatQuote(n.BSSID) + "," +
+			strconv.Itoa(n.Channel) + "," + strconv.Itoa(n.RSSI))
+		return
+	}
+	if len(args) < 2 {
+		e.fail()
+		return
+	}
+
+	if e.network != nil {
+		e.network = nil
+		e.send("WIFI DISCONNECT")
+	}
+	for i := range e.Networks {
+		n := &e.Networks[i]
+		if n.SSID != args[0] {
+			continue
+		}
+		if n.Password != args[1] {
+			// wrong password
+			e.send("+CWJAP:2", "", "FAIL")
+			return
+		}
+		e.network = n
+		e.send("WIFI CONNECTED", "WIFI GOT IP")
+		e.ok()
+		return
+	}
+	// cannot find the target AP
+	e.send("+CWJAP:3", "", "FAIL")
+}
+
+func (e *ESPAT) cipmux(query bool, args []string) {
+	if query {
+		mux := "0"
+		if e.mux {
+			mux = "1"
+		}
+		e.ok("+CIPMUX:" + mux)
+		return
+	}
+	if len(args) != 1 || (args[0] != "0" && args[0] != "1") {
+		e.fail()
+		return
+	}
+	for _, l := range e.links {
+		if l != nil {
+			e.fail("link is builded")
+			return
+		}
+	}
+	e.mux = args[0] == "1"
+	e.ok()
+}
+
+func (e *ESPAT) cipdomain(args []string) {
+	if len(args) != 1 {
+		e.fail()
+		return
+	}
+	ip, ok := e.Hosts[args[0]]
+	if !ok {
+		addrs, err := net.LookupHost(args[0])
+		for _, addr := range addrs {
+			if net.ParseIP(addr).To4() != nil {
+				ip = addr
+				break
+			}
+		}
+		if err != nil || ip == "" {
+			e.fail("DNS Fail")
+			return
+		}
+	}
+	e.ok("+CIPDOMAIN:" + ip)
+}
+
+// linkID returns the link ID at the start of args in multiple connection
+// mode, and removes it from args. It is 0 in single connection mode.
+func (e *ESPAT) linkID(args []string) (int, bool) {
+	if !e.mux {
+		return 0, true
+	}
+	id, err := strconv.Atoi(firstArg(args))
+	return id, err == nil && id >= 0 && id < espatMaxLinks
+}
+
+func (e *ESPAT) cipstart(args []string) {
+	id, ok := e.linkID(args)
+	if e.mux {
+		args = args[1:]
+	}
+	if !ok || len(args) < 3 {
+		e.fail()
+		return
+	}
+	if e.network == nil {
+		e.fail("no ip")
+		return
+	}
+	if e.links[id] != nil {
+		e.fail("ALREADY CONNECTED")
+		return
+	}
+	typ, host := args[0], args[1]
+	if _, err := strconv.Atoi(args[2]); err != nil {
+		e.fail()
+		return
+	}
+	address := net.JoinHostPort(host, args[2])
+
+	l := &espatLink{id: id}
+	switch typ {
+	case "TCP", "SSL":
+		conn, err := e.Dial("tcp", address)
+		if err != nil {
+			e.fail("CLOSED")
+			return
+		}
+		if typ == "SSL" {
+			config := &tls.Config{}
+			if e.TLSConfig != nil {
+				config = e.TLSConfig.Clone()
+			}
+			config.ServerName = host
+			if e.sni[id] != "" {
+				config.ServerName = e.sni[id]
+			}
+			tlsConn := tls.Client(conn, config)
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				e.fail("CLOSED")
+				return
+			}
+			conn = tlsConn
+		}
+		l.conn = conn
+	case "UDP":
+		localPort := 0
+		if len(args) >= 4 {
+			localPort, _ = strconv.Atoi(args[3])
+		}
+		udp, err := net.ListenUDP("udp", &net.UDPAddr{Port: localPort})
+		if err != nil {
+			e.fail()
+			return
+		}
+		remote, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			udp.Close()
+			e.fail()
+			return
+		}
+		l.conn, l.udp, l.remote = udp, udp, remote
+	default:
+		e.fail()
+		return
+	}
+
+	e.links[id] = l
+	go e.readLink(l)
+	e.send(e.linkEvent(id, "CONNECT"))
+	e.ok()
+}
+
+func (e *ESPAT) cipsend(args []string) {
+	id, ok := e.linkID(args)
+	if e.mux && len(args) > 0 {
+		args = args[1:]
+	}
+	size, err := strconv.Atoi(firstArg(args))
+	if !ok || err != nil || size <= 0 || size > 2048 {
+		e.fail()
+		return
+	}
+	l := e.links[id]
+	if l == nil {
+		e.fail("link is not valid")
+		return
+	}
+
+	// UDP data can be sent to another remote than the one of the link
+	e.sendTo = nil
+	if len(args) == 3 {
+		if l.udp == nil {
+			e.fail()
+			return
+		}
+		e.sendTo, err = net.ResolveUDPAddr("udp", net.JoinHostPort(args[1], args[2]))
+		if err != nil {
+			e.fail()
+			return
+		}
+	}
+	e.sendLink, e.sendFlash, e.sendLeft = id, "", size
+	e.sendBuf = e.sendBuf[:0]
+	e.ok()
+	e.uart.Send([]byte("> "))
+}
+
+// sent handles the data received after the prompt.
+func (e *ESPAT) sent() {
+	if e.sendFlash != "" {
+		e.Flash[e.sendFlash] = append([]byte(nil), e.sendBuf...)
+		e.ok()
+		return
+	}
+
+	l := e.links[e.sendLink]
+	if l == nil {
+		e.send("", "SEND FAIL")
+		return
+	}
+	var err error
+	switch {
+	case l.udp != nil && e.sendTo != nil:
+		_, err = l.udp.WriteToUDP(e.sendBuf, e.sendTo)
+	case l.udp != nil:
+		_, err = l.udp.WriteToUDP(e.sendBuf, l.remote)
+	default:
+		_, err = l.conn.Write(e.sendBuf)
+	}
+	e.send("", "Recv "+strconv.Itoa(len(e.sendBuf))+" bytes")
+	if err != nil {
+		e.send("", "SEND FAIL")
+		return
+	}
+	e.send("", "SEND OK")
+}
+
+func (e *ESPAT) cipclose(args []string) {
+	id, ok := e.linkID(args)
+	if !ok {
+		e.fail()
+		return
+	}
+	l := e.links[id]
+	if l == nil {
+		e.fail("UNLINK")
+		return
+	}
+	e.links[id] = nil
+	l.conn.Close()
+	e.send(e.linkEvent(id, "CLOSED"))
+	e.ok()
+}
+
+func (e *ESPAT) cipserver(args []string) {
+	switch firstArg(args) {
+	case "0":
+		if e.server != nil {
+			e.server.Close()
+			e.server = nil
+		}
+		e.ok()
+	case "1":
+		if !e.mux || e.server != nil {
+			e.fail()
+			return
+		}
+		port := "333"
+		if len(args) >= 2 {
+			port = args[1]
+		}
+		listen := e.Listen
+		if listen == nil {
+			listen = net.Listen
+		}
+		ln, err := listen("tcp", "127.0.0.1:"+port)
+		if err != nil {
+			e.fail()
+			return
+		}
+		e.server = ln
+		go e.accept(ln)
+		e.ok()
+	default:
+		e.fail()
+	}
+}
+
+func (e *ESPAT) sysflash(args []string) {
+	if len(args) < 2 {
+		e.fail()
+		return
+	}
+	switch args[0] {
+	case "0":
+		delete(e.Flash, args[1])
+		e.ok()
+	case "1":
+		if len(args) < 4 {
+			e.fail()
+			return
+		}
+		size, err := strconv.Atoi(args[3])
+		if err != nil || size <= 0 {
+			e.fail()
+			return
+		}
+		e.sendFlash, e.sendLeft = args[1], size
+		e.sendBuf = e.sendBuf[:0]
+		e.uart.Send([]byte(">"))
+	default:
+		e.fail()
+	}
+}
+
+// accept gives a link ID to every connection to the TCP server.
+func (e *ESPAT) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		e.mu.Lock()
+		id := -1
+		for i, l := range e.links {
+			if l == nil {
+				id = i
+				break
+			}
+		}
+		if e.server != ln || id < 0 {
+			e.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		l := &espatLink{id: id, conn: conn}
+		e.links[id] = l
+		e.send(e.linkEvent(id, "CONNECT"))
+		e.mu.Unlock()
+
+		go e.readLink(l)
+	}
+}
+
+// readLink sends the data received by the connection to the driver, until
+// it is closed.
+func (e *ESPAT) readLink(l *espatLink) {
+	buf := make([]byte, 1460)
+	for {
+		var n int
+		var remote net.Addr
+		var err error
+		if l.udp != nil {
+			var addr *net.UDPAddr
+			n, addr, err = l.udp.ReadFromUDP(buf)
+			if addr != nil {
+				remote = addr
+			}
+			e.mu.Lock()
+			if addr != nil {
+				l.remote = addr
+			}
+			e.mu.Unlock()
+		} else {
+			n, err = l.conn.Read(buf)
+			remote = l.conn.RemoteAddr()
+		}
+
+		e.mu.Lock()
+		if e.links[l.id] != l {
+			// closed by AT+CIPCLOSE
+			e.mu.Unlock()
+			return
+		}
+		if n > 0 {
+			e.uart.Send(e.ipd(l.id, buf[:n], remote))
+		}
+		if err != nil {
+			e.links[l.id] = nil
+			l.conn.Close()
+			e.send(e.linkEvent(l.id, "CLOSED"))
+		}
+		e.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ipd returns the "+IPD" message of data received by a link.
+func (e *ESPAT) ipd(id int, data []byte, remote net.Addr) []byte {
+	b := []byte("\r\n+IPD,")
+	if e.mux {
+		b = strconv.AppendInt(b, int64(id), 10)
+		b = append(b, ',')
+	}
+	b = strconv.AppendInt(b, int64(len(data)), 10)
+	if e.dinfo && remote != nil {
+		if host, port, err := net.SplitHostPort(remote.String()); err == nil {
+			b = append(b, ","+host+","+port...)
+		}
+	}
+	b = append(b, ':')
+	return append(b, data...)
+}
+
+// closeAll closes the TCP server and all the connections. e.mu must be
+// held.
+func (e *ESPAT) closeAll() {
+	if e.server != nil {
+		e.server.Close()
+		e.server = nil
+	}
+	for i, l := range e.links {
+		if l != nil {
+			l.conn.Close()
+			e.links[i] = nil
+		}
+	}
+}
+
+// espatParams splits the parameters of a command, and removes the quotes
+// around strings. Quotes, commas and backslashes in strings are escaped with
+// a backslash.
+func espatParams(s string) []string {
+	var params []string
+	var b []byte
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(s):
+			i++
+			b = append(b, s[i])
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			params = append(params, string(b))
+			b = b[:0]
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(params, string(b))
+}
+
+// espatQuote quotes a string parameter of a response.
+func espatQuote(s string) string {
+	b := []byte{'"'}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', ',', '\\':
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return string(append(b, '"'))
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
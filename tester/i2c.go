@@ -0,0 +1,360 @@
+package tester
+
+import (
+	"fmt"
+
+	"tinygo.org/x/drivers"
+)
+
+// I2COp is the kind of operation recorded in an I2CTransaction.
+type I2COp uint8
+
+const (
+	// OpTx is a raw write-then-read transfer done with Tx.
+	OpTx I2COp = iota
+
+	// OpReadRegister is a register read done with ReadRegister.
+	OpReadRegister
+
+	// OpWriteRegister is a register write done with WriteRegister.
+	OpWriteRegister
+)
+
+func (op I2COp) String() string {
+	switch op {
+	case OpTx:
+		return "Tx"
+	case OpReadRegister:
+		return "ReadRegister"
+	case OpWriteRegister:
+		return "WriteRegister"
+	default:
+		return "Unknown"
+	}
+}
+
+// I2CTransaction is a single operation recorded by an I2CBus.
+type I2CTransaction struct {
+	Op   I2COp
+	Addr uint8
+
+	// Reg is the register for OpReadRegister and OpWriteRegister.
+	Reg uint8
+
+	// W holds the bytes written by the driver and R the bytes returned to
+	// it. Both are copies taken when the transaction completed.
+	W, R []byte
+
+	// Err is the error returned to the driver, if any.
+	Err error
+}
+
+func (tx I2CTransaction) String() string {
+	switch tx.Op {
+	case OpReadRegister, OpWriteRegister:
+		return fmt.Sprintf("%s(0x%02X, 0x%02X) w=% X r=% X err=%v", tx.Op, tx.Addr, tx.Reg, tx.W, tx.R, tx.Err)
+	default:
+		return fmt.Sprintf("%s(0x%02X) w=% X r=% X err=%v", tx.Op, tx.Addr, tx.W, tx.R, tx.Err)
+	}
+}
+
+// I2CDevice is the device side of an I2CBus. Implement it to simulate
+// devices that do not fit the register model of I2CDevice8.
+type I2CDevice interface {
+	// Addr returns the 7-bit address of the device.
+	Addr() uint8
+
+	// ReadRegister implements the device side of drivers.I2C.ReadRegister.
+	ReadRegister(r uint8, buf []byte) error
+
+	// WriteRegister implements the device side of drivers.I2C.WriteRegister.
+	WriteRegister(r uint8, buf []byte) error
+
+	// Tx implements the device side of drivers.I2C.Tx.
+	Tx(w, r []byte) error
+}
+
+var _ drivers.I2C = (*I2CBus)(nil)
+
+// I2CBus is a fake I2C bus that implements drivers.I2C. It dispatches
+// operations to the attached devices by address and records every
+// transaction.
+type I2CBus struct {
+	c       Failer
+	devices []I2CDevice
+	txs     []I2CTransaction
+}
+
+// NewI2CBus returns an I2C bus without any devices attached.
+func NewI2CBus(c Failer) *I2CBus {
+	return &I2CBus{c: c}
+}
+
+// AddDevice attaches a device to the bus. Adding two devices with the same
+// address fails the test.
+func (bus *I2CBus) AddDevice(d I2CDevice) {
+	if bus.FindDevice(d.Addr()) != nil {
+		bus.c.Helper()
+		bus.c.Fatalf("device already exists at address 0x%02X", d.Addr())
+	}
+	bus.devices = append(bus.devices, d)
+}
+
+// FindDevice returns the device with the given address, or nil.
+func (bus *I2CBus) FindDevice(addr uint8) I2CDevice {
+	for _, d := range bus.devices {
+		if d.Addr() == addr {
+			return d
+		}
+	}
+	return nil
+}
+
+// ReadRegister implements drivers.I2C.
+func (bus *I2CBus) ReadRegister(addr uint8, r uint8, buf []byte) error {
+	err := ErrNACK
+	if d := bus.FindDevice(addr); d != nil {
+		err = d.ReadRegister(r, buf)
+	}
+	bus.record(I2CTransaction{Op: OpReadRegister, Addr: addr, Reg: r, R: clone(buf), Err: err})
+	return err
+}
+
+// WriteRegister implements drivers.I2C.
+func (bus *I2CBus) WriteRegister(addr uint8, r uint8, buf []byte) error {
+	err := ErrNACK
+	if d := bus.FindDevice(addr); d != nil {
+		err = d.WriteRegister(r, buf)
+	}
+	bus.record(I2CTransaction{Op: OpWriteRegister, Addr: addr, Reg: r, W: clone(buf), Err: err})
+	return err
+}
+
+// Tx implements drivers.I2C.
+func (bus *I2CBus) Tx(addr uint16, w, r []byte) error {
+	err := ErrNACK
+	if d := bus.FindDevice(uint8(addr)); d != nil {
+		err = d.Tx(w, r)
+	}
+	bus.record(I2CTransaction{Op: OpTx, Addr: uint8(addr), W: clone(w), R: clone(r), Err: err})
+	return err
+}
+
+func (bus *I2CBus) record(tx I2CTransaction) {
+	bus.txs = append(bus.txs, tx)
+}
+
+// Transactions returns all transactions recorded since the bus was created
+// or since the last call to ClearTransactions.
+func (bus *I2CBus) Transactions() []I2CTransaction {
+	return bus.txs
+}
+
+// ClearTransactions forgets all recorded transactions.
+func (bus *I2CBus) ClearTransactions() {
+	bus.txs = bus.txs[:0]
+}
+
+// AssertWritten fails the test unless a register write of exactly data to
+// register r of the device at addr has been recorded.
+func (bus *I2CBus) AssertWritten(addr uint8, r uint8, data ...byte) {
+	for _, tx := range bus.txs {
+		if tx.Addr != addr {
+			continue
+		}
+		switch {
+		case tx.Op == OpWriteRegister && tx.Reg == r && equal(tx.W, data):
+			return
+		case tx.Op == OpTx && len(tx.W) > 0 && tx.W[0] == r && equal(tx.W[1:], data):
+			return
+		}
+	}
+	bus.c.Helper()
+	bus.c.Fatalf("no write of % X to register 0x%02X of device 0x%02X", data, r, addr)
+}
+
+// I2CDevice8 is a fake device with 256 8-bit registers, the most common
+// layout for I2C sensors. Raw Tx writes set the register pointer with the
+// first byte and then write the remaining bytes, reads start at the register
+// pointer. The pointer is incremented after each byte, wrapping around.
+type I2CDevice8 struct {
+	c    Failer
+	addr uint8
+
+	// Registers holds the contents of the device registers. Tests can set
+	// it up before calling into the driver and inspect it afterwards.
+	Registers [256]uint8
+
+	// Err, if not nil, is returned by every operation on the device.
+	Err error
+
+	// NACK makes the device not acknowledge its address, as if it was not
+	// connected.
+	NACK bool
+
+	ptr uint8
+}
+
+// NewI2CDevice8 returns a fake device at the given address with all
+// registers set to zero.
+func NewI2CDevice8(c Failer, addr uint8) *I2CDevice8 {
+	return &I2CDevice8{c: c, addr: addr}
+}
+
+// Addr implements I2CDevice.
+func (d *I2CDevice8) Addr() uint8 {
+	return d.addr
+}
+
+// SetRegisters copies data into the registers starting at register r.
+func (d *I2CDevice8) SetRegisters(r uint8, data ...byte) {
+	for i, b := range data {
+		d.Registers[r+uint8(i)] = b
+	}
+}
+
+// AssertRegister fails the test unless the registers starting at r contain
+// the given data.
+func (d *I2CDevice8) AssertRegister(r uint8, data ...byte) {
+	for i, want := range data {
+		if got := d.Registers[r+uint8(i)]; got != want {
+			d.c.Helper()
+			d.c.Fatalf("register 0x%02X of device 0x%02X: got 0x%02X, want 0x%02X", r+uint8(i), d.addr, got, want)
+		}
+	}
+}
+
+// ReadRegister implements I2CDevice.
+func (d *I2CDevice8) ReadRegister(r uint8, buf []byte) error {
+	if err := d.check(); err != nil {
+		return err
+	}
+	d.ptr = r
+	d.read(buf)
+	return nil
+}
+
+// WriteRegister implements I2CDevice.
+func (d *I2CDevice8) WriteRegister(r uint8, buf []byte) error {
+	if err := d.check(); err != nil {
+		return err
+	}
+	d.ptr = r
+	d.write(buf)
+	return nil
+}
+
+// Tx implements I2CDevice.
+func (d *I2CDevice8) Tx(w, r []byte) error {
+	if err := d.check(); err != nil {
+		return err
+	}
+	if len(w) > 0 {
+		d.ptr = w[0]
+		d.write(w[1:])
+	}
+	d.read(r)
+	return nil
+}
+
+func (d *I2CDevice8) check() error {
+	if d.NACK {
+		return ErrNACK
+	}
+	return d.Err
+}
+
+func (d *I2CDevice8) read(buf []byte) {
+	for i := range buf {
+		buf[i] = d.Registers[d.ptr]
+		d.ptr++
+	}
+}
+
+func (d *I2CDevice8) write(buf []byte) {
+	for _, b := range buf {
+		d.Registers[d.ptr] = b
+		d.ptr++
+	}
+}
+
+// I2CCommandDevice is a fake device that is driven by commands instead of
+// registers, such as the SHT3x sensors. Every raw write is recorded as a
+// command and the following reads return the reply configured for it.
+type I2CCommandDevice struct {
+	c    Failer
+	addr uint8
+
+	// Err, if not nil, is returned by every operation on the device.
+	Err error
+
+	// NACK makes the device not acknowledge its address, as if it was not
+	// connected.
+	NACK bool
+
+	commands [][]byte
+	replies  map[string][]byte
+	reply    []byte
+}
+
+// NewI2CCommandDevice returns a fake command device at the given address.
+func NewI2CCommandDevice(c Failer, addr uint8) *I2CCommandDevice {
+	return &I2CCommandDevice{c: c, addr: addr, replies: map[string][]byte{}}
+}
+
+// Addr implements I2CDevice.
+func (d *I2CCommandDevice) Addr() uint8 {
+	return d.addr
+}
+
+// SetReply configures the bytes returned by reads following cmd.
+func (d *I2CCommandDevice) SetReply(cmd []byte, reply ...byte) {
+	d.replies[string(cmd)] = reply
+}
+
+// Commands returns the commands received by the device, in order.
+func (d *I2CCommandDevice) Commands() [][]byte {
+	return d.commands
+}
+
+// AssertCommand fails the test unless cmd has been received by the device.
+func (d *I2CCommandDevice) AssertCommand(cmd ...byte) {
+	for _, c := range d.commands {
+		if equal(c, cmd) {
+			return
+		}
+	}
+	d.c.Helper()
+	d.c.Fatalf("command % X not received by device 0x%02X", cmd, d.addr)
+}
+
+// ReadRegister implements I2CDevice. The register is handled as a one byte
+// command.
+func (d *I2CCommandDevice) ReadRegister(r uint8, buf []byte) error {
+	return d.Tx([]byte{r}, buf)
+}
+
+// WriteRegister implements I2CDevice. The register and data are handled as
+// a single command.
+func (d *I2CCommandDevice) WriteRegister(r uint8, buf []byte) error {
+	return d.Tx(append([]byte{r}, buf...), nil)
+}
+
+// Tx implements I2CDevice.
+func (d *I2CCommandDevice) Tx(w, r []byte) error {
+	if d.NACK {
+		return ErrNACK
+	}
+	if d.Err != nil {
+		return d.Err
+	}
+	if len(w) > 0 {
+		d.commands = append(d.commands, clone(w))
+		d.reply = d.replies[string(w)]
+	}
+	n := copy(r, d.reply)
+	for i := n; i < len(r); i++ {
+		r[i] = 0xFF // the bus is pulled up when nothing drives it
+	}
+	return nil
+}
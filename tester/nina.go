@@ -0,0 +1,895 @@
+package tester
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Framing of the NINA SPI protocol.
+const (
+	ninaStart = 0xE0
+	ninaEnd   = 0xEE
+	ninaErr   = 0xEF
+	ninaReply = 0x80
+
+	// commands with this flag use 16 bit parameter lengths
+	ninaData = 0x40
+)
+
+// Commands of the NINA firmware.
+const (
+	ninaSetNet            = 0x10
+	ninaSetPassphrase     = 0x11
+	ninaSetHostname       = 0x16
+	ninaSetPowerMode      = 0x17
+	ninaSetAPNet          = 0x18
+	ninaSetAPPassphrase   = 0x19
+	ninaSetDebug          = 0x1A
+	ninaGetTemperature    = 0x1B
+	ninaGetReasonCode     = 0x1F
+	ninaGetConnStatus     = 0x20
+	ninaGetIPAddr         = 0x21
+	ninaGetMACAddr        = 0x22
+	ninaGetCurrSSID       = 0x23
+	ninaGetCurrBSSID      = 0x24
+	ninaGetCurrRSSI       = 0x25
+	ninaGetCurrEncrType   = 0x26
+	ninaScanNetworks      = 0x27
+	ninaStartServerTCP    = 0x28
+	ninaGetStateTCP       = 0x29
+	ninaDataSentTCP       = 0x2A
+	ninaAvailDataTCP      = 0x2B
+	ninaStartClientTCP    = 0x2D
+	ninaStopClientTCP     = 0x2E
+	ninaGetClientStateTCP = 0x2F
+	ninaDisconnect        = 0x30
+	ninaGetIdxRSSI        = 0x32
+	ninaGetIdxEncrType    = 0x33
+	ninaReqHostByName     = 0x34
+	ninaGetHostByName     = 0x35
+	ninaStartScanNetworks = 0x36
+	ninaGetFwVersion      = 0x37
+	ninaSendDataUDP       = 0x39
+	ninaGetRemoteData     = 0x3A
+	ninaGetTime           = 0x3B
+	ninaGetIdxBSSID       = 0x3C
+	ninaGetIdxChannel     = 0x3D
+	ninaGetSocket         = 0x3F
+	ninaSetClientCert     = 0x40
+	ninaSetCertKey        = 0x41
+	ninaSendDataTCP       = 0x44
+	ninaGetDatabufTCP     = 0x45
+	ninaInsertDataBuf     = 0x46
+)
+
+// Connection status, socket modes and TCP states of the NINA firmware.
+const (
+	ninaStatusNoSSIDAvail   = 1
+	ninaStatusConnected     = 3
+	ninaStatusConnectFailed = 4
+	ninaStatusDisconnected  = 6
+
+	ninaModeTCP = 0
+	ninaModeUDP = 1
+	ninaModeTLS = 2
+	ninaModeMul = 3
+
+	ninaStateClosed      = 0
+	ninaStateListen      = 1
+	ninaStateEstablished = 4
+	ninaStateCloseWait   = 7
+
+	ninaMaxSockets  = 10
+	ninaMaxNetworks = 10
+	ninaNoSocket    = 0xFF
+)
+
+// NINANetwork is an access point seen by the NINA simulator.
+type NINANetwork struct {
+	SSID     string
+	Password string
+	BSSID    [6]byte
+	RSSI     int32
+	Channel  uint8
+
+	// Encryption is the encryption type, as wifinina.EncryptionType: 7 for
+	// an open network, 4 for WPA2.
+	Encryption uint8
+}
+
+// NINACommand is a command received by the NINA simulator.
+type NINACommand struct {
+	Cmd    uint8
+	Params [][]byte
+}
+
+// NINA simulates the NINA firmware of a u-blox NINA-W102 or of an ESP32 on an
+// AirLift board, as used by the wifinina driver. It implements the device
+// side of the SPI protocol and drives the ready/ack pin, so attach it to an
+// SPIBus and pass its pins to the driver:
+//
+//	sim := tester.NewNINA(t)
+//	defer sim.Close()
+//	dev := &wifinina.Device{
+//		SPI:   tester.NewSPIBus(t, sim),
+//		CS:    sim.CS,
+//		ACK:   sim.ACK,
+//		GPIO0: sim.GPIO0,
+//		RESET: sim.RESET,
+//	}
+//	dev.Configure()
+//
+// The commands for the network, scans, DNS and sockets have default
+// responses; the sockets are bridged to the network of the host like with
+// the ESPAT simulator. The response to any command can be replaced with
+// SetReply. The exported fields must be set before the driver sends its
+// first command.
+type NINA struct {
+	// Networks are returned by scans. Setting the network connects to the
+	// one with the same SSID and Password.
+	Networks []NINANetwork
+
+	// IP, Netmask and Gateway are the configuration once connected, in
+	// dotted decimal notation.
+	IP, Netmask, Gateway string
+
+	MAC             [6]byte
+	FirmwareVersion string
+	Temperature     float32
+
+	// Hosts maps domain names to the addresses returned by the host name
+	// requests. Other names are looked up with the resolver of the host.
+	Hosts map[string]string
+
+	// Dial opens the client connections, net.Dial by default. TLSConfig is
+	// used on top of it for TLS connections, with the server name passed by
+	// the driver or else the address connected to.
+	Dial      func(network, address string) (net.Conn, error)
+	TLSConfig *tls.Config
+
+	// Listen opens the TCP servers, by default net.Listen on 127.0.0.1 and
+	// the port of the command. ServerAddr returns the address it listens on.
+	Listen func(network, address string) (net.Listener, error)
+
+	// CS, ACK, GPIO0 and RESET are the pins to pass to the driver.
+	CS, ACK, GPIO0, RESET *Pin
+
+	c Failer
+
+	mu       sync.Mutex
+	selected bool
+	rx       []byte // command being received
+	tx       []byte // response to the last command
+	txPos    int
+	replies  map[uint8][][]byte
+	commands []NINACommand
+	network  *NINANetwork
+	status   uint8
+	hostIP   []byte
+	hostname string
+	sockets  [ninaMaxSockets]*ninaSocket
+}
+
+// ninaSocket is a socket of the simulator.
+type ninaSocket struct {
+	mode uint8
+
+	// TCP and TLS connections, closed is set once the remote end closed it
+	conn   net.Conn
+	data   []byte
+	closed bool
+
+	// TCP servers, and the server of the connections accepted by one
+	ln         net.Listener
+	listenPort int
+	server     *ninaSocket
+
+	// UDP sockets: the packets received, the packet being read, the packet
+	// being built and its destination
+	udp     *net.UDPConn
+	packets []ninaPacket
+	packet  ninaPacket
+	out     []byte
+	dest    *net.UDPAddr
+}
+
+type ninaPacket struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// NewNINA returns a simulator with one open network named "tinygo".
+// Call Close at the end of the test to close the connections of the host it
+// opened.
+func NewNINA(c Failer) *NINA {
+	n := &NINA{
+		Networks: []NINANetwork{{
+			SSID:       "tinygo",
+			BSSID:      [6]byte{0xA0, 0xB1, 0xC2, 0xD3, 0xE4, 0xF5},
+			RSSI:       -50,
+			Channel:    6,
+			Encryption: 7,
+		}},
+		IP:              "192.168.1.101",
+		Netmask:         "255.255.255.0",
+		Gateway:         "192.168.1.1",
+		MAC:             [6]byte{0x24, 0x0A, 0xC4, 0x00, 0x00, 0x01},
+		FirmwareVersion: "1.4.8",
+		Temperature:     25,
+		Hosts:           make(map[string]string),
+		Dial:            net.Dial,
+		CS:              NewPin(true),
+		ACK:             NewPin(false),
+		GPIO0:           NewPin(false),
+		RESET:           NewPin(true),
+		c:               c,
+		replies:         make(map[uint8][][]byte),
+		status:          ninaStatusDisconnected,
+	}
+	n.CS.OnChange = n.chipSelect
+	return n
+}
+
+// SetReply replaces the response to cmd with params. Without params the
+// firmware responds with an error.
+func (n *NINA) SetReply(cmd uint8, params ...[]byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.replies[cmd] = params
+}
+
+// ClearReply restores the default response to cmd.
+func (n *NINA) ClearReply(cmd uint8) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.replies, cmd)
+}
+
+// Commands returns the commands received so far.
+func (n *NINA) Commands() []NINACommand {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]NINACommand(nil), n.commands...)
+}
+
+// AssertCommand fails the test if cmd was not received.
+func (n *NINA) AssertCommand(cmd uint8) {
+	for _, c := range n.Commands() {
+		if c.Cmd == cmd {
+			return
+		}
+	}
+	n.c.Helper()
+	n.c.Fatalf("command 0x%02X not received", cmd)
+}
+
+// Hostname returns the host name set by the driver.
+func (n *NINA) Hostname() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.hostname
+}
+
+// ServerAddr returns the address of the TCP server started on port, or nil
+// if there is none.
+func (n *NINA) ServerAddr(port int) net.Addr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, s := range n.sockets {
+		if s != nil && s.ln != nil && s.listenPort == port {
+			return s.ln.Addr()
+		}
+	}
+	return nil
+}
+
+// Close closes all the sockets.
+func (n *NINA) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := range n.sockets {
+		n.stop(uint8(i))
+	}
+}
+
+// Exchange implements SPIDevice.
+func (n *NINA) Exchange(w byte) byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.selected {
+		return 0xFF
+	}
+	if n.tx != nil {
+		if n.txPos < len(n.tx) {
+			n.txPos++
+			return n.tx[n.txPos-1]
+		}
+		return 0xFF
+	}
+	n.rx = append(n.rx, w)
+	return 0xFF
+}
+
+// chipSelect is called when the driver changes CS. The firmware raises ACK
+// while selected, and executes the command once deselected. The next
+// selection then reads the response.
+func (n *NINA) chipSelect(level bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !level {
+		n.selected = true
+		n.ACK.Set(true)
+		return
+	}
+
+	n.selected = false
+	if n.tx != nil {
+		n.tx, n.txPos = nil, 0
+	} else if len(n.rx) > 0 {
+		n.tx = n.execute(n.rx)
+		n.rx = n.rx[:0]
+	}
+	n.ACK.Set(false)
+}
+
+// execute parses a command, and returns the response to send.
+func (n *NINA) execute(b []byte) []byte {
+	if len(b) < 3 || b[0] != ninaStart {
+		return []byte{ninaErr}
+	}
+	cmd := b[1] &^ ninaReply
+	count := int(b[2])
+	b = b[3:]
+
+	var params [][]byte
+	for i := 0; i < count; i++ {
+		var size int
+		if cmd&ninaData != 0 {
+			if len(b) < 2 {
+				return []byte{ninaErr}
+			}
+			size, b = int(binary.BigEndian.Uint16(b)), b[2:]
+		} else {
+			if len(b) < 1 {
+				return []byte{ninaErr}
+			}
+			size, b = int(b[0]), b[1:]
+		}
+		if len(b) < size {
+			return []byte{ninaErr}
+		}
+		params = append(params, clone(b[:size]))
+		b = b[size:]
+	}
+	if len(b) < 1 || b[0] != ninaEnd {
+		return []byte{ninaErr}
+	}
+	n.commands = append(n.commands, NINACommand{Cmd: cmd, Params: params})
+
+	reply, ok := n.replies[cmd]
+	if !ok {
+		reply = n.command(cmd, params)
+	}
+	if len(reply) == 0 {
+		return []byte{ninaErr}
+	}
+
+	resp := []byte{ninaStart, cmd | ninaReply, byte(len(reply))}
+	for _, p := range reply {
+		if cmd == ninaGetDatabufTCP {
+			resp = append(resp, byte(len(p)>>8))
+		}
+		resp = append(resp, byte(len(p)))
+		resp = append(resp, p...)
+	}
+	return append(resp, ninaEnd)
+}
+
+// command executes a command, and returns the parameters of the response,
+// none for an error.
+func (n *NINA) command(cmd uint8, params [][]byte) [][]byte {
+	ok := [][]byte{{1}}
+	param := func(i int) []byte {
+		if i < len(params) {
+			return params[i]
+		}
+		return nil
+	}
+	sock := func(i int) (uint8, bool) {
+		p := param(i)
+		return firstByte(p), len(p) == 1 && p[0] < ninaMaxSockets
+	}
+
+	switch cmd {
+	case ninaSetNet:
+		n.join(string(param(0)), "")
+		return ok
+	case ninaSetPassphrase:
+		n.join(string(param(0)), string(param(1)))
+		return ok
+	case ninaSetHostname:
+		n.hostname = string(param(0))
+		return ok
+	case ninaSetPowerMode, ninaSetDebug, ninaSetAPNet, ninaSetAPPassphrase, ninaSetClientCert, ninaSetCertKey,
+		ninaStartScanNetworks, ninaDataSentTCP:
+		return ok
+	case ninaDisconnect:
+		n.network = nil
+		n.status = ninaStatusDisconnected
+		return ok
+	case ninaGetTemperature:
+		return [][]byte{le32(math.Float32bits(n.Temperature))}
+	case ninaGetReasonCode:
+		return [][]byte{{0}}
+	case ninaGetConnStatus:
+		return [][]byte{{n.status}}
+	case ninaGetIPAddr:
+		if n.network == nil {
+			return [][]byte{ipv4(""), ipv4(""), ipv4("")}
+		}
+		return [][]byte{ipv4(n.IP), ipv4(n.Netmask), ipv4(n.Gateway)}
+	case ninaGetMACAddr:
+		return [][]byte{reversed(n.MAC)}
+	case ninaGetTime:
+		return [][]byte{le32(uint32(time.Now().Unix()))}
+	case ninaGetFwVersion:
+		return [][]byte{[]byte(n.FirmwareVersion)}
+
+	case ninaGetCurrSSID, ninaGetCurrBSSID, ninaGetCurrRSSI, ninaGetCurrEncrType:
+		var ap NINANetwork
+		if n.network != nil {
+			ap = *n.network
+		}
+		return n.networkInfo(cmd, ap)
+	case ninaScanNetworks:
+		var ssids [][]byte
+		for i, ap := range n.Networks {
+			if i == ninaMaxNetworks {
+				break
+			}
+			ssids = append(ssids, []byte(ap.SSID))
+		}
+		if len(ssids) == 0 {
+			// the driver expects at least one parameter
+			ssids = [][]byte{{}}
+		}
+		return ssids
+	case ninaGetIdxRSSI, ninaGetIdxEncrType, ninaGetIdxBSSID, ninaGetIdxChannel:
+		var ap NINANetwork
+		if i := int(firstByte(param(0))); i < len(n.Networks) {
+			ap = n.Networks[i]
+		}
+		return n.networkInfo(cmd, ap)
+
+	case ninaReqHostByName:
+		n.hostIP = n.lookup(string(param(0)))
+		if n.hostIP == nil {
+			return [][]byte{{0}}
+		}
+		return ok
+	case ninaGetHostByName:
+		if n.hostIP == nil {
+			return [][]byte{{255, 255, 255, 255}}
+		}
+		return [][]byte{n.hostIP}
+
+	case ninaGetSocket:
+		for i, s := range n.sockets {
+			if s == nil {
+				return [][]byte{{byte(i)}}
+			}
+		}
+		return [][]byte{{ninaNoSocket}}
+	case ninaStartClientTCP:
+		var host string
+		if len(params) == 5 {
+			host, params = string(params[0]), params[1:]
+		}
+		if len(params) != 4 || len(params[0]) != 4 || len(params[1]) != 2 {
+			return nil
+		}
+		id, valid := sock(2)
+		if !valid {
+			return nil
+		}
+		ip := net.IP(params[0]).String()
+		port := int(binary.BigEndian.Uint16(params[1]))
+		return [][]byte{{boolByte(n.startClient(id, host, ip, port, firstByte(params[3])))}}
+	case ninaStartServerTCP:
+		// the group address comes first in multicast mode
+		var group string
+		if len(params) == 4 && len(params[0]) == 4 {
+			group, params = net.IP(params[0]).String(), params[1:]
+		}
+		id, valid := sock(1)
+		if len(params) != 3 || len(params[0]) != 2 || !valid {
+			return nil
+		}
+		port := int(binary.BigEndian.Uint16(params[0]))
+		return [][]byte{{boolByte(n.startServer(id, group, port, firstByte(params[2])))}}
+	case ninaStopClientTCP:
+		id, valid := sock(0)
+		if !valid {
+			return nil
+		}
+		n.stop(id)
+		return ok
+	case ninaGetStateTCP, ninaGetClientStateTCP:
+		id, valid := sock(0)
+		if !valid {
+			return nil
+		}
+		return [][]byte{{n.state(n.sockets[id])}}
+	case ninaAvailDataTCP:
+		id, valid := sock(0)
+		if !valid {
+			return nil
+		}
+		v := make([]byte, 2)
+		binary.LittleEndian.PutUint16(v, n.available(n.sockets[id]))
+		return [][]byte{v}
+	case ninaGetDatabufTCP:
+		id, valid := sock(0)
+		if !valid || len(param(1)) != 2 {
+			return nil
+		}
+		return [][]byte{n.read(n.sockets[id], int(binary.LittleEndian.Uint16(param(1))))}
+	case ninaSendDataTCP:
+		id, valid := sock(0)
+		if !valid {
+			return nil
+		}
+		written := make([]byte, 2)
+		if s := n.sockets[id]; s != nil && s.conn != nil && s.udp == nil {
+			if m, err := s.conn.Write(param(1)); err == nil {
+				binary.BigEndian.PutUint16(written, uint16(m))
+			}
+		}
+		return [][]byte{written}
+	case ninaInsertDataBuf:
+		id, valid := sock(0)
+		if !valid || n.sockets[id] == nil || n.sockets[id].udp == nil {
+			return [][]byte{{0}}
+		}
+		s := n.sockets[id]
+		s.out = append(s.out, param(1)...)
+		return ok
+	case ninaSendDataUDP:
+		id, valid := sock(0)
+		if !valid || n.sockets[id] == nil || n.sockets[id].udp == nil || n.sockets[id].dest == nil {
+			return [][]byte{{0}}
+		}
+		s := n.sockets[id]
+		_, err := s.udp.WriteToUDP(s.out, s.dest)
+		s.out = s.out[:0]
+		return [][]byte{{boolByte(err == nil)}}
+	case ninaGetRemoteData:
+		id, valid := sock(0)
+		if !valid || n.sockets[id] == nil || n.sockets[id].packet.from == nil {
+			return [][]byte{{0, 0, 0, 0}, {0, 0}}
+		}
+		from := n.sockets[id].packet.from
+		port := make([]byte, 2)
+		binary.BigEndian.PutUint16(port, uint16(from.Port))
+		return [][]byte{ipv4(from.IP.String()), port}
+	}
+	return nil
+}
+
+// networkInfo returns the response to the commands returning information
+// about the current network or a scanned one.
+func (n *NINA) networkInfo(cmd uint8, ap NINANetwork) [][]byte {
+	switch cmd {
+	case ninaGetCurrSSID:
+		return [][]byte{[]byte(ap.SSID)}
+	case ninaGetCurrBSSID, ninaGetIdxBSSID:
+		return [][]byte{reversed(ap.BSSID)}
+	case ninaGetCurrRSSI, ninaGetIdxRSSI:
+		return [][]byte{le32(uint32(ap.RSSI))}
+	case ninaGetIdxChannel:
+		return [][]byte{{ap.Channel}}
+	default:
+		return [][]byte{{ap.Encryption}}
+	}
+}
+
+// join connects to a network, the driver then polls the connection status.
+func (n *NINA) join(ssid, password string) {
+	n.network = nil
+	n.status = ninaStatusNoSSIDAvail
+	for i := range n.Networks {
+		ap := &n.Networks[i]
+		if ap.SSID != ssid {
+			continue
+		}
+		if ap.Password != password {
+			n.status = ninaStatusConnectFailed
+			return
+		}
+		n.network = ap
+		n.status = ninaStatusConnected
+		return
+	}
+}
+
+// lookup returns the IPv4 address of host, or nil.
+func (n *NINA) lookup(host string) []byte {
+	if addr, ok := n.Hosts[host]; ok {
+		host = addr
+	}
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		return ip
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	return nil
+}
+
+// startClient connects a socket, or sets the destination of a UDP socket.
+func (n *NINA) startClient(id uint8, host, ip string, port int, mode uint8) bool {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	if mode == ninaModeUDP {
+		s := n.sockets[id]
+		if s == nil {
+			s = &ninaSocket{mode: mode}
+			if !n.bindUDP(id, s, 0) {
+				return false
+			}
+		}
+		dest, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			return false
+		}
+		s.dest = dest
+		return true
+	}
+
+	if n.network == nil || n.sockets[id] != nil {
+		return false
+	}
+	conn, err := n.Dial("tcp", address)
+	if err != nil {
+		return false
+	}
+	if mode == ninaModeTLS {
+		config := &tls.Config{}
+		if n.TLSConfig != nil {
+			config = n.TLSConfig.Clone()
+		}
+		config.ServerName = ip
+		if host != "" {
+			config.ServerName = host
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return false
+		}
+		conn = tlsConn
+	}
+	s := &ninaSocket{mode: mode, conn: conn}
+	n.sockets[id] = s
+	go n.readConn(s)
+	return true
+}
+
+// startServer starts a TCP server, or binds a UDP socket to a local port.
+func (n *NINA) startServer(id uint8, group string, port int, mode uint8) bool {
+	if n.sockets[id] != nil {
+		return false
+	}
+	s := &ninaSocket{mode: mode}
+	switch mode {
+	case ninaModeUDP:
+		return n.bindUDP(id, s, port)
+	case ninaModeMul:
+		udp, err := net.ListenMulticastUDP("udp4", nil, &net.UDPAddr{IP: net.ParseIP(group), Port: port})
+		if err != nil {
+			return false
+		}
+		s.mode, s.udp, s.conn = ninaModeUDP, udp, udp
+		n.sockets[id] = s
+		go n.readUDP(s)
+		return true
+	}
+
+	listen := n.Listen
+	if listen == nil {
+		listen = net.Listen
+	}
+	ln, err := listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	s.ln, s.listenPort = ln, port
+	n.sockets[id] = s
+	go n.accept(s)
+	return true
+}
+
+// bindUDP opens the UDP socket of s on the local port.
+func (n *NINA) bindUDP(id uint8, s *ninaSocket, port int) bool {
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return false
+	}
+	s.udp, s.conn = udp, udp
+	n.sockets[id] = s
+	go n.readUDP(s)
+	return true
+}
+
+// stop closes a socket. The connections accepted by a server stay open.
+func (n *NINA) stop(id uint8) {
+	s := n.sockets[id]
+	if s == nil {
+		return
+	}
+	n.sockets[id] = nil
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	if s.ln != nil {
+		s.ln.Close()
+	}
+}
+
+// state returns the TCP state of a socket.
+func (n *NINA) state(s *ninaSocket) uint8 {
+	switch {
+	case s == nil:
+		return ninaStateClosed
+	case s.ln != nil:
+		return ninaStateListen
+	case s.closed:
+		return ninaStateCloseWait
+	default:
+		return ninaStateEstablished
+	}
+}
+
+// available returns the number of bytes that can be read from a socket. For
+// servers, it returns the first client with data available, or 255. For UDP
+// sockets, the next packet is parsed once the current one has been read.
+func (n *NINA) available(s *ninaSocket) uint16 {
+	switch {
+	case s == nil:
+		return 0
+	case s.ln != nil:
+		for i, c := range n.sockets {
+			if c != nil && c.server == s && len(c.data) > 0 {
+				return uint16(i)
+			}
+		}
+		return ninaNoSocket
+	case s.udp != nil:
+		if len(s.packet.data) == 0 && len(s.packets) > 0 {
+			s.packet = s.packets[0]
+			s.packets = s.packets[1:]
+		}
+		return uint16(len(s.packet.data))
+	default:
+		return uint16(len(s.data))
+	}
+}
+
+// read returns up to size bytes received by a socket.
+func (n *NINA) read(s *ninaSocket, size int) []byte {
+	if s == nil {
+		return nil
+	}
+	data := &s.data
+	if s.udp != nil {
+		data = &s.packet.data
+	}
+	if size > len(*data) {
+		size = len(*data)
+	}
+	b := clone((*data)[:size])
+	*data = (*data)[size:]
+	return b
+}
+
+// accept gives a socket to every connection to a TCP server.
+func (n *NINA) accept(server *ninaSocket) {
+	for {
+		conn, err := server.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		s := &ninaSocket{mode: ninaModeTCP, conn: conn, server: server}
+		added := false
+		for i, c := range n.sockets {
+			if c == nil {
+				n.sockets[i] = s
+				added = true
+				break
+			}
+		}
+		n.mu.Unlock()
+
+		if !added {
+			conn.Close()
+			continue
+		}
+		go n.readConn(s)
+	}
+}
+
+// readConn buffers the data received by a TCP or TLS connection.
+func (n *NINA) readConn(s *ninaSocket) {
+	buf := make([]byte, 1024)
+	for {
+		m, err := s.conn.Read(buf)
+		n.mu.Lock()
+		s.data = append(s.data, buf[:m]...)
+		if err != nil {
+			s.closed = true
+		}
+		n.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readUDP queues the packets received by a UDP socket.
+func (n *NINA) readUDP(s *ninaSocket) {
+	buf := make([]byte, 1500)
+	for {
+		m, from, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		n.mu.Lock()
+		s.packets = append(s.packets, ninaPacket{data: clone(buf[:m]), from: from})
+		n.mu.Unlock()
+	}
+}
+
+func firstByte(b []byte) byte {
+	if len(b) == 0 {
+		return 0
+	}
+	return b[0]
+}
+
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+// ipv4 returns the 4 bytes of a dotted decimal address, zeros if invalid.
+func ipv4(s string) []byte {
+	if ip := net.ParseIP(s).To4(); ip != nil {
+		return clone(ip)
+	}
+	return make([]byte, 4)
+}
+
+// reversed returns a MAC address in the byte order sent by the firmware.
+func reversed(mac [6]byte) []byte {
+	return []byte{mac[5], mac[4], mac[3], mac[2], mac[1], mac[0]}
+}
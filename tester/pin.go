@@ -0,0 +1,50 @@
+package tester
+
+import "tinygo.org/x/drivers"
+
+var _ drivers.Pin = (*Pin)(nil)
+
+// Pin is a fake GPIO pin that implements drivers.Pin. The driver and the
+// simulated device both set its level, the device can be notified of the
+// changes made by the driver with OnChange.
+type Pin struct {
+	level bool
+
+	// OnChange, if not nil, is called when the driver changes the level of
+	// the pin with High or Low.
+	OnChange func(level bool)
+}
+
+// NewPin returns a pin at the given level.
+func NewPin(level bool) *Pin {
+	return &Pin{level: level}
+}
+
+// Get implements drivers.Pin.
+func (p *Pin) Get() bool {
+	return p.level
+}
+
+// High implements drivers.Pin.
+func (p *Pin) High() {
+	p.drive(true)
+}
+
+// Low implements drivers.Pin.
+func (p *Pin) Low() {
+	p.drive(false)
+}
+
+func (p *Pin) drive(level bool) {
+	changed := p.level != level
+	p.level = level
+	if changed && p.OnChange != nil {
+		p.OnChange(level)
+	}
+}
+
+// Set sets the level of the pin, as driven by the device. OnChange is not
+// called.
+func (p *Pin) Set(level bool) {
+	p.level = level
+}
@@ -0,0 +1,156 @@
+package tester
+
+import (
+	"fmt"
+
+	"tinygo.org/x/drivers"
+)
+
+// SPITransaction is a single Tx or Transfer call recorded by an SPIBus.
+type SPITransaction struct {
+	// W holds the bytes written by the driver and R the bytes returned to
+	// it. W is nil for read-only transfers and R is nil for write-only ones.
+	W, R []byte
+
+	// Err is the error returned to the driver, if any.
+	Err error
+}
+
+func (tx SPITransaction) String() string {
+	return fmt.Sprintf("w=% X r=% X err=%v", tx.W, tx.R, tx.Err)
+}
+
+// SPIDevice is the device side of an SPIBus. The chip select pin is driven
+// by the driver through a machine.Pin and is therefore not visible to the
+// bus, so devices must find the frame boundaries in the byte stream.
+type SPIDevice interface {
+	// Exchange is called for every byte clocked on the bus. It receives the
+	// byte sent by the driver and returns the byte sent back to it.
+	Exchange(w byte) byte
+}
+
+var _ drivers.SPI = (*SPIBus)(nil)
+
+// SPIBus is a fake SPI bus that implements drivers.SPI. It passes every byte
+// to the attached device and records every transaction.
+type SPIBus struct {
+	c   Failer
+	dev SPIDevice
+	txs []SPITransaction
+
+	// Err, if not nil, is returned by every transfer. No bytes reach the
+	// device while it is set.
+	Err error
+}
+
+// NewSPIBus returns an SPI bus with the given device attached. If dev is nil
+// the bus reads back zeros, as if nothing was driving MISO.
+func NewSPIBus(c Failer, dev SPIDevice) *SPIBus {
+	return &SPIBus{c: c, dev: dev}
+}
+
+// Tx implements drivers.SPI.
+func (bus *SPIBus) Tx(w, r []byte) error {
+	if w != nil && r != nil && len(w) != len(r) {
+		bus.c.Helper()
+		bus.c.Fatalf("SPI Tx with different buffer lengths: %d and %d", len(w), len(r))
+	}
+	if bus.Err != nil {
+		bus.txs = append(bus.txs, SPITransaction{W: clone(w), Err: bus.Err})
+		return bus.Err
+	}
+	n := len(w)
+	if len(r) > n {
+		n = len(r)
+	}
+	for i := 0; i < n; i++ {
+		var b byte
+		if w != nil {
+			b = w[i]
+		}
+		b = bus.exchange(b)
+		if r != nil {
+			r[i] = b
+		}
+	}
+	bus.txs = append(bus.txs, SPITransaction{W: clone(w), R: clone(r)})
+	return nil
+}
+
+// Transfer implements drivers.SPI.
+func (bus *SPIBus) Transfer(w byte) (byte, error) {
+	if bus.Err != nil {
+		bus.txs = append(bus.txs, SPITransaction{W: []byte{w}, Err: bus.Err})
+		return 0, bus.Err
+	}
+	r := bus.exchange(w)
+	bus.txs = append(bus.txs, SPITransaction{W: []byte{w}, R: []byte{r}})
+	return r, nil
+}
+
+func (bus *SPIBus) exchange(w byte) byte {
+	if bus.dev == nil {
+		return 0
+	}
+	return bus.dev.Exchange(w)
+}
+
+// Transactions returns all transactions recorded since the bus was created
+// or since the last call to ClearTransactions.
+func (bus *SPIBus) Transactions() []SPITransaction {
+	return bus.txs
+}
+
+// ClearTransactions forgets all recorded transactions.
+func (bus *SPIBus) ClearTransactions() {
+	bus.txs = bus.txs[:0]
+}
+
+// Written returns all bytes written by the driver since the last call to
+// ClearTransactions, concatenated.
+func (bus *SPIBus) Written() []byte {
+	var w []byte
+	for _, tx := range bus.txs {
+		if tx.W != nil {
+			w = append(w, tx.W...)
+		} else {
+			w = append(w, make([]byte, len(tx.R))...)
+		}
+	}
+	return w
+}
+
+// AssertWritten fails the test unless the bytes written by the driver since
+// the last call to ClearTransactions are exactly data.
+func (bus *SPIBus) AssertWritten(data ...byte) {
+	if w := bus.Written(); !equal(w, data) {
+		bus.c.Helper()
+		bus.c.Fatalf("SPI written: got % X, want % X", w, data)
+	}
+}
+
+// SPIQueueDevice is a fake SPI device that returns queued bytes in order,
+// regardless of what is written to it. Once the queue is empty it returns
+// Idle. It is enough for simple devices such as ADCs that answer a fixed
+// length command.
+type SPIQueueDevice struct {
+	// Idle is returned when no bytes are queued.
+	Idle byte
+
+	queue []byte
+}
+
+// Queue appends bytes to be returned by the following exchanges.
+func (d *SPIQueueDevice) Queue(data ...byte) {
+	d.queue = append(d.queue, data...)
+}
+
+// Exchange implements SPIDevice.
+func (d *SPIQueueDevice) Exchange(w byte) byte {
+	if len(d.queue) == 0 {
+		return d.Idle
+	}
+	b := d.queue[0]
+	d.queue = d.queue[1:]
+	return b
+}
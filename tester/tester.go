@@ -0,0 +1,81 @@
+// Package tester provides fake I2C, SPI and UART buses, pins and devices so
+// that drivers can be exercised on the host with plain "go test", without any
+// hardware.
+//
+// The fakes implement the drivers.I2C, drivers.SPI, drivers.UART and
+// drivers.Pin interfaces. Every transaction is recorded so that tests can
+// assert on the exact bytes a driver puts on the bus, and errors or NACKs can
+// be injected per device. The ESPAT and NINA devices simulate the firmware of
+// the WiFi co-processors used by the espat and wifinina drivers, bridging
+// their connections to the network of the host.
+//
+// Here is an example of a test for a driver using an I2C register device:
+//
+//	func TestConfigure(t *testing.T) {
+//		bus := tester.NewI2CBus(t)
+//		fake := tester.NewI2CDevice8(t, bme280.Address)
+//		bus.AddDevice(fake)
+//
+//		dev := bme280.New(bus)
+//		dev.Configure()
+//
+//		fake.AssertRegister(bme280.CTRL_MEAS_ADDR, 0xB7)
+//	}
+//
+// And one for the espat driver:
+//
+//	func TestConnect(t *testing.T) {
+//		uart := tester.NewUART()
+//		sim := tester.NewESPAT(t, uart)
+//		defer sim.Close()
+//
+//		dev := espat.New(uart)
+//		if err := dev.ConnectToAP("tinygo", "", 5); err != nil {
+//			t.Fatal(err)
+//		}
+//		sim.AssertCommand(`AT+CWJAP="tinygo",""`)
+//	}
+//
+package tester // import "tinygo.org/x/drivers/tester"
+
+import "errors"
+
+var (
+	// ErrNACK is returned when no device acknowledges an I2C address, or
+	// when a device has been configured to not acknowledge.
+	ErrNACK = errors.New("tester: I2C NACK")
+)
+
+// Failer is implemented by *testing.T and *testing.B. It is used to report
+// assertion failures without making this package depend on "testing".
+type Failer interface {
+	// Helper marks the calling function as a test helper function.
+	Helper()
+
+	// Fatalf logs the message and marks the test as failed.
+	Fatalf(format string, args ...interface{})
+}
+
+// clone returns a copy of b, so that recorded transactions are not modified
+// by the driver reusing its buffers.
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// equal reports whether a and b contain the same bytes.
+func equal(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
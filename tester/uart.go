@@ -0,0 +1,90 @@
+package tester
+
+import (
+	"sync"
+
+	"tinygo.org/x/drivers"
+)
+
+// UARTDevice is the device side of a UART, such as the ESPAT simulator.
+type UARTDevice interface {
+	// Receive is called with the bytes written by the driver. The slice is
+	// not used by the UART once Receive returns.
+	Receive(b []byte)
+}
+
+var _ drivers.UART = (*UART)(nil)
+
+// UART is a fake UART that implements drivers.UART. The bytes written by the
+// driver are passed to the attached device, and the device sends bytes back
+// to the driver with Send. Unlike the other fakes of this package it is safe
+// for concurrent use, so that devices can send data from their own
+// goroutines.
+type UART struct {
+	mu      sync.Mutex
+	rx      []byte
+	written []byte
+
+	// serializes the calls to the device
+	wmu sync.Mutex
+	dev UARTDevice
+}
+
+// NewUART returns a UART without any device attached. The bytes written to
+// it are only recorded until SetDevice is called.
+func NewUART() *UART {
+	return &UART{}
+}
+
+// SetDevice attaches the device to the UART.
+func (u *UART) SetDevice(dev UARTDevice) {
+	u.wmu.Lock()
+	defer u.wmu.Unlock()
+	u.dev = dev
+}
+
+// Write implements drivers.UART. The device receives the bytes before Write
+// returns.
+func (u *UART) Write(b []byte) (int, error) {
+	u.mu.Lock()
+	u.written = append(u.written, b...)
+	u.mu.Unlock()
+
+	u.wmu.Lock()
+	defer u.wmu.Unlock()
+	if u.dev != nil {
+		u.dev.Receive(clone(b))
+	}
+	return len(b), nil
+}
+
+// Read implements drivers.UART. Like machine.UART, it does not wait for
+// data and returns 0 when none has been sent by the device.
+func (u *UART) Read(b []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	n := copy(b, u.rx)
+	u.rx = u.rx[:copy(u.rx, u.rx[n:])]
+	return n, nil
+}
+
+// Buffered implements drivers.UART.
+func (u *UART) Buffered() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.rx)
+}
+
+// Send queues bytes for the driver to read, as if sent by the device.
+func (u *UART) Send(b []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rx = append(u.rx, b...)
+}
+
+// Written returns a copy of all the bytes written by the driver so far.
+func (u *UART) Written() []byte {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return clone(u.written)
+}
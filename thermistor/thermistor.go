@@ -29,8 +29,12 @@ package thermistor // import "tinygo.org/x/drivers/thermistor"
 import (
 	"machine"
 	"math"
+
+	"tinygo.org/x/drivers"
 )
 
+var _ drivers.Thermometer = (*Device)(nil)
+
 // Device holds the ADC pin and the needed settings for calculating the
 // temperature based on the resistance.
 type Device struct {
@@ -0,0 +1,19 @@
+package drivers
+
+import "io"
+
+// UART represents a UART connection. It is notably implemented by the
+// machine.UART type, but can also be implemented by another serial port or a
+// fake UART for testing.
+type UART interface {
+	// Read reads the bytes received so far into b. It does not wait for
+	// more, and returns 0 when nothing has been received.
+	io.Reader
+
+	// Write transmits the bytes in b.
+	io.Writer
+
+	// Buffered returns the number of bytes received that have not been read
+	// yet.
+	Buffered() int
+}
@@ -10,10 +10,16 @@
 package vl53l1x // import "tinygo.org/x/drivers/vl53l1x"
 
 import (
-	"machine"
+	"errors"
 	"time"
+
+	"tinygo.org/x/drivers"
 )
 
+// ErrTimeout is returned when no measurement is ready within the configured
+// timeout.
+var ErrTimeout = errors.New("vl53l1x: timeout waiting for measurement")
+
 type DistanceMode uint8
 type RangeStatus uint8
 
@@ -33,9 +39,11 @@ type resultBuffer struct {
 	signalRateCrosstalkMCPSSD0 uint16
 }
 
+var _ drivers.DistanceSensor = (*Device)(nil)
+
 // Device wraps an I2C connection to a VL53L1X device.
 type Device struct {
-	bus                machine.I2C
+	bus                drivers.I2C
 	Address            uint16
 	mode               DistanceMode
 	timeout            uint32
@@ -52,7 +60,7 @@ type Device struct {
 // configured.
 //
 // This function only creates the Device object, it does not touch the device.
-func New(bus machine.I2C) Device {
+func New(bus drivers.I2C) Device {
 	return Device{
 		bus:     bus,
 		Address: Address,
@@ -235,6 +243,21 @@ func (d *Device) SetMeasurementTimingBudget(budgetMicroseconds uint32) bool {
 // Read stores in the buffer the values of the sensor and returns
 // the current distance in mm
 func (d *Device) Read(blocking bool) uint16 {
+	mm, _ := d.read(blocking)
+	return mm
+}
+
+// ReadDistance waits for a measurement and returns the distance in mm. It
+// returns ErrTimeout if the measurement is not ready within the timeout set
+// with SetTimeout.
+func (d *Device) ReadDistance() (int32, error) {
+	mm, err := d.read(true)
+	return int32(mm), err
+}
+
+// read stores in the buffer the values of the sensor and returns the
+// current distance in mm
+func (d *Device) read(blocking bool) (uint16, error) {
 	if blocking {
 		start := time.Now()
 
@@ -245,7 +268,7 @@ func (d *Device) Read(blocking bool) uint16 {
 				d.rangingData.mm = 0
 				d.rangingData.signalRateMCPS = 0
 				d.rangingData.ambientRateMCPS = 0
-				return d.rangingData.mm
+				return d.rangingData.mm, ErrTimeout
 			}
 		}
 	}
@@ -260,7 +283,7 @@ func (d *Device) Read(blocking bool) uint16 {
 	d.getRangingData()
 	d.writeReg(SYSTEM_INTERRUPT_CLEAR, 0x01) //sys_interrupt_clear_range
 
-	return d.rangingData.mm
+	return d.rangingData.mm, nil
 }
 
 // updateDSS updates the DSS
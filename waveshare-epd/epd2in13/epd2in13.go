@@ -7,8 +7,10 @@ package epd2in13 // import "tinygo.org/x/drivers/waveshare-epd/epd2in13"
 import (
 	"errors"
 	"image/color"
-	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 )
 
 type Config struct {
@@ -19,11 +21,11 @@ type Config struct {
 }
 
 type Device struct {
-	bus          machine.SPI
-	cs           machine.Pin
-	dc           machine.Pin
-	rst          machine.Pin
-	busy         machine.Pin
+	bus          drivers.SPI
+	cs           drivers.Pin
+	dc           drivers.Pin
+	rst          drivers.Pin
+	busy         drivers.Pin
 	logicalWidth int16
 	width        int16
 	height       int16
@@ -51,11 +53,11 @@ var lutPartialUpdate = [30]uint8{
 }
 
 // New returns a new epd2in13x driver. Pass in a fully configured SPI bus.
-func New(bus machine.SPI, csPin, dcPin, rstPin, busyPin machine.Pin) Device {
-	csPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	dcPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	rstPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	busyPin.Configure(machine.PinConfig{Mode: machine.PinInput})
+func New(bus drivers.SPI, csPin, dcPin, rstPin, busyPin drivers.Pin) Device {
+	pin.ConfigureOutput(csPin)
+	pin.ConfigureOutput(dcPin)
+	pin.ConfigureOutput(rstPin)
+	pin.ConfigureInput(busyPin)
 	return Device{
 		bus:  bus,
 		cs:   csPin,
@@ -7,8 +7,10 @@ package epd2in13x // import "tinygo.org/x/drivers/waveshare-epd/epd2in13x"
 import (
 	"errors"
 	"image/color"
-	"machine"
 	"time"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 )
 
 type Config struct {
@@ -18,11 +20,11 @@ type Config struct {
 }
 
 type Device struct {
-	bus          machine.SPI
-	cs           machine.Pin
-	dc           machine.Pin
-	rst          machine.Pin
-	busy         machine.Pin
+	bus          drivers.SPI
+	cs           drivers.Pin
+	dc           drivers.Pin
+	rst          drivers.Pin
+	busy         drivers.Pin
 	width        int16
 	height       int16
 	buffer       [][]uint8
@@ -32,11 +34,11 @@ type Device struct {
 type Color uint8
 
 // New returns a new epd2in13x driver. Pass in a fully configured SPI bus.
-func New(bus machine.SPI, csPin, dcPin, rstPin, busyPin machine.Pin) Device {
-	csPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	dcPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	rstPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	busyPin.Configure(machine.PinConfig{Mode: machine.PinInput})
+func New(bus drivers.SPI, csPin, dcPin, rstPin, busyPin drivers.Pin) Device {
+	pin.ConfigureOutput(csPin)
+	pin.ConfigureOutput(dcPin)
+	pin.ConfigureOutput(rstPin)
+	pin.ConfigureInput(busyPin)
 	return Device{
 		bus:  bus,
 		cs:   csPin,
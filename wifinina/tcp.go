@@ -1,11 +1,11 @@
 package wifinina
 
 import (
-	"fmt"
-	"strconv"
+	"io"
 	"time"
 
 	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/net/tls"
 )
 
 const (
@@ -13,13 +13,40 @@ const (
 )
 
 func (d *Device) NewDriver() net.DeviceDriver {
-	return &Driver{dev: d, sock: NoSocketAvail}
+	drv := &Driver{dev: d}
+	for i := range drv.sockets {
+		drv.sockets[i].sock = NoSocketAvail
+	}
+	return drv
 }
 
+// Driver implements net.DeviceDriver. The net.Socket handles it returns are
+// indexes in sockets; the WiFiNINA socket is only allocated on connect, as
+// the firmware hands out the same socket until it is in use.
 type Driver struct {
 	dev     *Device
-	sock    uint8
-	readBuf readBuffer
+	sockets [MaxSockets]socket
+}
+
+type socket struct {
+	inUse     bool
+	listening bool
+	sock      uint8
+	protocol  net.Protocol
+	readBuf   readBuffer
+
+	// UDP destination, and sender of the last packet received
+	ip, remoteIP     IPAddress
+	port, remotePort uint16
+
+	// bytes of the UDP packet being read still in the WiFiNINA
+	packetLeft int
+
+	// TLS server name
+	serverName string
+
+	// deadline of the operation on the socket, see SetSocketDeadline
+	deadline time.Time
 }
 
 type readBuffer struct {
@@ -33,22 +60,96 @@ func (drv *Driver) GetDNS(domain string) (string, error) {
 	return ipAddr.String(), err
 }
 
-func (drv *Driver) ConnectTCPSocket(addr, portStr string) error {
-	return drv.connectSocket(addr, portStr, ProtoModeTCP)
+func (drv *Driver) socket(sock net.Socket) (*socket, error) {
+	if sock < 0 || sock >= MaxSockets || !drv.sockets[sock].inUse {
+		return nil, net.ErrInvalidSocket
+	}
+	return &drv.sockets[sock], nil
+}
+
+func (drv *Driver) OpenSocket(protocol net.Protocol) (net.Socket, error) {
+	for i := range drv.sockets {
+		s := &drv.sockets[i]
+		if !s.inUse {
+			s.inUse = true
+			s.listening = false
+			s.sock = NoSocketAvail
+			s.protocol = protocol
+			s.ip, s.remoteIP = "", ""
+			s.port, s.remotePort = 0, 0
+			s.serverName = ""
+			s.readBuf.head = 0
+			s.readBuf.size = 0
+			s.packetLeft = 0
+			s.deadline = time.Time{}
+			return net.Socket(i), nil
+		}
+	}
+	return net.NoSocket, net.ErrNoSocketAvail
 }
 
-func (drv *Driver) ConnectSSLSocket(addr, portStr string) error {
-	return drv.connectSocket(addr, portStr, ProtoModeTLS)
+func (drv *Driver) ConnectSocket(sock net.Socket, addr string, port, localPort int) error {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return err
+	}
+	switch s.protocol {
+	case net.ProtocolTCP:
+		return drv.connectSocket(s, addr, port, ProtoModeTCP)
+	case net.ProtocolTLS:
+		return drv.connectSocket(s, addr, port, ProtoModeTLS)
+	case net.ProtocolUDP:
+		return drv.connectUDPSocket(s, addr, port, localPort)
+	default:
+		return ErrNotImplemented
+	}
 }
 
-func (drv *Driver) connectSocket(addr, portStr string, mode uint8) error {
+func (drv *Driver) connectUDPSocket(s *socket, addr string, port, localPort int) error {
+	// net.ListenUDP uses "0" as the remote address, the packets are then
+	// sent to the sender of the last packet received
+	s.ip, s.port = "", 0
+	if addr != "0" && addr != "" {
+		ipAddr, err := drv.dev.GetHostByName(addr)
+		if err != nil {
+			return err
+		}
+		s.ip, s.port = ipAddr, uint16(port)
+	}
 
-	// convert port to uint16
-	p64, err := strconv.ParseUint(portStr, 10, 16)
+	// check to see if socket is already set; if so, stop it
+	if s.sock != NoSocketAvail {
+		if err := drv.stop(s); err != nil {
+			return err
+		}
+	}
+
+	// get a socket from the device
+	sock, err := drv.dev.GetSocket()
 	if err != nil {
-		return fmt.Errorf("could not convert port to uint16: %s", err.Error())
+		return err
+	}
+	if sock == NoSocketAvail {
+		return net.ErrNoSocketAvail
 	}
-	port := uint16(p64)
+
+	// listen to the local port, and set the destination; either one books the
+	// socket in the firmware
+	if localPort != 0 {
+		if err := drv.dev.StartServer(uint16(localPort), sock, ProtoModeUDP); err != nil {
+			return err
+		}
+	}
+	if s.ip != "" {
+		if err := drv.dev.StartClient(s.ip.AsUint32(), s.port, sock, ProtoModeUDP); err != nil {
+			return err
+		}
+	}
+	s.sock = sock
+	return nil
+}
+
+func (drv *Driver) connectSocket(s *socket, addr string, port int, mode uint8) error {
 
 	// look up the hostname if necessary; if an IP address was specified, the
 	// same will be returned.  Otherwise, an IPv4 for the hostname is returned.
@@ -59,25 +160,34 @@ func (drv *Driver) connectSocket(addr, portStr string, mode uint8) error {
 	ip := ipAddr.AsUint32()
 
 	// check to see if socket is already set; if so, stop it
-	if drv.sock != NoSocketAvail {
-		if err := drv.stop(); err != nil {
+	if s.sock != NoSocketAvail {
+		if err := drv.stop(s); err != nil {
 			return err
 		}
 	}
 
 	// get a socket from the device
-	if drv.sock, err = drv.dev.GetSocket(); err != nil {
+	if s.sock, err = drv.dev.GetSocket(); err != nil {
 		return err
 	}
+	if s.sock == NoSocketAvail {
+		return net.ErrNoSocketAvail
+	}
 
 	// attempt to start the client
-	if err := drv.dev.StartClient(ip, port, drv.sock, mode); err != nil {
+	if s.serverName != "" && mode == ProtoModeTLS {
+		err = drv.dev.StartClientHost(s.serverName, ip, uint16(port), s.sock, mode)
+	} else {
+		err = drv.dev.StartClient(ip, uint16(port), s.sock, mode)
+	}
+	if err != nil {
+		s.sock = NoSocketAvail
 		return err
 	}
 
 	// FIXME: this 4 second timeout is simply mimicking the Arduino driver
 	for t := newTimer(4 * time.Second); !t.Expired(); {
-		connected, err := drv.IsConnected()
+		connected, err := drv.isConnected(s)
 		if err != nil {
 			return err
 		}
@@ -90,93 +200,366 @@ func (drv *Driver) connectSocket(addr, portStr string, mode uint8) error {
 	return ErrConnectionTimeout
 }
 
-func (drv *Driver) ConnectUDPSocket(addr, sport, lport string) error {
-	return ErrNotImplemented
+func (drv *Driver) ListenSocket(protocol net.Protocol, port int) (net.Socket, error) {
+	if protocol != net.ProtocolTCP {
+		return net.NoSocket, ErrNotImplemented
+	}
+	sock, err := drv.OpenSocket(protocol)
+	if err != nil {
+		return net.NoSocket, err
+	}
+	s := &drv.sockets[sock]
+	if s.sock, err = drv.dev.GetSocket(); err != nil || s.sock == NoSocketAvail {
+		s.inUse = false
+		if err == nil {
+			err = net.ErrNoSocketAvail
+		}
+		return net.NoSocket, err
+	}
+	if err := drv.dev.StartServer(uint16(port), s.sock, ProtoModeTCP); err != nil {
+		s.inUse = false
+		return net.NoSocket, err
+	}
+	s.listening = true
+	return sock, nil
+}
+
+// ListenMulticastSocket implements net.MulticastDriver.
+func (drv *Driver) ListenMulticastSocket(group string, port int) (net.Socket, error) {
+	ip, err := ParseIPv4(group)
+	if err != nil {
+		return net.NoSocket, err
+	}
+	sock, err := drv.OpenSocket(net.ProtocolUDP)
+	if err != nil {
+		return net.NoSocket, err
+	}
+	s := &drv.sockets[sock]
+	if s.sock, err = drv.dev.GetSocket(); err != nil || s.sock == NoSocketAvail {
+		s.inUse = false
+		if err == nil {
+			err = net.ErrNoSocketAvail
+		}
+		return net.NoSocket, err
+	}
+	if err := drv.dev.StartServerMulticast(ip.AsUint32(), uint16(port), s.sock); err != nil {
+		s.inUse = false
+		return net.NoSocket, err
+	}
+	return sock, nil
 }
 
-func (drv *Driver) DisconnectSocket() error {
-	return drv.stop()
+// SetHostname sets the hostname of the device, see Device.SetHostname.
+func (drv *Driver) SetHostname(hostname string) error {
+	return drv.dev.SetHostname(hostname)
 }
 
-func (drv *Driver) StartSocketSend(size int) error {
-	// not needed for WiFiNINA???
+// AcceptSocket returns a socket for the next client of the server. Clients
+// are only reported by the firmware once they have sent some data.
+func (drv *Driver) AcceptSocket(sock net.Socket) (net.Socket, error) {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return net.NoSocket, err
+	}
+	if !s.listening {
+		return net.NoSocket, net.ErrInvalidSocket
+	}
+	n, err := drv.dev.AvailServer(s.sock)
+	if err != nil || n == NoSocketAvail {
+		return net.NoSocket, err
+	}
+
+	// the firmware keeps reporting clients with data, skip the ones that
+	// have already been accepted
+	for i := range drv.sockets {
+		if drv.sockets[i].inUse && drv.sockets[i].sock == n {
+			return net.NoSocket, nil
+		}
+	}
+
+	client, err := drv.OpenSocket(net.ProtocolTCP)
+	if err != nil {
+		return net.NoSocket, err
+	}
+	drv.sockets[client].sock = n
+	return client, nil
+}
+
+// ConfigureTLS implements tls.DeviceDriver. Custom root certificates are not
+// supported, the firmware always verifies servers with its own store.
+func (drv *Driver) ConfigureTLS(sock net.Socket, config *tls.Config) error {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return err
+	}
+	if config.RootCAs != nil {
+		return ErrNotImplemented
+	}
+	if config.Certificate != nil {
+		if err := drv.dev.SetClientCert(string(config.Certificate)); err != nil {
+			return err
+		}
+	}
+	if config.PrivateKey != nil {
+		if err := drv.dev.SetCertKey(string(config.PrivateKey)); err != nil {
+			return err
+		}
+	}
+	s.serverName = config.ServerName
 	return nil
 }
 
-func (drv *Driver) Response(timeout int) ([]byte, error) {
-	return nil, nil
+func (drv *Driver) CloseSocket(sock net.Socket) error {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return err
+	}
+	if s.listening {
+		// the firmware has no command to stop a server
+		s.inUse = false
+		s.listening = false
+		s.sock = NoSocketAvail
+		return nil
+	}
+	err = drv.stop(s)
+	s.inUse = false
+	return err
 }
 
-func (drv *Driver) Write(b []byte) (n int, err error) {
-	if drv.sock == NoSocketAvail {
+func (drv *Driver) SendSocket(sock net.Socket, b []byte) (n int, err error) {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return 0, err
+	}
+	if s.sock == NoSocketAvail {
 		return 0, ErrNoSocketAvail
 	}
 	if len(b) == 0 {
 		return 0, ErrNoData
 	}
-	written, err := drv.dev.SendData(b, drv.sock)
+	if s.protocol == net.ProtocolUDP {
+		return drv.sendUDP(s, b)
+	}
+	if s.expired() {
+		return 0, net.ErrDeadlineExceeded
+	}
+	written, err := drv.dev.SendData(b, s.sock)
 	if err != nil {
 		return 0, err
 	}
 	if written == 0 {
 		return 0, ErrDataNotWritten
 	}
-	if sent, _ := drv.dev.CheckDataSent(drv.sock); !sent {
+	if s.expired() {
+		return int(written), net.ErrDeadlineExceeded
+	}
+	if sent, _ := drv.dev.CheckDataSent(s.sock); !sent {
 		return 0, ErrCheckDataError
 	}
 	return len(b), nil
 }
 
-func (drv *Driver) ReadSocket(b []byte) (n int, err error) {
-	avail, err := drv.available()
+// SendSocketTo implements net.UDPDriver.
+func (drv *Driver) SendSocketTo(sock net.Socket, b []byte, addr string, port int) (n int, err error) {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return 0, err
+	}
+	if s.sock == NoSocketAvail || s.protocol != net.ProtocolUDP {
+		return 0, net.ErrInvalidSocket
+	}
+	if len(b) == 0 {
+		return 0, ErrNoData
+	}
+	ip, err := ParseIPv4(addr)
 	if err != nil {
-		println("ReadSocket error: " + err.Error())
 		return 0, err
 	}
+	return drv.sendUDPTo(s, b, ip, uint16(port))
+}
+
+// sendUDP sends b as a single packet.
+func (drv *Driver) sendUDP(s *socket, b []byte) (n int, err error) {
+	ip, port := s.ip, s.port
+	if ip == "" {
+		ip, port = s.remoteIP, s.remotePort
+	}
+	if ip == "" {
+		return 0, ErrNoRemoteAddr
+	}
+	return drv.sendUDPTo(s, b, ip, port)
+}
+
+// sendUDPTo sends b as a single packet to ip and port.
+func (drv *Driver) sendUDPTo(s *socket, b []byte, ip IPAddress, port uint16) (n int, err error) {
+	if err := drv.dev.StartClient(ip.AsUint32(), port, s.sock, ProtoModeUDP); err != nil {
+		return 0, err
+	}
+	if ok, err := drv.dev.InsertDataBuf(b, s.sock); err != nil || !ok {
+		if err == nil {
+			err = ErrDataNotWritten
+		}
+		return 0, err
+	}
+	if ok, err := drv.dev.SendUDPData(s.sock); err != nil || !ok {
+		if err == nil {
+			err = ErrCheckDataError
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// RecvSocket implements net.DeviceDriver. A TCP socket returns io.EOF once
+// the connection has been closed and the data received has been read.
+func (drv *Driver) RecvSocket(sock net.Socket, b []byte) (n int, err error) {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return 0, err
+	}
+	if s.expired() {
+		return 0, net.ErrDeadlineExceeded
+	}
+	avail, err := drv.available(s)
+	if err != nil {
+		return 0, err
+	}
+	if avail == 0 && s.protocol != net.ProtocolUDP {
+		// the data received before the peer closed the connection is read
+		// first, the state is checked again in case it came in between
+		if connected, err := drv.isConnected(s); err != nil || connected {
+			return 0, err
+		}
+		if s.expired() {
+			return 0, net.ErrDeadlineExceeded
+		}
+		if avail, err = drv.available(s); err != nil || avail == 0 {
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+	}
 	if avail == 0 {
 		return 0, nil
 	}
-	length := len(b)
-	if avail < length {
-		length = avail
+	n = s.readBuf.read(b)
+
+	// a UDP read returns the rest of the packet, up to len(b), so that
+	// datagrams larger than the read buffer are not split
+	for s.protocol == net.ProtocolUDP && n < len(b) && s.packetLeft > 0 {
+		if _, err := drv.available(s); err != nil {
+			return n, err
+		}
+		n += s.readBuf.read(b[n:])
 	}
-	copy(b, drv.readBuf.data[drv.readBuf.head:drv.readBuf.head+length])
-	drv.readBuf.head += length
-	drv.readBuf.size -= length
-	return length, nil
+	return n, nil
+}
+
+// SetSocketDeadline implements net.DeadlineDriver. The deadline is checked
+// before every command sent to the WiFiNINA, a command that has been started
+// is always completed so that the SPI protocol stays in sync.
+func (drv *Driver) SetSocketDeadline(sock net.Socket, t time.Time) error {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return err
+	}
+	s.deadline = t
+	return nil
+}
+
+// expired reports whether the deadline of the socket has passed.
+func (s *socket) expired() bool {
+	return !s.deadline.IsZero() && !time.Now().Before(s.deadline)
+}
+
+// read copies the buffered data to b.
+func (r *readBuffer) read(b []byte) int {
+	n := copy(b, r.data[r.head:r.head+r.size])
+	r.head += n
+	r.size -= n
+	return n
 }
 
 // IsSocketDataAvailable returns of there is socket data available
-func (drv *Driver) IsSocketDataAvailable() bool {
-	n, err := drv.available()
+func (drv *Driver) IsSocketDataAvailable(sock net.Socket) bool {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return false
+	}
+	n, err := drv.available(s)
 	return err == nil && n > 0
 }
 
-func (drv *Driver) available() (int, error) {
-	if drv.readBuf.size == 0 {
-		n, err := drv.dev.GetDataBuf(drv.sock, drv.readBuf.data[:])
+func (drv *Driver) available(s *socket) (int, error) {
+	if s.sock == NoSocketAvail {
+		return 0, nil
+	}
+	if s.readBuf.size == 0 && s.protocol == net.ProtocolUDP && s.packetLeft == 0 {
+		// parse the next packet and keep track of its sender
+		n, err := drv.dev.AvailData(s.sock)
+		if err != nil || n == 0 {
+			return 0, err
+		}
+		ip, port, err := drv.dev.GetRemoteData(s.sock)
+		if err != nil {
+			return 0, err
+		}
+		s.remoteIP, s.remotePort = ip, port
+		s.packetLeft = int(n)
+	}
+	if s.readBuf.size == 0 {
+		n, err := drv.dev.GetDataBuf(s.sock, s.readBuf.data[:])
 		if n > 0 {
-			drv.readBuf.head = 0
-			drv.readBuf.size = n
+			s.readBuf.head = 0
+			s.readBuf.size = n
+		}
+		if s.protocol == net.ProtocolUDP {
+			s.packetLeft -= n
+			if n == 0 || s.packetLeft < 0 {
+				s.packetLeft = 0
+			}
 		}
 		if err != nil {
 			return int(n), err
 		}
 	}
-	return drv.readBuf.size, nil
+	return s.readBuf.size, nil
+}
+
+// SocketRemoteAddr returns the sender of the last packet received by the UDP
+// socket.
+func (drv *Driver) SocketRemoteAddr(sock net.Socket) (string, int, error) {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return "", 0, err
+	}
+	if s.remoteIP == "" {
+		return "", 0, ErrNoRemoteAddr
+	}
+	return s.remoteIP.String(), int(s.remotePort), nil
+}
+
+// IsConnected returns if the socket is connected.
+func (drv *Driver) IsConnected(sock net.Socket) (bool, error) {
+	s, err := drv.socket(sock)
+	if err != nil {
+		return false, nil
+	}
+	return drv.isConnected(s)
 }
 
-func (drv *Driver) IsConnected() (bool, error) {
-	if drv.sock == NoSocketAvail {
+func (drv *Driver) isConnected(s *socket) (bool, error) {
+	if s.sock == NoSocketAvail {
 		return false, nil
 	}
-	s, err := drv.status()
+	st, err := drv.status(s)
 	if err != nil {
 		return false, err
 	}
-	isConnected := !(s == TCPStateListen || s == TCPStateClosed ||
-		s == TCPStateFinWait1 || s == TCPStateFinWait2 || s == TCPStateTimeWait ||
-		s == TCPStateSynSent || s == TCPStateSynRcvd || s == TCPStateCloseWait)
+	isConnected := !(st == TCPStateListen || st == TCPStateClosed ||
+		st == TCPStateFinWait1 || st == TCPStateFinWait2 || st == TCPStateTimeWait ||
+		st == TCPStateSynSent || st == TCPStateSynRcvd || st == TCPStateCloseWait)
 	// TODO: investigate if the below is necessary (as per Arduino driver)
 	//if !isConnected {
 	//	//close socket buffer?
@@ -186,20 +569,20 @@ func (drv *Driver) IsConnected() (bool, error) {
 	return isConnected, nil
 }
 
-func (drv *Driver) status() (uint8, error) {
-	if drv.sock == NoSocketAvail {
+func (drv *Driver) status(s *socket) (uint8, error) {
+	if s.sock == NoSocketAvail {
 		return TCPStateClosed, nil
 	}
-	return drv.dev.GetClientState(drv.sock)
+	return drv.dev.GetClientState(s.sock)
 }
 
-func (drv *Driver) stop() error {
-	if drv.sock == NoSocketAvail {
+func (drv *Driver) stop(s *socket) error {
+	if s.sock == NoSocketAvail {
 		return nil
 	}
-	drv.dev.StopClient(drv.sock)
+	drv.dev.StopClient(s.sock)
 	for t := newTimer(5 * time.Second); !t.Expired(); {
-		st, _ := drv.status()
+		st, _ := drv.status(s)
 		if st == TCPStateClosed {
 			break
 		}
@@ -208,6 +591,8 @@ func (drv *Driver) stop() error {
 		// an issue so should investigate further
 		//time.Sleep(1 * time.Millisecond)
 	}
-	drv.sock = NoSocketAvail
+	s.sock = NoSocketAvail
+	s.readBuf.size = 0
+	s.packetLeft = 0
 	return nil
 }
@@ -9,10 +9,11 @@ package wifinina // import "tinygo.org/x/drivers/wifinina"
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"time"
 
-	"machine"
-
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/internal/pin"
 	"tinygo.org/x/drivers/net"
 )
 
@@ -124,6 +125,10 @@ const (
 	//	GET_IDX_SSID_CMD	= 0x31,
 	//	GET_TEST_CMD		= 0x38
 
+	// client certificate commands of the Adafruit firmware (AirLift boards)
+	CmdSetClientCert = 0x40
+	CmdSetCertKey    = 0x41
+
 	// All command with DATA_FLAG 0x40 send a 16bit Len
 	CmdSendDataTCP   = 0x44
 	CmdGetDatabufTCP = 0x45
@@ -150,6 +155,7 @@ const (
 	ErrDataNotWritten     Error = 0xF5
 	ErrCheckDataError     Error = 0xF6
 	ErrBufferTooSmall     Error = 0xF7
+	ErrNoRemoteAddr       Error = 0xF8
 	ErrNoSocketAvail      Error = 0xFF
 
 	NoSocketAvail uint8 = 0xFF
@@ -258,11 +264,11 @@ type command struct {
 }
 
 type Device struct {
-	SPI   machine.SPI
-	CS    machine.Pin
-	ACK   machine.Pin
-	GPIO0 machine.Pin
-	RESET machine.Pin
+	SPI   drivers.SPI
+	CS    drivers.Pin
+	ACK   drivers.Pin
+	GPIO0 drivers.Pin
+	RESET drivers.Pin
 
 	buf   [64]byte
 	ssids [10]string
@@ -272,10 +278,10 @@ func (d *Device) Configure() {
 
 	net.UseDriver(d.NewDriver())
 
-	d.CS.Configure(machine.PinConfig{machine.PinOutput})
-	d.ACK.Configure(machine.PinConfig{machine.PinInput})
-	d.RESET.Configure(machine.PinConfig{machine.PinOutput})
-	d.GPIO0.Configure(machine.PinConfig{machine.PinOutput})
+	pin.ConfigureOutput(d.CS)
+	pin.ConfigureInput(d.ACK)
+	pin.ConfigureOutput(d.RESET)
+	pin.ConfigureOutput(d.GPIO0)
 
 	d.GPIO0.High()
 	d.CS.High()
@@ -285,7 +291,7 @@ func (d *Device) Configure() {
 	time.Sleep(1 * time.Millisecond)
 
 	d.GPIO0.Low()
-	d.GPIO0.Configure(machine.PinConfig{machine.PinInput})
+	pin.ConfigureInput(d.GPIO0)
 
 }
 
@@ -311,6 +317,98 @@ func (d *Device) StartClient(addr uint32, port uint16, sock uint8, mode uint8) e
 	return err
 }
 
+// StartClientHost is like StartClient, but also passes the host name, which
+// is used for SNI and to verify the server certificate of TLS connections.
+func (d *Device) StartClientHost(host string, addr uint32, port uint16, sock uint8, mode uint8) error {
+	if _debug {
+		println("[StartClientHost] called StartClientHost()\r")
+		fmt.Printf("[StartClientHost] host: %s, addr: % 02X, port: %d, sock: %d\r\n", host, addr, port, sock)
+	}
+	if err := d.waitForSlaveSelect(); err != nil {
+		d.spiSlaveDeselect()
+		return err
+	}
+	l := d.sendCmd(CmdStartClientTCP, 5)
+	l += d.sendParamStr(host, false) + 1
+	l += d.sendParam32(addr, false)
+	l += d.sendParam16(port, false)
+	l += d.sendParam8(sock, false)
+	l += d.sendParam8(mode, true)
+	d.addPadding(l)
+	d.spiSlaveDeselect()
+	_, err := d.waitRspCmd1(CmdStartClientTCP)
+	return err
+}
+
+func (d *Device) StartServer(port uint16, sock uint8, mode uint8) error {
+	if _debug {
+		println("[StartServer] called StartServer()\r")
+		fmt.Printf("[StartServer] port: %d, sock: %d\r\n", port, sock)
+	}
+	if err := d.waitForSlaveSelect(); err != nil {
+		d.spiSlaveDeselect()
+		return err
+	}
+	l := d.sendCmd(CmdStartServerTCP, 3)
+	l += d.sendParam16(port, false)
+	l += d.sendParam8(sock, false)
+	l += d.sendParam8(mode, true)
+	d.addPadding(l)
+	d.spiSlaveDeselect()
+	_, err := d.waitRspCmd1(CmdStartServerTCP)
+	return err
+}
+
+// StartServerMulticast listens on sock for the UDP datagrams sent to the
+// multicast group addr and port.
+func (d *Device) StartServerMulticast(addr uint32, port uint16, sock uint8) error {
+	if _debug {
+		println("[StartServerMulticast] called StartServerMulticast()\r")
+		fmt.Printf("[StartServerMulticast] addr: % 02X, port: %d, sock: %d\r\n", addr, port, sock)
+	}
+	if err := d.waitForSlaveSelect(); err != nil {
+		d.spiSlaveDeselect()
+		return err
+	}
+	l := d.sendCmd(CmdStartServerTCP, 4)
+	l += d.sendParam32(addr, false)
+	l += d.sendParam16(port, false)
+	l += d.sendParam8(sock, false)
+	l += d.sendParam8(ProtoModeMul, true)
+	d.addPadding(l)
+	d.spiSlaveDeselect()
+	_, err := d.waitRspCmd1(CmdStartServerTCP)
+	return err
+}
+
+func (d *Device) GetServerState(sock uint8) (uint8, error) {
+	return d.getUint8(d.reqUint8(CmdGetStateTCP, sock))
+}
+
+// AvailServer returns the socket of a client of the server on sock that has
+// data available, or NoSocketAvail if there is none.
+func (d *Device) AvailServer(sock uint8) (uint8, error) {
+	n, err := d.AvailData(sock)
+	if err != nil || n >= uint16(NoSocketAvail) {
+		return NoSocketAvail, err
+	}
+	return uint8(n), nil
+}
+
+// AvailData returns the number of bytes available on sock. For UDP sockets
+// the next packet is parsed once the current one has been read completely.
+func (d *Device) AvailData(sock uint8) (uint16, error) {
+	l, err := d.reqUint8(CmdAvailDataTCP, sock)
+	if err != nil {
+		return 0, err
+	}
+	if l != 2 {
+		return 0, ErrUnexpectedLength
+	}
+	// the firmware sends the value as a little endian uint16
+	return binary.LittleEndian.Uint16(d.buf[0:2]), nil
+}
+
 func (d *Device) GetSocket() (uint8, error) {
 	return d.getUint8(d.req0(CmdGetSocket))
 }
@@ -332,6 +430,43 @@ func (d *Device) SendData(buf []byte, sock uint8) (uint16, error) {
 	return d.getUint16(d.waitRspCmd1(CmdSendDataTCP))
 }
 
+// InsertDataBuf appends buf to the packet being built for the UDP socket.
+func (d *Device) InsertDataBuf(buf []byte, sock uint8) (bool, error) {
+	if err := d.waitForSlaveSelect(); err != nil {
+		d.spiSlaveDeselect()
+		return false, err
+	}
+	l := d.sendCmd(CmdInsertDataBuf, 2)
+	l += d.sendParamBuf([]byte{sock}, false)
+	l += d.sendParamBuf(buf, true)
+	d.addPadding(l)
+	d.spiSlaveDeselect()
+	ok, err := d.getUint8(d.waitRspCmd1(CmdInsertDataBuf))
+	return ok == 1, err
+}
+
+// SendUDPData sends the packet built with InsertDataBuf to the remote address
+// set with StartClient.
+func (d *Device) SendUDPData(sock uint8) (bool, error) {
+	ok, err := d.getUint8(d.reqUint8(CmdSendDataUDP, sock))
+	return ok == 1, err
+}
+
+// GetRemoteData returns the address and port of the sender of the last
+// packet received by the UDP socket.
+func (d *Device) GetRemoteData(sock uint8) (IPAddress, uint16, error) {
+	sl := make([]string, 2)
+	if l, err := d.reqRspStr1(CmdGetRemoteData, sock, sl); err != nil {
+		return "", 0, err
+	} else if l != 2 {
+		return "", 0, ErrUnexpectedLength
+	}
+	if len(sl[1]) != 2 {
+		return "", 0, ErrUnexpectedLength
+	}
+	return IPAddress(sl[0]), binary.BigEndian.Uint16([]byte(sl[1])), nil
+}
+
 func (d *Device) CheckDataSent(sock uint8) (bool, error) {
 	var lastErr error
 	for timeout := 0; timeout < 10; timeout++ {
@@ -544,8 +679,31 @@ func (d *Device) SetDNS(which uint8, dns1 uint32, dns2 uint32) error {
 	return ErrNotImplemented
 }
 
+// SetClientCert sets the PEM encoded client certificate used for TLS
+// connections. It needs the Adafruit firmware of the AirLift boards.
+func (d *Device) SetClientCert(cert string) error {
+	ok, err := d.getUint8(d.reqBuf16(CmdSetClientCert, []byte(cert)))
+	if err == nil && ok != 1 {
+		err = ErrCmdErrorReceived
+	}
+	return err
+}
+
+// SetCertKey sets the PEM encoded private key of the client certificate. It
+// needs the Adafruit firmware of the AirLift boards.
+func (d *Device) SetCertKey(key string) error {
+	ok, err := d.getUint8(d.reqBuf16(CmdSetCertKey, []byte(key)))
+	if err == nil && ok != 1 {
+		err = ErrCmdErrorReceived
+	}
+	return err
+}
+
+// SetHostname sets the hostname of the device, which is sent to the DHCP
+// server. It must be called before connecting to the access point.
 func (d *Device) SetHostname(hostname string) error {
-	return ErrNotImplemented
+	_, err := d.reqStr(CmdSetHostname, hostname)
+	return err
 }
 
 func (d *Device) SetPowerMode(mode uint8) error {
@@ -611,7 +769,7 @@ func (d *Device) getInt32(l uint8, err error) (int32, error) {
 
 func (d *Device) getFloat32(l uint8, err error) (float32, error) {
 	i, err := d.getUint32(l, err)
-	return float32(i), err
+	return math.Float32frombits(i), err
 }
 
 func (d *Device) getMACAddress(l uint8, err error) (MACAddress, error) {
@@ -621,7 +779,8 @@ func (d *Device) getMACAddress(l uint8, err error) (MACAddress, error) {
 	if l != 6 {
 		return 0, ErrUnexpectedLength
 	}
-	return MACAddress(binary.LittleEndian.Uint64(d.buf[0:8]) >> 16), err
+	// the firmware sends the address least significant byte first
+	return MACAddress(binary.LittleEndian.Uint64(d.buf[0:8]) & 0xFFFFFFFFFFFF), err
 }
 
 // req0 sends a command to the device with no request parameters
@@ -653,6 +812,20 @@ func (d *Device) reqStr(cmd uint8, p1 string) (uint8, error) {
 	return d.waitRspCmd1(cmd)
 }
 
+// reqBuf16 sends a command to the device with a single parameter with a
+// 16-bit length, as needed by the commands with the DATA_FLAG
+func (d *Device) reqBuf16(cmd uint8, p []byte) (uint8, error) {
+	if err := d.waitForSlaveSelect(); err != nil {
+		d.spiSlaveDeselect()
+		return 0, err
+	}
+	l := d.sendCmd(cmd, 1)
+	l += d.sendParamBuf(p, true)
+	d.addPadding(l)
+	d.spiSlaveDeselect()
+	return d.waitRspCmd1(cmd)
+}
+
 // reqStr sends a command to the device with 2 string parameters
 func (d *Device) reqStr2(cmd uint8, p1 string, p2 string) (uint8, error) {
 	if err := d.sendCmdStr2(cmd, p1, p2); err != nil {
@@ -712,7 +885,7 @@ func (d *Device) sendCmdStr(cmd uint8, p1 string) (err error) {
 		return err
 	}
 	l := d.sendCmd(cmd, 1)
-	l += d.sendParamStr(p1, false)
+	l += d.sendParamStr(p1, true)
 	d.addPadding(l)
 	return nil
 }
@@ -1012,7 +1185,7 @@ func (d *Device) readAndCheckByte(check byte, read *byte) bool {
 // readParamLen16 reads 2 bytes from the SPI bus (MSB first), returning uint16
 func (d *Device) readParamLen16() (v uint16, err error) {
 	if b, err := d.SPI.Transfer(0xFF); err == nil {
-		v |= uint16(b << 8)
+		v |= uint16(b) << 8
 		if b, err = d.SPI.Transfer(0xFF); err == nil {
 			v |= uint16(b)
 		}
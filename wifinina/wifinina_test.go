@@ -0,0 +1,388 @@
+package wifinina
+
+import (
+	"bytes"
+	"io"
+	gonet "net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/net"
+	"tinygo.org/x/drivers/tester"
+)
+
+func newSim(t *testing.T) (*tester.NINA, *Device) {
+	sim := tester.NewNINA(t)
+	t.Cleanup(sim.Close)
+	d := &Device{SPI: tester.NewSPIBus(t, sim), CS: sim.CS, ACK: sim.ACK, GPIO0: sim.GPIO0, RESET: sim.RESET}
+	d.Configure()
+	return sim, d
+}
+
+func connect(t *testing.T, d *Device) {
+	if err := d.SetNetwork("tinygo"); err != nil {
+		t.Fatal(err)
+	}
+	st, err := d.GetConnectionStatus()
+	if err != nil || st != StatusConnected {
+		t.Fatal(st, err)
+	}
+}
+
+func TestNINAInfo(t *testing.T) {
+	sim, d := newSim(t)
+	v, err := d.GetFwVersion()
+	if err != nil || v != "1.4.8" {
+		t.Fatal(v, err)
+	}
+	sim.Networks = append(sim.Networks, tester.NINANetwork{SSID: "secure", Password: "pw", RSSI: -70, Channel: 11, Encryption: 4})
+	n, err := d.ScanNetworks()
+	if err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	if d.GetNetworkSSID(1) != "secure" {
+		t.Fatal(d.GetNetworkSSID(1))
+	}
+	rssi, err := d.GetNetworkRSSI(1)
+	if err != nil || rssi != -70 {
+		t.Fatal(rssi, err)
+	}
+	enc, _ := d.GetNetworkEncrType(1)
+	ch, _ := d.GetNetworkChannel(1)
+	if enc != 4 || ch != 11 {
+		t.Fatal(enc, ch)
+	}
+	if err := d.SetPassphrase("secure", "bad"); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := d.GetConnectionStatus(); st != StatusConnectFailed {
+		t.Fatal(st)
+	}
+	if err := d.SetPassphrase("secure", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	ssid, err := d.GetCurrentSSID()
+	if err != nil || ssid != "secure" {
+		t.Fatal(ssid, err)
+	}
+	ip, mask, gw, err := d.GetIP()
+	if err != nil || ip.String() != "192.168.1.101" || mask.String() != "255.255.255.0" || gw.String() != "192.168.1.1" {
+		t.Fatal(ip.String(), mask.String(), gw.String(), err)
+	}
+	mac, err := d.GetMACAddress()
+	if err != nil || mac.String() != "0000240AC4000001" {
+		t.Fatal(mac.String(), err)
+	}
+	bssid, err := d.GetNetworkBSSID(0)
+	if err != nil || bssid.String() != "0000A0B1C2D3E4F5" {
+		t.Fatal(bssid.String(), err)
+	}
+	temp, err := d.GetTemperature()
+	if err != nil || temp != 25 {
+		t.Fatal(temp, err)
+	}
+	sim.SetReply(CmdGetFwVersion)
+	if _, err := d.GetFwVersion(); err == nil {
+		t.Fatal("expected error")
+	}
+	sim.SetReply(CmdGetFwVersion, []byte("9.9.9"))
+	if v, _ := d.GetFwVersion(); v != "9.9.9" {
+		t.Fatal(v)
+	}
+	sim.ClearReply(CmdGetFwVersion)
+	sim.AssertCommand(CmdSetPassphrase)
+}
+
+func TestNINATCP(t *testing.T) {
+	sim, d := newSim(t)
+	connect(t, d)
+
+	ln, err := gonet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 64)
+		n, _ := c.Read(buf)
+		c.Write(append([]byte("echo:"), buf[:n]...))
+		c.Close()
+	}()
+	sim.Hosts["echo.test"] = "127.0.0.1"
+	port := ln.Addr().(*gonet.TCPAddr).Port
+
+	addr, err := net.ResolveTCPAddr("tcp", "echo.test:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	buf := make([]byte, 64)
+	// the server closes the connection after its response, which is read
+	// in full before io.EOF
+	deadline := time.Now().Add(2 * time.Second)
+	for err == nil && time.Now().Before(deadline) {
+		var n int
+		n, err = c.Read(buf)
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "echo:hello" || err != io.EOF {
+		t.Fatalf("%q %v", got, err)
+	}
+	if n, err := c.Read(buf); n != 0 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+	c.Close()
+	sim.AssertCommand(CmdStopClientTCP)
+}
+
+func TestNINAServerUDP(t *testing.T) {
+	sim, d := newSim(t)
+	connect(t, d)
+	drv := d.NewDriver()
+
+	sock, err := drv.ListenSocket(net.ProtocolTCP, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := sim.ServerAddr(8080)
+	if addr == nil {
+		t.Fatal("no server")
+	}
+	c, err := gonet.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping"))
+	var client net.Socket = net.NoSocket
+	for i := 0; i < 200 && client == net.NoSocket; i++ {
+		client, err = drv.AcceptSocket(sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if client == net.NoSocket {
+		t.Fatal("no client")
+	}
+	buf := make([]byte, 16)
+	n, err := drv.RecvSocket(client, buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatal(string(buf[:n]), err)
+	}
+	if _, err := drv.SendSocket(client, []byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	n, _ = c.Read(buf)
+	if string(buf[:n]) != "pong" {
+		t.Fatal(string(buf[:n]))
+	}
+
+	// UDP echo
+	uc, err := gonet.ListenUDP("udp", &gonet.UDPAddr{IP: gonet.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	go func() {
+		b := make([]byte, 64)
+		n, from, err := uc.ReadFromUDP(b)
+		if err == nil {
+			uc.WriteToUDP(b[:n], from)
+			time.Sleep(50 * time.Millisecond)
+			uc.WriteToUDP(bytes.Repeat([]byte("x"), 300), from)
+			uc.WriteToUDP([]byte("small"), from)
+		}
+	}()
+	us, _ := drv.OpenSocket(net.ProtocolUDP)
+	if err := drv.ConnectSocket(us, "127.0.0.1", uc.LocalAddr().(*gonet.UDPAddr).Port, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := drv.SendSocket(us, []byte("dgram")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if drv.IsSocketDataAvailable(us) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	n, err = drv.RecvSocket(us, buf)
+	if err != nil || string(buf[:n]) != "dgram" {
+		t.Fatal(string(buf[:n]), err)
+	}
+	ip, p, err := drv.SocketRemoteAddr(us)
+	if err != nil || ip != "127.0.0.1" || p != uc.LocalAddr().(*gonet.UDPAddr).Port {
+		t.Fatal(ip, p, err)
+	}
+
+	// datagrams larger than the read buffer are not split, nor merged
+	time.Sleep(200 * time.Millisecond)
+	big := make([]byte, 1024)
+	if n, err = drv.RecvSocket(us, big); n != 300 || err != nil {
+		t.Fatal(n, err)
+	}
+	if n, err = drv.RecvSocket(us, big); string(big[:n]) != "small" {
+		t.Fatal(string(big[:n]), err)
+	}
+}
+
+// The temperature is sent as the bits of a float32, little endian.
+func TestNINATemperature(t *testing.T) {
+	sim, d := newSim(t)
+	sim.Temperature = 21.75
+	temp, err := d.GetTemperature()
+	if err != nil || temp != 21.75 {
+		t.Fatal(temp, err)
+	}
+}
+
+// The MAC addresses are sent least significant byte first, in 6 bytes.
+func TestNINAMACAddress(t *testing.T) {
+	sim, d := newSim(t)
+	sim.MAC = [6]byte{0x24, 0x0A, 0xC4, 0x12, 0x34, 0x56}
+	mac, err := d.GetMACAddress()
+	if err != nil || mac != 0x240AC4123456 || mac.String() != "0000240AC4123456" {
+		t.Fatal(mac.String(), err)
+	}
+	if _, err := d.ScanNetworks(); err != nil {
+		t.Fatal(err)
+	}
+	bssid, err := d.GetNetworkBSSID(0)
+	if err != nil || bssid != 0xA0B1C2D3E4F5 {
+		t.Fatal(bssid.String(), err)
+	}
+}
+
+// Commands with a single string parameter must end with the end byte, or the
+// firmware rejects them.
+func TestNINAStringCommand(t *testing.T) {
+	sim, d := newSim(t)
+	if err := d.SetNetwork("tinygo"); err != nil {
+		t.Fatal(err)
+	}
+	cmds := sim.Commands()
+	last := cmds[len(cmds)-1]
+	if last.Cmd != CmdSetNet || len(last.Params) != 1 || string(last.Params[0]) != "tinygo" {
+		t.Fatalf("%+v", last)
+	}
+	if st, err := d.GetConnectionStatus(); err != nil || st != StatusConnected {
+		t.Fatal(st, err)
+	}
+}
+
+// The length of the data read from a socket has 16 bits, MSB first.
+func TestNINAGetDataBuf(t *testing.T) {
+	sim, d := newSim(t)
+	connect(t, d)
+
+	data := bytes.Repeat([]byte("0123456789"), 30)
+	ln, err := gonet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			c.Write(data)
+			c.Close()
+		}
+	}()
+	sim.Hosts["data.test"] = "127.0.0.1"
+
+	sock, err := d.GetSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := ParseIPv4("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(ln.Addr().(*gonet.TCPAddr).Port)
+	if err := d.StartClient(ip.AsUint32(), port, sock, ProtoModeTCP); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if n, _ := d.AvailData(sock); n >= uint16(len(data)) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	buf := make([]byte, len(data))
+	n, err := d.GetDataBuf(sock, buf)
+	if err != nil || n != len(data) || !bytes.Equal(buf, data) {
+		t.Fatal(n, err)
+	}
+}
+
+// The commands with the DATA_FLAG, like the certificates, have parameters
+// with a 16-bit length.
+func TestNINAClientCert(t *testing.T) {
+	sim, d := newSim(t)
+	cert := strings.Repeat("C", 1000)
+	if err := d.SetClientCert(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetCertKey(strings.Repeat("K", 300)); err != nil {
+		t.Fatal(err)
+	}
+	cmds := sim.Commands()
+	for i, want := range []struct {
+		cmd uint8
+		n   int
+	}{{CmdSetClientCert, 1000}, {CmdSetCertKey, 300}} {
+		c := cmds[len(cmds)-2+i]
+		if c.Cmd != want.cmd || len(c.Params) != 1 || len(c.Params[0]) != want.n {
+			t.Fatalf("%#x: %d parameters", c.Cmd, len(c.Params))
+		}
+	}
+}
+
+func TestNINAMulticast(t *testing.T) {
+	sim, d := newSim(t)
+	connect(t, d)
+	drv := d.NewDriver().(*Driver)
+	if err := drv.SetHostname("dev-a"); err != nil || sim.Hostname() != "dev-a" {
+		t.Fatal(sim.Hostname(), err)
+	}
+	sock, err := drv.ListenMulticastSocket("224.0.0.251", 15353)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := drv.ListenMulticastSocket("bad", 15353); err == nil {
+		t.Fatal("bad group accepted")
+	}
+	uc, err := gonet.ListenUDP("udp", &gonet.UDPAddr{IP: gonet.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	port := uc.LocalAddr().(*gonet.UDPAddr).Port
+	if _, err := drv.SendSocketTo(sock, []byte("query"), "127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	uc.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 16)
+	n, _, err := uc.ReadFromUDP(b)
+	if err != nil || string(b[:n]) != "query" {
+		t.Fatal(string(b[:n]), err)
+	}
+	if err := drv.CloseSocket(sock); err != nil {
+		t.Fatal(err)
+	}
+}